@@ -18,18 +18,23 @@ import (
 
 // DBConnect connect to a database using connect string
 func DBConnect(driver string, source string, timeout int) (dbh *sqlx.DB, err error) {
-	const defaultTimeout = 5
-	// Create a new child context with a 5-second timeout, using the
-	// provided ctx parameter as the parent.
+	dbh, err = sqlx.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	if err = pingDB(dbh, timeout); err != nil {
+		return nil, err
+	}
+	return dbh, err
+}
 
+// pingDB checks an opened connection with a timeout of at least 5 seconds
+func pingDB(dbh *sqlx.DB, timeout int) error {
+	const defaultTimeout = 5
 	if timeout < defaultTimeout {
 		timeout = defaultTimeout
 	}
 	log.Debugf("try to connect, timeout %d", timeout)
-	dbh, err = sqlx.Open(driver, source)
-	if err != nil {
-		return nil, err
-	}
 
 	// Create a context with timeout, using the empty
 	// context.Background() as the parent.
@@ -37,12 +42,12 @@ func DBConnect(driver string, source string, timeout int) (dbh *sqlx.DB, err err
 	defer cancel()
 
 	// Use this when testing the connection pool.
-	if err = dbh.PingContext(ctx); err != nil {
+	if err := dbh.PingContext(ctx); err != nil {
 		log.Debugf("DB Connect returned error= %s", err)
-		return nil, err
+		return err
 	}
 	log.Debugf("DB Connect success")
-	return dbh, err
+	return nil
 }
 
 // DBLogout disconnect from a database and set handler to nil on success otherwise return error and old handler
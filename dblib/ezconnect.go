@@ -0,0 +1,491 @@
+package dblib
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultOraclePort is used for EZConnect addresses without port
+const DefaultOraclePort = "1521"
+
+// EZConnect holds an EZConnect or EZConnect Plus string like
+// tcps://host1:1521,host2:1522/service:dedicated/instance?connect_timeout=10&ssl_server_dn_match=yes
+type EZConnect struct {
+	// Protocol is TCP or TCPS
+	Protocol string
+	// AddressLists holds the host groups separated by semicolon, hosts of a group by comma
+	AddressLists []TNSAddressList
+	ServiceName  string
+	Server       string
+	InstanceName string
+	// Params holds the ?name=value options with lower case names
+	Params map[string]string
+}
+
+// ezSecurityParams are options of the SECURITY section
+var ezSecurityParams = map[string]bool{
+	"ssl_server_cert_dn":  true,
+	"ssl_server_dn_match": true,
+	"wallet_location":     true,
+	"my_wallet_directory": true,
+	"ssl_version":         true,
+	"ssl_cipher_suites":   true,
+	"authentication":      true,
+	"token_auth":          true,
+	"token_location":      true,
+}
+
+// ezConnectDataParams are options of the CONNECT_DATA section
+var ezConnectDataParams = map[string]bool{
+	"pool_connection_class": true,
+	"pool_purity":           true,
+	"pool_boundary":         true,
+	"pool_name":             true,
+	"pool_tag":              true,
+	"service_tag":           true,
+	"colocation_tag":        true,
+	"connection_id_prefix":  true,
+}
+
+// ezAddressParams are options of every ADDRESS section
+var ezAddressParams = map[string]bool{
+	"https_proxy":      true,
+	"https_proxy_port": true,
+	"send_buf_size":    true,
+	"recv_buf_size":    true,
+}
+
+var reJDBCPrefix = regexp.MustCompile(`(?i)^jdbc:oracle:thin:[^@]*@`)
+var reJDBCSID = regexp.MustCompile(`^([\w.\-]+):(\d+):(\w+)$`)
+
+// ParseEZConnect parses an EZConnect or EZConnect Plus string
+func ParseEZConnect(s string) (ez *EZConnect, err error) {
+	ez = &EZConnect{Protocol: "TCP", Params: make(map[string]string)}
+	rest := strings.TrimSpace(s)
+	if p, r, ok := strings.Cut(rest, "://"); ok {
+		ez.Protocol = strings.ToUpper(p)
+		rest = r
+	}
+	if ez.Protocol != "TCP" && ez.Protocol != "TCPS" {
+		return nil, fmt.Errorf("ezconnect %s: unsupported protocol %s", s, ez.Protocol)
+	}
+	rest = strings.TrimPrefix(rest, "//")
+	if r, query, ok := strings.Cut(rest, "?"); ok {
+		rest = r
+		if err = ez.parseParams(query); err != nil {
+			return nil, fmt.Errorf("ezconnect %s: %v", s, err)
+		}
+	}
+	hosts, path := rest, ""
+	if i := ezPathStart(rest); i >= 0 {
+		hosts, path = rest[:i], rest[i+1:]
+	}
+	if err = ez.parseHosts(hosts); err != nil {
+		return nil, fmt.Errorf("ezconnect %s: %v", s, err)
+	}
+	service, instance, _ := strings.Cut(path, "/")
+	ez.ServiceName, ez.Server, _ = strings.Cut(service, ":")
+	ez.InstanceName = instance
+	if server, ok := ez.Params["server"]; ok && ez.Server == "" {
+		ez.Server = server
+	}
+	delete(ez.Params, "server")
+	return
+}
+
+// ezPathStart returns the index of the slash after the hosts, brackets of IPv6 addresses are skipped
+func ezPathStart(s string) int {
+	bracket := false
+	for i, c := range s {
+		switch c {
+		case '[':
+			bracket = true
+		case ']':
+			bracket = false
+		case '/':
+			if !bracket {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseHosts parses host groups like h1,h2:1522;h3, a host without port uses the port of the next host
+func (ez *EZConnect) parseHosts(s string) error {
+	for _, group := range strings.Split(s, ";") {
+		var l TNSAddressList
+		for _, h := range strings.Split(group, ",") {
+			h = strings.TrimSpace(h)
+			host, port := h, ""
+			if strings.HasPrefix(h, "[") || strings.Count(h, ":") == 1 {
+				var err error
+				if host, port, err = net.SplitHostPort(h); err != nil {
+					if host, port = strings.Trim(h, "[]"), ""; !strings.HasSuffix(h, "]") {
+						return fmt.Errorf("invalid address %q", h)
+					}
+				}
+			}
+			if host == "" {
+				return fmt.Errorf("empty host in %q", s)
+			}
+			if port != "" {
+				if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+					return fmt.Errorf("invalid port %q", port)
+				}
+				// hosts before without port share this port
+				for i := len(l.Addresses) - 1; i >= 0 && l.Addresses[i].Port == ""; i-- {
+					l.Addresses[i].Port = port
+				}
+			}
+			l.Addresses = append(l.Addresses, TNSAddress{Protocol: ez.Protocol, Host: host, Port: port})
+		}
+		for i := range l.Addresses {
+			if l.Addresses[i].Port == "" {
+				l.Addresses[i].Port = DefaultOraclePort
+			}
+		}
+		ez.AddressLists = append(ez.AddressLists, l)
+	}
+	return nil
+}
+
+// parseParams parses name=value&name="quoted value" options
+func (ez *EZConnect) parseParams(query string) error {
+	for query != "" {
+		name, rest, ok := strings.Cut(query, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid option %q", query)
+		}
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return fmt.Errorf("unterminated quoted value of %s", name)
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+			if rest != "" && !strings.HasPrefix(rest, "&") {
+				return fmt.Errorf("expected & after value of %s", name)
+			}
+			rest = strings.TrimPrefix(rest, "&")
+		} else {
+			value, rest, _ = strings.Cut(rest, "&")
+		}
+		ez.Params[strings.ToLower(strings.TrimSpace(name))] = value
+		query = rest
+	}
+	return nil
+}
+
+// ezQuote quotes option values with special characters
+func ezQuote(v string) string {
+	if strings.ContainsAny(v, "&=?,;/ ") {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+// String returns the EZConnect Plus form
+func (ez *EZConnect) String() string {
+	var sb strings.Builder
+	if strings.ToUpper(ez.Protocol) == "TCPS" {
+		sb.WriteString("tcps://")
+	}
+	for i, l := range ez.AddressLists {
+		if i > 0 {
+			sb.WriteString(";")
+		}
+		for j, a := range l.Addresses {
+			if j > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(net.JoinHostPort(a.Host, a.Port))
+		}
+	}
+	sb.WriteString("/" + ez.ServiceName)
+	if ez.Server != "" {
+		sb.WriteString(":" + ez.Server)
+	}
+	if ez.InstanceName != "" {
+		sb.WriteString("/" + ez.InstanceName)
+	}
+	for i, k := range sortedKeys(ez.Params) {
+		sep := "&"
+		if i == 0 {
+			sep = "?"
+		}
+		sb.WriteString(sep + k + "=" + ezQuote(ez.Params[k]))
+	}
+	return sb.String()
+}
+
+// Node returns the connect descriptor tree
+func (ez *EZConnect) Node() *NVPair {
+	leaf := func(name string, value string) *NVPair {
+		return &NVPair{Name: name, Value: value}
+	}
+	address := func(a TNSAddress) *NVPair {
+		n := &NVPair{Name: "ADDRESS", Children: []*NVPair{leaf("PROTOCOL", a.Protocol), leaf("HOST", a.Host), leaf("PORT", a.Port)}}
+		for _, k := range sortedKeys(ez.Params) {
+			if ezAddressParams[k] {
+				n.Children = append(n.Children, leaf(strings.ToUpper(k), ez.Params[k]))
+			}
+		}
+		return n
+	}
+	desc := &NVPair{Name: "DESCRIPTION"}
+	cd := &NVPair{Name: "CONNECT_DATA", Children: []*NVPair{leaf("SERVICE_NAME", ez.ServiceName)}}
+	if ez.Server != "" {
+		cd.Children = append(cd.Children, leaf("SERVER", ez.Server))
+	}
+	if ez.InstanceName != "" {
+		cd.Children = append(cd.Children, leaf("INSTANCE_NAME", ez.InstanceName))
+	}
+	sec := &NVPair{Name: "SECURITY"}
+	for _, k := range sortedKeys(ez.Params) {
+		name, value := strings.ToUpper(k), ez.Params[k]
+		switch {
+		case ezAddressParams[k]:
+		case ezSecurityParams[k]:
+			if name == "WALLET_LOCATION" {
+				name = "MY_WALLET_DIRECTORY"
+			}
+			sec.Children = append(sec.Children, leaf(name, value))
+		case ezConnectDataParams[k]:
+			cd.Children = append(cd.Children, leaf(name, value))
+		default:
+			desc.Children = append(desc.Children, leaf(name, value))
+		}
+	}
+	if len(ez.AddressLists) == 1 {
+		for _, a := range ez.AddressLists[0].Addresses {
+			desc.Children = append(desc.Children, address(a))
+		}
+	} else {
+		for _, l := range ez.AddressLists {
+			al := &NVPair{Name: "ADDRESS_LIST"}
+			for _, a := range l.Addresses {
+				al.Children = append(al.Children, address(a))
+			}
+			desc.Children = append(desc.Children, al)
+		}
+	}
+	desc.Children = append(desc.Children, cd)
+	if len(sec.Children) > 0 {
+		desc.Children = append(desc.Children, sec)
+	}
+	return desc
+}
+
+// Descriptor returns the connect descriptor text
+func (ez *EZConnect) Descriptor() string {
+	return ez.Node().String()
+}
+
+// TNSEntry returns an entry for alias with the descriptor of the EZConnect string
+func (ez *EZConnect) TNSEntry(alias string) TNSEntry {
+	return BuildTnsEntry("ezconnect", ez.Descriptor(), alias)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NewEZConnect converts a descriptor with one DESCRIPTION to EZConnect Plus if all settings can be expressed
+func NewEZConnect(d *TNSDescriptor) (ez *EZConnect, err error) {
+	if len(d.Descriptions) != 1 {
+		return nil, fmt.Errorf("ezconnect supports one DESCRIPTION, found %d", len(d.Descriptions))
+	}
+	desc := d.Descriptions[0]
+	cd := desc.ConnectData
+	if cd.SID != "" {
+		return nil, fmt.Errorf("ezconnect does not support SID %s", cd.SID)
+	}
+	ez = &EZConnect{ServiceName: cd.ServiceName, Server: cd.Server, InstanceName: cd.InstanceName, Params: make(map[string]string)}
+	lists := desc.AddressLists
+	if len(desc.Addresses) > 0 {
+		if len(lists) > 0 {
+			return nil, fmt.Errorf("ezconnect cannot mix ADDRESS and ADDRESS_LIST")
+		}
+		lists = []TNSAddressList{{Addresses: desc.Addresses}}
+	}
+	addressParams := map[string]string{}
+	for i, l := range lists {
+		if l.LoadBalance != "" || l.Failover != "" || l.SourceRoute != "" {
+			return nil, fmt.Errorf("ezconnect cannot express parameters of ADDRESS_LIST")
+		}
+		for j, a := range l.Addresses {
+			protocol := strings.ToUpper(a.Protocol)
+			if protocol != "TCP" && protocol != "TCPS" {
+				return nil, fmt.Errorf("ezconnect does not support protocol %s", a.Protocol)
+			}
+			if ez.Protocol != "" && ez.Protocol != protocol {
+				return nil, fmt.Errorf("ezconnect requires the same protocol for all addresses")
+			}
+			ez.Protocol = protocol
+			params := fmt.Sprint(a.Params)
+			if i+j == 0 {
+				for k, v := range a.Params {
+					addressParams[strings.ToLower(k)] = v
+				}
+			} else if params != fmt.Sprint(lists[0].Addresses[0].Params) {
+				return nil, fmt.Errorf("ezconnect requires the same parameters for all addresses")
+			}
+		}
+	}
+	add := func(params map[string]string, allowed map[string]bool, section string) error {
+		for k, v := range params {
+			name := strings.ToLower(k)
+			if allowed != nil && !allowed[name] {
+				return fmt.Errorf("ezconnect cannot express %s in %s", k, section)
+			}
+			if strings.ContainsAny(v, "()") {
+				return fmt.Errorf("ezconnect cannot express nested %s", k)
+			}
+			ez.Params[name] = v
+		}
+		return nil
+	}
+	security := map[string]string{}
+	for k, v := range desc.Security.Params {
+		security[k] = v
+	}
+	if desc.Security.SSLServerCertDN != "" {
+		security["SSL_SERVER_CERT_DN"] = desc.Security.SSLServerCertDN
+	}
+	if desc.Security.SSLServerDNMatch != "" {
+		security["SSL_SERVER_DN_MATCH"] = desc.Security.SSLServerDNMatch
+	}
+	if desc.Security.WalletLocation != "" {
+		security["WALLET_LOCATION"] = desc.Security.WalletLocation
+	}
+	general := map[string]string{}
+	for k, v := range desc.Params {
+		general[k] = v
+	}
+	if desc.LoadBalance != "" {
+		general["LOAD_BALANCE"] = desc.LoadBalance
+	}
+	if desc.Failover != "" {
+		general["FAILOVER"] = desc.Failover
+	}
+	for _, e := range []error{
+		add(addressParams, ezAddressParams, "ADDRESS"),
+		add(cd.Params, ezConnectDataParams, "CONNECT_DATA"),
+		add(security, ezSecurityParams, "SECURITY"),
+		add(general, nil, "DESCRIPTION"),
+	} {
+		if e != nil {
+			return nil, e
+		}
+	}
+	ez.AddressLists = lists
+	return
+}
+
+// EZConnectString returns the EZConnect Plus form of a TNS entry
+func EZConnectString(e TNSEntry) (string, error) {
+	d := e.Descriptor
+	if d == nil {
+		var err error
+		if d, err = ParseTNSDescriptor(e.Desc); err != nil {
+			return "", fmt.Errorf("entry %s: %w", e.Name, err)
+		}
+	}
+	ez, err := NewEZConnect(d)
+	if err != nil {
+		return "", fmt.Errorf("entry %s: %w", e.Name, err)
+	}
+	return ez.String(), nil
+}
+
+// ConnectDescriptor returns the connect descriptor of an alias from entries, a descriptor,
+// a JDBC thin URL, a go-ora URL or an EZConnect string
+func ConnectDescriptor(connect string, entries TNSEntries, domain string) (desc string, err error) {
+	connect = strings.TrimSpace(connect)
+	switch {
+	case connect == "":
+		return "", fmt.Errorf("empty connect string")
+	case strings.HasPrefix(strings.ToLower(connect), "oracle://"):
+		return goOraDescriptor(connect, entries, domain)
+	case reJDBCPrefix.MatchString(connect):
+		url := reJDBCPrefix.ReplaceAllString(connect, "")
+		if m := reJDBCSID.FindStringSubmatch(url); m != nil {
+			// host:port:sid
+			return fmt.Sprintf("(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=%s)(PORT=%s))(CONNECT_DATA=(SID=%s)))", m[1], m[2], m[3]), nil
+		}
+		// JDBC adds the wallet location as URL parameter to descriptors
+		if strings.HasPrefix(url, "(") {
+			url, _, _ = strings.Cut(url, "?")
+		}
+		return ConnectDescriptor(url, entries, domain)
+	case strings.HasPrefix(connect, "("):
+		if _, err = ParseTNSDescriptor(connect); err != nil {
+			return "", err
+		}
+		return connect, nil
+	}
+	if e, ok := GetEntry(connect, entries, domain); ok {
+		log.Debugf("ConnectDescriptor: use alias %s from %s", e.Name, e.Location)
+		return e.Desc, nil
+	}
+	if !strings.ContainsAny(connect, ":/") {
+		return "", fmt.Errorf("alias %s not found", connect)
+	}
+	ez, err := ParseEZConnect(connect)
+	if err != nil {
+		return "", err
+	}
+	return ez.Descriptor(), nil
+}
+
+// goOraDescriptor returns the descriptor of a go-ora URL with connStr option or host, port and service
+func goOraDescriptor(connect string, entries TNSEntries, domain string) (string, error) {
+	u, err := url.Parse(connect)
+	if err != nil {
+		return "", err
+	}
+	if connStr := u.Query().Get("connStr"); connStr != "" {
+		return ConnectDescriptor(connStr, entries, domain)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("go-ora url has no server")
+	}
+	port := u.Port()
+	if port == "" {
+		port = DefaultOraclePort
+	}
+	ez := &EZConnect{
+		Protocol:     "TCP",
+		AddressLists: []TNSAddressList{{Addresses: []TNSAddress{{Protocol: "TCP", Host: u.Hostname(), Port: port}}}},
+		ServiceName:  strings.TrimPrefix(u.Path, "/"),
+	}
+	if strings.EqualFold(u.Query().Get("SSL"), "true") {
+		ez.Protocol = "TCPS"
+		ez.AddressLists[0].Addresses[0].Protocol = "TCPS"
+	}
+	return ez.Descriptor(), nil
+}
+
+// DBConnectString connects to an oracle database with a connect string accepted by ConnectDescriptor using DBConnectEntry
+func DBConnectString(connect string, user string, password string, entries TNSEntries, domain string, timeout int) (*sqlx.DB, error) {
+	desc, err := ConnectDescriptor(connect, entries, domain)
+	if err != nil {
+		return nil, err
+	}
+	return DBConnectEntry(BuildTnsEntry(connect, desc, connect), user, password, GoOraOptions{}, timeout)
+}
@@ -0,0 +1,337 @@
+package dblib
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	ora "github.com/sijms/go-ora/v2"
+	"github.com/sijms/go-ora/v2/configurations"
+	log "github.com/sirupsen/logrus"
+)
+
+// GoOraOptions configures the DSN built by BuildGoOraDSN
+type GoOraOptions struct {
+	// ConnectTimeout in seconds, default is CONNECT_TIMEOUT or TRANSPORT_CONNECT_TIMEOUT of the descriptor
+	ConnectTimeout int
+	// Timeout in seconds for reads and writes
+	Timeout int
+	// SSL holds the wallet and TLS settings, TNSSSLconfig is used if nil
+	SSL *TNSSSL
+	// WalletPassword opens a wallet without auto login
+	WalletPassword string
+	// Options are passed to go-ora unchanged like PREFETCH_ROWS or TRACE FILE
+	Options map[string]string
+}
+
+var reTimeout = regexp.MustCompile(`(?i)^\s*([0-9.]+)\s*(ms|sec|s|min)?\s*$`)
+var reSSLVersion = regexp.MustCompile(`1\.[0-3]`)
+
+// timeoutSeconds converts descriptor timeouts like 10, 10 sec or 500 ms to full seconds
+func timeoutSeconds(v string) int {
+	m := reTimeout.FindStringSubmatch(v)
+	if m == nil {
+		return 0
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.ToLower(m[2]) {
+	case "ms":
+		f /= 1000
+	case "min":
+		f *= 60
+	}
+	return int(math.Ceil(f))
+}
+
+// isYes reports if a sqlnet value like yes, on or true is set
+func isYes(v string) bool {
+	switch strings.ToLower(v) {
+	case "yes", "on", "true", "1":
+		return true
+	}
+	return false
+}
+
+// entryDescriptor returns the parsed descriptor of an entry
+func entryDescriptor(e TNSEntry) (d *TNSDescriptor, err error) {
+	if d = e.Descriptor; d == nil {
+		if d, err = ParseTNSDescriptor(e.Desc); err != nil {
+			err = fmt.Errorf("entry %s: %w", e.Name, err)
+		}
+	}
+	return
+}
+
+// entrySSL returns the SSL settings of opts.SSL or TNSSSLconfig overridden by the SECURITY section of the first description
+func entrySSL(d *TNSDescriptor, opts GoOraOptions) TNSSSL {
+	ssl := TNSSSLconfig
+	if opts.SSL != nil {
+		ssl = *opts.SSL
+	}
+	sec := d.Descriptions[0].Security
+	if sec.SSLServerDNMatch != "" {
+		ssl.ServerDNMatch = isYes(sec.SSLServerDNMatch)
+	}
+	if sec.WalletLocation != "" {
+		ssl.WalletLocation = sec.WalletLocation
+	}
+	return ssl
+}
+
+// BuildGoOraDSN returns a go-ora URL for a TNS entry. All addresses are passed as servers in order
+// for failover, TCPS enables SSL with the wallet from opts.SSL or the SECURITY section and always verifies
+// the server certificate chain. Entries with mixed or other protocols are passed as connStr
+func BuildGoOraDSN(e TNSEntry, user string, password string, opts GoOraOptions) (dsn string, err error) {
+	d, err := entryDescriptor(e)
+	if err != nil {
+		return
+	}
+	ssl := entrySSL(d, opts)
+	query := url.Values{}
+	for k, v := range opts.Options {
+		query.Set(k, v)
+	}
+
+	var servers []TNSAddress
+	protocols := map[string]bool{}
+	for _, a := range d.Addresses() {
+		if a.Host != "" {
+			servers = append(servers, a)
+			protocols[strings.ToUpper(a.Protocol)] = true
+		}
+	}
+	if len(servers) == 0 {
+		return "", fmt.Errorf("entry %s has no server address", e.Name)
+	}
+	desc := d.Descriptions[0]
+	if protocols["TCPS"] {
+		// DN matching is done by TNSTLSConfig, the chain is verified in any case
+		query.Set("SSL", "true")
+		query.Set("SSL VERIFY", "true")
+		if ssl.WalletLocation != "" {
+			query.Set("WALLET", strings.TrimPrefix(ssl.WalletLocation, "file:"))
+		}
+		if opts.WalletPassword != "" {
+			query.Set("WALLET PASSWORD", opts.WalletPassword)
+		}
+	}
+	connectTimeout := opts.ConnectTimeout
+	for _, name := range []string{"CONNECT_TIMEOUT", "TRANSPORT_CONNECT_TIMEOUT"} {
+		if v, ok := desc.Params[name]; ok && connectTimeout == 0 {
+			connectTimeout = timeoutSeconds(v)
+		}
+	}
+	if connectTimeout > 0 {
+		query.Set("CONNECTION TIMEOUT", strconv.Itoa(connectTimeout))
+	}
+	if opts.Timeout > 0 {
+		query.Set("TIMEOUT", strconv.Itoa(opts.Timeout))
+	}
+
+	cd := desc.ConnectData
+	if len(protocols) > 1 || !(protocols["TCP"] || protocols["TCPS"]) {
+		// go-ora reads protocol, servers and service from the descriptor
+		query.Set("connStr", d.Node.String())
+		dsn = ora.BuildUrl("", 0, "", user, password, nil) + "?" + query.Encode()
+		log.Debugf("go-ora DSN for %s uses connStr", e.Name)
+		return
+	}
+	port, _ := strconv.Atoi(servers[0].Port)
+	for _, s := range servers[1:] {
+		query.Add("server", s.Host+":"+s.Port)
+	}
+	if cd.ServiceName == "" && cd.SID != "" {
+		query.Set("SID", cd.SID)
+	}
+	if cd.InstanceName != "" {
+		query.Set("INSTANCE NAME", cd.InstanceName)
+	}
+	dsn = ora.BuildUrl(servers[0].Host, port, cd.ServiceName, user, password, nil)
+	if len(query) > 0 {
+		dsn += "?" + query.Encode()
+	}
+	return
+}
+
+// TNSTLSConfig returns a TLS config for cipher suites and versions of ssl and an expected server certificate DN,
+// nil is returned if go-ora defaults can be used. Server certificate chains are always verified against the
+// certificates of the auto login wallet in ssl.WalletLocation or the system roots. The host name is checked
+// unless serverCertDN is given, then the subject of the verified certificate must match it
+func TNSTLSConfig(ssl TNSSSL, serverCertDN string) (config *tls.Config, err error) {
+	if ssl.Ciphers == "" && ssl.Version == "" && serverCertDN == "" {
+		return nil, nil
+	}
+	config = &tls.Config{}
+	if ssl.WalletLocation != "" {
+		if config.RootCAs, config.Certificates, err = walletTLS(ssl.WalletLocation); err != nil {
+			return nil, err
+		}
+	}
+	if ssl.Ciphers != "" {
+		known := map[string]uint16{}
+		for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			known[c.Name] = c.ID
+		}
+		for _, name := range strings.Split(strings.Trim(ssl.Ciphers, "()"), ",") {
+			name = strings.ToUpper(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			id, ok := known["TLS_"+strings.TrimPrefix(strings.TrimPrefix(name, "SSL_"), "TLS_")]
+			if !ok {
+				return nil, fmt.Errorf("unsupported cipher suite %s", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	if ssl.Version != "" {
+		versions := map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+		for _, v := range reSSLVersion.FindAllString(ssl.Version, -1) {
+			if config.MinVersion == 0 || versions[v] < config.MinVersion {
+				config.MinVersion = versions[v]
+			}
+		}
+		if config.MinVersion == 0 {
+			return nil, fmt.Errorf("unsupported ssl version %s", ssl.Version)
+		}
+	}
+	if serverCertDN != "" {
+		// the DN replaces the host name check, the chain is verified in VerifyPeerCertificate
+		config.InsecureSkipVerify = true //nolint:gosec
+		want := normalizeDN(serverCertDN)
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no server certificate")
+			}
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				c, e := x509.ParseCertificate(raw)
+				if e != nil {
+					return e
+				}
+				certs[i] = c
+			}
+			cert := certs[0]
+			verify := x509.VerifyOptions{Roots: config.RootCAs, Intermediates: x509.NewCertPool()}
+			for _, c := range certs[1:] {
+				verify.Intermediates.AddCert(c)
+			}
+			if _, e := cert.Verify(verify); e != nil {
+				return fmt.Errorf("server certificate %s: %w", cert.Subject, e)
+			}
+			if got := normalizeDN(cert.Subject.String()); got != want {
+				return fmt.Errorf("server certificate DN %s does not match %s", cert.Subject, serverCertDN)
+			}
+			return nil
+		}
+	}
+	return
+}
+
+// walletTLS returns the certificates of the auto login wallet in dir as trust store and
+// the certificates with matching private keys as client certificates like go-ora does
+func walletTLS(dir string) (roots *x509.CertPool, clientCerts []tls.Certificate, err error) {
+	w, err := configurations.NewWallet(filepath.Join(strings.TrimPrefix(dir, "file:"), "cwallet.sso"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read wallet %s: %w", dir, err)
+	}
+	var keys []*rsa.PrivateKey
+	for _, raw := range w.PrivateKeys {
+		key, e := x509.ParsePKCS1PrivateKey(raw)
+		if e != nil {
+			return nil, nil, fmt.Errorf("wallet %s: %w", dir, e)
+		}
+		keys = append(keys, key)
+	}
+	roots = x509.NewCertPool()
+	for _, raw := range w.Certificates {
+		cert, e := x509.ParseCertificate(raw)
+		if e != nil {
+			return nil, nil, fmt.Errorf("wallet %s: %w", dir, e)
+		}
+		roots.AddCert(cert)
+		for _, key := range keys {
+			if key.PublicKey.Equal(cert.PublicKey) {
+				clientCerts = append(clientCerts, tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: key, Leaf: cert})
+			}
+		}
+	}
+	return
+}
+
+// normalizeDN returns a DN in upper case without blanks around separators
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		k, v, _ := strings.Cut(p, "=")
+		parts[i] = strings.ToUpper(strings.TrimSpace(k)) + "=" + strings.ToUpper(strings.TrimSpace(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// goOraConnector returns a go-ora connector for an entry using BuildGoOraDSN and TNSTLSConfig for TCPS
+func goOraConnector(e TNSEntry, user string, password string, opts GoOraOptions) (connector driver.Connector, err error) {
+	dsn, err := BuildGoOraDSN(e, user, password, opts)
+	if err != nil {
+		return
+	}
+	connector = ora.NewConnector(dsn)
+	d, _ := entryDescriptor(e)
+	tcps := false
+	for _, a := range d.Addresses() {
+		tcps = tcps || strings.EqualFold(a.Protocol, "TCPS")
+	}
+	if !tcps {
+		return
+	}
+	ssl := entrySSL(d, opts)
+	serverCertDN := ""
+	if ssl.ServerDNMatch {
+		serverCertDN = d.Descriptions[0].Security.SSLServerCertDN
+	}
+	config, err := TNSTLSConfig(ssl, serverCertDN)
+	if err != nil {
+		return nil, fmt.Errorf("entry %s: %w", e.Name, err)
+	}
+	if config != nil {
+		connector.(*ora.OracleConnector).WithTLSConfig(config)
+	}
+	return
+}
+
+// DBConnectEntry connects to the database of a TNS entry with go-ora using BuildGoOraDSN and TNSTLSConfig
+func DBConnectEntry(e TNSEntry, user string, password string, opts GoOraOptions, timeout int) (dbh *sqlx.DB, err error) {
+	connector, err := goOraConnector(e, user, password, opts)
+	if err != nil {
+		return
+	}
+	dbh = sqlx.NewDb(sql.OpenDB(connector), "oracle")
+	if err = pingDB(dbh, timeout); err != nil {
+		_ = dbh.Close()
+		return nil, err
+	}
+	return
+}
+
+// DBConnectAlias connects to the database of an alias from entries like sqlplus user/password@alias
+func DBConnectAlias(alias string, entries TNSEntries, domain string, user string, password string, opts GoOraOptions, timeout int) (*sqlx.DB, error) {
+	e, ok := GetEntry(alias, entries, domain)
+	if !ok {
+		return nil, fmt.Errorf("alias %s not found", alias)
+	}
+	return DBConnectEntry(e, user, password, opts, timeout)
+}
@@ -0,0 +1,690 @@
+package dblib
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tommi2day/gomodules/common"
+)
+
+const listenerora = "listener.ora"
+
+// OraConfigParam is a parameter of sqlnet.ora, ldap.ora or listener.ora with its leading comment lines
+type OraConfigParam struct {
+	// Node holds the name as written and the value
+	Node     *NVPair
+	Comments []string
+	Line     int
+}
+
+// OraConfigFile is a parameter file which keeps order and comments for writing it back
+type OraConfigFile struct {
+	Params []*OraConfigParam
+	// Trailer holds comment lines after the last parameter
+	Trailer []string
+}
+
+var reOraConfigParam = regexp.MustCompile(`^([\w.\-]+)\s*=(.*)$`)
+
+// parseOraConfigValue parses the value text of NAME = value starting at line and column
+func parseOraConfigValue(name string, text string, line int, column int) (*NVPair, error) {
+	prefix := "(" + name + "="
+	n, err := ParseNVPair(prefix + text + "\n)")
+	if err == nil {
+		return n, nil
+	}
+	// single line values like dc=example,dc=com are kept as written
+	if v := strings.TrimSpace(text); !strings.ContainsAny(v, "()\n") {
+		return &NVPair{Name: name, Value: strings.Trim(v, `"`), Line: line, Column: 1}, nil
+	}
+	var pe *NVParseError
+	if errors.As(err, &pe) {
+		if pe.Line == 1 {
+			pe.Column += column - 1 - len(prefix)
+		}
+		pe.Line += line - 1
+	}
+	return nil, fmt.Errorf("parameter %s: %w", name, err)
+}
+
+// ParseOraConfig parses a parameter file, parameters must start in the first column and continue indented
+func ParseOraConfig(content string) (*OraConfigFile, error) {
+	f := &OraConfigFile{}
+	var pending []string
+	var skipped []string
+	var param *OraConfigParam
+	var name string
+	var value strings.Builder
+	valueColumn := 0
+	finish := func() (err error) {
+		if param != nil {
+			param.Node, err = parseOraConfigValue(name, value.String(), param.Line, valueColumn)
+			if param.Node != nil {
+				param.Node.Line, param.Node.Column = param.Line, 1
+			}
+		}
+		return
+	}
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			if trimmed != "" {
+				pending = append(pending, line)
+			}
+			skipped = append(skipped, line)
+			continue
+		case line[0] == ' ' || line[0] == '\t' || line[0] == '(' || line[0] == ')':
+			if param == nil {
+				return nil, &NVParseError{Line: i + 1, Column: 1, Msg: "continuation line without parameter"}
+			}
+			value.WriteString(strings.Join(append(skipped, line), "\n") + "\n")
+			pending = nil
+			skipped = nil
+			continue
+		}
+		if err := finish(); err != nil {
+			return nil, err
+		}
+		m := reOraConfigParam.FindStringSubmatch(line)
+		if m == nil {
+			return nil, &NVParseError{Line: i + 1, Column: 1, Msg: "expected parameter = value"}
+		}
+		param = &OraConfigParam{Comments: pending, Line: i + 1}
+		name = m[1]
+		value.Reset()
+		value.WriteString(m[2] + "\n")
+		valueColumn = len(line) - len(m[2]) + 1
+		pending = nil
+		skipped = nil
+		f.Params = append(f.Params, param)
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	f.Trailer = pending
+	return f, nil
+}
+
+// ReadOraConfig reads and parses a parameter file
+func ReadOraConfig(filename string) (*OraConfigFile, error) {
+	content, err := common.ReadFileToString(filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ParseOraConfig(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	log.Debugf("parsed %d parameters from %s", len(f.Params), filename)
+	return f, nil
+}
+
+// formatOraConfigParam writes NAME = value, nested values are indented on the following lines
+func formatOraConfigParam(n *NVPair) string {
+	var sb strings.Builder
+	writeNVComments(&sb, n.Comments, "")
+	if len(n.Children) == 0 {
+		writeNVComments(&sb, n.EndComments, "")
+		sb.WriteString(strings.TrimSuffix(strings.Replace(nvFormatInline(n), "(", "", 1), ")") + "\n")
+		return sb.String()
+	}
+	sb.WriteString(n.Name + " =\n")
+	for _, c := range n.Children {
+		formatNVPair(&sb, c, 1)
+	}
+	writeNVComments(&sb, n.EndComments, tnsIndent)
+	return sb.String()
+}
+
+// String returns the formatted file with comments
+func (f *OraConfigFile) String() string {
+	var sb strings.Builder
+	for _, p := range f.Params {
+		for _, c := range p.Comments {
+			sb.WriteString(c + "\n")
+		}
+		sb.WriteString(formatOraConfigParam(p.Node))
+	}
+	for _, c := range f.Trailer {
+		sb.WriteString(c + "\n")
+	}
+	return sb.String()
+}
+
+// WriteFile writes the formatted file
+func (f *OraConfigFile) WriteFile(filename string) error {
+	return common.WriteStringToFile(filename, f.String())
+}
+
+// find returns the parameter with the given name, names are case-insensitive
+func (f *OraConfigFile) find(name string) (*OraConfigParam, int) {
+	for i, p := range f.Params {
+		if strings.EqualFold(p.Node.Name, name) {
+			return p, i
+		}
+	}
+	return nil, -1
+}
+
+// Get returns the value tree of a parameter or nil
+func (f *OraConfigFile) Get(name string) *NVPair {
+	if p, _ := f.find(name); p != nil {
+		return p.Node
+	}
+	return nil
+}
+
+// Value returns the value of a parameter, list values are joined by comma
+func (f *OraConfigFile) Value(name string) string {
+	n := f.Get(name)
+	if n == nil {
+		return ""
+	}
+	if len(n.List) > 0 {
+		return strings.Join(n.List, ",")
+	}
+	if len(n.Children) > 0 {
+		return nvParamValue(n)
+	}
+	return n.Value
+}
+
+// Set replaces or appends a parameter with a value text like 10, (TNSNAMES, LDAP) or (SOURCE=...)
+func (f *OraConfigFile) Set(name string, value string) error {
+	n, err := parseOraConfigValue(name, value, 1, 1)
+	if err != nil {
+		return err
+	}
+	if p, _ := f.find(name); p != nil {
+		n.Name = p.Node.Name
+		p.Node = n
+		return nil
+	}
+	f.Params = append(f.Params, &OraConfigParam{Node: n})
+	return nil
+}
+
+// Remove deletes a parameter with its comments
+func (f *OraConfigFile) Remove(name string) bool {
+	if _, i := f.find(name); i >= 0 {
+		f.Params = slices.Delete(f.Params, i, i+1)
+		return true
+	}
+	return false
+}
+
+// nvFind returns the first node with name in the tree depth first
+func nvFind(n *NVPair, name string) *NVPair {
+	if strings.EqualFold(n.Name, name) {
+		return n
+	}
+	for _, c := range n.Children {
+		if found := nvFind(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// nvValues returns the list or the single value of a node
+func nvValues(n *NVPair) []string {
+	if len(n.List) > 0 {
+		return n.List
+	}
+	if n.Value != "" {
+		return []string{n.Value}
+	}
+	return nil
+}
+
+// types of parameter values for validation
+type oraValueType int
+
+const (
+	oraAny oraValueType = iota
+	oraInt
+	oraTimeout
+	oraBool
+	oraEnum
+	oraList
+	oraTree
+	oraServers
+)
+
+type oraParamSpec struct {
+	typ oraValueType
+	// values are the allowed values of enums and lists
+	values []string
+}
+
+var (
+	oraEncryptionLevels = []string{"ACCEPTED", "REJECTED", "REQUESTED", "REQUIRED"}
+	oraEncryptionTypes  = []string{"AES256", "AES192", "AES128", "3DES168", "3DES112", "DES", "DES40", "RC4_256", "RC4_128", "RC4_56", "RC4_40"}
+	oraChecksumTypes    = []string{"SHA512", "SHA384", "SHA256", "SHA1", "MD5"}
+)
+
+var sqlnetParams = map[string]oraParamSpec{
+	"NAMES.DEFAULT_DOMAIN":                    {typ: oraAny},
+	"NAMES.DIRECTORY_PATH":                    {oraList, []string{"TNSNAMES", "LDAP", "EZCONNECT", "HOSTNAME", "NIS", "CDS"}},
+	"NAMES.LDAP_AUTHENTICATE_BIND":            {typ: oraBool},
+	"NAMES.LDAP_CONN_TIMEOUT":                 {typ: oraInt},
+	"SQLNET.EXPIRE_TIME":                      {typ: oraInt},
+	"SQLNET.INBOUND_CONNECT_TIMEOUT":          {typ: oraTimeout},
+	"SQLNET.OUTBOUND_CONNECT_TIMEOUT":         {typ: oraTimeout},
+	"SQLNET.RECV_TIMEOUT":                     {typ: oraTimeout},
+	"SQLNET.SEND_TIMEOUT":                     {typ: oraTimeout},
+	"TCP.CONNECT_TIMEOUT":                     {typ: oraTimeout},
+	"TCP.NODELAY":                             {typ: oraBool},
+	"TCP.VALIDNODE_CHECKING":                  {typ: oraBool},
+	"TCP.INVITED_NODES":                       {typ: oraList},
+	"TCP.EXCLUDED_NODES":                      {typ: oraList},
+	"SQLNET.ENCRYPTION_CLIENT":                {oraEnum, oraEncryptionLevels},
+	"SQLNET.ENCRYPTION_SERVER":                {oraEnum, oraEncryptionLevels},
+	"SQLNET.ENCRYPTION_TYPES_CLIENT":          {oraList, oraEncryptionTypes},
+	"SQLNET.ENCRYPTION_TYPES_SERVER":          {oraList, oraEncryptionTypes},
+	"SQLNET.CRYPTO_CHECKSUM_CLIENT":           {oraEnum, oraEncryptionLevels},
+	"SQLNET.CRYPTO_CHECKSUM_SERVER":           {oraEnum, oraEncryptionLevels},
+	"SQLNET.CRYPTO_CHECKSUM_TYPES_CLIENT":     {oraList, oraChecksumTypes},
+	"SQLNET.CRYPTO_CHECKSUM_TYPES_SERVER":     {oraList, oraChecksumTypes},
+	"SQLNET.AUTHENTICATION_SERVICES":          {typ: oraList},
+	"SQLNET.ALLOWED_LOGON_VERSION_CLIENT":     {typ: oraInt},
+	"SQLNET.ALLOWED_LOGON_VERSION_SERVER":     {typ: oraInt},
+	"SQLNET.WALLET_OVERRIDE":                  {typ: oraBool},
+	"SQLNET.USE_HTTPS_PROXY":                  {typ: oraBool},
+	"WALLET_LOCATION":                         {typ: oraTree},
+	"ENCRYPTION_WALLET_LOCATION":              {typ: oraTree},
+	"SSL_CLIENT_AUTHENTICATION":               {typ: oraBool},
+	"SSL_SERVER_DN_MATCH":                     {typ: oraBool},
+	"SSL_VERSION":                             {typ: oraAny},
+	"SSL_CIPHER_SUITES":                       {typ: oraList},
+	"DISABLE_OOB":                             {typ: oraBool},
+	"DIAG_ADR_ENABLED":                        {typ: oraBool},
+	"ADR_BASE":                                {typ: oraAny},
+	"TRACE_LEVEL_CLIENT":                      {typ: oraAny},
+	"TRACE_LEVEL_SERVER":                      {typ: oraAny},
+	"TRACE_DIRECTORY_CLIENT":                  {typ: oraAny},
+	"TRACE_DIRECTORY_SERVER":                  {typ: oraAny},
+	"LOG_DIRECTORY_CLIENT":                    {typ: oraAny},
+	"LOG_DIRECTORY_SERVER":                    {typ: oraAny},
+	"SQLNET.KERBEROS5_CONF":                   {typ: oraAny},
+	"SQLNET.KERBEROS5_KEYTAB":                 {typ: oraAny},
+	"SQLNET.AUTHENTICATION_KERBEROS5_SERVICE": {typ: oraAny},
+}
+
+var ldaporaParams = map[string]oraParamSpec{
+	"DEFAULT_ADMIN_CONTEXT": {typ: oraAny},
+	"DIRECTORY_SERVERS":     {typ: oraServers},
+	"DIRECTORY_SERVER_TYPE": {oraEnum, []string{"OID", "AD", "OUD"}},
+}
+
+// listenerParams are global listener.ora parameters, names ending with _ take the listener name as suffix
+var listenerParams = map[string]oraParamSpec{
+	"SID_LIST_":                         {typ: oraTree},
+	"ADR_BASE_":                         {typ: oraAny},
+	"INBOUND_CONNECT_TIMEOUT_":          {typ: oraTimeout},
+	"LOGGING_":                          {oraEnum, []string{"ON", "OFF"}},
+	"LOG_DIRECTORY_":                    {typ: oraAny},
+	"LOG_FILE_":                         {typ: oraAny},
+	"TRACE_LEVEL_":                      {typ: oraAny},
+	"TRACE_DIRECTORY_":                  {typ: oraAny},
+	"TRACE_FILE_":                       {typ: oraAny},
+	"SECURE_REGISTER_":                  {typ: oraAny},
+	"SECURE_PROTOCOL_":                  {typ: oraAny},
+	"SECURE_CONTROL_":                   {typ: oraAny},
+	"VALID_NODE_CHECKING_REGISTRATION_": {typ: oraAny},
+	"REGISTRATION_INVITED_NODES_":       {typ: oraList},
+	"REGISTRATION_EXCLUDED_NODES_":      {typ: oraList},
+	"USE_SID_AS_SERVICE_":               {typ: oraBool},
+	"DEDICATED_THROUGH_BROKER_":         {typ: oraBool},
+	"SUBSCRIBE_FOR_NODE_DOWN_EVENT_":    {typ: oraBool},
+	"ENABLE_GLOBAL_DYNAMIC_ENDPOINT_":   {typ: oraBool},
+	"SAVE_CONFIG_ON_STOP_":              {typ: oraBool},
+	"STARTUP_WAIT_TIME_":                {typ: oraInt},
+	"ADMIN_RESTRICTIONS_":               {typ: oraBool},
+	"CONNECTION_RATE_":                  {typ: oraInt},
+	"DIAG_ADR_ENABLED_":                 {typ: oraBool},
+	"WALLET_LOCATION":                   {typ: oraTree},
+	"SSL_CLIENT_AUTHENTICATION":         {typ: oraBool},
+	"SSL_VERSION":                       {typ: oraAny},
+	"SSL_CIPHER_SUITES":                 {typ: oraList},
+	"DIAG_ADR_ENABLED":                  {typ: oraBool},
+	"TCP.VALIDNODE_CHECKING":            {typ: oraBool},
+	"TCP.INVITED_NODES":                 {typ: oraList},
+	"TCP.EXCLUDED_NODES":                {typ: oraList},
+}
+
+// checkOraValue returns an error message if the value does not match the spec
+func checkOraValue(n *NVPair, spec oraParamSpec) string {
+	values := nvValues(n)
+	switch spec.typ {
+	case oraTree:
+		if len(n.Children) == 0 {
+			return "expected nested parameters"
+		}
+		return ""
+	case oraList, oraServers:
+	default:
+		if len(n.Children) > 0 || len(n.List) > 0 {
+			return "expected a single value"
+		}
+	}
+	v := n.Value
+	switch spec.typ {
+	case oraInt:
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Sprintf("invalid number %q", v)
+		}
+	case oraTimeout:
+		if !reTimeout.MatchString(v) {
+			return fmt.Sprintf("invalid timeout %q", v)
+		}
+	case oraBool:
+		if !slices.Contains([]string{"YES", "NO", "ON", "OFF", "TRUE", "FALSE", "1", "0"}, strings.ToUpper(v)) {
+			return fmt.Sprintf("invalid boolean %q", v)
+		}
+	case oraEnum:
+		if !slices.Contains(spec.values, strings.ToUpper(v)) {
+			return fmt.Sprintf("invalid value %q, expected one of %s", v, strings.Join(spec.values, ", "))
+		}
+	case oraList:
+		if len(n.Children) > 0 {
+			return "expected a list"
+		}
+		for _, e := range values {
+			if len(spec.values) > 0 && !slices.Contains(spec.values, strings.ToUpper(e)) {
+				return fmt.Sprintf("invalid value %q, expected any of %s", e, strings.Join(spec.values, ", "))
+			}
+		}
+	case oraServers:
+		for _, e := range values {
+			f := strings.Split(e, ":")
+			if len(f) < 2 || len(f) > 3 || f[0] == "" {
+				return fmt.Sprintf("invalid directory server %q, expected host:port[:sslport]", e)
+			}
+			for _, p := range f[1:] {
+				if _, err := strconv.ParseUint(p, 10, 16); err != nil {
+					return fmt.Sprintf("invalid port in directory server %q", e)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// validate checks the values of known parameters, strict reports unknown parameters too
+func (f *OraConfigFile) validate(lookup func(name string) (oraParamSpec, bool), strict bool) (errs []error) {
+	for _, p := range f.Params {
+		spec, ok := lookup(strings.ToUpper(p.Node.Name))
+		if !ok {
+			if strict {
+				errs = append(errs, &NVParseError{Line: p.Line, Column: 1, Msg: fmt.Sprintf("unknown parameter %s", p.Node.Name)})
+			}
+			continue
+		}
+		if msg := checkOraValue(p.Node, spec); msg != "" {
+			errs = append(errs, &NVParseError{Line: p.Line, Column: 1, Msg: fmt.Sprintf("%s: %s", p.Node.Name, msg)})
+		}
+	}
+	return
+}
+
+// SQLNetConfig is the typed content of sqlnet.ora, File holds all parameters and is used for changes
+type SQLNetConfig struct {
+	DefaultDomain string
+	DirectoryPath []string
+	// ExpireTime is SQLNET.EXPIRE_TIME in minutes
+	ExpireTime int
+	// timeouts in seconds
+	InboundConnectTimeout  int
+	OutboundConnectTimeout int
+	RecvTimeout            int
+	SendTimeout            int
+	TCPConnectTimeout      int
+	// native network encryption and checksum levels and types
+	EncryptionClient       string
+	EncryptionServer       string
+	EncryptionTypesClient  []string
+	EncryptionTypesServer  []string
+	ChecksumClient         string
+	ChecksumServer         string
+	ChecksumTypesClient    []string
+	ChecksumTypesServer    []string
+	AuthenticationServices []string
+	SSL                    TNSSSL
+	File                   *OraConfigFile
+}
+
+// oraWalletLocation returns the DIRECTORY of a WALLET_LOCATION parameter
+func oraWalletLocation(f *OraConfigFile) string {
+	if n := f.Get("WALLET_LOCATION"); n != nil {
+		if d := nvFind(n, "DIRECTORY"); d != nil {
+			return d.Value
+		}
+	}
+	return ""
+}
+
+// oraSSL returns the SSL settings of a parameter file
+func oraSSL(f *OraConfigFile) TNSSSL {
+	return TNSSSL{
+		WalletLocation:      oraWalletLocation(f),
+		ClientAthentication: isYes(f.Value("SSL_CLIENT_AUTHENTICATION")),
+		ServerDNMatch:       isYes(f.Value("SSL_SERVER_DN_MATCH")),
+		Ciphers:             f.Value("SSL_CIPHER_SUITES"),
+		Version:             f.Value("SSL_VERSION"),
+	}
+}
+
+// oraListValue returns the values of a list parameter
+func oraListValue(f *OraConfigFile, name string) []string {
+	if n := f.Get(name); n != nil {
+		return nvValues(n)
+	}
+	return nil
+}
+
+// NewSQLNetConfig builds the typed view of a parsed sqlnet.ora
+func NewSQLNetConfig(f *OraConfigFile) *SQLNetConfig {
+	c := &SQLNetConfig{File: f, SSL: oraSSL(f)}
+	c.DefaultDomain = f.Value("NAMES.DEFAULT_DOMAIN")
+	c.DirectoryPath = oraListValue(f, "NAMES.DIRECTORY_PATH")
+	c.ExpireTime, _ = strconv.Atoi(f.Value("SQLNET.EXPIRE_TIME"))
+	c.InboundConnectTimeout = timeoutSeconds(f.Value("SQLNET.INBOUND_CONNECT_TIMEOUT"))
+	c.OutboundConnectTimeout = timeoutSeconds(f.Value("SQLNET.OUTBOUND_CONNECT_TIMEOUT"))
+	c.RecvTimeout = timeoutSeconds(f.Value("SQLNET.RECV_TIMEOUT"))
+	c.SendTimeout = timeoutSeconds(f.Value("SQLNET.SEND_TIMEOUT"))
+	c.TCPConnectTimeout = timeoutSeconds(f.Value("TCP.CONNECT_TIMEOUT"))
+	c.EncryptionClient = f.Value("SQLNET.ENCRYPTION_CLIENT")
+	c.EncryptionServer = f.Value("SQLNET.ENCRYPTION_SERVER")
+	c.EncryptionTypesClient = oraListValue(f, "SQLNET.ENCRYPTION_TYPES_CLIENT")
+	c.EncryptionTypesServer = oraListValue(f, "SQLNET.ENCRYPTION_TYPES_SERVER")
+	c.ChecksumClient = f.Value("SQLNET.CRYPTO_CHECKSUM_CLIENT")
+	c.ChecksumServer = f.Value("SQLNET.CRYPTO_CHECKSUM_SERVER")
+	c.ChecksumTypesClient = oraListValue(f, "SQLNET.CRYPTO_CHECKSUM_TYPES_CLIENT")
+	c.ChecksumTypesServer = oraListValue(f, "SQLNET.CRYPTO_CHECKSUM_TYPES_SERVER")
+	c.AuthenticationServices = oraListValue(f, "SQLNET.AUTHENTICATION_SERVICES")
+	return c
+}
+
+// ReadSQLNetConfig reads sqlnet.ora from the TNS_ADMIN directory filePath
+func ReadSQLNetConfig(filePath string) (*SQLNetConfig, error) {
+	f, err := ReadOraConfig(path.Join(filePath, sqlnetora))
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLNetConfig(f), nil
+}
+
+// Validate checks the values of known sqlnet.ora parameters, strict reports unknown parameters too
+func (c *SQLNetConfig) Validate(strict bool) []error {
+	return c.File.validate(func(name string) (oraParamSpec, bool) {
+		spec, ok := sqlnetParams[name]
+		return spec, ok
+	}, strict)
+}
+
+// LdapOraConfig is the typed content of ldap.ora, File holds all parameters and is used for changes
+type LdapOraConfig struct {
+	DefaultAdminContext string
+	Servers             []LdapServer
+	ServerType          string
+	File                *OraConfigFile
+}
+
+// NewLdapOraConfig builds the typed view of a parsed ldap.ora, invalid servers are skipped
+func NewLdapOraConfig(f *OraConfigFile) *LdapOraConfig {
+	c := &LdapOraConfig{File: f}
+	c.DefaultAdminContext = f.Value("DEFAULT_ADMIN_CONTEXT")
+	c.ServerType = f.Value("DIRECTORY_SERVER_TYPE")
+	for _, s := range oraListValue(f, "DIRECTORY_SERVERS") {
+		fields := strings.Split(s, ":")
+		if len(fields) < 2 || fields[0] == "" {
+			log.Warnf("ldap.ora: skip invalid directory server %s", s)
+			continue
+		}
+		server := LdapServer{Hostname: fields[0]}
+		server.Port, _ = strconv.Atoi(fields[1])
+		if len(fields) > 2 {
+			server.SSLPort, _ = strconv.Atoi(fields[2])
+		}
+		c.Servers = append(c.Servers, server)
+	}
+	return c
+}
+
+// ReadLdapOraConfig reads ldap.ora from the TNS_ADMIN directory filePath
+func ReadLdapOraConfig(filePath string) (*LdapOraConfig, error) {
+	f, err := ReadOraConfig(path.Join(filePath, ldapora))
+	if err != nil {
+		return nil, err
+	}
+	return NewLdapOraConfig(f), nil
+}
+
+// Validate checks the values of known ldap.ora parameters, strict reports unknown parameters too
+func (c *LdapOraConfig) Validate(strict bool) []error {
+	return c.File.validate(func(name string) (oraParamSpec, bool) {
+		spec, ok := ldaporaParams[name]
+		return spec, ok
+	}, strict)
+}
+
+// ListenerSID is a SID_DESC entry of a static listener registration
+type ListenerSID struct {
+	SIDName      string
+	GlobalDBName string
+	OracleHome   string
+	Program      string
+}
+
+// ListenerConfig is the typed content of listener.ora, File holds all parameters and is used for changes
+type ListenerConfig struct {
+	// Listeners maps the upper case listener names to their addresses
+	Listeners map[string][]TNSAddress
+	// SIDs maps the upper case listener names to the static registrations of SID_LIST_name
+	SIDs map[string][]ListenerSID
+	File *OraConfigFile
+}
+
+// listenerAddresses returns all ADDRESS sections of a tree
+func listenerAddresses(n *NVPair) (addresses []TNSAddress, err error) {
+	if strings.EqualFold(n.Name, "ADDRESS") {
+		a, e := newTNSAddress(n)
+		if e != nil {
+			return nil, e
+		}
+		return []TNSAddress{a}, nil
+	}
+	for _, c := range n.Children {
+		var list []TNSAddress
+		if list, err = listenerAddresses(c); err != nil {
+			return
+		}
+		addresses = append(addresses, list...)
+	}
+	return
+}
+
+// isListenerDefinition reports if a parameter value holds listener addresses
+func isListenerDefinition(n *NVPair) bool {
+	for _, c := range n.Children {
+		switch strings.ToUpper(c.Name) {
+		case "DESCRIPTION_LIST", "DESCRIPTION", "ADDRESS_LIST", "ADDRESS":
+			return true
+		}
+	}
+	return false
+}
+
+// NewListenerConfig builds the typed view of a parsed listener.ora
+func NewListenerConfig(f *OraConfigFile) (c *ListenerConfig, err error) {
+	c = &ListenerConfig{File: f, Listeners: make(map[string][]TNSAddress), SIDs: make(map[string][]ListenerSID)}
+	for _, p := range f.Params {
+		if !isListenerDefinition(p.Node) {
+			continue
+		}
+		var addresses []TNSAddress
+		if addresses, err = listenerAddresses(p.Node); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", p.Node.Name, err)
+		}
+		c.Listeners[strings.ToUpper(p.Node.Name)] = addresses
+	}
+	for name := range c.Listeners {
+		n := f.Get("SID_LIST_" + name)
+		if n == nil {
+			continue
+		}
+		for _, sd := range n.All("SID_LIST") {
+			for _, d := range sd.All("SID_DESC") {
+				c.SIDs[name] = append(c.SIDs[name], ListenerSID{
+					SIDName:      d.Attr("SID_NAME"),
+					GlobalDBName: d.Attr("GLOBAL_DBNAME"),
+					OracleHome:   d.Attr("ORACLE_HOME"),
+					Program:      d.Attr("PROGRAM"),
+				})
+			}
+		}
+	}
+	return
+}
+
+// ReadListenerConfig reads listener.ora from the TNS_ADMIN directory filePath
+func ReadListenerConfig(filePath string) (*ListenerConfig, error) {
+	f, err := ReadOraConfig(path.Join(filePath, listenerora))
+	if err != nil {
+		return nil, err
+	}
+	return NewListenerConfig(f)
+}
+
+// Validate checks listener definitions and the values of known listener.ora parameters,
+// parameters may have a listener name as suffix. Strict reports unknown parameters too
+func (c *ListenerConfig) Validate(strict bool) []error {
+	return c.File.validate(func(name string) (oraParamSpec, bool) {
+		if _, ok := c.Listeners[name]; ok {
+			return oraParamSpec{typ: oraTree}, true
+		}
+		if spec, ok := listenerParams[name]; ok {
+			return spec, true
+		}
+		for listener := range c.Listeners {
+			prefix, ok := strings.CutSuffix(name, listener)
+			if !ok || !strings.HasSuffix(prefix, "_") {
+				continue
+			}
+			// LISTENER is also a suffix of MY_LISTENER, try the other listeners
+			if spec, known := listenerParams[prefix]; known {
+				return spec, true
+			}
+		}
+		return oraParamSpec{}, false
+	}, strict)
+}
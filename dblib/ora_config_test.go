@@ -0,0 +1,181 @@
+package dblib
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+const sqlnetFull = `# client settings
+NAMES.DEFAULT_DOMAIN = local
+NAMES.DIRECTORY_PATH = (TNSNAMES, LDAP, EZCONNECT)
+SQLNET.EXPIRE_TIME = 10
+SQLNET.OUTBOUND_CONNECT_TIMEOUT = 500ms
+SQLNET.RECV_TIMEOUT = 30
+TCP.CONNECT_TIMEOUT = 5
+SQLNET.ENCRYPTION_CLIENT = required
+SQLNET.ENCRYPTION_TYPES_CLIENT = (AES256, AES192)
+SQLNET.CRYPTO_CHECKSUM_CLIENT = REQUESTED
+SQLNET.CRYPTO_CHECKSUM_TYPES_CLIENT = (SHA256)
+SQLNET.AUTHENTICATION_SERVICES = (BEQ, TCPS)
+# wallet for TCPS
+WALLET_LOCATION =
+  (SOURCE =
+    (METHOD = FILE)
+    (METHOD_DATA = (DIRECTORY = /etc/oracle/wallet))
+  )
+SSL_SERVER_DN_MATCH = yes
+# end
+`
+
+const listenerFull = `LISTENER =
+  (DESCRIPTION_LIST =
+    (DESCRIPTION =
+      (ADDRESS = (PROTOCOL = TCP)(HOST = db1)(PORT = 1521))
+      (ADDRESS = (PROTOCOL = IPC)(KEY = EXTPROC1521))
+    )
+  )
+SID_LIST_LISTENER =
+  (SID_LIST =
+    (SID_DESC =
+      (GLOBAL_DBNAME = orcl.local)
+      (ORACLE_HOME = /u01/app/oracle/product/19c)
+      (SID_NAME = ORCL)
+    )
+  )
+ADR_BASE_LISTENER = /u01/app/oracle
+INBOUND_CONNECT_TIMEOUT_LISTENER = 60
+USE_SID_AS_SERVICE_LISTENER = on
+`
+
+func TestOraConfig(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	oraAdmin := path.Join(test.TestData, "oraconfig")
+	require.NoError(t, os.MkdirAll(oraAdmin, 0750))
+
+	t.Run("SQLNet", func(t *testing.T) {
+		f, err := ParseOraConfig(sqlnetFull)
+		require.NoError(t, err)
+		c := NewSQLNetConfig(f)
+		assert.Equal(t, "local", c.DefaultDomain)
+		assert.Equal(t, []string{"TNSNAMES", "LDAP", "EZCONNECT"}, c.DirectoryPath)
+		assert.Equal(t, 10, c.ExpireTime)
+		assert.Equal(t, 1, c.OutboundConnectTimeout)
+		assert.Equal(t, 30, c.RecvTimeout)
+		assert.Equal(t, 5, c.TCPConnectTimeout)
+		assert.Equal(t, "required", c.EncryptionClient)
+		assert.Equal(t, []string{"AES256", "AES192"}, c.EncryptionTypesClient)
+		assert.Equal(t, []string{"SHA256"}, c.ChecksumTypesClient)
+		assert.Equal(t, []string{"BEQ", "TCPS"}, c.AuthenticationServices)
+		assert.Equal(t, "/etc/oracle/wallet", c.SSL.WalletLocation)
+		assert.True(t, c.SSL.ServerDNMatch)
+		assert.Empty(t, c.Validate(true))
+
+		assert.Equal(t, sqlnetFull, f.String(), "round trip")
+		require.NoError(t, f.Set("sqlnet.expire_time", "5"))
+		require.NoError(t, f.Set("DISABLE_OOB", "on"))
+		assert.True(t, f.Remove("TCP.CONNECT_TIMEOUT"))
+		assert.False(t, f.Remove("TCP.CONNECT_TIMEOUT"))
+		filename := path.Join(oraAdmin, sqlnetora)
+		require.NoError(t, f.WriteFile(filename))
+		c, err = ReadSQLNetConfig(oraAdmin)
+		require.NoError(t, err)
+		assert.Equal(t, 5, c.ExpireTime)
+		assert.Equal(t, 0, c.TCPConnectTimeout)
+		assert.Equal(t, "on", c.File.Value("disable_oob"))
+		assert.Contains(t, c.File.String(), "SQLNET.EXPIRE_TIME = 5\n")
+
+		domain, namesPath, ssl := ReadSQLNetOra(oraAdmin)
+		assert.Equal(t, "local", domain)
+		assert.Equal(t, []string{"TNSNAMES", "LDAP", "EZCONNECT"}, namesPath)
+		assert.Equal(t, "/etc/oracle/wallet", ssl.WalletLocation)
+		require.NoError(t, common.WriteStringToFile(filename, "NAMES.DIRECTORY_PATH =\n  (TNSNAMES,\n   LDAP)\n"))
+		_, namesPath, _ = ReadSQLNetOra(oraAdmin)
+		assert.Equal(t, []string{"TNSNAMES", "LDAP"}, namesPath, "multi-line value")
+
+		f, err = ParseOraConfig(sqlnetcontent)
+		require.NoError(t, err)
+		c = NewSQLNetConfig(f)
+		assert.Equal(t, "1.2", c.SSL.Version)
+		assert.Equal(t, "SSL_RSA_WITH_RC4_128_SHA", c.SSL.Ciphers)
+		assert.True(t, c.SSL.ClientAthentication)
+	})
+	t.Run("Validate", func(t *testing.T) {
+		f, err := ParseOraConfig("NAMES.DIRECTORY_PATH = (TNSNAMES, FILES)\nSQLNET.EXPIRE_TIME = often\n" +
+			"SQLNET.ENCRYPTION_SERVER = maybe\nSQLNET.RECV_TIMEOUT = 1 hour\nWALLET_LOCATION = /tmp\nMY.PARAM = 1\n")
+		require.NoError(t, err)
+		c := NewSQLNetConfig(f)
+		errs := c.Validate(false)
+		require.Len(t, errs, 5)
+		assert.EqualError(t, errs[0], `line 1, column 1: NAMES.DIRECTORY_PATH: invalid value "FILES", expected any of TNSNAMES, LDAP, EZCONNECT, HOSTNAME, NIS, CDS`)
+		assert.Contains(t, errs[1].Error(), "line 2")
+		assert.Len(t, c.Validate(true), 6, "unknown parameter in strict mode")
+
+		_, err = ParseOraConfig(" (A=1)\n")
+		assert.ErrorContains(t, err, "continuation line without parameter")
+		_, err = ParseOraConfig("A = 1\nB\n")
+		assert.ErrorContains(t, err, "line 2")
+		_, err = ParseOraConfig("A = 1\nWALLET_LOCATION =\n  (SOURCE = (METHOD = FILE)\n")
+		assert.ErrorContains(t, err, "parameter WALLET_LOCATION")
+	})
+	t.Run("LdapOra", func(t *testing.T) {
+		f, err := ParseOraConfig(ldaporaOK)
+		require.NoError(t, err)
+		c := NewLdapOraConfig(f)
+		assert.Equal(t, "dc=oracle,dc=local", c.DefaultAdminContext)
+		assert.Equal(t, "OID", c.ServerType)
+		assert.Equal(t, []LdapServer{{Hostname: "localhost", Port: 1389, SSLPort: 1636}, {Hostname: "localhost", Port: 1389}}, c.Servers)
+		assert.Empty(t, c.Validate(true))
+		assert.Contains(t, f.String(), `DEFAULT_ADMIN_CONTEXT = "dc=oracle,dc=local"`)
+
+		f, err = ParseOraConfig("DEFAULT_ADMIN_CONTEXT = dc=oracle,dc=local\n" + ldaporaFail)
+		require.NoError(t, err)
+		c = NewLdapOraConfig(f)
+		assert.Equal(t, "dc=oracle,dc=local", c.DefaultAdminContext, "unquoted context")
+		assert.Len(t, c.Validate(false), 1)
+		assert.Len(t, c.Servers, 1)
+
+		require.NoError(t, common.WriteStringToFile(path.Join(oraAdmin, ldapora), ldaporaOK))
+		c, err = ReadLdapOraConfig(oraAdmin)
+		require.NoError(t, err)
+		assert.Len(t, c.Servers, 2)
+	})
+	t.Run("Listener", func(t *testing.T) {
+		f, err := ParseOraConfig(listenerFull)
+		require.NoError(t, err)
+		c, err := NewListenerConfig(f)
+		require.NoError(t, err)
+		require.Len(t, c.Listeners["LISTENER"], 2)
+		assert.Equal(t, TNSAddress{Protocol: "TCP", Host: "db1", Port: "1521"}, c.Listeners["LISTENER"][0])
+		assert.Equal(t, []ListenerSID{{SIDName: "ORCL", GlobalDBName: "orcl.local", OracleHome: "/u01/app/oracle/product/19c"}}, c.SIDs["LISTENER"])
+		assert.Empty(t, c.Validate(true))
+		assert.Equal(t, listenerFull, f.String(), "round trip")
+
+		require.NoError(t, f.Set("USE_SID_AS_SERVICE_LISTENER", "maybe"))
+		require.NoError(t, f.Set("TRACE_LEVEL_OTHER", "16"))
+		assert.Len(t, c.Validate(false), 1)
+		assert.Len(t, c.Validate(true), 2, "no listener OTHER")
+
+		f, err = ParseOraConfig("LISTENER = (ADDRESS = (PROTOCOL = TCP)(HOST = db1)(PORT = 1521))\n" +
+			"MY_LISTENER = (ADDRESS = (PROTOCOL = TCP)(HOST = db1)(PORT = 1522))\n" +
+			"SID_LIST_MY_LISTENER = (SID_LIST = (SID_DESC = (SID_NAME = ORCL)))\n")
+		require.NoError(t, err)
+		multi, err := NewListenerConfig(f)
+		require.NoError(t, err)
+		for i := 0; i < 20; i++ {
+			require.Empty(t, multi.Validate(true), "LISTENER is a suffix of MY_LISTENER")
+		}
+
+		require.NoError(t, f.WriteFile(path.Join(oraAdmin, listenerora)))
+		c, err = ReadListenerConfig(oraAdmin)
+		require.NoError(t, err)
+		assert.Len(t, c.Listeners, 2)
+	})
+}
@@ -2,22 +2,24 @@
 package dblib
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/tommi2day/gomodules/common"
 )
 
-// TNSAddress holds  host/port of an address section
+// TNSAddress holds  protocol/host/port of an address section
 type TNSAddress struct {
-	Host string
-	Port string
+	Protocol string
+	Host     string
+	Port     string
+	// Params holds other values like KEY or HTTPS_PROXY with upper case names
+	Params map[string]string
 }
 
 // TNSEntry structure for holding one entry from tnsnames.ora
@@ -27,6 +29,8 @@ type TNSEntry struct {
 	Location string
 	Service  string
 	Servers  []TNSAddress
+	// Descriptor is the parsed description, nil if Desc could not be parsed
+	Descriptor *TNSDescriptor
 }
 
 // TNSEntries Map of tns entries
@@ -67,69 +71,88 @@ func CheckTNSadmin(tnsadmin string) (tnsnames string, err error) {
 	return
 }
 
+var reLenientService = regexp.MustCompile(`(?mi)(?:SERVICE_NAME|SID)\s*=\s*([\w.]+)`)
+var reLenientServer = regexp.MustCompile(`(?m)HOST\s*=\s*([\w\-_.]+)\s*\)\s*\(\s*PORT\s*=\s*(\d+)`)
+
 // BuildTnsEntry build map for entry
 func BuildTnsEntry(location string, desc string, tnsAlias string) TNSEntry {
-	var service = ""
-	reService := regexp.MustCompile(`(?mi)(?:SERVICE_NAME|SID)\s*=\s*([\w.]+)`)
-	s := reService.FindStringSubmatch(desc)
-	if len(s) > 1 {
-		service = s[1]
+	return buildTnsEntryAt(location, desc, tnsAlias, 1, 1)
+}
+
+// buildTnsEntryAt builds an entry from a description starting at line and column of its source
+func buildTnsEntryAt(location string, desc string, tnsAlias string, line int, column int) TNSEntry {
+	entry := TNSEntry{Name: tnsAlias, Desc: desc, Location: location}
+	d, err := ParseTNSDescriptor(desc)
+	if err != nil {
+		var pe *NVParseError
+		if errors.As(err, &pe) {
+			if pe.Line == 1 {
+				pe.Column += column - 1
+			}
+			pe.Line += line - 1
+		}
+		log.Warnf("Parse: Entry %s from %s: %v, using lenient parsing", tnsAlias, location, err)
+		entry.Service, entry.Servers = lenientTnsEntry(desc)
+		return entry
+	}
+	entry.Descriptor = d
+	entry.Service = d.Service()
+	for _, a := range d.Addresses() {
+		if a.Host != "" {
+			entry.Servers = append(entry.Servers, a)
+			log.Debugf("parsed Host: %s, Port %s", a.Host, a.Port)
+		}
 	}
-	servers := getServers(desc)
-	entry := TNSEntry{Name: tnsAlias, Desc: desc, Location: location, Service: service, Servers: servers}
 	log.Debugf("Build Entry for %s", tnsAlias)
 	return entry
 }
 
-// ReadSQLNetOra reads a sqlnet.ora and returns default domain and names path
-func ReadSQLNetOra(filePath string) (domain string, namesPath []string, sslInfo TNSSSL) {
-	filename := path.Join(filePath, sqlnetora)
-	domain = ""
-	sslCfg := TNSSSL{WalletLocation: "", ClientAthentication: false, ServerDNMatch: false, Ciphers: "", Version: ""}
-
-	content, err := common.ReadFileToString(filename)
+// lenientTnsEntry extracts service and servers from a description which does not validate,
+// the pair tree is searched if the syntax is valid, otherwise the text is matched
+func lenientTnsEntry(desc string) (service string, servers []TNSAddress) {
+	n, err := ParseNVPair(desc)
 	if err != nil {
-		log.Debugf("Cannot read sqlnet.ora as %s: %v", filename, err)
+		if s := reLenientService.FindStringSubmatch(desc); len(s) > 1 {
+			service = s[1]
+		}
+		for _, a := range reLenientServer.FindAllStringSubmatch(desc, -1) {
+			servers = append(servers, TNSAddress{Host: a[1], Port: a[2]})
+			log.Debugf("parsed Host: %s, Port %s", a[1], a[2])
+		}
 		return
 	}
-	// ^"(.+?)(?<!\\)"\s*=\s*"([\s\S]*?)(?<!\\)";
-	// all keys are lowwer case
-	// ini cannt parse multiline entries, so we use string matching
-
-	// Regular expression to match key-value pairs
-	re := regexp.MustCompile(`(?m)^\s*(\w+(?:\.\w+)*)\s*=\s*(.+?)\s*$`)
-	re2 := regexp.MustCompile(`(?i)YES|ON|TRUE|1`)
-	matches := re.FindAllStringSubmatch(content, -1)
-	names := ""
-	for _, match := range matches {
-		key := strings.ToLower(match[1])
-		value := strings.Trim(match[2], `"()`)
-		value = strings.ReplaceAll(value, " ", "")
-		switch key {
-		case "names.default_domain":
-			domain = value
-		case "names.directory_path":
-			namesPath = strings.Split(value, ",")
-		case "ssl_cipher_suites":
-			sslCfg.Ciphers = value
-		case "ssl_version":
-			sslCfg.Version = value
-		case "ssl_server_dn_match":
-			sslCfg.ServerDNMatch = re2.MatchString(value)
-		case "ssl_client_authentication":
-			sslCfg.ClientAthentication, _ = strconv.ParseBool(value)
+	var walk func(n *NVPair)
+	walk = func(n *NVPair) {
+		switch strings.ToUpper(n.Name) {
+		case "SERVICE_NAME", "SID":
+			if service == "" {
+				service = n.Value
+			}
+		case "ADDRESS":
+			a := TNSAddress{Protocol: n.Attr("PROTOCOL"), Host: n.Attr("HOST"), Port: n.Attr("PORT")}
+			if a.Host != "" {
+				servers = append(servers, a)
+				log.Debugf("parsed Host: %s, Port %s", a.Host, a.Port)
+			}
+			return
+		}
+		for _, c := range n.Children {
+			walk(c)
 		}
 	}
+	walk(n)
+	return
+}
 
-	// Handle WALLET_LOCATION separately as it's multi-line
-	reWallet := regexp.MustCompile(`WALLET_LOCATION\s*=\s*\(\s*SOURCE\s*=\s*\(\s*METHOD\s*=\s*FILE\s*\)\s*\(\s*METHOD_DATA\s*=\s*\(\s*DIRECTORY\s*=\s*"([^"]*)"`)
-	if walletMatch := reWallet.FindStringSubmatch(content); len(walletMatch) > 1 {
-		sslCfg.WalletLocation = walletMatch[1]
+// ReadSQLNetOra reads a sqlnet.ora and returns default domain and names path
+func ReadSQLNetOra(filePath string) (domain string, namesPath []string, sslInfo TNSSSL) {
+	c, err := ReadSQLNetConfig(filePath)
+	if err != nil {
+		log.Debugf("Cannot read sqlnet.ora in %s: %v", filePath, err)
+		return
 	}
-	// all keys are lowwer case
-	log.Debugf("parsed %s, domain=%s, names filePath=%s, Wallet=%s", filename, domain, names, sslCfg.WalletLocation)
-	sslInfo = sslCfg
-	return
+	log.Debugf("parsed sqlnet.ora in %s, domain=%s, names path=%v, Wallet=%s", filePath, c.DefaultDomain, c.DirectoryPath, c.SSL.WalletLocation)
+	return c.DefaultDomain, c.DirectoryPath, c.SSL
 }
 
 // GetEntry matches given string to tns entries using with domain part and without
@@ -157,6 +180,7 @@ func GetTnsnames(filename string, recursiv bool) (TNSEntries, string, error) {
 	var reNewEntry = regexp.MustCompile(`(?im)^([\w.]+)\s*=(.*)`)
 	var tnsAlias = ""
 	var desc = ""
+	var descLine, descColumn int
 
 	// try to find sqlnet ora and read domain
 	tnsDir := filepath.Dir(filename)
@@ -201,11 +225,13 @@ func GetTnsnames(filename string, recursiv bool) (TNSEntries, string, error) {
 		if i > 0 {
 			// save previous entry
 			if len(tnsAlias) > 0 && len(desc) > 0 {
-				tnsEntries[tnsAlias] = BuildTnsEntry(location, desc, tnsAlias)
+				tnsEntries[tnsAlias] = buildTnsEntryAt(location, desc, tnsAlias, descLine, descColumn)
 			}
 			// new entry
 			location = fmt.Sprintf("%s Line: %d", filename, l)
 			tnsAlias = strings.ToUpper(newEntry[1])
+			descLine = l
+			descColumn = len(strings.TrimRight(line, "\r\n")) - len(newEntry[2]) + 1
 			if i > 2 {
 				desc = newEntry[2] + "\n"
 			}
@@ -216,7 +242,7 @@ func GetTnsnames(filename string, recursiv bool) (TNSEntries, string, error) {
 
 	// save last entry
 	if len(tnsAlias) > 0 && len(desc) > 0 {
-		tnsEntries[tnsAlias] = BuildTnsEntry(location, desc, tnsAlias)
+		tnsEntries[tnsAlias] = buildTnsEntryAt(location, desc, tnsAlias, descLine, descColumn)
 	}
 
 	// sanity check
@@ -319,20 +345,3 @@ func getIfile(filename string, recursiv bool) (entries TNSEntries, err error) {
 	entries, _, err = GetTnsnames(filename, recursiv)
 	return
 }
-
-// getServers extract TNSAddress part
-func getServers(tnsDesc string) (servers []TNSAddress) {
-	re := regexp.MustCompile(`(?m)HOST\s*=\s*([\w\-_.]+)\s*\)\s*\(\s*PORT\s*=\s*(\d+)`)
-	match := re.FindAllStringSubmatch(tnsDesc, -1)
-	for _, a := range match {
-		if len(a) > 1 {
-			host := a[1]
-			port := a[2]
-			servers = append(servers, TNSAddress{
-				Host: host, Port: port,
-			})
-			log.Debugf("parsed Host: %s, Port %s", host, port)
-		}
-	}
-	return
-}
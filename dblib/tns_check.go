@@ -0,0 +1,263 @@
+package dblib
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tommi2day/gomodules/netlib"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// default settings of CheckTNSEntries
+const (
+	DefaultCheckWorkers = 8
+	DefaultCheckTimeout = 5 * time.Second
+)
+
+// ListenerPingFunc checks a listener at address host:port for a service
+type ListenerPingFunc func(ctx context.Context, address string, service string) error
+
+// CredentialsFunc returns the login for an alias, an empty user skips the login check
+type CredentialsFunc func(alias string) (user string, password string, err error)
+
+// TNSCheckOptions configures CheckTNSEntries
+type TNSCheckOptions struct {
+	// Workers limits the concurrent checks, default DefaultCheckWorkers
+	Workers int
+	// Timeout applies to every single check, default DefaultCheckTimeout
+	Timeout time.Duration
+	// RacInfo is the racinfo.ini used to resolve RAC addresses, DNS SRV records are used if empty
+	RacInfo string
+	// ListenerPing is called for every reachable address if set
+	ListenerPing ListenerPingFunc
+	// Credentials enables a database login per alias if set
+	Credentials CredentialsFunc
+}
+
+// TNSCheckStatus is the outcome of a single check
+type TNSCheckStatus struct {
+	Done     bool
+	Err      error
+	Duration time.Duration
+}
+
+// OK reports if the check was done without error
+func (s TNSCheckStatus) OK() bool {
+	return s.Done && s.Err == nil
+}
+
+// String returns ok, skipped or the error
+func (s TNSCheckStatus) String() string {
+	switch {
+	case !s.Done:
+		return "skipped"
+	case s.Err != nil:
+		return s.Err.Error()
+	}
+	return fmt.Sprintf("ok (%s)", s.Duration.Round(time.Millisecond))
+}
+
+// TNSAddressReport holds the checks of one resolved address
+type TNSAddressReport struct {
+	Server   ServiceEntryType
+	TCP      TNSCheckStatus
+	Listener TNSCheckStatus
+}
+
+// TNSAliasReport holds the checks of one alias
+type TNSAliasReport struct {
+	Alias     string
+	Service   string
+	Location  string
+	Addresses []TNSAddressReport
+	Login     TNSCheckStatus
+	// Err is set if the entry has no usable address
+	Err error
+}
+
+// OK reports if an address was reachable and all done listener and login checks succeeded
+func (r TNSAliasReport) OK() bool {
+	if r.Err != nil || (r.Login.Done && r.Login.Err != nil) {
+		return false
+	}
+	for _, a := range r.Addresses {
+		if a.TCP.OK() && (!a.Listener.Done || a.Listener.OK()) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPool calls fn for 0..n-1 with at most workers concurrent calls
+func runPool(ctx context.Context, workers int, n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// timed runs check with the timeout and records the result
+func timed(ctx context.Context, timeout time.Duration, check func(ctx context.Context) error) (s TNSCheckStatus) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	s.Err = check(ctx)
+	s.Duration = time.Since(start)
+	s.Done = true
+	return
+}
+
+// resolveTNSServers returns the addresses of all servers of an entry, RAC hosts are expanded
+func resolveTNSServers(e TNSEntry, racInfo string) (servers ServiceEntries) {
+	for _, s := range e.Servers {
+		resolved := GetRacAdresses(s.Host, racInfo)
+		if len(resolved) == 0 {
+			resolved = getServiceList(s.Host, s.Port)
+		}
+		if len(resolved) == 0 {
+			// keep unresolvable hosts in the report
+			resolved = ServiceEntries{{Host: s.Host, Port: s.Port}}
+		}
+		servers = append(servers, resolved...)
+	}
+	return
+}
+
+// serverAddress returns ip:port or host:port if the host was not resolved
+func serverAddress(server ServiceEntryType) string {
+	host := server.IP
+	if host == "" {
+		host = server.Host
+	}
+	return net.JoinHostPort(host, server.Port)
+}
+
+// checkTCP connects to the address of a server
+func checkTCP(ctx context.Context, server ServiceEntryType) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", serverAddress(server))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkLogin connects to the database of an entry and closes the connection
+func checkLogin(ctx context.Context, e TNSEntry, user string, password string) (err error) {
+	connector, err := goOraConnector(e, user, password, GoOraOptions{})
+	if err != nil {
+		return
+	}
+	db := sql.OpenDB(connector)
+	defer func() {
+		_ = db.Close()
+	}()
+	return db.PingContext(ctx)
+}
+
+// CheckTNSEntries checks concurrently that all addresses of all entries are reachable by TCP
+// and optionally the listener and a login. The reports are sorted by alias
+func CheckTNSEntries(ctx context.Context, entries TNSEntries, opts TNSCheckOptions) []TNSAliasReport {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultCheckWorkers
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultCheckTimeout
+	}
+	aliases := make([]string, 0, len(entries))
+	for k := range entries {
+		aliases = append(aliases, k)
+	}
+	sort.Strings(aliases)
+	reports := make([]TNSAliasReport, len(aliases))
+
+	// resolve addresses first to build the list of checks
+	if DNSConfig == nil {
+		// set the default resolver before the concurrent lookups
+		DNSConfig = netlib.NewResolver("", 0, false)
+	}
+	if IPv4Only {
+		DNSConfig.IPv4Only = true
+	}
+	runPool(ctx, opts.Workers, len(aliases), func(i int) {
+		e := entries[aliases[i]]
+		r := &reports[i]
+		r.Alias, r.Service, r.Location = aliases[i], e.Service, e.Location
+		for _, s := range resolveTNSServers(e, opts.RacInfo) {
+			r.Addresses = append(r.Addresses, TNSAddressReport{Server: s})
+		}
+		if len(r.Addresses) == 0 {
+			r.Err = errors.New("no server address")
+		}
+	})
+
+	type job struct {
+		alias   int
+		address int
+	}
+	var jobs []job
+	for i := range reports {
+		for j := range reports[i].Addresses {
+			jobs = append(jobs, job{i, j})
+		}
+		if opts.Credentials != nil && reports[i].Err == nil {
+			jobs = append(jobs, job{i, -1})
+		}
+	}
+	runPool(ctx, opts.Workers, len(jobs), func(n int) {
+		r := &reports[jobs[n].alias]
+		if jobs[n].address < 0 {
+			r.Login = loginStatus(ctx, r.Alias, entries[r.Alias], opts)
+			return
+		}
+		a := &r.Addresses[jobs[n].address]
+		a.TCP = timed(ctx, opts.Timeout, func(ctx context.Context) error {
+			return checkTCP(ctx, a.Server)
+		})
+		if a.TCP.OK() && opts.ListenerPing != nil {
+			a.Listener = timed(ctx, opts.Timeout, func(ctx context.Context) error {
+				return opts.ListenerPing(ctx, serverAddress(a.Server), r.Service)
+			})
+		}
+	})
+	for i := range reports {
+		if ctx.Err() != nil && !reports[i].OK() && reports[i].Err == nil {
+			reports[i].Err = ctx.Err()
+		}
+		log.Debugf("check %s: ok=%v", reports[i].Alias, reports[i].OK())
+	}
+	return reports
+}
+
+// loginStatus gets the credentials and logs in
+func loginStatus(ctx context.Context, alias string, e TNSEntry, opts TNSCheckOptions) TNSCheckStatus {
+	user, password, err := opts.Credentials(alias)
+	if err != nil {
+		return TNSCheckStatus{Done: true, Err: fmt.Errorf("no credentials: %v", err)}
+	}
+	if user == "" {
+		return TNSCheckStatus{}
+	}
+	return timed(ctx, opts.Timeout, func(ctx context.Context) error {
+		return checkLogin(ctx, e, user, password)
+	})
+}
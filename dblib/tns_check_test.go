@@ -0,0 +1,127 @@
+package dblib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkEntry(alias string, ports ...string) TNSEntry {
+	addresses := ""
+	for _, p := range ports {
+		addresses += fmt.Sprintf("(ADDRESS=(PROTOCOL=TCP)(HOST=127.0.0.1)(PORT=%s))", p)
+	}
+	return BuildTnsEntry("check", "(DESCRIPTION=(ADDRESS_LIST="+addresses+")(CONNECT_DATA=(SERVICE_NAME="+alias+")))", alias)
+}
+
+func TestCheckTNSEntries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+	go func() {
+		for {
+			c, e := l.Accept()
+			if e != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+	_, open, _ := net.SplitHostPort(l.Addr().String())
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	_ = closed.Close()
+
+	entries := TNSEntries{
+		"UP":    checkEntry("UP", open),
+		"DOWN":  checkEntry("DOWN", closedPort),
+		"MIXED": checkEntry("MIXED", closedPort, open),
+		"EMPTY": {Name: "EMPTY", Desc: "(DESCRIPTION=)"},
+	}
+
+	t.Run("TCP", func(t *testing.T) {
+		reports := CheckTNSEntries(context.Background(), entries, TNSCheckOptions{Timeout: time.Second})
+		require.Len(t, reports, 4)
+		assert.Equal(t, []string{"DOWN", "EMPTY", "MIXED", "UP"}, []string{reports[0].Alias, reports[1].Alias, reports[2].Alias, reports[3].Alias})
+		down, empty, mixed, up := reports[0], reports[1], reports[2], reports[3]
+		assert.False(t, down.OK())
+		require.Len(t, down.Addresses, 1)
+		assert.Error(t, down.Addresses[0].TCP.Err)
+		assert.ErrorContains(t, empty.Err, "no server address")
+		assert.True(t, mixed.OK())
+		require.Len(t, mixed.Addresses, 2)
+		assert.False(t, mixed.Addresses[0].TCP.OK())
+		assert.True(t, mixed.Addresses[1].TCP.OK())
+		assert.True(t, up.OK())
+		assert.Equal(t, "127.0.0.1", up.Addresses[0].Server.IP)
+		assert.Equal(t, "skipped", up.Addresses[0].Listener.String())
+		assert.False(t, up.Login.Done)
+	})
+	t.Run("Listener", func(t *testing.T) {
+		var calls, running, maxRunning int32
+		var mu sync.Mutex
+		ping := func(ctx context.Context, address string, service string) error {
+			atomic.AddInt32(&calls, 1)
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			mu.Lock()
+			if n > maxRunning {
+				maxRunning = n
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			if service == "MIXED" {
+				return errors.New("ORA-12514")
+			}
+			assert.Equal(t, net.JoinHostPort("127.0.0.1", open), address)
+			return nil
+		}
+		reports := CheckTNSEntries(context.Background(), entries, TNSCheckOptions{Workers: 1, ListenerPing: ping})
+		assert.Equal(t, int32(2), calls, "only reachable addresses are pinged")
+		assert.Equal(t, int32(1), maxRunning)
+		assert.False(t, reports[2].OK())
+		assert.ErrorContains(t, reports[2].Addresses[1].Listener.Err, "ORA-12514")
+		assert.True(t, reports[3].OK())
+		assert.True(t, reports[3].Addresses[0].Listener.OK())
+	})
+	t.Run("Login", func(t *testing.T) {
+		var asked []string
+		var mu sync.Mutex
+		credentials := func(alias string) (string, string, error) {
+			mu.Lock()
+			asked = append(asked, alias)
+			mu.Unlock()
+			if alias == "MIXED" {
+				return "", "", errors.New("not found")
+			}
+			return "", "", nil
+		}
+		reports := CheckTNSEntries(context.Background(), entries, TNSCheckOptions{Credentials: credentials})
+		assert.ElementsMatch(t, []string{"DOWN", "MIXED", "UP"}, asked, "no login without address")
+		assert.ErrorContains(t, reports[2].Login.Err, "no credentials")
+		assert.False(t, reports[2].OK())
+		assert.False(t, reports[3].Login.Done, "empty user skips login")
+		assert.True(t, reports[3].OK())
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		reports := CheckTNSEntries(ctx, entries, TNSCheckOptions{})
+		require.Len(t, reports, 4)
+		for _, r := range reports {
+			assert.Falsef(t, r.OK(), "%s must fail", r.Alias)
+		}
+		assert.ErrorIs(t, reports[3].Err, context.Canceled)
+	})
+}
@@ -0,0 +1,277 @@
+package dblib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// TNSDescriptor is the typed form of a connect descriptor with one or more descriptions
+type TNSDescriptor struct {
+	Descriptions []TNSDescription
+	// LoadBalance and Failover of a DESCRIPTION_LIST
+	LoadBalance string
+	Failover    string
+	// Node is the parsed tree the descriptor was built from
+	Node *NVPair
+}
+
+// TNSDescription holds one DESCRIPTION section
+type TNSDescription struct {
+	// AddressLists holds the ADDRESS_LIST sections
+	AddressLists []TNSAddressList
+	// Addresses holds ADDRESS sections given directly in the description
+	Addresses   []TNSAddress
+	ConnectData TNSConnectData
+	Security    TNSSecurity
+	LoadBalance string
+	Failover    string
+	// Params holds other values like CONNECT_TIMEOUT or RETRY_COUNT with upper case names
+	Params map[string]string
+}
+
+// TNSAddressList holds one ADDRESS_LIST section
+type TNSAddressList struct {
+	Addresses   []TNSAddress
+	LoadBalance string
+	Failover    string
+	SourceRoute string
+}
+
+// TNSConnectData holds the CONNECT_DATA section
+type TNSConnectData struct {
+	ServiceName  string
+	SID          string
+	InstanceName string
+	Server       string
+	// Params holds other values with upper case names, nested sections like FAILOVER_MODE in compact form
+	Params map[string]string
+}
+
+// TNSSecurity holds the SECURITY section
+type TNSSecurity struct {
+	SSLServerCertDN  string
+	SSLServerDNMatch string
+	WalletLocation   string
+	// Params holds other values with upper case names
+	Params map[string]string
+}
+
+// ParseTNSDescriptor parses a connect descriptor text like (DESCRIPTION=...)
+func ParseTNSDescriptor(desc string) (*TNSDescriptor, error) {
+	n, err := ParseNVPair(desc)
+	if err != nil {
+		return nil, err
+	}
+	return NewTNSDescriptor(n)
+}
+
+// NewTNSDescriptor builds a descriptor from a DESCRIPTION_LIST or DESCRIPTION tree
+func NewTNSDescriptor(n *NVPair) (d *TNSDescriptor, err error) {
+	d = &TNSDescriptor{Node: n}
+	var descriptions []*NVPair
+	switch strings.ToUpper(n.Name) {
+	case "DESCRIPTION_LIST":
+		for _, c := range n.Children {
+			switch strings.ToUpper(c.Name) {
+			case "DESCRIPTION":
+				descriptions = append(descriptions, c)
+			case "LOAD_BALANCE":
+				d.LoadBalance = c.Value
+			case "FAILOVER":
+				d.Failover = c.Value
+			default:
+				return nil, nvErrorf(c, "unexpected %s in DESCRIPTION_LIST", c.Name)
+			}
+		}
+	case "DESCRIPTION":
+		descriptions = []*NVPair{n}
+	default:
+		return nil, nvErrorf(n, "expected DESCRIPTION or DESCRIPTION_LIST, found %s", n.Name)
+	}
+	if len(descriptions) == 0 {
+		return nil, nvErrorf(n, "no DESCRIPTION found")
+	}
+	for _, dn := range descriptions {
+		var desc TNSDescription
+		if desc, err = newTNSDescription(dn); err != nil {
+			return nil, err
+		}
+		d.Descriptions = append(d.Descriptions, desc)
+	}
+	return
+}
+
+func newTNSDescription(n *NVPair) (d TNSDescription, err error) {
+	if len(n.Children) == 0 {
+		return d, nvErrorf(n, "DESCRIPTION has no parameters")
+	}
+	d.Params = make(map[string]string)
+	for _, c := range n.Children {
+		switch name := strings.ToUpper(c.Name); name {
+		case "ADDRESS_LIST":
+			var al TNSAddressList
+			if al, err = newTNSAddressList(c); err != nil {
+				return
+			}
+			d.AddressLists = append(d.AddressLists, al)
+		case "ADDRESS":
+			var a TNSAddress
+			if a, err = newTNSAddress(c); err != nil {
+				return
+			}
+			d.Addresses = append(d.Addresses, a)
+		case "CONNECT_DATA":
+			if d.ConnectData, err = newTNSConnectData(c); err != nil {
+				return
+			}
+		case "SECURITY":
+			if d.Security, err = newTNSSecurity(c); err != nil {
+				return
+			}
+		case "LOAD_BALANCE":
+			d.LoadBalance = c.Value
+		case "FAILOVER":
+			d.Failover = c.Value
+		default:
+			d.Params[name] = nvParamValue(c)
+		}
+	}
+	if len(d.AddressLists) == 0 && len(d.Addresses) == 0 {
+		return d, nvErrorf(n, "DESCRIPTION has no ADDRESS")
+	}
+	return
+}
+
+func newTNSAddressList(n *NVPair) (l TNSAddressList, err error) {
+	for _, c := range n.Children {
+		switch strings.ToUpper(c.Name) {
+		case "ADDRESS":
+			var a TNSAddress
+			if a, err = newTNSAddress(c); err != nil {
+				return
+			}
+			l.Addresses = append(l.Addresses, a)
+		case "LOAD_BALANCE":
+			l.LoadBalance = c.Value
+		case "FAILOVER":
+			l.Failover = c.Value
+		case "SOURCE_ROUTE":
+			l.SourceRoute = c.Value
+		default:
+			return l, nvErrorf(c, "unexpected %s in ADDRESS_LIST", c.Name)
+		}
+	}
+	if len(l.Addresses) == 0 {
+		return l, nvErrorf(n, "ADDRESS_LIST has no ADDRESS")
+	}
+	return
+}
+
+func newTNSAddress(n *NVPair) (a TNSAddress, err error) {
+	if len(n.Children) == 0 {
+		return a, nvErrorf(n, "ADDRESS has no parameters")
+	}
+	for _, c := range n.Children {
+		switch name := strings.ToUpper(c.Name); name {
+		case "PROTOCOL":
+			a.Protocol = c.Value
+		case "HOST":
+			a.Host = c.Value
+		case "PORT":
+			if _, e := strconv.ParseUint(c.Value, 10, 16); e != nil {
+				return a, nvErrorf(c, "invalid PORT %q", c.Value)
+			}
+			a.Port = c.Value
+		default:
+			if a.Params == nil {
+				a.Params = make(map[string]string)
+			}
+			a.Params[name] = nvParamValue(c)
+		}
+	}
+	if a.Protocol == "" {
+		return a, nvErrorf(n, "ADDRESS has no PROTOCOL")
+	}
+	return
+}
+
+func newTNSConnectData(n *NVPair) (cd TNSConnectData, err error) {
+	cd.Params = make(map[string]string)
+	for _, c := range n.Children {
+		switch name := strings.ToUpper(c.Name); name {
+		case "SERVICE_NAME":
+			cd.ServiceName = c.Value
+		case "SID":
+			cd.SID = c.Value
+		case "INSTANCE_NAME":
+			cd.InstanceName = c.Value
+		case "SERVER":
+			cd.Server = c.Value
+		default:
+			cd.Params[name] = nvParamValue(c)
+		}
+	}
+	if len(n.Children) == 0 && n.Value != "" {
+		return cd, nvErrorf(n, "CONNECT_DATA must contain parameters")
+	}
+	return
+}
+
+func newTNSSecurity(n *NVPair) (s TNSSecurity, err error) {
+	s.Params = make(map[string]string)
+	for _, c := range n.Children {
+		switch name := strings.ToUpper(c.Name); name {
+		case "SSL_SERVER_CERT_DN":
+			s.SSLServerCertDN = c.Value
+		case "SSL_SERVER_DN_MATCH":
+			s.SSLServerDNMatch = c.Value
+		case "MY_WALLET_DIRECTORY", "WALLET_LOCATION":
+			s.WalletLocation = c.Value
+		default:
+			s.Params[name] = nvParamValue(c)
+		}
+	}
+	if len(n.Children) == 0 && n.Value != "" {
+		return s, nvErrorf(n, "SECURITY must contain parameters")
+	}
+	return
+}
+
+// nvParamValue returns the value of a leaf, a list joined by comma or nested pairs in compact form
+func nvParamValue(n *NVPair) string {
+	switch {
+	case len(n.Children) > 0:
+		var sb strings.Builder
+		for _, c := range n.Children {
+			c.write(&sb)
+		}
+		return sb.String()
+	case len(n.List) > 0:
+		return strings.Join(n.List, ",")
+	}
+	return n.Value
+}
+
+// Addresses returns all addresses of all descriptions in order
+func (d *TNSDescriptor) Addresses() (addresses []TNSAddress) {
+	for _, desc := range d.Descriptions {
+		addresses = append(addresses, desc.Addresses...)
+		for _, l := range desc.AddressLists {
+			addresses = append(addresses, l.Addresses...)
+		}
+	}
+	return
+}
+
+// Service returns the first SERVICE_NAME or SID
+func (d *TNSDescriptor) Service() string {
+	for _, desc := range d.Descriptions {
+		if desc.ConnectData.ServiceName != "" {
+			return desc.ConnectData.ServiceName
+		}
+		if desc.ConnectData.SID != "" {
+			return desc.ConnectData.SID
+		}
+	}
+	return ""
+}
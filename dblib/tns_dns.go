@@ -128,7 +128,7 @@ func getServiceList(host string, port string) (services ServiceEntries) {
 	}
 
 	// set resolver network ip =ipv4+ipv6 or ip4 only
-	if IPv4Only {
+	if IPv4Only && !DNSConfig.IPv4Only {
 		DNSConfig.IPv4Only = true
 	}
 
@@ -2,14 +2,9 @@ package dblib
 
 import (
 	"fmt"
-	"path"
-	"strconv"
-	"strings"
 
 	"github.com/tommi2day/gomodules/ldaplib"
 
-	"gopkg.in/ini.v1"
-
 	"github.com/go-ldap/ldap/v3"
 	log "github.com/sirupsen/logrus"
 )
@@ -62,44 +57,13 @@ func ReadLdapTns(lc *ldaplib.LdapConfigType, contextDN string) (TNSEntries, erro
 
 // ReadLdapOra Reads ldap ora and returns servers and context
 func ReadLdapOra(filePath string) (ctx string, servers []LdapServer) {
-	filename := path.Join(filePath, ldapora)
-	ctx = ""
-	host := ""
-	port := 0
-	ssl := 0
-	log.Debugf("Try to read ldap.ora at %s", filename)
-	cfg, err := ini.InsensitiveLoad(filename)
+	c, err := ReadLdapOraConfig(filePath)
 	if err != nil {
-		log.Debugf("Cannot Read ldap.ora at %s", filename)
+		log.Debugf("Cannot read ldap.ora in %s: %v", filePath, err)
 		return
 	}
-	ctx = cfg.Section("").Key(strings.ToLower("DEFAULT_ADMIN_CONTEXT")).String()
-	srv := cfg.Section("").Key(strings.ToLower("DIRECTORY_SERVERS")).String()
-	replacer := strings.NewReplacer("(", "", ")", "", " ", "")
-	srv = replacer.Replace(srv)
-	srvs := strings.Split(srv, ",")
-	for _, e := range srvs {
-		if !strings.Contains(e, ":") {
-			continue
-		}
-		f := strings.Split(e, ":")
-		if len(f) < 2 {
-			continue
-		}
-		host = f[0]
-		port, _ = strconv.Atoi(f[1])
-		if strings.TrimSpace(host) == "" {
-			continue
-		}
-		ssl = 0
-		if len(f) > 2 {
-			ssl, _ = strconv.Atoi(f[2])
-		}
-		server := LdapServer{Hostname: host, Port: port, SSLPort: ssl}
-		servers = append(servers, server)
-	}
-	log.Debugf("ReadLdapOra CTX: %s, Servers %v", ctx, servers)
-	return
+	log.Debugf("ReadLdapOra CTX: %s, Servers %v", c.DefaultAdminContext, c.Servers)
+	return c.DefaultAdminContext, c.Servers
 }
 
 // GetOracleContext retrieve next OracleContext Object from LDAP
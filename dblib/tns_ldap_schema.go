@@ -0,0 +1,101 @@
+package dblib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/tommi2day/gomodules/ldaplib"
+)
+
+// OracleNetSchemaDN is the cn=config entry of OracleNetSchemaLDIF
+const OracleNetSchemaDN = "cn=oraclenet,cn=schema,cn=config"
+
+// OracleNetSchemaLDIF defines orclContext, orclNetService and orclNetServiceAlias with the Oracle
+// Internet Directory OIDs for OpenLDAP servers with cn=config
+const OracleNetSchemaLDIF = `dn: ` + OracleNetSchemaDN + `
+changetype: add
+objectClass: olcSchemaConfig
+cn: oraclenet
+olcAttributeTypes: {0}( 2.16.840.1.113894.7.1.1 NAME 'orclVersion' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )
+olcAttributeTypes: {1}( 2.16.840.1.113894.3.1.12 NAME 'orclNetDescName' EQUALITY distinguishedNameMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 SINGLE-VALUE )
+olcAttributeTypes: {2}( 2.16.840.1.113894.3.1.13 NAME 'orclNetDescString' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )
+olcObjectClasses: {0}( 2.16.840.1.113894.7.2.3 NAME 'orclContext' SUP top STRUCTURAL MUST cn )
+olcObjectClasses: {1}( 2.16.840.1.113894.3.2.5 NAME 'orclNetService' SUP top STRUCTURAL MUST cn MAY ( orclNetDescName $ orclNetDescString $ orclVersion $ description ) )
+olcObjectClasses: {2}( 2.16.840.1.113894.3.2.6 NAME 'orclNetServiceAlias' SUP alias STRUCTURAL MUST cn )
+`
+
+// oracleNetClasses must be known by the server for LDAP TNS naming
+var oracleNetClasses = []string{"orclContext", "orclNetService", "orclNetServiceAlias"}
+
+// OracleContextLDIF returns the LDIF to add cn=OracleContext under baseDN
+func OracleContextLDIF(baseDN string) string {
+	return fmt.Sprintf("dn: cn=OracleContext,%s\nchangetype: add\nobjectClass: top\nobjectClass: orclContext\ncn: OracleContext\n", baseDN)
+}
+
+// MissingOracleNetClasses returns the object classes of OracleNetSchemaLDIF unknown in the subschema of the server
+func MissingOracleNetClasses(lc *ldaplib.LdapConfigType) (missing []string, err error) {
+	if lc == nil || lc.Conn == nil {
+		return nil, fmt.Errorf("ldap connection is nil")
+	}
+	result, err := lc.Search("cn=Subschema", "(objectClass=subschema)", []string{"objectClasses"}, ldap.ScopeBaseObject, ldap.NeverDerefAliases)
+	if err != nil {
+		return nil, fmt.Errorf("subschema search returned error:%v", err)
+	}
+	known := make(map[string]bool)
+	for _, e := range result {
+		for _, v := range e.GetEqualFoldAttributeValues("objectClasses") {
+			for _, c := range oracleNetClasses {
+				if strings.Contains(strings.ToLower(v), "name '"+strings.ToLower(c)+"'") {
+					known[c] = true
+				}
+			}
+		}
+	}
+	for _, c := range oracleNetClasses {
+		if !known[c] {
+			missing = append(missing, c)
+		}
+	}
+	return
+}
+
+// ApplyOracleNetSchema loads OracleNetSchemaLDIF if any of its object classes is missing,
+// lc must be bound with write access to cn=config
+func ApplyOracleNetSchema(lc *ldaplib.LdapConfigType) (err error) {
+	missing, err := MissingOracleNetClasses(lc)
+	if err != nil {
+		return
+	}
+	if len(missing) == 0 {
+		log.Debug("Oracle Net schema already available")
+		return
+	}
+	if len(missing) < len(oracleNetClasses) {
+		return fmt.Errorf("oracle net schema partially available, missing %s", strings.Join(missing, ", "))
+	}
+	log.Infof("Apply Oracle Net schema %s", OracleNetSchemaDN)
+	return lc.ApplyLDIF(OracleNetSchemaLDIF, false)
+}
+
+// CreateOracleContext adds cn=OracleContext under baseDN if it does not exist and returns its DN
+func CreateOracleContext(lc *ldaplib.LdapConfigType, baseDN string) (contextDN string, err error) {
+	if lc == nil || lc.Conn == nil {
+		return "", fmt.Errorf("ldap connection is nil")
+	}
+	contextDN = "cn=OracleContext," + baseDN
+	result, err := lc.Search(baseDN, "(&(objectClass=orclContext)(cn=OracleContext))", []string{"cn"}, ldap.ScopeSingleLevel, ldap.NeverDerefAliases)
+	if err != nil {
+		return "", fmt.Errorf("context search returned error:%v", err)
+	}
+	if len(result) > 0 {
+		log.Debugf("Oracle Context %s exists", result[0].DN)
+		return result[0].DN, nil
+	}
+	if err = lc.ApplyLDIF(OracleContextLDIF(baseDN), false); err != nil {
+		return "", err
+	}
+	log.Infof("Created Oracle Context %s", contextDN)
+	return
+}
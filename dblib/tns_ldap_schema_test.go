@@ -0,0 +1,39 @@
+package dblib
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldif"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/ldaplib"
+)
+
+func TestOracleNetSchema(t *testing.T) {
+	l, err := ldif.Parse(OracleNetSchemaLDIF)
+	require.NoError(t, err)
+	require.Len(t, l.Entries, 1)
+	add := l.Entries[0].Add
+	require.NotNil(t, add)
+	assert.Equal(t, OracleNetSchemaDN, add.DN)
+	var classes []string
+	for _, a := range add.Attributes {
+		if a.Type == "olcObjectClasses" {
+			classes = append(classes, a.Vals...)
+		}
+	}
+	require.Len(t, classes, len(oracleNetClasses))
+	for i, c := range oracleNetClasses {
+		assert.Contains(t, classes[i], "NAME '"+c+"'")
+	}
+
+	l, err = ldif.Parse(OracleContextLDIF("dc=oracle,dc=local"))
+	require.NoError(t, err)
+	require.Len(t, l.Entries, 1)
+	assert.Equal(t, "cn=OracleContext,dc=oracle,dc=local", l.Entries[0].Add.DN)
+
+	lc := ldaplib.NewConfig("localhost", 0, false, false, "dc=oracle,dc=local", ldapTimeout)
+	assert.ErrorContains(t, ApplyOracleNetSchema(lc), "ldap connection is nil")
+	_, err = CreateOracleContext(lc, "dc=oracle,dc=local")
+	assert.ErrorContains(t, err, "ldap connection is nil")
+}
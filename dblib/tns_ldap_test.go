@@ -3,6 +3,7 @@ package dblib
 import (
 	"fmt"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/tommi2day/gomodules/common"
@@ -74,6 +75,19 @@ func TestOracleLdap(t *testing.T) {
 		}
 	})
 
+	t.Run("Parse multiline ldap.ora", func(t *testing.T) {
+		err = common.WriteStringToFile(ldapAdmin+"/ldap.ora", `# directory naming
+default_admin_context = "dc=oracle,dc=local" # inline comment
+DIRECTORY_SERVERS =
+  (oid1.local:389:636,
+   oid2.local:389)
+`)
+		require.NoErrorf(t, err, "Create test ldap.ora failed")
+		oraclecontext, ldapservers := ReadLdapOra(ldapAdmin)
+		assert.Equal(t, "dc=oracle,dc=local", oraclecontext)
+		assert.Equal(t, []LdapServer{{Hostname: "oid1.local", Port: 389, SSLPort: 636}, {Hostname: "oid2.local", Port: 389}}, ldapservers)
+	})
+
 	err = common.WriteStringToFile(ldapAdmin+"/ldap.ora", ldaporaOK)
 	require.NoErrorf(t, err, "Create test ldap.ora failed")
 	t.Run("Parse ldap.ora", func(t *testing.T) {
@@ -123,6 +137,14 @@ func TestOracleLdap(t *testing.T) {
 		}
 	})
 
+	t.Run("Oracle Context Bootstrap", func(t *testing.T) {
+		missing, e := MissingOracleNetClasses(lc)
+		require.NoErrorf(t, e, "Subschema search failed: %v", e)
+		assert.Empty(t, missing, "schema loaded by container")
+		ctx, e := CreateOracleContext(lc, base)
+		require.NoErrorf(t, e, "Create Oracle Context failed: %v", e)
+		assert.Equal(t, "cn=OracleContext,"+LdapBaseDn, ctx, "existing context returned")
+	})
 	t.Run("Get Oracle Context", func(t *testing.T) {
 		context, err = GetOracleContext(lc, base)
 		expected := "cn=OracleContext," + LdapBaseDn
@@ -191,4 +213,24 @@ func TestOracleLdap(t *testing.T) {
 		err = DeleteLdapTNSEntry(lc, dn, alias)
 		require.NoErrorf(t, err, "Delete TNS from Ldap failed: %s", err)
 	})
+	t.Run("Sync TNS", func(t *testing.T) {
+		filename := path.Join(ldapAdmin, "sync_tnsnames.ora")
+		err = common.WriteStringToFile(filename, syncTnsnames)
+		require.NoErrorf(t, err, "Create test tnsnames.ora failed")
+		opts := TNSSyncOptions{Domain: "local", Delete: true, DryRun: true}
+		plan, _, err := SyncTNSLdap(lc, context, filename, SyncFileToLdap, opts)
+		require.NoErrorf(t, err, "Sync plan failed: %s", err)
+		assert.Len(t, plan.Ops, 3)
+		t.Logf("Plan:\n%s", plan)
+		opts.DryRun = false
+		_, summary, err := SyncTNSLdap(lc, context, filename, SyncFileToLdap, opts)
+		require.NoErrorf(t, err, "Sync to Ldap failed: %s", err)
+		assert.Equal(t, 3, summary.Added)
+		objects, err := ReadLdapTNSObjects(lc, context)
+		require.NoErrorf(t, err, "Ldap Read returned error:%v", err)
+		assert.Len(t, objects, 3)
+		plan, _, err = SyncTNSLdap(lc, context, filename, SyncLdapToFile, opts)
+		require.NoErrorf(t, err, "Sync to file failed: %s", err)
+		assert.True(t, plan.Empty(), "file and Ldap in sync")
+	})
 }
@@ -0,0 +1,419 @@
+package dblib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NVPair is a node of an Oracle Net name-value pair tree like (NAME=value) or (NAME=(CHILD=value)...)
+type NVPair struct {
+	Name string
+	// Value is the atom value of a leaf
+	Value string
+	// List holds the values of a comma separated list like (TNSNAMES,EZCONNECT)
+	List []string
+	// Children are the nested pairs
+	Children []*NVPair
+	// Comments are the # comment lines before the pair, EndComments the ones before its closing parenthesis
+	Comments    []string
+	EndComments []string
+	// Line and Column of the name, starting with 1
+	Line   int
+	Column int
+}
+
+// NVParseError is a syntax or structure error with its position in the parsed text
+type NVParseError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+// Error returns the message with position
+func (e *NVParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// nvErrorf returns a NVParseError at the position of a node
+func nvErrorf(n *NVPair, format string, args ...interface{}) *NVParseError {
+	return &NVParseError{Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)}
+}
+
+type nvTokenType int
+
+const (
+	nvEOF nvTokenType = iota
+	nvLParen
+	nvRParen
+	nvEqual
+	nvComma
+	nvAtom
+)
+
+var nvTokenNames = map[nvTokenType]string{
+	nvEOF:    "end of input",
+	nvLParen: "'('",
+	nvRParen: "')'",
+	nvEqual:  "'='",
+	nvComma:  "','",
+	nvAtom:   "value",
+}
+
+type nvToken struct {
+	typ    nvTokenType
+	value  string
+	line   int
+	column int
+	// offset of the first byte and after the last byte in the input
+	start int
+	end   int
+	// comments are the # comments before the token
+	comments []string
+}
+
+// nvTokenize splits NV text into tokens, comments from # to the end of the line are attached to the next token
+func nvTokenize(s string) (tokens []nvToken, err error) {
+	line, col := 1, 1
+	endLine, endCol := 1, 1
+	var comments []string
+	i := 0
+	advance := func() {
+		if s[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+		i++
+	}
+	for i < len(s) {
+		c := s[i]
+		tok := nvToken{line: line, column: col, start: i}
+		switch {
+		case c == ' ', c == '\t', c == '\r', c == '\n':
+			advance()
+			continue
+		case c == '#':
+			begin := i
+			for i < len(s) && s[i] != '\n' {
+				advance()
+			}
+			comments = append(comments, strings.TrimSpace(s[begin:i]))
+			continue
+		case c == '(':
+			tok.typ = nvLParen
+			advance()
+		case c == ')':
+			tok.typ = nvRParen
+			advance()
+		case c == '=':
+			tok.typ = nvEqual
+			advance()
+		case c == ',':
+			tok.typ = nvComma
+			advance()
+		case c == '"', c == '\'':
+			tok.typ = nvAtom
+			advance()
+			begin := i
+			for i < len(s) && s[i] != c {
+				advance()
+			}
+			if i >= len(s) {
+				return nil, &NVParseError{Line: tok.line, Column: tok.column, Msg: "unterminated quoted value"}
+			}
+			tok.value = s[begin:i]
+			advance()
+		default:
+			tok.typ = nvAtom
+			var sb strings.Builder
+			for i < len(s) && !strings.ContainsRune(" \t\r\n()=,#\"'", rune(s[i])) {
+				// a backslash escapes the next character
+				if s[i] == '\\' && i+1 < len(s) {
+					advance()
+				}
+				sb.WriteByte(s[i])
+				advance()
+			}
+			tok.value = sb.String()
+		}
+		tok.end = i
+		tok.comments, comments = comments, nil
+		tokens = append(tokens, tok)
+		endLine, endCol = line, col
+	}
+	// end of input is reported behind the last token
+	tokens = append(tokens, nvToken{typ: nvEOF, line: endLine, column: endCol, start: len(s), end: len(s), comments: comments})
+	return
+}
+
+type nvParser struct {
+	tokens []nvToken
+	pos    int
+	// comments of the consumed tokens not yet attached to a pair
+	comments []string
+}
+
+func (p *nvParser) peek(n int) nvToken {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *nvParser) next() nvToken {
+	t := p.peek(0)
+	if p.pos < len(p.tokens)-1 {
+		p.comments = append(p.comments, t.comments...)
+		p.pos++
+	}
+	return t
+}
+
+// takeComments returns and clears the comments of the consumed tokens
+func (p *nvParser) takeComments() (comments []string) {
+	comments, p.comments = p.comments, nil
+	return
+}
+
+func (p *nvParser) expect(typ nvTokenType) (nvToken, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, p.unexpected(t, nvTokenNames[typ])
+	}
+	return t, nil
+}
+
+func (p *nvParser) unexpected(t nvToken, want string) error {
+	got := nvTokenNames[t.typ]
+	if t.typ == nvAtom {
+		got = fmt.Sprintf("%q", t.value)
+	}
+	return &NVParseError{Line: t.line, Column: t.column, Msg: fmt.Sprintf("expected %s, found %s", want, got)}
+}
+
+// pair parses (NAME=value)
+func (p *nvParser) pair() (*NVPair, error) {
+	if _, err := p.expect(nvLParen); err != nil {
+		return nil, err
+	}
+	name, err := p.expect(nvAtom)
+	if err != nil {
+		return nil, err
+	}
+	n := &NVPair{Name: name.value, Line: name.line, Column: name.column}
+	if _, err = p.expect(nvEqual); err != nil {
+		return nil, err
+	}
+	n.Comments = p.takeComments()
+	if err = p.value(n); err != nil {
+		return nil, err
+	}
+	if _, err = p.expect(nvRParen); err != nil {
+		return nil, err
+	}
+	n.EndComments = p.takeComments()
+	return n, nil
+}
+
+// isPairStart reports if the next tokens are ( NAME =
+func (p *nvParser) isPairStart() bool {
+	return p.peek(0).typ == nvLParen && p.peek(1).typ == nvAtom && p.peek(2).typ == nvEqual
+}
+
+// value parses the nested pairs, the atom or the list after NAME=
+func (p *nvParser) value(n *NVPair) error {
+	switch t := p.peek(0); t.typ {
+	case nvLParen:
+		if !p.isPairStart() {
+			// parenthesized list (a, b)
+			p.next()
+			list, err := p.list(false)
+			if err != nil {
+				return err
+			}
+			n.List = list
+			_, err = p.expect(nvRParen)
+			return err
+		}
+		for p.isPairStart() {
+			child, err := p.pair()
+			if err != nil {
+				return err
+			}
+			n.Children = append(n.Children, child)
+		}
+		if t = p.peek(0); t.typ == nvLParen {
+			return p.unexpected(p.peek(1), "parameter name")
+		}
+	case nvAtom:
+		list, err := p.list(true)
+		if err != nil {
+			return err
+		}
+		if len(list) == 1 {
+			n.Value = list[0]
+		} else {
+			n.List = list
+		}
+	}
+	// an empty value is accepted
+	return nil
+}
+
+// list parses one or more comma separated values, if words is set adjacent words are joined with a blank
+func (p *nvParser) list(words bool) (list []string, err error) {
+	for {
+		var parts []string
+		for p.peek(0).typ == nvAtom {
+			if len(parts) > 0 && !words {
+				// (NAME VALUE) is a pair with missing =
+				return nil, p.unexpected(p.peek(0), nvTokenNames[nvEqual])
+			}
+			parts = append(parts, p.next().value)
+		}
+		if len(parts) == 0 {
+			return nil, p.unexpected(p.peek(0), "value")
+		}
+		list = append(list, strings.Join(parts, " "))
+		if p.peek(0).typ != nvComma {
+			return
+		}
+		p.next()
+	}
+}
+
+// ParseNVPair parses a single parenthesized name-value pair tree like a connect descriptor
+func ParseNVPair(s string) (*NVPair, error) {
+	tokens, err := nvTokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &nvParser{tokens: tokens}
+	if t := p.peek(0); t.typ == nvEOF {
+		return nil, &NVParseError{Line: t.line, Column: t.column, Msg: "empty descriptor"}
+	}
+	n, err := p.pair()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek(0)
+	if t.typ != nvEOF {
+		return nil, p.unexpected(t, nvTokenNames[nvEOF])
+	}
+	// keep comments after the closing parenthesis with the pair
+	n.EndComments = append(n.EndComments, t.comments...)
+	return n, nil
+}
+
+// nvParsePairs parses a sequence of pairs optionally separated by commas as sent by a listener,
+// the pairs before a syntax error are returned with the error
+func nvParsePairs(s string) (pairs []*NVPair, err error) {
+	tokens, err := nvTokenize(s)
+	if err != nil {
+		return
+	}
+	p := &nvParser{tokens: tokens}
+	for {
+		for p.peek(0).typ == nvComma {
+			p.next()
+		}
+		if p.peek(0).typ == nvEOF {
+			return
+		}
+		var n *NVPair
+		if n, err = p.pair(); err != nil {
+			return
+		}
+		pairs = append(pairs, n)
+	}
+}
+
+// Get returns the first child with the given name, names are case-insensitive
+func (n *NVPair) Get(name string) *NVPair {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// All returns all children with the given name
+func (n *NVPair) All(name string) (pairs []*NVPair) {
+	if n == nil {
+		return
+	}
+	for _, c := range n.Children {
+		if strings.EqualFold(c.Name, name) {
+			pairs = append(pairs, c)
+		}
+	}
+	return
+}
+
+// Attr returns the value of the first child with the given name or an empty string
+func (n *NVPair) Attr(name string) string {
+	if c := n.Get(name); c != nil {
+		return c.Value
+	}
+	return ""
+}
+
+// String returns the pair in compact form without blanks
+func (n *NVPair) String() string {
+	var sb strings.Builder
+	n.write(&sb)
+	return sb.String()
+}
+
+func (n *NVPair) write(sb *strings.Builder) {
+	sb.WriteString("(" + n.Name + "=")
+	switch {
+	case len(n.Children) > 0:
+		for _, c := range n.Children {
+			c.write(sb)
+		}
+	case len(n.List) > 0:
+		sb.WriteString("(" + nvQuoteList(n.List) + ")")
+	default:
+		sb.WriteString(nvQuote(n.Value))
+	}
+	sb.WriteString(")")
+}
+
+// nvSpecialChars are the characters a value can only hold quoted or escaped
+const nvSpecialChars = " \t\r\n()=,#\"'\\"
+
+// nvQuote quotes values with special characters
+func nvQuote(v string) string {
+	if !strings.ContainsAny(v, nvSpecialChars) {
+		return v
+	}
+	switch {
+	case !strings.Contains(v, `"`):
+		return `"` + v + `"`
+	case !strings.Contains(v, "'"):
+		return "'" + v + "'"
+	}
+	// quoted values cannot hold both quote characters, escape all special characters instead
+	var sb strings.Builder
+	for _, c := range v {
+		if strings.ContainsRune(nvSpecialChars, c) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func nvQuoteList(list []string) string {
+	q := make([]string, len(list))
+	for i, v := range list {
+		q[i] = nvQuote(v)
+	}
+	return strings.Join(q, ",")
+}
@@ -0,0 +1,129 @@
+package dblib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const descList = `(DESCRIPTION_LIST=
+  (LOAD_BALANCE=off)(FAILOVER=on)
+  # primary site
+  (DESCRIPTION=
+    (CONNECT_TIMEOUT=10)(RETRY_COUNT=3)
+    (ADDRESS_LIST=
+      (LOAD_BALANCE=on)
+      (ADDRESS=(PROTOCOL=TCP)(HOST=db1.example.com)(PORT=1521))
+      (ADDRESS=(PROTOCOL=TCP)(HOST=db2.example.com)(PORT=1521)))
+    (ADDRESS_LIST=
+      (ADDRESS=(PROTOCOL=TCPS)(HOST=db3.example.com)(PORT=2484)))
+    (CONNECT_DATA=(SERVER=dedicated)(SERVICE_NAME=sales.example.com)
+      (FAILOVER_MODE=(TYPE=select)(METHOD=basic)))
+    (SECURITY=(SSL_SERVER_CERT_DN="CN=db3,O=Example, C=DE")(SSL_SERVER_DN_MATCH=yes)))
+  (DESCRIPTION=
+    (ADDRESS=(PROTOCOL=ipc)(KEY=extproc))
+    (CONNECT_DATA=(SID=ORCL)(INSTANCE_NAME=orcl1))))`
+
+func TestTNSParser(t *testing.T) {
+	t.Run("Tree", func(t *testing.T) {
+		n, err := ParseNVPair(descList)
+		require.NoError(t, err)
+		assert.Equal(t, "DESCRIPTION_LIST", n.Name)
+		assert.Len(t, n.All("description"), 2)
+		assert.Equal(t, "on", n.Attr("failover"))
+		d := n.Get("DESCRIPTION")
+		require.NotNil(t, d)
+		assert.Equal(t, 4, d.Line)
+		assert.Equal(t, 4, d.Column)
+		assert.Nil(t, n.Get("missing"))
+		assert.Equal(t, "(SECURITY=(SSL_SERVER_CERT_DN=\"CN=db3,O=Example, C=DE\")(SSL_SERVER_DN_MATCH=yes))", d.Get("SECURITY").String())
+
+		n, err = ParseNVPair("(NAMES.DIRECTORY_PATH = (TNSNAMES, EZCONNECT , LDAP))")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"TNSNAMES", "EZCONNECT", "LDAP"}, n.List)
+		assert.Equal(t, "(NAMES.DIRECTORY_PATH=(TNSNAMES,EZCONNECT,LDAP))", n.String())
+		n, err = ParseNVPair(`(COMMENT = two words)`)
+		require.NoError(t, err)
+		assert.Equal(t, "two words", n.Value)
+		n, err = ParseNVPair(`(DIRECTORY=C:\\oracle\(x\))`)
+		require.NoError(t, err)
+		assert.Equal(t, `C:\oracle(x)`, n.Value)
+
+		// values with both quote characters are escaped
+		for _, v := range []string{`a"b'c d`, `it's "x"`, `\'"`} {
+			n = &NVPair{Name: "COMMENT", Value: v}
+			p, err := ParseNVPair(FormatNVPair(n, 0))
+			require.NoError(t, err, v)
+			assert.Equal(t, v, p.Value)
+			p, err = ParseNVPair(n.String())
+			require.NoError(t, err, v)
+			assert.Equal(t, v, p.Value)
+		}
+	})
+	t.Run("Descriptor", func(t *testing.T) {
+		d, err := ParseTNSDescriptor(descList)
+		require.NoError(t, err)
+		assert.Equal(t, "off", d.LoadBalance)
+		require.Len(t, d.Descriptions, 2)
+		first := d.Descriptions[0]
+		assert.Equal(t, "10", first.Params["CONNECT_TIMEOUT"])
+		require.Len(t, first.AddressLists, 2)
+		assert.Equal(t, "on", first.AddressLists[0].LoadBalance)
+		assert.Equal(t, TNSAddress{Protocol: "TCPS", Host: "db3.example.com", Port: "2484"}, first.AddressLists[1].Addresses[0])
+		assert.Equal(t, "sales.example.com", first.ConnectData.ServiceName)
+		assert.Equal(t, "dedicated", first.ConnectData.Server)
+		assert.Equal(t, "(TYPE=select)(METHOD=basic)", first.ConnectData.Params["FAILOVER_MODE"])
+		assert.Equal(t, "CN=db3,O=Example, C=DE", first.Security.SSLServerCertDN)
+		assert.Equal(t, "yes", first.Security.SSLServerDNMatch)
+		second := d.Descriptions[1]
+		assert.Equal(t, "ORCL", second.ConnectData.SID)
+		assert.Equal(t, "extproc", second.Addresses[0].Params["KEY"])
+		assert.Len(t, d.Addresses(), 4)
+		assert.Equal(t, "sales.example.com", d.Service())
+	})
+	t.Run("Errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			desc   string
+			line   int
+			column int
+			msg    string
+		}{
+			{"", 1, 1, "empty descriptor"},
+			{"(DESCRIPTION=\n  (ADDRESS=(PROTOCOL=TCP)(HOST=x)(PORT=1521))\n  (CONNECT_DATA=(SERVICE_NAME=x)", 3, 33, "expected ')', found end of input"},
+			{"(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=x)(PORT=15x21)))", 1, 46, `invalid PORT "15x21"`},
+			{"(DESCRIPTION=(ADDRESS=(HOST=x)(PORT=1521)))", 1, 15, "ADDRESS has no PROTOCOL"},
+			{"(DESCRIPTION=(CONNECT_DATA=(SID=x)))", 1, 2, "DESCRIPTION has no ADDRESS"},
+			{"(ADDRESS=(PROTOCOL=TCP))", 1, 2, "expected DESCRIPTION or DESCRIPTION_LIST"},
+			{"(DESCRIPTION=(ADDRESS_LIST=(HOST=x)))", 1, 29, "unexpected HOST in ADDRESS_LIST"},
+			{"(DESCRIPTION=(SECURITY=(SSL_SERVER_CERT_DN=\"CN=x)))", 1, 44, "unterminated quoted value"},
+			{"(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=x))) trailing", 1, 48, "expected end of input"},
+			{"(DESCRIPTION=(ADDRESS=(PROTOCOL TCP)))", 1, 33, "expected '='"},
+		} {
+			_, err := ParseTNSDescriptor(tc.desc)
+			require.Errorf(t, err, "no error for %q", tc.desc)
+			var pe *NVParseError
+			require.Truef(t, errors.As(err, &pe), "wrong error type for %q: %v", tc.desc, err)
+			assert.Equalf(t, tc.line, pe.Line, "line of %q: %v", tc.desc, err)
+			assert.Equalf(t, tc.column, pe.Column, "column of %q: %v", tc.desc, err)
+			assert.Containsf(t, pe.Msg, tc.msg, "message of %q", tc.desc)
+		}
+	})
+	t.Run("Entry", func(t *testing.T) {
+		e := BuildTnsEntry("test", descList, "SALES")
+		require.NotNil(t, e.Descriptor)
+		assert.Equal(t, "sales.example.com", e.Service)
+		assert.Len(t, e.Servers, 3, "addresses without host are no servers")
+		e = BuildTnsEntry("test", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=x)(PORT=1521))", "BROKEN")
+		assert.Nil(t, e.Descriptor)
+		assert.Empty(t, e.Service)
+		assert.Equal(t, []TNSAddress{{Host: "x", Port: "1521"}}, e.Servers, "servers matched in text")
+
+		// structural errors keep service and servers
+		e = BuildTnsEntry("test", "(DESCRIPTION=(ADDRESS=(HOST=db1)(PORT=1521))(ADDRESS=(PROTOCOL=TCP)(HOST=db2)(PORT=1522))(CONNECT_DATA=(SID=ORCL)))", "NOPROTO")
+		assert.Nil(t, e.Descriptor)
+		assert.Equal(t, "ORCL", e.Service)
+		assert.Equal(t, []TNSAddress{{Host: "db1", Port: "1521"}, {Protocol: "TCP", Host: "db2", Port: "1522"}}, e.Servers)
+	})
+}
@@ -0,0 +1,438 @@
+package dblib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TNS packet types
+const (
+	tnsConnect  = 1
+	tnsAccept   = 2
+	tnsRefuse   = 4
+	tnsRedirect = 5
+	tnsData     = 6
+	tnsResend   = 11
+	tnsMarker   = 12
+)
+
+// TNS protocol values of the probe connect packet
+const (
+	tnsProbeVersion   = 314
+	tnsProbeLoVersion = 300
+	tnsProbeSDU       = 8192
+	tnsProbeTDU       = 32767
+	// tnsConnectOffset is the offset of the connect data in the connect packet
+	tnsConnectOffset = 58
+	// tnsMaxConnectData is the largest connect data sent inside the connect packet
+	tnsMaxConnectData = 230
+	// tnsDataEOF is the data flag of the last data packet
+	tnsDataEOF    = 0x40
+	tnsMaxResends = 3
+	// tnsMaxPacket is the largest packet accepted, the probe negotiates a smaller SDU
+	tnsMaxPacket = 64 * 1024
+	// tnsMaxData is the largest response accepted from data packets
+	tnsMaxData = 1024 * 1024
+)
+
+// ListenerVersion is the VSNNUM sent with listener commands
+var ListenerVersion = 318767104
+
+// TNSProbeStatus classifies the listener response to a connect
+type TNSProbeStatus int
+
+// listener responses
+const (
+	ProbeAccepted TNSProbeStatus = iota + 1
+	ProbeRefused
+	ProbeRedirected
+)
+
+// String returns the name of the status
+func (s TNSProbeStatus) String() string {
+	switch s {
+	case ProbeAccepted:
+		return "accepted"
+	case ProbeRefused:
+		return "refused"
+	case ProbeRedirected:
+		return "redirected"
+	}
+	return "unknown"
+}
+
+// TNSProbeResult is the listener response to a connect
+type TNSProbeResult struct {
+	Status TNSProbeStatus
+	// Code is the error number sent with a refused connect
+	Code int
+	// Redirect is the address the client is redirected to
+	Redirect string
+	// Version is the TNS protocol version of an accepted connect
+	Version int
+	// Data is the text sent by the listener
+	Data string
+}
+
+// Err returns a ListenerError if the connect was refused
+func (r TNSProbeResult) Err() error {
+	if r.Status == ProbeRefused {
+		return &ListenerError{Code: r.Code, Data: r.Data}
+	}
+	return nil
+}
+
+// ListenerError is an error number returned by a listener
+type ListenerError struct {
+	Code int
+	Data string
+}
+
+var listenerMessages = map[int]string{
+	1169:  "TNS:The listener has not recognized the password",
+	1189:  "TNS:The listener could not authenticate the user",
+	12505: "TNS:listener does not currently know of SID given in connect descriptor",
+	12508: "TNS:listener could not resolve the COMMAND given",
+	12514: "TNS:listener does not currently know of service requested in connect descriptor",
+	12516: "TNS:listener could not find available handler with matching protocol stack",
+	12518: "TNS:listener could not hand off client connection",
+	12519: "TNS:no appropriate service handler found",
+	12520: "TNS:listener could not find available handler for requested type of server",
+	12521: "TNS:listener does not currently know of instance requested in connect descriptor",
+	12526: "TNS:listener: all appropriate instances are in restricted mode",
+	12528: "TNS:listener: all appropriate instances are blocking new connections",
+	12564: "TNS:connection refused",
+}
+
+// Error returns ORA-nnnnn or TNS-nnnnn with message
+func (e *ListenerError) Error() string {
+	prefix := "ORA"
+	if e.Code < 12000 {
+		prefix = "TNS"
+	}
+	msg, ok := listenerMessages[e.Code]
+	if !ok {
+		msg = "TNS:connection refused"
+	}
+	return fmt.Sprintf("%s-%05d: %s", prefix, e.Code, msg)
+}
+
+var reListenerErr = regexp.MustCompile(`(?i)\(\s*(?:ERR|CODE)\s*=\s*([0-9]+)\s*\)`)
+
+// listenerErrorCode returns the first non zero ERR or CODE value of a listener response
+func listenerErrorCode(data string) int {
+	for _, m := range reListenerErr.FindAllStringSubmatch(data, -1) {
+		if code, _ := strconv.Atoi(m[1]); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// tnsPacket returns a packet with 2 byte length header
+func tnsPacket(typ byte, flags byte, body []byte) []byte {
+	p := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint16(p, uint16(8+len(body)))
+	p[4] = typ
+	p[5] = flags
+	return append(p, body...)
+}
+
+// tnsConnectPackets returns the connect packet and a data packet if the connect data is too long
+func tnsConnectPackets(connectData string) (packets [][]byte) {
+	body := make([]byte, tnsConnectOffset-8)
+	binary.BigEndian.PutUint16(body[0:], tnsProbeVersion)
+	binary.BigEndian.PutUint16(body[2:], tnsProbeLoVersion)
+	binary.BigEndian.PutUint16(body[6:], tnsProbeSDU)
+	binary.BigEndian.PutUint16(body[8:], tnsProbeTDU)
+	binary.BigEndian.PutUint16(body[10:], 0x7f08)
+	binary.BigEndian.PutUint16(body[14:], 1)
+	binary.BigEndian.PutUint16(body[16:], uint16(len(connectData)))
+	binary.BigEndian.PutUint16(body[18:], tnsConnectOffset)
+	if len(connectData) <= tnsMaxConnectData {
+		return [][]byte{tnsPacket(tnsConnect, 0, append(body, connectData...))}
+	}
+	return [][]byte{
+		tnsPacket(tnsConnect, 0, body),
+		tnsPacket(tnsData, 0, append([]byte{0, 0}, connectData...)),
+	}
+}
+
+// readTNSPacket reads a whole packet, long selects the 4 byte length of version 315 and later
+func readTNSPacket(r io.Reader, long bool) (p []byte, err error) {
+	head := make([]byte, 8)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(head))
+	if long {
+		length = int(binary.BigEndian.Uint32(head))
+	}
+	if length < 8 || length > tnsMaxPacket {
+		return nil, fmt.Errorf("invalid TNS packet length %d", length)
+	}
+	p = make([]byte, length)
+	copy(p, head)
+	_, err = io.ReadFull(r, p[8:])
+	return
+}
+
+// tnsField returns the data at offset with length or an empty string if out of range
+func tnsField(p []byte, offset int, length int) string {
+	if offset < 0 || length <= 0 || offset+length > len(p) {
+		return ""
+	}
+	return string(p[offset : offset+length])
+}
+
+// probeCID returns the CID section identifying this program
+func probeCID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("(CID=(PROGRAM=%s)(HOST=%s)(USER=%s))", nvQuote(filepath.Base(os.Args[0])), nvQuote(host), nvQuote(os.Getenv("USER")))
+}
+
+// ProbeListener sends a connect with connectData to the listener at host:port and returns the response.
+// An accepted connect is closed at once, if collect is set the following data packets are read into Data
+func ProbeListener(ctx context.Context, address string, connectData string, collect bool) (res TNSProbeResult, err error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	// unblock reads and writes if the context ends
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	res, err = tnsExchange(conn, connectData, collect)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("probe %s: %w", address, ctx.Err())
+	}
+	log.Debugf("probe %s: %s %d %v", address, res.Status, res.Code, err)
+	return
+}
+
+// tnsExchange sends the connect packets and reads the response
+func tnsExchange(conn io.ReadWriter, connectData string, collect bool) (res TNSProbeResult, err error) {
+	packets := tnsConnectPackets(connectData)
+	send := func() error {
+		for _, p := range packets {
+			if _, e := conn.Write(p); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+	if err = send(); err != nil {
+		return
+	}
+	resends := 0
+	var p []byte
+	for {
+		if p, err = readTNSPacket(conn, false); err != nil {
+			return
+		}
+		switch p[4] {
+		case tnsResend:
+			if resends++; resends > tnsMaxResends {
+				return res, errors.New("too many resend requests")
+			}
+			if err = send(); err != nil {
+				return
+			}
+		case tnsMarker:
+			continue
+		case tnsRefuse:
+			if len(p) < 12 {
+				return res, errors.New("short refuse packet")
+			}
+			res.Status = ProbeRefused
+			res.Data = tnsField(p, 12, int(binary.BigEndian.Uint16(p[10:])))
+			res.Code = listenerErrorCode(res.Data)
+			if res.Code == 0 && !collect {
+				res.Code = 12564
+			}
+			return
+		case tnsRedirect:
+			if len(p) < 10 {
+				return res, errors.New("short redirect packet")
+			}
+			res.Status = ProbeRedirected
+			data := tnsField(p, 10, int(binary.BigEndian.Uint16(p[8:])))
+			if data == "" {
+				// the address follows in a data packet
+				if p, err = readTNSPacket(conn, false); err != nil {
+					return
+				}
+				data = tnsField(p, 10, len(p)-10)
+			}
+			res.Redirect, _, _ = strings.Cut(data, "\x00")
+			res.Data = data
+			return
+		case tnsAccept:
+			if len(p) < 24 {
+				return res, errors.New("short accept packet")
+			}
+			res.Status = ProbeAccepted
+			res.Version = int(binary.BigEndian.Uint16(p[8:]))
+			res.Data = tnsField(p, int(binary.BigEndian.Uint16(p[20:])), int(binary.BigEndian.Uint16(p[18:])))
+			if collect {
+				err = readTNSData(conn, res.Version >= 315, &res)
+			}
+			return
+		default:
+			return res, fmt.Errorf("unexpected TNS packet type %d", p[4])
+		}
+	}
+}
+
+// readTNSData appends the payload of data packets until the last one or the end of the connection
+func readTNSData(r io.Reader, long bool, res *TNSProbeResult) error {
+	var sb strings.Builder
+	sb.WriteString(res.Data)
+	defer func() {
+		res.Data = sb.String()
+	}()
+	for {
+		p, err := readTNSPacket(r, long)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p[4] != tnsData || len(p) < 10 {
+			continue
+		}
+		if sb.Len()+len(p)-10 > tnsMaxData {
+			return fmt.Errorf("listener response exceeds %d bytes", tnsMaxData)
+		}
+		sb.Write(p[10:])
+		if binary.BigEndian.Uint16(p[8:])&tnsDataEOF != 0 {
+			return nil
+		}
+	}
+}
+
+// probeDescriptor returns the connect descriptor for a probe
+func probeDescriptor(address string, connectData string) string {
+	host, port, _ := net.SplitHostPort(address)
+	return fmt.Sprintf("(DESCRIPTION=(CONNECT_DATA=%s%s)(ADDRESS=(PROTOCOL=TCP)(HOST=%s)(PORT=%s)))", connectData, probeCID(), host, port)
+}
+
+// ProbeService checks if the listener at host:port knows the service
+func ProbeService(ctx context.Context, address string, service string) (TNSProbeResult, error) {
+	return ProbeListener(ctx, address, probeDescriptor(address, "(SERVICE_NAME="+nvQuote(service)+")"), false)
+}
+
+// ProbeSID checks if the listener at host:port knows the SID
+func ProbeSID(ctx context.Context, address string, sid string) (TNSProbeResult, error) {
+	return ProbeListener(ctx, address, probeDescriptor(address, "(SID="+nvQuote(sid)+")"), false)
+}
+
+// ListenerPing is a ListenerPingFunc, it succeeds if the listener accepts or redirects a connect to the service
+func ListenerPing(ctx context.Context, address string, service string) error {
+	res, err := ProbeService(ctx, address, service)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// ListenerCommand sends a command like status, services or version to the listener and returns the answer
+func ListenerCommand(ctx context.Context, address string, command string) (data string, err error) {
+	cmd := fmt.Sprintf("(CONNECT_DATA=%s(COMMAND=%s)(ARGUMENTS=64)(SERVICE=LISTENER)(VERSION=%d))", probeCID(), command, ListenerVersion)
+	res, err := ProbeListener(ctx, address, "(DESCRIPTION="+cmd+")", true)
+	if err != nil {
+		return
+	}
+	data = res.Data
+	if code := listenerErrorCode(data); code != 0 {
+		err = &ListenerError{Code: code, Data: data}
+	}
+	return
+}
+
+// TNSListenerInstance is an instance of a listener service
+type TNSListenerInstance struct {
+	Name   string
+	Status string
+}
+
+// TNSListenerService is a service registered at the listener
+type TNSListenerService struct {
+	Name      string
+	Instances []TNSListenerInstance
+}
+
+// TNSListenerStatus is the answer of a listener to the status command
+type TNSListenerStatus struct {
+	Alias     string
+	Version   string
+	StartDate string
+	Uptime    string
+	Services  []TNSListenerService
+	// Pairs holds the parsed answer
+	Pairs []*NVPair
+}
+
+// ListenerStatus queries the status with the registered services, remote status may be forbidden by the listener
+func ListenerStatus(ctx context.Context, address string) (status *TNSListenerStatus, err error) {
+	data, err := ListenerCommand(ctx, address, "status")
+	if err != nil {
+		return
+	}
+	status = &TNSListenerStatus{}
+	status.Pairs, err = nvParsePairs(data)
+	if err != nil {
+		return status, fmt.Errorf("cannot parse listener status: %w", err)
+	}
+	var walk func(n *NVPair)
+	walk = func(n *NVPair) {
+		switch strings.ToUpper(n.Name) {
+		case "ALIAS":
+			if status.Alias == "" {
+				status.Alias = n.Value
+			}
+		case "VERSION":
+			if status.Version == "" {
+				status.Version = n.Value
+			}
+		case "START_DATE":
+			status.StartDate = n.Value
+		case "UPTIME":
+			status.Uptime = n.Value
+		case "SERVICE":
+			if name := n.Attr("SERVICE_NAME"); name != "" {
+				s := TNSListenerService{Name: name}
+				for _, i := range n.All("INSTANCE") {
+					s.Instances = append(s.Instances, TNSListenerInstance{Name: i.Attr("INSTANCE_NAME"), Status: i.Attr("INSTANCE_STATUS")})
+				}
+				status.Services = append(status.Services, s)
+				return
+			}
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	for _, n := range status.Pairs {
+		walk(n)
+	}
+	return
+}
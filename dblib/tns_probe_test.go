@@ -0,0 +1,215 @@
+package dblib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const listenerStatus = "(DESCRIPTION=(TMP=)(VSNNUM=318767104)(ERR=0)(ALIAS=LISTENER)(SECURITY=OFF)" +
+	"(VERSION=TNSLSNR for Linux: Version 19.0.0.0.0 - Production)(START_DATE=19-OCT-2026 08:00:00)(UPTIME=0 days 2 hr. 0 min. 5 sec)),," +
+	"(ENDPOINT=(HANDLER=(STA=ready)(DESCRIPTION=(ADDRESS=(PROTOCOL=tcp)(HOST=db)(PORT=1521))))),," +
+	"(SERVICE=(SERVICE_NAME=FREE)(INSTANCE=(INSTANCE_NAME=FREE)(NUM=1)(INSTANCE_STATUS=READY))),," +
+	"(SERVICE=(SERVICE_NAME=FREEPDB1)(INSTANCE=(INSTANCE_NAME=FREE)(NUM=1)(INSTANCE_STATUS=READY))),"
+
+// fakeListener answers connect packets like an Oracle listener
+func fakeListener(t *testing.T) (address string, resends *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	resends = new(int32)
+	go func() {
+		for {
+			c, e := l.Accept()
+			if e != nil {
+				return
+			}
+			go serveFakeListener(c, resends)
+		}
+	}()
+	return l.Addr().String(), resends
+}
+
+func serveFakeListener(c net.Conn, resends *int32) {
+	defer func() {
+		_ = c.Close()
+	}()
+	refuse := func(data string) {
+		body := []byte{1, 0, 0, 0}
+		binary.BigEndian.PutUint16(body[2:], uint16(len(data)))
+		_, _ = c.Write(tnsPacket(tnsRefuse, 0, append(body, data...)))
+	}
+	accept := func(data string) {
+		body := make([]byte, 24)
+		binary.BigEndian.PutUint16(body[0:], tnsProbeVersion)
+		binary.BigEndian.PutUint16(body[10:], uint16(len(data)))
+		binary.BigEndian.PutUint16(body[12:], 32)
+		_, _ = c.Write(tnsPacket(tnsAccept, 0, append(body, data...)))
+	}
+	p, err := readTNSPacket(c, false)
+	if err != nil || p[4] != tnsConnect {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(p[24:]))
+	data := tnsField(p, int(binary.BigEndian.Uint16(p[26:])), length)
+	if data == "" {
+		d, e := readTNSPacket(c, false)
+		if e != nil {
+			return
+		}
+		data = string(d[10:])
+	}
+	switch {
+	case strings.Contains(data, "(SERVICE_NAME=resend)") && atomic.AddInt32(resends, 1) == 1:
+		_, _ = c.Write(tnsPacket(tnsResend, 0, nil))
+		serveFakeListener(c, resends)
+	case strings.Contains(data, "(SERVICE_NAME=good") || strings.Contains(data, "(SERVICE_NAME=resend)"):
+		accept("")
+	case strings.Contains(data, "(SERVICE_NAME=scan)"):
+		redirect := "(ADDRESS=(PROTOCOL=TCP)(HOST=10.0.0.2)(PORT=1522))\x00" + data
+		body := []byte{0, 0}
+		binary.BigEndian.PutUint16(body, uint16(len(redirect)))
+		_, _ = c.Write(tnsPacket(tnsRedirect, 2, append(body, redirect...)))
+	case strings.Contains(data, "(SERVICE_NAME=hang)"):
+		time.Sleep(2 * time.Second)
+	case strings.Contains(data, "(SID="):
+		refuse("(DESCRIPTION=(TMP=)(VSNNUM=318767104)(ERR=12505)(ERROR_STACK=(ERROR=(CODE=12505)(EMFI=4))))")
+	case strings.Contains(data, "(COMMAND=status)"):
+		// status is sent with the accept and data packets
+		accept(listenerStatus[:40])
+		rest := listenerStatus[40:]
+		for len(rest) > 0 {
+			n := min(100, len(rest))
+			flags := []byte{0, 0}
+			if n == len(rest) {
+				flags[1] = tnsDataEOF
+			}
+			_, _ = c.Write(tnsPacket(tnsData, 0, append(flags, rest[:n]...)))
+			rest = rest[n:]
+		}
+	case strings.Contains(data, "(COMMAND="):
+		refuse("(DESCRIPTION=(TMP=)(VSNNUM=318767104)(ERR=1189)(ERROR_STACK=(ERROR=(CODE=1189)(EMFI=4))))")
+	default:
+		refuse("(DESCRIPTION=(TMP=)(VSNNUM=318767104)(ERR=12514)(ERROR_STACK=(ERROR=(CODE=12514)(EMFI=4))))")
+	}
+}
+
+func TestTNSProbe(t *testing.T) {
+	address, resends := fakeListener(t)
+	ctx := context.Background()
+
+	t.Run("Connect packet", func(t *testing.T) {
+		packets := tnsConnectPackets("(DESCRIPTION=(CONNECT_DATA=(SERVICE_NAME=x)))")
+		require.Len(t, packets, 1)
+		p := packets[0]
+		assert.Equal(t, len(p), int(binary.BigEndian.Uint16(p)))
+		assert.Equal(t, byte(tnsConnect), p[4])
+		assert.Equal(t, uint16(tnsProbeVersion), binary.BigEndian.Uint16(p[8:]))
+		assert.Equal(t, "(DESCRIPTION=(CONNECT_DATA=(SERVICE_NAME=x)))", string(p[tnsConnectOffset:]))
+		packets = tnsConnectPackets(strings.Repeat("x", tnsMaxConnectData+1))
+		require.Len(t, packets, 2)
+		assert.Len(t, packets[0], tnsConnectOffset)
+		assert.Equal(t, byte(tnsData), packets[1][4])
+	})
+	t.Run("Packet limits", func(t *testing.T) {
+		head := []byte{0xff, 0xff, 0xff, 0xff, tnsData, 0, 0, 0}
+		_, err := readTNSPacket(bytes.NewReader(head), true)
+		assert.ErrorContains(t, err, "invalid TNS packet length 4294967295")
+		_, err = readTNSPacket(bytes.NewReader(head), false)
+		assert.ErrorIs(t, err, io.EOF, "64 KiB is accepted, body missing")
+
+		var stream bytes.Buffer
+		chunk := make([]byte, 60000)
+		for stream.Len() <= tnsMaxData {
+			stream.Write(tnsPacket(tnsData, 0, append([]byte{0, 0}, chunk...)))
+		}
+		res := TNSProbeResult{}
+		err = readTNSData(&stream, false, &res)
+		assert.ErrorContains(t, err, "listener response exceeds")
+		assert.LessOrEqual(t, len(res.Data), tnsMaxData)
+	})
+	t.Run("Service", func(t *testing.T) {
+		res, err := ProbeService(ctx, address, "good")
+		require.NoError(t, err)
+		assert.Equal(t, ProbeAccepted, res.Status)
+		assert.Equal(t, tnsProbeVersion, res.Version)
+		assert.NoError(t, res.Err())
+
+		res, err = ProbeService(ctx, address, "good_"+strings.Repeat("x", 250))
+		require.NoError(t, err)
+		assert.Equal(t, ProbeAccepted, res.Status, "long connect data is sent in a data packet")
+
+		res, err = ProbeService(ctx, address, "unknown")
+		require.NoError(t, err)
+		assert.Equal(t, ProbeRefused, res.Status)
+		assert.Equal(t, 12514, res.Code)
+		var le *ListenerError
+		require.True(t, errors.As(res.Err(), &le))
+		assert.Equal(t, "ORA-12514: TNS:listener does not currently know of service requested in connect descriptor", le.Error())
+
+		res, err = ProbeService(ctx, address, "scan")
+		require.NoError(t, err)
+		assert.Equal(t, ProbeRedirected, res.Status)
+		assert.Equal(t, "(ADDRESS=(PROTOCOL=TCP)(HOST=10.0.0.2)(PORT=1522))", res.Redirect)
+		assert.NoError(t, res.Err())
+
+		res, err = ProbeService(ctx, address, "resend")
+		require.NoError(t, err)
+		assert.Equal(t, ProbeAccepted, res.Status)
+		assert.Equal(t, int32(2), atomic.LoadInt32(resends))
+	})
+	t.Run("SID", func(t *testing.T) {
+		res, err := ProbeSID(ctx, address, "ORCL")
+		require.NoError(t, err)
+		assert.Equal(t, 12505, res.Code)
+		assert.ErrorContains(t, res.Err(), "ORA-12505")
+	})
+	t.Run("Ping", func(t *testing.T) {
+		assert.NoError(t, ListenerPing(ctx, address, "good"))
+		assert.ErrorContains(t, ListenerPing(ctx, address, "unknown"), "ORA-12514")
+		tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		err := ListenerPing(tctx, address, "hang")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("Status", func(t *testing.T) {
+		status, err := ListenerStatus(ctx, address)
+		require.NoError(t, err)
+		assert.Equal(t, "LISTENER", status.Alias)
+		assert.Equal(t, "TNSLSNR for Linux: Version 19.0.0.0.0 - Production", status.Version)
+		assert.Equal(t, "19-OCT-2026 08:00:00", status.StartDate)
+		require.Len(t, status.Services, 2)
+		assert.Equal(t, "FREEPDB1", status.Services[1].Name)
+		assert.Equal(t, []TNSListenerInstance{{Name: "FREE", Status: "READY"}}, status.Services[1].Instances)
+
+		_, err = ListenerCommand(ctx, address, "services")
+		var le *ListenerError
+		require.True(t, errors.As(err, &le), "remote services forbidden")
+		assert.Equal(t, 1189, le.Code)
+		assert.ErrorContains(t, err, "TNS-01189")
+	})
+	t.Run("Check", func(t *testing.T) {
+		host, port, _ := net.SplitHostPort(address)
+		entries := TNSEntries{
+			"GOOD": BuildTnsEntry("probe", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST="+host+")(PORT="+port+"))(CONNECT_DATA=(SERVICE_NAME=good)))", "GOOD"),
+			"BAD":  BuildTnsEntry("probe", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST="+host+")(PORT="+port+"))(CONNECT_DATA=(SERVICE_NAME=bad)))", "BAD"),
+		}
+		reports := CheckTNSEntries(ctx, entries, TNSCheckOptions{ListenerPing: ListenerPing})
+		require.Len(t, reports, 2)
+		assert.False(t, reports[0].OK())
+		assert.ErrorContains(t, reports[0].Addresses[0].Listener.Err, "ORA-12514")
+		assert.True(t, reports[1].OK())
+	})
+}
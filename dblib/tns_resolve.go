@@ -0,0 +1,288 @@
+package dblib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tommi2day/gomodules/ldaplib"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// naming methods of NAMES.DIRECTORY_PATH and direct descriptors
+const (
+	ResolveTNSNames   = "TNSNAMES"
+	ResolveLDAP       = "LDAP"
+	ResolveEZConnect  = "EZCONNECT"
+	ResolveHostname   = "HOSTNAME"
+	ResolveDescriptor = "DESCRIPTOR"
+)
+
+// DefaultDirectoryPath is used if sqlnet.ora has no NAMES.DIRECTORY_PATH
+var DefaultDirectoryPath = []string{ResolveTNSNames, ResolveLDAP, ResolveEZConnect}
+
+// LdapLookupFunc returns the TNS entries of an Oracle context DN
+type LdapLookupFunc func(contextDN string) (TNSEntries, error)
+
+// TNSResolution is the answer of a resolver for an alias
+type TNSResolution struct {
+	Alias string
+	// Source is the naming method which resolved the alias
+	Source string
+	// Location is the tnsnames.ora line, the LDAP DN or the EZConnect string of the entry
+	Location string
+	Entry    TNSEntry
+	// Tried holds the methods asked before with the reason they did not resolve
+	Tried []string
+}
+
+// TNSResolver resolves aliases like the Oracle client with sqlnet.ora, tnsnames.ora and ldap.ora of TNSAdmin
+type TNSResolver struct {
+	TNSAdmin      string
+	Domain        string
+	DirectoryPath []string
+	// LdapContext is DEFAULT_ADMIN_CONTEXT of ldap.ora
+	LdapContext string
+	LdapServers []LdapServer
+	// LdapLookup reads the entries of an Oracle context, default is an anonymous bind to LdapServers
+	LdapLookup LdapLookupFunc
+	// LdapTimeout in seconds for connects to LdapServers
+	LdapTimeout int
+	// LdapInsecure skips the certificate verification of SSL connects to LdapServers
+	LdapInsecure bool
+	// HostLookup resolves host names of the HOSTNAME method, default is net.DefaultResolver
+	HostLookup func(ctx context.Context, host string) ([]string, error)
+
+	tnsEntries  TNSEntries
+	tnsErr      error
+	ldapEntries map[string]TNSEntries
+}
+
+// NewTNSResolver reads sqlnet.ora and ldap.ora of tnsAdmin, missing files use Oracle defaults
+func NewTNSResolver(tnsAdmin string) (r *TNSResolver, err error) {
+	if tnsAdmin, err = filepath.Abs(tnsAdmin); err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(tnsAdmin); err != nil {
+		return nil, fmt.Errorf("TNS_ADMIN %s: %w", tnsAdmin, err)
+	}
+	r = &TNSResolver{TNSAdmin: tnsAdmin, LdapTimeout: 10, ldapEntries: make(map[string]TNSEntries)}
+	if _, e := os.Stat(path.Join(tnsAdmin, sqlnetora)); e == nil {
+		var sqlnet *SQLNetConfig
+		if sqlnet, err = ReadSQLNetConfig(tnsAdmin); err != nil {
+			return nil, err
+		}
+		r.Domain = sqlnet.DefaultDomain
+		for _, m := range sqlnet.DirectoryPath {
+			r.DirectoryPath = append(r.DirectoryPath, strings.ToUpper(m))
+		}
+	}
+	if len(r.DirectoryPath) == 0 {
+		r.DirectoryPath = DefaultDirectoryPath
+	}
+	if _, e := os.Stat(path.Join(tnsAdmin, ldapora)); e == nil {
+		var ldapOra *LdapOraConfig
+		if ldapOra, err = ReadLdapOraConfig(tnsAdmin); err != nil {
+			return nil, err
+		}
+		r.LdapContext = ldapOra.DefaultAdminContext
+		r.LdapServers = ldapOra.Servers
+	}
+	log.Debugf("resolver for %s uses %s, domain %s", tnsAdmin, strings.Join(r.DirectoryPath, ","), r.Domain)
+	return
+}
+
+// ResolveAlias resolves an alias with the configuration of tnsAdmin
+func ResolveAlias(tnsAdmin string, alias string) (res TNSResolution, err error) {
+	r, err := NewTNSResolver(tnsAdmin)
+	if err != nil {
+		return
+	}
+	return r.Resolve(alias)
+}
+
+// Resolve asks the naming methods in DirectoryPath order, a connect descriptor is used as given
+func (r *TNSResolver) Resolve(alias string) (res TNSResolution, err error) {
+	alias = strings.TrimSpace(alias)
+	res.Alias = alias
+	if strings.HasPrefix(alias, "(") {
+		res.Source, res.Location = ResolveDescriptor, alias
+		if res.Entry, err = entryFromDescriptor(alias, alias); err != nil {
+			return res, err
+		}
+		return
+	}
+	for _, method := range r.DirectoryPath {
+		var ok bool
+		var reason string
+		switch method {
+		case ResolveTNSNames:
+			res.Entry, res.Location, reason, ok = r.resolveTNSNames(alias)
+		case ResolveLDAP:
+			res.Entry, res.Location, reason, ok = r.resolveLdap(alias)
+		case ResolveEZConnect:
+			res.Entry, res.Location, reason, ok = r.resolveEZConnect(alias)
+		case ResolveHostname:
+			res.Entry, res.Location, reason, ok = r.resolveHostname(alias)
+		default:
+			reason = "naming method not supported"
+		}
+		if ok {
+			res.Source = method
+			log.Debugf("resolved %s with %s at %s", alias, method, res.Location)
+			return
+		}
+		res.Tried = append(res.Tried, method+": "+reason)
+	}
+	err = fmt.Errorf("could not resolve %s: %s", alias, strings.Join(res.Tried, "; "))
+	return
+}
+
+// entryFromDescriptor builds an entry and reports parse errors of the descriptor
+func entryFromDescriptor(location string, desc string) (e TNSEntry, err error) {
+	d, err := ParseTNSDescriptor(desc)
+	if err != nil {
+		return e, err
+	}
+	e = BuildTnsEntry(location, d.Node.String(), "")
+	return
+}
+
+// qualifiedAlias appends the default domain to names without domain like the Oracle client
+func (r *TNSResolver) qualifiedAlias(alias string) string {
+	if r.Domain != "" && !strings.Contains(alias, ".") {
+		return alias + "." + r.Domain
+	}
+	return alias
+}
+
+// resolveTNSNames looks up tnsnames.ora of TNSAdmin including IFILEs, the file is read once
+func (r *TNSResolver) resolveTNSNames(alias string) (e TNSEntry, location string, reason string, ok bool) {
+	filename := path.Join(r.TNSAdmin, "tnsnames.ora")
+	if r.tnsEntries == nil && r.tnsErr == nil {
+		if _, r.tnsErr = os.Stat(filename); r.tnsErr == nil {
+			r.tnsEntries, _, r.tnsErr = GetTnsnames(filename, true)
+			if r.tnsErr != nil {
+				// entries with errors are dropped, all others are usable
+				log.Warnf("resolver: %v", r.tnsErr)
+				r.tnsErr = nil
+			}
+		}
+	}
+	if r.tnsErr != nil {
+		return e, "", fmt.Sprintf("%s not readable", filename), false
+	}
+	name := r.qualifiedAlias(alias)
+	if e, ok = r.tnsEntries[strings.ToUpper(name)]; !ok {
+		return e, "", fmt.Sprintf("%s not found in %s", name, filename), false
+	}
+	return e, e.Location, "", true
+}
+
+// ldapContextDN returns the Oracle context and the common name to look up, names with a domain
+// which is not the default domain are searched in the context of their domain components
+func (r *TNSResolver) ldapContextDN(alias string) (contextDN string, cn string) {
+	name := alias
+	// the default domain is case insensitive like in shortName
+	if suffix := "." + r.Domain; r.Domain != "" && len(name) > len(suffix) &&
+		strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		name = name[:len(name)-len(suffix)]
+	}
+	if cn, domain, ok := strings.Cut(name, "."); ok {
+		return "cn=OracleContext,dc=" + strings.Join(strings.Split(domain, "."), ",dc="), cn
+	}
+	if r.LdapContext == "" {
+		return "", name
+	}
+	return "cn=OracleContext," + r.LdapContext, name
+}
+
+// readLdapContext reads all entries of a context with an anonymous bind to the first reachable server
+func (r *TNSResolver) readLdapContext(contextDN string) (entries TNSEntries, err error) {
+	if len(r.LdapServers) == 0 {
+		return nil, fmt.Errorf("no directory servers in %s", ldapora)
+	}
+	for _, s := range r.LdapServers {
+		lc := r.ldapConfig(s)
+		if err = lc.Connect("", ""); err != nil {
+			log.Debugf("resolver: ldap %s failed: %v", lc.URL, err)
+			continue
+		}
+		entries, err = ReadLdapTns(lc, contextDN)
+		_ = lc.Conn.Close()
+		return
+	}
+	return
+}
+
+// ldapConfig returns the connect config for a directory server, SSL certificates are verified unless LdapInsecure is set
+func (r *TNSResolver) ldapConfig(s LdapServer) *ldaplib.LdapConfigType {
+	tls, port := false, s.Port
+	if s.SSLPort > 0 && port == 0 {
+		tls, port = true, s.SSLPort
+	}
+	return ldaplib.NewConfig(s.Hostname, port, tls, r.LdapInsecure, r.LdapContext, r.LdapTimeout)
+}
+
+// resolveLdap looks up the alias in the Oracle context of the directory, contexts are read once
+func (r *TNSResolver) resolveLdap(alias string) (e TNSEntry, location string, reason string, ok bool) {
+	contextDN, cn := r.ldapContextDN(alias)
+	if contextDN == "" {
+		return e, "", "no DEFAULT_ADMIN_CONTEXT in " + ldapora, false
+	}
+	if r.ldapEntries == nil {
+		r.ldapEntries = make(map[string]TNSEntries)
+	}
+	entries, cached := r.ldapEntries[contextDN]
+	if !cached {
+		lookup := r.LdapLookup
+		if lookup == nil {
+			lookup = r.readLdapContext
+		}
+		var err error
+		if entries, err = lookup(contextDN); err != nil {
+			return e, "", err.Error(), false
+		}
+		r.ldapEntries[contextDN] = entries
+	}
+	for name, entry := range entries {
+		if strings.EqualFold(name, cn) {
+			return entry, entry.Location, "", true
+		}
+	}
+	return e, "", fmt.Sprintf("%s not found in %s", cn, contextDN), false
+}
+
+// resolveEZConnect accepts names with a port, service or protocol like host:port/service
+func (r *TNSResolver) resolveEZConnect(alias string) (e TNSEntry, location string, reason string, ok bool) {
+	if !strings.ContainsAny(alias, ":/") {
+		return e, "", "no EZConnect syntax", false
+	}
+	ez, err := ParseEZConnect(alias)
+	if err != nil {
+		return e, "", err.Error(), false
+	}
+	return ez.TNSEntry(alias), alias, "", true
+}
+
+// resolveHostname maps a resolvable host name to host:1521/host
+func (r *TNSResolver) resolveHostname(alias string) (e TNSEntry, location string, reason string, ok bool) {
+	lookup := r.HostLookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := lookup(ctx, alias); err != nil {
+		return e, "", fmt.Sprintf("host %s not found", alias), false
+	}
+	ez := &EZConnect{Protocol: "TCP", ServiceName: alias, Params: map[string]string{},
+		AddressLists: []TNSAddressList{{Addresses: []TNSAddress{{Protocol: "TCP", Host: alias, Port: DefaultOraclePort}}}}}
+	return ez.TNSEntry(alias), alias, "", true
+}
@@ -0,0 +1,137 @@
+package dblib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+const resolveSQLNet = `NAMES.DEFAULT_DOMAIN = local
+NAMES.DIRECTORY_PATH = (TNSNAMES, LDAP, EZCONNECT, HOSTNAME, NIS)
+`
+
+const resolveTnsnames = `XE.LOCAL =
+  (DESCRIPTION =
+    (ADDRESS = (PROTOCOL = TCP)(HOST = 127.0.0.1)(PORT = 1521))
+    (CONNECT_DATA = (SERVICE_NAME = XE))
+  )
+`
+
+func TestTNSResolver(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	resolveAdmin := path.Join(test.TestData, "resolve")
+	require.NoError(t, os.MkdirAll(resolveAdmin, 0750))
+	require.NoError(t, common.WriteStringToFile(path.Join(resolveAdmin, sqlnetora), resolveSQLNet))
+	require.NoError(t, common.WriteStringToFile(path.Join(resolveAdmin, "tnsnames.ora"), resolveTnsnames))
+	require.NoError(t, common.WriteStringToFile(path.Join(resolveAdmin, ldapora), ldaporaOK))
+
+	r, err := NewTNSResolver(resolveAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, "local", r.Domain)
+	assert.Equal(t, []string{"TNSNAMES", "LDAP", "EZCONNECT", "HOSTNAME", "NIS"}, r.DirectoryPath)
+	assert.Equal(t, "dc=oracle,dc=local", r.LdapContext)
+	require.Len(t, r.LdapServers, 2)
+
+	var contexts []string
+	r.LdapLookup = func(contextDN string) (TNSEntries, error) {
+		contexts = append(contexts, contextDN)
+		if contextDN == "cn=OracleContext,dc=oracle,dc=local" {
+			dn := "cn=LDAPDB," + contextDN
+			return TNSEntries{"LDAPDB": BuildTnsEntry(dn, "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=ldaphost)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=ldapdb)))", "LDAPDB")}, nil
+		}
+		return nil, errors.New("no such object")
+	}
+	r.HostLookup = func(_ context.Context, host string) ([]string, error) {
+		if host == "dbhost" {
+			return []string{"10.0.0.1"}, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	t.Run("TNSNAMES", func(t *testing.T) {
+		res, err := r.Resolve("xe")
+		require.NoError(t, err)
+		assert.Equal(t, ResolveTNSNames, res.Source)
+		assert.Equal(t, "XE", res.Entry.Service)
+		assert.Contains(t, res.Location, "tnsnames.ora Line: 1")
+		assert.Empty(t, res.Tried)
+	})
+	t.Run("LDAP", func(t *testing.T) {
+		res, err := r.Resolve("ldapdb")
+		require.NoError(t, err)
+		assert.Equal(t, ResolveLDAP, res.Source)
+		assert.Equal(t, "cn=LDAPDB,cn=OracleContext,dc=oracle,dc=local", res.Location)
+		require.Len(t, res.Tried, 1)
+		assert.Contains(t, res.Tried[0], "TNSNAMES: ldapdb.local not found")
+		_, err = r.Resolve("ldapdb.local")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cn=OracleContext,dc=oracle,dc=local"}, contexts, "context read once")
+		res, err = r.Resolve("LDAPDB.LOCAL")
+		require.NoError(t, err, "default domain should match case insensitive")
+		assert.Equal(t, ResolveLDAP, res.Source)
+		assert.Len(t, contexts, 1)
+
+		_, err = r.Resolve("sales.example.com")
+		assert.Error(t, err)
+		assert.Contains(t, contexts, "cn=OracleContext,dc=example,dc=com")
+	})
+	t.Run("LdapInsecure", func(t *testing.T) {
+		s := LdapServer{Hostname: "oid", SSLPort: 3131}
+		lc := r.ldapConfig(s)
+		assert.False(t, lc.Insecure, "certificates should be verified by default")
+		assert.True(t, lc.TLS)
+		assert.Equal(t, 3131, lc.Port)
+		r.LdapInsecure = true
+		defer func() { r.LdapInsecure = false }()
+		assert.True(t, r.ldapConfig(s).Insecure)
+	})
+	t.Run("EZCONNECT", func(t *testing.T) {
+		res, err := r.Resolve("dbhost:1522/sales")
+		require.NoError(t, err)
+		assert.Equal(t, ResolveEZConnect, res.Source)
+		assert.Equal(t, "sales", res.Entry.Service)
+		assert.Equal(t, "1522", res.Entry.Servers[0].Port)
+	})
+	t.Run("HOSTNAME", func(t *testing.T) {
+		res, err := r.Resolve("dbhost")
+		require.NoError(t, err)
+		assert.Equal(t, ResolveHostname, res.Source)
+		assert.Equal(t, "dbhost", res.Entry.Service)
+		assert.Len(t, res.Tried, 3)
+	})
+	t.Run("Descriptor", func(t *testing.T) {
+		res, err := r.Resolve("(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h)(PORT=1521))(CONNECT_DATA=(SID=ORCL)))")
+		require.NoError(t, err)
+		assert.Equal(t, ResolveDescriptor, res.Source)
+		assert.Equal(t, "ORCL", res.Entry.Service)
+		_, err = r.Resolve("(DESCRIPTION=")
+		assert.Error(t, err)
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		res, err := r.Resolve("missing")
+		require.Error(t, err)
+		require.Len(t, res.Tried, 5)
+		assert.Equal(t, "NIS: naming method not supported", res.Tried[4])
+		assert.ErrorContains(t, err, "could not resolve missing")
+	})
+	t.Run("Defaults", func(t *testing.T) {
+		emptyAdmin := path.Join(test.TestData, "resolve_empty")
+		require.NoError(t, os.MkdirAll(emptyAdmin, 0750))
+		res, err := ResolveAlias(emptyAdmin, "h1/s")
+		require.NoError(t, err)
+		assert.Equal(t, ResolveEZConnect, res.Source)
+		assert.Len(t, res.Tried, 2)
+		assert.Contains(t, res.Tried[1], "LDAP: no DEFAULT_ADMIN_CONTEXT")
+		_, err = ResolveAlias(path.Join(test.TestData, "not_existing"), "xe")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,400 @@
+package dblib
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/tommi2day/gomodules/ldaplib"
+)
+
+// actions of a sync plan
+const (
+	SyncAdd    = "add"
+	SyncModify = "modify"
+	SyncDelete = "delete"
+)
+
+// TNSSyncDirection selects the side which is changed by a sync
+type TNSSyncDirection int
+
+const (
+	// SyncFileToLdap makes the Oracle context equal to the tnsnames file
+	SyncFileToLdap TNSSyncDirection = iota
+	// SyncLdapToFile makes the tnsnames file equal to the Oracle context
+	SyncLdapToFile
+)
+
+// TNSSyncOptions configures a sync
+type TNSSyncOptions struct {
+	// Domain is removed from file names for LDAP and appended to LDAP names in the file
+	Domain string
+	// Delete removes entries missing in the source
+	Delete bool
+	// DryRun only plans the changes
+	DryRun bool
+}
+
+// LdapTNSObject is a net service or a net service alias in an Oracle context
+type LdapTNSObject struct {
+	DN   string
+	Name string
+	Desc string
+	// AliasOf is the name of the service an alias points to with aliasedObjectName
+	AliasOf string
+}
+
+// TNSSyncOp is a planned change of one name
+type TNSSyncOp struct {
+	Action string
+	// Name is the name without domain
+	Name    string
+	Desc    string
+	AliasOf string
+	// Target is the name as written in the file or the DN of the entry to change
+	Target string
+	// Recreate is set if a service becomes an alias or the other way round
+	Recreate bool
+}
+
+// TNSSyncPlan lists the changes which make the target equal to the source
+type TNSSyncPlan struct {
+	Direction TNSSyncDirection
+	Domain    string
+	Ops       []TNSSyncOp
+}
+
+// TNSSyncSummary counts the applied changes
+type TNSSyncSummary struct {
+	Added    int
+	Modified int
+	Deleted  int
+	Errors   []error
+}
+
+// tnsSyncEntry is a service or alias of one side with the name as written
+type tnsSyncEntry struct {
+	Target  string
+	Desc    string
+	AliasOf string
+}
+
+// shortName returns the upper case name without domain
+func shortName(name string, domain string) string {
+	name = strings.ToUpper(name)
+	if domain != "" {
+		name = strings.TrimSuffix(name, "."+strings.ToUpper(domain))
+	}
+	return name
+}
+
+// fileName returns the name with domain for a tnsnames file
+func fileName(name string, domain string) string {
+	if domain != "" && !strings.Contains(name, ".") {
+		return name + "." + strings.ToUpper(domain)
+	}
+	return name
+}
+
+// fileSyncEntries returns the services and aliases of a file, the first name of an entry is the service
+func fileSyncEntries(f *TNSFile, domain string) map[string]tnsSyncEntry {
+	entries := make(map[string]tnsSyncEntry)
+	for _, item := range f.Items {
+		if item.IFile != "" {
+			log.Debugf("sync: IFILE %s is not synchronized", item.IFile)
+			continue
+		}
+		desc := item.Node.String()
+		service := shortName(item.Names[0], domain)
+		for i, name := range item.Names {
+			e := tnsSyncEntry{Target: name, Desc: desc}
+			if i > 0 {
+				e.AliasOf = service
+			}
+			entries[shortName(name, domain)] = e
+		}
+	}
+	return entries
+}
+
+// ldapSyncEntries returns the services and aliases of LDAP objects
+func ldapSyncEntries(objects []LdapTNSObject, domain string) map[string]tnsSyncEntry {
+	entries := make(map[string]tnsSyncEntry)
+	for _, o := range objects {
+		e := tnsSyncEntry{Target: o.DN, Desc: o.Desc}
+		if o.AliasOf != "" {
+			e.AliasOf = shortName(o.AliasOf, domain)
+		}
+		entries[shortName(o.Name, domain)] = e
+	}
+	return entries
+}
+
+// syncServices returns the services of entries as TNSEntries for DiffTNSEntries
+func syncServices(entries map[string]tnsSyncEntry) TNSEntries {
+	services := make(TNSEntries)
+	for name, e := range entries {
+		if e.AliasOf == "" {
+			services[name] = TNSEntry{Name: name, Desc: e.Desc}
+		}
+	}
+	return services
+}
+
+// PlanTNSSync compares a tnsnames file with the objects of an Oracle context and plans the changes
+// of the target side. Services are added and modified before aliases, aliases are deleted before services
+func PlanTNSSync(f *TNSFile, objects []LdapTNSObject, direction TNSSyncDirection, opts TNSSyncOptions) (plan TNSSyncPlan) {
+	plan.Direction, plan.Domain = direction, opts.Domain
+	source, target := fileSyncEntries(f, opts.Domain), ldapSyncEntries(objects, opts.Domain)
+	if direction == SyncLdapToFile {
+		source, target = target, source
+	}
+	diff := DiffTNSEntries(syncServices(target), syncServices(source))
+	changed := make(map[string]bool)
+	for _, name := range diff.Changed {
+		changed[name] = true
+	}
+	for name, s := range source {
+		t, exists := target[name]
+		op := TNSSyncOp{Name: name, Desc: s.Desc, AliasOf: s.AliasOf, Target: t.Target}
+		switch {
+		case !exists:
+			op.Action = SyncAdd
+		case (s.AliasOf == "") != (t.AliasOf == ""):
+			op.Action, op.Recreate = SyncModify, true
+		case changed[name] || s.AliasOf != t.AliasOf:
+			op.Action = SyncModify
+		default:
+			continue
+		}
+		plan.Ops = append(plan.Ops, op)
+	}
+	if opts.Delete {
+		for name, t := range target {
+			if _, exists := source[name]; !exists {
+				plan.Ops = append(plan.Ops, TNSSyncOp{Action: SyncDelete, Name: name, Desc: t.Desc, AliasOf: t.AliasOf, Target: t.Target})
+			}
+		}
+	}
+	sort.Slice(plan.Ops, func(i, j int) bool {
+		ri, rj := plan.Ops[i].rank(), plan.Ops[j].rank()
+		if ri != rj {
+			return ri < rj
+		}
+		return plan.Ops[i].Name < plan.Ops[j].Name
+	})
+	log.Debugf("sync plan has %d changes", len(plan.Ops))
+	return
+}
+
+// rank orders operations so that alias targets exist when aliases are written
+func (op TNSSyncOp) rank() int {
+	alias := 0
+	if op.AliasOf != "" {
+		alias = 1
+	}
+	if op.Action == SyncDelete {
+		return 3 - alias
+	}
+	return alias
+}
+
+// String returns a line like + NAME (DESCRIPTION=...) or - NAME -> SERVICE for dry runs
+func (op TNSSyncOp) String() string {
+	sign := map[string]string{SyncAdd: "+", SyncModify: "~", SyncDelete: "-"}[op.Action]
+	if op.AliasOf != "" {
+		return fmt.Sprintf("%s %s -> %s", sign, op.Name, op.AliasOf)
+	}
+	return fmt.Sprintf("%s %s %s", sign, op.Name, op.Desc)
+}
+
+// String returns one line per change
+func (p TNSSyncPlan) String() string {
+	var sb strings.Builder
+	for _, op := range p.Ops {
+		sb.WriteString(op.String() + "\n")
+	}
+	return sb.String()
+}
+
+// Empty reports if there is nothing to change
+func (p TNSSyncPlan) Empty() bool {
+	return len(p.Ops) == 0
+}
+
+// String returns the counts of the summary
+func (s TNSSyncSummary) String() string {
+	return fmt.Sprintf("added: %d, modified: %d, deleted: %d, failed: %d", s.Added, s.Modified, s.Deleted, len(s.Errors))
+}
+
+// count adds a done operation or its error to the summary
+func (s *TNSSyncSummary) count(op TNSSyncOp, err error) {
+	if err != nil {
+		s.Errors = append(s.Errors, fmt.Errorf("%s %s: %w", op.Action, op.Name, err))
+		return
+	}
+	switch op.Action {
+	case SyncAdd:
+		s.Added++
+	case SyncModify:
+		s.Modified++
+	case SyncDelete:
+		s.Deleted++
+	}
+}
+
+// ReadLdapTNSObjects reads net services and net service aliases of an Oracle context without dereferencing aliases
+func ReadLdapTNSObjects(lc *ldaplib.LdapConfigType, contextDN string) (objects []LdapTNSObject, err error) {
+	if lc == nil {
+		return nil, fmt.Errorf("no valid ldap config given")
+	}
+	filter := "(|(objectClass=orclNetService)(objectClass=orclNetServiceAlias)(objectClass=alias))"
+	result, err := lc.Search(contextDN, filter, []string{"cn", "orclNetDescString", "aliasedObjectName"}, ldap.ScopeSingleLevel, ldap.NeverDerefAliases)
+	if err != nil {
+		return nil, fmt.Errorf("service search returned error:%v", err)
+	}
+	descs := make(map[string]string)
+	for _, e := range result {
+		o := LdapTNSObject{DN: e.DN, Name: e.GetEqualFoldAttributeValue("cn"), Desc: e.GetEqualFoldAttributeValue("orclNetDescString")}
+		if target := e.GetEqualFoldAttributeValue("aliasedObjectName"); target != "" {
+			dn, e := ldap.ParseDN(target)
+			if e != nil || len(dn.RDNs) == 0 {
+				log.Warnf("sync: skip alias %s with invalid target %s", o.DN, target)
+				continue
+			}
+			o.AliasOf = dn.RDNs[0].Attributes[0].Value
+		}
+		if o.Name == "" {
+			continue
+		}
+		descs[strings.ToUpper(o.Name)] = o.Desc
+		objects = append(objects, o)
+	}
+	for i, o := range objects {
+		if o.AliasOf != "" {
+			objects[i].Desc = descs[strings.ToUpper(o.AliasOf)]
+		}
+	}
+	log.Debugf("found %d net services and aliases in %s", len(objects), contextDN)
+	return
+}
+
+// AddLdapTNSAlias adds a net service alias pointing to the entry with DN target
+func AddLdapTNSAlias(lc *ldaplib.LdapConfigType, context string, alias string, target string) (err error) {
+	log.Debugf("Add Ldap Alias %s for %s", alias, target)
+	dn := fmt.Sprintf("cn=%s,%s", ldap.EscapeDN(alias), context)
+	attributes := []ldap.Attribute{
+		{Type: "objectClass", Vals: []string{"top", "alias", "orclNetServiceAlias"}},
+		{Type: "cn", Vals: []string{alias}},
+		{Type: "aliasedObjectName", Vals: []string{target}},
+	}
+	return lc.AddEntry(dn, attributes)
+}
+
+// ApplyLdap changes the Oracle context contextDN with the plan
+func (p TNSSyncPlan) ApplyLdap(lc *ldaplib.LdapConfigType, contextDN string) (summary TNSSyncSummary) {
+	for _, op := range p.Ops {
+		var err error
+		switch {
+		case op.Action == SyncDelete:
+			err = DeleteLdapTNSEntry(lc, op.Target, op.Name)
+		case op.Action == SyncModify && !op.Recreate && op.AliasOf == "":
+			err = ModifyLdapTNSEntry(lc, op.Target, op.Name, op.Desc)
+		case op.Action == SyncModify && !op.Recreate:
+			err = lc.ModifyAttribute(op.Target, "replace", "aliasedObjectName", []string{fmt.Sprintf("cn=%s,%s", ldap.EscapeDN(op.AliasOf), contextDN)})
+		default:
+			if op.Recreate {
+				if err = DeleteLdapTNSEntry(lc, op.Target, op.Name); err != nil {
+					break
+				}
+			}
+			if op.AliasOf == "" {
+				err = AddLdapTNSEntry(lc, contextDN, op.Name, op.Desc)
+			} else {
+				err = AddLdapTNSAlias(lc, contextDN, op.Name, fmt.Sprintf("cn=%s,%s", ldap.EscapeDN(op.AliasOf), contextDN))
+			}
+		}
+		summary.count(op, err)
+	}
+	log.Infof("sync to %s: %s", contextDN, summary)
+	return
+}
+
+// findSyncItem returns the item and index of a name without domain
+func findSyncItem(f *TNSFile, name string, domain string) (*TNSFileItem, int) {
+	for _, item := range f.Items {
+		for i, n := range item.Names {
+			if shortName(n, domain) == name {
+				return item, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// ApplyFile changes the tnsnames file with the plan, aliases are added to the names of their service
+func (p TNSSyncPlan) ApplyFile(f *TNSFile) (summary TNSSyncSummary) {
+	for _, op := range p.Ops {
+		var err error
+		name := op.Target
+		if name == "" {
+			name = fileName(op.Name, p.Domain)
+		}
+		if op.Action == SyncDelete || op.Recreate || (op.Action == SyncModify && op.AliasOf != "") {
+			f.Remove(name)
+		}
+		switch {
+		case op.Action == SyncDelete:
+		case op.AliasOf != "":
+			item, _ := findSyncItem(f, op.AliasOf, p.Domain)
+			if item == nil {
+				err = fmt.Errorf("service %s not found", op.AliasOf)
+				break
+			}
+			item.Names = append(item.Names, name)
+		default:
+			if item, i := findSyncItem(f, op.Name, p.Domain); item != nil && i == 0 {
+				// keep the aliases of the service
+				var n *NVPair
+				if n, err = entryNode(TNSEntry{Name: name, Desc: op.Desc}); err == nil {
+					item.Node = n
+				}
+				break
+			}
+			err = f.Set(TNSEntry{Name: name, Desc: op.Desc})
+		}
+		summary.count(op, err)
+	}
+	log.Infof("sync to file: %s", summary)
+	return
+}
+
+// SyncTNSLdap plans and applies a sync between the tnsnames file filename and an Oracle context.
+// The file is written for SyncLdapToFile, nothing is changed with DryRun
+func SyncTNSLdap(lc *ldaplib.LdapConfigType, contextDN string, filename string, direction TNSSyncDirection, opts TNSSyncOptions) (plan TNSSyncPlan, summary TNSSyncSummary, err error) {
+	f, err := ReadTNSFile(filename)
+	if err != nil {
+		return
+	}
+	objects, err := ReadLdapTNSObjects(lc, contextDN)
+	if err != nil {
+		return
+	}
+	plan = PlanTNSSync(f, objects, direction, opts)
+	if opts.DryRun || plan.Empty() {
+		return
+	}
+	if direction == SyncFileToLdap {
+		summary = plan.ApplyLdap(lc, contextDN)
+	} else {
+		summary = plan.ApplyFile(f)
+		err = f.WriteFile(filename)
+	}
+	if err == nil && len(summary.Errors) > 0 {
+		err = errors.Join(summary.Errors...)
+	}
+	return
+}
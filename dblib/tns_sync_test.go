@@ -0,0 +1,85 @@
+package dblib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const syncTnsnames = `# services
+SALES.LOCAL, SALES_RO.LOCAL =
+  (DESCRIPTION =
+    (ADDRESS = (PROTOCOL = TCP)(HOST = db1)(PORT = 1521))
+    (CONNECT_DATA = (SERVICE_NAME = sales))
+  )
+
+HR.LOCAL =
+  (DESCRIPTION =
+    (ADDRESS = (PROTOCOL = TCP)(HOST = db2)(PORT = 1521))
+    (CONNECT_DATA = (SERVICE_NAME = hr))
+  )
+`
+
+const syncContext = "cn=OracleContext,dc=oracle,dc=local"
+
+func syncObjects() []LdapTNSObject {
+	return []LdapTNSObject{
+		{DN: "cn=HR," + syncContext, Name: "HR", Desc: "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=db3)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=hr)))"},
+		{DN: "cn=OLD," + syncContext, Name: "OLD", Desc: "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=db4)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=old)))"},
+		{DN: "cn=OLD_ALIAS," + syncContext, Name: "OLD_ALIAS", AliasOf: "OLD"},
+		{DN: "cn=SALES_RO," + syncContext, Name: "SALES_RO", AliasOf: "HR"},
+	}
+}
+
+func TestTNSSync(t *testing.T) {
+	f, err := ParseTNSFile(syncTnsnames)
+	require.NoError(t, err)
+
+	t.Run("FileToLdap", func(t *testing.T) {
+		plan := PlanTNSSync(f, syncObjects(), SyncFileToLdap, TNSSyncOptions{Domain: "local"})
+		require.Len(t, plan.Ops, 3)
+		assert.Equal(t, SyncModify, plan.Ops[0].Action)
+		assert.Equal(t, "cn=HR,"+syncContext, plan.Ops[0].Target)
+		assert.Equal(t, TNSSyncOp{Action: SyncAdd, Name: "SALES", Desc: "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=db1)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=sales)))"}, plan.Ops[1])
+		assert.Equal(t, TNSSyncOp{Action: SyncModify, Name: "SALES_RO", AliasOf: "SALES", Target: "cn=SALES_RO," + syncContext,
+			Desc: plan.Ops[1].Desc}, plan.Ops[2], "alias points to another service")
+
+		plan = PlanTNSSync(f, syncObjects(), SyncFileToLdap, TNSSyncOptions{Domain: "local", Delete: true})
+		require.Len(t, plan.Ops, 5)
+		assert.Equal(t, "- OLD_ALIAS -> OLD", plan.Ops[3].String(), "aliases are deleted first")
+		assert.Equal(t, SyncDelete, plan.Ops[4].Action)
+		assert.Equal(t, "OLD", plan.Ops[4].Name)
+		assert.Equal(t, 5, strings.Count(plan.String(), "\n"))
+		assert.True(t, strings.HasPrefix(plan.String(), "~ HR (DESCRIPTION="), plan.String())
+	})
+	t.Run("LdapToFile", func(t *testing.T) {
+		f, err := ParseTNSFile(syncTnsnames)
+		require.NoError(t, err)
+		plan := PlanTNSSync(f, syncObjects(), SyncLdapToFile, TNSSyncOptions{Domain: "local", Delete: true})
+		var lines []string
+		for _, op := range plan.Ops {
+			lines = append(lines, op.Action+" "+op.Name)
+		}
+		assert.Equal(t, []string{"modify HR", "add OLD", "add OLD_ALIAS", "modify SALES_RO", "delete SALES"}, lines)
+
+		summary := plan.ApplyFile(f)
+		assert.Empty(t, summary.Errors)
+		assert.Equal(t, "added: 2, modified: 2, deleted: 1, failed: 0", summary.String())
+		assert.True(t, PlanTNSSync(f, syncObjects(), SyncLdapToFile, TNSSyncOptions{Domain: "local", Delete: true}).Empty(), "in sync")
+		entries := f.Entries("tnsnames.ora")
+		assert.Len(t, entries, 4)
+		assert.Equal(t, "db3", entries["SALES_RO.LOCAL"].Servers[0].Host, "alias of HR")
+		assert.Equal(t, "db4", entries["OLD_ALIAS.LOCAL"].Servers[0].Host)
+		assert.Contains(t, f.String(), "HR.LOCAL, SALES_RO.LOCAL =\n")
+		assert.Contains(t, f.String(), "OLD.LOCAL, OLD_ALIAS.LOCAL =\n")
+	})
+	t.Run("Errors", func(t *testing.T) {
+		f := &TNSFile{}
+		plan := TNSSyncPlan{Direction: SyncLdapToFile, Ops: []TNSSyncOp{{Action: SyncAdd, Name: "A", AliasOf: "MISSING"}}}
+		summary := plan.ApplyFile(f)
+		require.Len(t, summary.Errors, 1)
+		assert.EqualError(t, summary.Errors[0], "add A: service MISSING not found")
+	})
+}
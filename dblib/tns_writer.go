@@ -0,0 +1,431 @@
+package dblib
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tommi2day/gomodules/common"
+)
+
+// TNSFileItem is an entry or IFILE directive of a tnsnames file with its leading comment lines
+type TNSFileItem struct {
+	// Names are the aliases of an entry as written, empty for an IFILE directive
+	Names []string
+	// IFile is the file name of an IFILE directive
+	IFile    string
+	Comments []string
+	Node     *NVPair
+	Line     int
+}
+
+// TNSFile is a tnsnames file which keeps comments and IFILE directives for writing it back
+type TNSFile struct {
+	Items []*TNSFileItem
+	// Trailer holds comment lines after the last item
+	Trailer []string
+}
+
+// tnsIndent is the indentation of one level in formatted output
+const tnsIndent = "  "
+
+// tnsBlockNames are always formatted on multiple lines
+var tnsBlockNames = []string{"DESCRIPTION_LIST", "DESCRIPTION", "ADDRESS_LIST", "CONNECT_DATA", "SECURITY", "SID_LIST", "SID_DESC"}
+
+var reTNSFileEntry = regexp.MustCompile(`^([\w.\-]+(?:\s*,\s*[\w.\-]+)*)\s*=(.*)$`)
+var reTNSFileIfile = regexp.MustCompile(`(?i)^IFILE\s*=\s*(.*?)\s*$`)
+
+// FormatNVPair formats a pair tree indented in the style of Oracle Net Manager, leaves and addresses are kept on one line
+func FormatNVPair(n *NVPair, level int) string {
+	var sb strings.Builder
+	formatNVPair(&sb, n, level)
+	return sb.String()
+}
+
+func formatNVPair(sb *strings.Builder, n *NVPair, level int) {
+	indent := strings.Repeat(tnsIndent, level)
+	writeNVComments(sb, n.Comments, indent)
+	if len(n.Children) == 0 || (!slices.Contains(tnsBlockNames, strings.ToUpper(n.Name)) && nvAllLeaves(n) && !slices.ContainsFunc(n.Children, nvHasComments)) {
+		// comments of a single line pair are written before it
+		writeNVComments(sb, n.EndComments, indent)
+		sb.WriteString(indent + nvFormatInline(n) + "\n")
+		return
+	}
+	sb.WriteString(indent + "(" + n.Name + " =\n")
+	for _, c := range n.Children {
+		formatNVPair(sb, c, level+1)
+	}
+	writeNVComments(sb, n.EndComments, indent+tnsIndent)
+	sb.WriteString(indent + ")\n")
+}
+
+// writeNVComments writes comment lines with the indent of the pair they belong to
+func writeNVComments(sb *strings.Builder, comments []string, indent string) {
+	for _, c := range comments {
+		sb.WriteString(indent + c + "\n")
+	}
+}
+
+// nvHasComments checks if a pair or one of its children has comments
+func nvHasComments(n *NVPair) bool {
+	if len(n.Comments) > 0 || len(n.EndComments) > 0 {
+		return true
+	}
+	for _, c := range n.Children {
+		if nvHasComments(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func nvAllLeaves(n *NVPair) bool {
+	for _, c := range n.Children {
+		if len(c.Children) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// nvFormatInline formats a pair on one line with blanks around =
+func nvFormatInline(n *NVPair) string {
+	switch {
+	case len(n.Children) > 0:
+		var sb strings.Builder
+		for _, c := range n.Children {
+			sb.WriteString(nvFormatInline(c))
+		}
+		return "(" + n.Name + " = " + sb.String() + ")"
+	case len(n.List) > 0:
+		return "(" + n.Name + " = (" + strings.Join(strings.Split(nvQuoteList(n.List), ","), ", ") + "))"
+	}
+	return "(" + n.Name + " = " + nvQuote(n.Value) + ")"
+}
+
+// formatTNSItem writes an alias with its indented descriptor
+func formatTNSItem(names []string, n *NVPair) string {
+	return strings.Join(names, ", ") + " =\n" + FormatNVPair(n, 1)
+}
+
+// entryNode returns the parsed tree of an entry
+func entryNode(e TNSEntry) (*NVPair, error) {
+	if e.Descriptor != nil && e.Descriptor.Node != nil {
+		return e.Descriptor.Node, nil
+	}
+	d, err := ParseTNSDescriptor(e.Desc)
+	if err != nil {
+		return nil, fmt.Errorf("entry %s: %v", e.Name, err)
+	}
+	return d.Node, nil
+}
+
+// FormatTNSEntry returns an entry as formatted tnsnames.ora text
+func FormatTNSEntry(e TNSEntry) (string, error) {
+	n, err := entryNode(e)
+	if err != nil {
+		return "", err
+	}
+	return formatTNSItem([]string{e.Name}, n), nil
+}
+
+// FormatTnsnames returns the entries sorted by name as formatted tnsnames.ora text
+func FormatTnsnames(entries TNSEntries) (string, error) {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		e := entries[k]
+		if e.Name == "" {
+			e.Name = k
+		}
+		s, err := FormatTNSEntry(e)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// WriteTnsnames writes the entries as formatted tnsnames.ora file
+func WriteTnsnames(filename string, entries TNSEntries) error {
+	content, err := FormatTnsnames(entries)
+	if err != nil {
+		return err
+	}
+	log.Debugf("write %d entries to %s", len(entries), filename)
+	return common.WriteStringToFile(filename, content)
+}
+
+// ParseTNSFile parses tnsnames.ora content, entries must start in the first column and continue indented
+func ParseTNSFile(content string) (*TNSFile, error) {
+	f := &TNSFile{}
+	var pending []string
+	var skipped []string
+	var item *TNSFileItem
+	var desc strings.Builder
+	descColumn := 0
+	finish := func() error {
+		if item == nil || item.IFile != "" {
+			return nil
+		}
+		n, err := ParseNVPair(desc.String())
+		if err == nil {
+			_, err = NewTNSDescriptor(n)
+		}
+		if err != nil {
+			var pe *NVParseError
+			if errors.As(err, &pe) {
+				if pe.Line == 1 {
+					pe.Column += descColumn - 1
+				}
+				pe.Line += item.Line - 1
+			}
+			return fmt.Errorf("entry %s: %w", strings.Join(item.Names, ","), err)
+		}
+		item.Node = n
+		return nil
+	}
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			// keep comments until it is known if they belong to the next item
+			if trimmed != "" {
+				pending = append(pending, line)
+			}
+			skipped = append(skipped, line)
+			continue
+		case line[0] == ' ' || line[0] == '\t' || line[0] == '(' || line[0] == ')':
+			if item == nil || item.IFile != "" {
+				return nil, &NVParseError{Line: i + 1, Column: 1, Msg: "continuation line without entry"}
+			}
+			// skipped lines keep the positions, comments inside a descriptor are attached to its pairs
+			desc.WriteString(strings.Join(append(skipped, line), "\n") + "\n")
+			pending = nil
+			skipped = nil
+			continue
+		}
+		if err := finish(); err != nil {
+			return nil, err
+		}
+		if m := reTNSFileIfile.FindStringSubmatch(line); m != nil {
+			item = &TNSFileItem{IFile: m[1], Comments: pending, Line: i + 1}
+		} else if m = reTNSFileEntry.FindStringSubmatch(line); m != nil {
+			item = &TNSFileItem{Comments: pending, Line: i + 1}
+			for _, name := range strings.Split(m[1], ",") {
+				item.Names = append(item.Names, strings.TrimSpace(name))
+			}
+			desc.Reset()
+			desc.WriteString(m[2] + "\n")
+			descColumn = len(line) - len(m[2]) + 1
+		} else {
+			return nil, &NVParseError{Line: i + 1, Column: 1, Msg: "expected alias = descriptor or IFILE"}
+		}
+		pending = nil
+		skipped = nil
+		f.Items = append(f.Items, item)
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	f.Trailer = pending
+	return f, nil
+}
+
+// ReadTNSFile reads and parses a tnsnames file without following IFILE directives
+func ReadTNSFile(filename string) (*TNSFile, error) {
+	content, err := common.ReadFileToString(filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ParseTNSFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return f, nil
+}
+
+// String returns the formatted file with comments and IFILE directives
+func (f *TNSFile) String() string {
+	var parts []string
+	for _, item := range f.Items {
+		var sb strings.Builder
+		for _, c := range item.Comments {
+			sb.WriteString(c + "\n")
+		}
+		if item.IFile != "" {
+			sb.WriteString("IFILE = " + item.IFile + "\n")
+		} else {
+			sb.WriteString(formatTNSItem(item.Names, item.Node))
+		}
+		parts = append(parts, sb.String())
+	}
+	if len(f.Trailer) > 0 {
+		parts = append(parts, strings.Join(f.Trailer, "\n")+"\n")
+	}
+	return strings.Join(parts, "\n")
+}
+
+// WriteFile writes the formatted file
+func (f *TNSFile) WriteFile(filename string) error {
+	return common.WriteStringToFile(filename, f.String())
+}
+
+// Entries returns the entries of the file with upper case names, IFILE directives are not followed
+func (f *TNSFile) Entries(filename string) TNSEntries {
+	entries := make(TNSEntries)
+	for _, item := range f.Items {
+		if item.IFile != "" {
+			continue
+		}
+		desc := strings.TrimSuffix(FormatNVPair(item.Node, 0), "\n")
+		location := fmt.Sprintf("%s Line: %d", filename, item.Line)
+		for _, name := range item.Names {
+			alias := strings.ToUpper(name)
+			entries[alias] = BuildTnsEntry(location, desc, alias)
+		}
+	}
+	return entries
+}
+
+// find returns the item and index of the alias
+func (f *TNSFile) find(name string) (*TNSFileItem, int) {
+	for _, item := range f.Items {
+		for i, n := range item.Names {
+			if strings.EqualFold(n, name) {
+				return item, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// Set replaces the descriptor of an existing alias or appends a new entry
+func (f *TNSFile) Set(e TNSEntry) error {
+	n, err := entryNode(e)
+	if err != nil {
+		return err
+	}
+	item, i := f.find(e.Name)
+	switch {
+	case item == nil:
+		f.Items = append(f.Items, &TNSFileItem{Names: []string{e.Name}, Node: n})
+	case len(item.Names) == 1:
+		item.Node = n
+	default:
+		// split the alias from a shared entry
+		item.Names = slices.Delete(item.Names, i, i+1)
+		f.Items = append(f.Items, &TNSFileItem{Names: []string{e.Name}, Node: n})
+	}
+	return nil
+}
+
+// Remove deletes an alias and returns false if it was not found
+func (f *TNSFile) Remove(name string) bool {
+	item, i := f.find(name)
+	if item == nil {
+		return false
+	}
+	item.Names = slices.Delete(item.Names, i, i+1)
+	if len(item.Names) == 0 {
+		f.Items = slices.DeleteFunc(f.Items, func(it *TNSFileItem) bool { return it == item })
+	}
+	return true
+}
+
+// TNSDiff lists the names of entries which differ between two sets
+type TNSDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty reports if there are no differences
+func (d TNSDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// String returns a summary of the differences
+func (d TNSDiff) String() string {
+	return fmt.Sprintf("added: %v, removed: %v, changed: %v", d.Added, d.Removed, d.Changed)
+}
+
+// tnsCanonical returns a comparable form of an entry, names are upper case and blanks are removed
+func tnsCanonical(e TNSEntry) string {
+	n, err := entryNode(e)
+	if err != nil {
+		return strings.Join(strings.Fields(e.Desc), "")
+	}
+	return nvCanonical(n)
+}
+
+func nvCanonical(n *NVPair) string {
+	var sb strings.Builder
+	sb.WriteString("(" + strings.ToUpper(n.Name) + "=")
+	switch {
+	case len(n.Children) > 0:
+		for _, c := range n.Children {
+			sb.WriteString(nvCanonical(c))
+		}
+	case len(n.List) > 0:
+		sb.WriteString("(" + nvQuoteList(n.List) + ")")
+	default:
+		sb.WriteString(nvQuote(n.Value))
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// DiffTNSEntries compares two entry sets by name and descriptor content, formatting is ignored
+func DiffTNSEntries(from TNSEntries, to TNSEntries) (diff TNSDiff) {
+	for k, e := range to {
+		old, ok := from[k]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, k)
+		case tnsCanonical(old) != tnsCanonical(e):
+			diff.Changed = append(diff.Changed, k)
+		}
+	}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			diff.Removed = append(diff.Removed, k)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return
+}
+
+// MergeTNSEntries adds entries of update missing in base, changed entries are replaced if overwrite is set.
+// The returned diff describes the changes applied to base
+func MergeTNSEntries(base TNSEntries, update TNSEntries, overwrite bool) (merged TNSEntries, diff TNSDiff) {
+	merged = make(TNSEntries, len(base))
+	for k, e := range base {
+		merged[k] = e
+	}
+	all := DiffTNSEntries(base, update)
+	for _, k := range all.Added {
+		merged[k] = update[k]
+	}
+	diff.Added = all.Added
+	if overwrite {
+		for _, k := range all.Changed {
+			merged[k] = update[k]
+		}
+		diff.Changed = all.Changed
+	}
+	log.Debugf("merge tns entries: %s", diff)
+	return
+}
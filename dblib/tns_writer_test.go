@@ -0,0 +1,166 @@
+package dblib
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+const formattedXE = `XE =
+  (DESCRIPTION =
+    (ADDRESS_LIST =
+      (ADDRESS = (PROTOCOL = TCP)(HOST = 127.0.0.1)(PORT = 1521))
+    )
+    (CONNECT_DATA =
+      (SERVER = DEDICATED)
+      (SERVICE_NAME = XE)
+      (FAILOVER_MODE = (TYPE = select)(METHOD = basic))
+    )
+  )
+`
+
+func TestTNSWriter(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	t.Run("Format", func(t *testing.T) {
+		e := BuildTnsEntry("test", "(DESCRIPTION=(ADDRESS_LIST=(ADDRESS=(PROTOCOL=TCP)(HOST=127.0.0.1)(PORT=1521)))"+
+			"(CONNECT_DATA=(SERVER=DEDICATED)(SERVICE_NAME=XE)(FAILOVER_MODE=(TYPE=select)(METHOD=basic))))", "XE")
+		s, e2 := FormatTNSEntry(e)
+		require.NoError(t, e2)
+		assert.Equal(t, formattedXE, s)
+		n, e2 := ParseNVPair("(NAMES.DIRECTORY_PATH=(TNSNAMES,LDAP))")
+		require.NoError(t, e2)
+		assert.Equal(t, "(NAMES.DIRECTORY_PATH = (TNSNAMES, LDAP))\n", FormatNVPair(n, 0))
+		_, e2 = FormatTNSEntry(TNSEntry{Name: "BAD", Desc: "(DESCRIPTION="})
+		assert.ErrorContains(t, e2, "entry BAD")
+	})
+	t.Run("Round trip", func(t *testing.T) {
+		content := "# header comment\n\n" + tnsnamesora + "\n# trailing comment\n"
+		f, e := ParseTNSFile(content)
+		require.NoError(t, e)
+		require.Len(t, f.Items, 3)
+		assert.Equal(t, "ifile.ora", f.Items[0].IFile)
+		assert.Equal(t, []string{"# header comment", "# Test ifile relative"}, f.Items[0].Comments)
+		assert.Equal(t, []string{"DB_T.local"}, f.Items[1].Names)
+		assert.Equal(t, []string{"# trailing comment"}, f.Trailer)
+		out := f.String()
+		assert.True(t, strings.HasPrefix(out, "# header comment\n# Test ifile relative\nIFILE = ifile.ora\n\nDB_T.local =\n  (DESCRIPTION =\n"), out)
+		assert.Contains(t, out, "      (ADDRESS = (PROTOCOL = TCP)(HOST = vdb1.ora.local)(PORT = 1672))\n")
+		assert.True(t, strings.HasSuffix(out, ")\n\n# trailing comment\n"))
+
+		// formatting is stable and keeps the content
+		f2, e := ParseTNSFile(out)
+		require.NoError(t, e)
+		assert.Equal(t, out, f2.String())
+		assert.True(t, DiffTNSEntries(f.Entries("a"), f2.Entries("b")).Empty())
+
+		entries := f.Entries("tnsnames.ora")
+		require.Len(t, entries, 2)
+		assert.Equal(t, "DB_V.local", entries["DB_V.LOCAL"].Service)
+		assert.Equal(t, "tnsnames.ora Line: 6", entries["DB_T.LOCAL"].Location)
+		assert.Len(t, entries["DB_T.LOCAL"].Servers, 2)
+	})
+	t.Run("Descriptor Comments", func(t *testing.T) {
+		content := "XE =\n  (DESCRIPTION =\n    # primary\n    (ADDRESS = (PROTOCOL = TCP)(HOST = db1)(PORT = 1521))\n" +
+			"    (CONNECT_DATA =\n      (SERVICE_NAME = XE) # service\n\n      # dedicated only\n    )\n  ) # end\n\n# next\nYE = (DESCRIPTION = (ADDRESS = (PROTOCOL = TCP)(HOST = db2)(PORT = 1521)))\n"
+		f, e := ParseTNSFile(content)
+		require.NoError(t, e)
+		require.Len(t, f.Items, 2)
+		assert.Equal(t, []string{"# next"}, f.Items[1].Comments)
+		out := f.String()
+		assert.Equal(t, "XE =\n  (DESCRIPTION =\n    # primary\n    (ADDRESS = (PROTOCOL = TCP)(HOST = db1)(PORT = 1521))\n"+
+			"    (CONNECT_DATA =\n      (SERVICE_NAME = XE)\n      # service\n      # dedicated only\n    )\n    # end\n  )\n\n# next\nYE =\n", out[:strings.Index(out, "YE =\n")+5])
+		f2, e := ParseTNSFile(out)
+		require.NoError(t, e)
+		assert.Equal(t, out, f2.String(), "formatting is stable")
+		assert.Equal(t, "XE", f2.Entries("x")["XE"].Service)
+
+		// comments force the multi line format
+		n, e := ParseNVPair("(ADDRESS = (PROTOCOL = TCP)\n# port\n(PORT = 1521))")
+		require.NoError(t, e)
+		assert.Equal(t, "(ADDRESS =\n  (PROTOCOL = TCP)\n  # port\n  (PORT = 1521)\n)\n", FormatNVPair(n, 0))
+	})
+	t.Run("Edit", func(t *testing.T) {
+		f, e := ParseTNSFile("# shared\nA, B =\n  (DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h1)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=s1)))\n")
+		require.NoError(t, e)
+		require.NoError(t, f.Set(TNSEntry{Name: "B", Desc: "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h2)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=s2)))"}))
+		require.NoError(t, f.Set(TNSEntry{Name: "C", Desc: "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h3)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=s3)))"}))
+		assert.Error(t, f.Set(TNSEntry{Name: "D", Desc: "broken"}))
+		entries := f.Entries("x")
+		assert.Equal(t, "s1", entries["A"].Service)
+		assert.Equal(t, "s2", entries["B"].Service)
+		assert.Equal(t, "s3", entries["C"].Service)
+		assert.True(t, f.Remove("a"))
+		assert.False(t, f.Remove("a"))
+		assert.True(t, strings.HasPrefix(f.String(), "B =\n"), "comment of removed entry is dropped")
+		assert.Len(t, f.Items, 2)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			content string
+			line    int
+			column  int
+		}{
+			{"XE =\n  (DESCRIPTION =\n\n    (ADDRESS=(PROTOCOL=TCP)(HOST=x)(PORT=abc))\n    (CONNECT_DATA=(SID=x)))\n", 4, 37},
+			{"XE = (DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=x)(PORT=1521))(CONNECT_DATA=(SID=x))\n", 1, 84},
+			{"  (DESCRIPTION=)\n", 1, 1},
+			{"XE.error = Error\n", 1, 12},
+			{"ok = (DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=x)(PORT=1521)))\n!bad\n", 2, 1},
+		} {
+			_, e := ParseTNSFile(tc.content)
+			var pe *NVParseError
+			require.Truef(t, errors.As(e, &pe), "no parse error for %q: %v", tc.content, e)
+			assert.Equalf(t, tc.line, pe.Line, "line of %q: %v", tc.content, e)
+			assert.Equalf(t, tc.column, pe.Column, "column of %q: %v", tc.content, e)
+		}
+	})
+	t.Run("Diff and Merge", func(t *testing.T) {
+		base := TNSEntries{
+			"A": BuildTnsEntry("", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h1)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=a)))", "A"),
+			"B": BuildTnsEntry("", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h1)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=b)))", "B"),
+			"C": BuildTnsEntry("", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h1)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=c)))", "C"),
+		}
+		update := TNSEntries{
+			// only formatting and case of names differ
+			"A": BuildTnsEntry("", "(description = (address=(protocol=TCP)(host=h1)(port=1521))\n (connect_data=(service_name=a)))", "A"),
+			"B": BuildTnsEntry("", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h2)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=b)))", "B"),
+			"D": BuildTnsEntry("", "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCP)(HOST=h1)(PORT=1521))(CONNECT_DATA=(SERVICE_NAME=d)))", "D"),
+		}
+		diff := DiffTNSEntries(base, update)
+		assert.Equal(t, TNSDiff{Added: []string{"D"}, Removed: []string{"C"}, Changed: []string{"B"}}, diff)
+		assert.False(t, diff.Empty())
+
+		merged, applied := MergeTNSEntries(base, update, false)
+		assert.Len(t, merged, 4)
+		assert.Equal(t, "h1", merged["B"].Servers[0].Host)
+		assert.Equal(t, []string{"D"}, applied.Added)
+		assert.Empty(t, applied.Changed)
+		assert.Len(t, base, 3, "base must not be modified")
+		merged, applied = MergeTNSEntries(base, update, true)
+		assert.Equal(t, "h2", merged["B"].Servers[0].Host)
+		assert.Equal(t, []string{"B"}, applied.Changed)
+	})
+	t.Run("Write", func(t *testing.T) {
+		dir := path.Join(test.TestData, "tnswriter")
+		require.NoError(t, os.MkdirAll(dir, 0750))
+		filename := path.Join(dir, "tnsnames.ora")
+		f, e := ParseTNSFile(tnsnamesora)
+		require.NoError(t, e)
+		entries := f.Entries(filename)
+		require.NoError(t, WriteTnsnames(filename, entries))
+		read, _, e := GetTnsnames(filename, false)
+		require.NoError(t, e)
+		assert.True(t, DiffTNSEntries(entries, read).Empty())
+		content, _ := common.ReadFileToString(filename)
+		assert.True(t, strings.HasPrefix(content, "DB_T.LOCAL =\n"))
+	})
+}
@@ -65,53 +65,70 @@ func cleanAgeKeys(content string) []string {
 */
 // AgeDecryptFile decrypts a file using an age identity
 func AgeDecryptFile(filename string, identityFile string) (decryptedContent string, err error) {
-	decryptedContent = ""
+	// Read encrypted file
+	encrypted, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read encrypted file: %v", err)
+	}
+	return ageDecryptData(encrypted, identityFile)
+}
+
+// ageDecryptData decrypts age encrypted data using the identities from identityFile
+func ageDecryptData(encrypted []byte, identityFile string) (decryptedContent string, err error) {
+	decrypted, err := ageDecryptBytes(encrypted, identityFile)
+	decryptedContent = string(decrypted)
+	wipeBytes(decrypted)
+	return
+}
+
+// ageDecryptBytes is ageDecryptData returning the plain data in a buffer the caller can wipe
+func ageDecryptBytes(encrypted []byte, identityFile string) (decrypted []byte, err error) {
 	// Read private key
-	keyFile, err := os.Open(identityFile)
+	identities, err := readAgeIdentities(identityFile)
 	if err != nil {
-		err = fmt.Errorf("failed to open identity file '%s: %v", identityFile, err)
 		return
 	}
-	identities, err := age.ParseIdentities(keyFile)
+
+	decrypted, err = ageDecryptMessage(encrypted, identities)
 	if err != nil {
-		err = fmt.Errorf("failed to parse identity file '%s': %v", identityFile, err)
-		return
+		return nil, fmt.Errorf("failed to decrypt using identities from '%s': %v", identityFile, err)
 	}
+	return
+}
 
-	// Read encrypted file
-	encrypted, err := os.ReadFile(filename)
+// ageDecryptWithIdentities decrypts age encrypted data with already parsed identities
+func ageDecryptWithIdentities(encrypted []byte, identities []age.Identity) (string, error) {
+	decrypted, err := ageDecryptMessage(encrypted, identities)
 	if err != nil {
-		return "", fmt.Errorf("failed to read encrypted file: %v", err)
+		return "", err
 	}
+	content := string(decrypted)
+	wipeBytes(decrypted)
+	return content, nil
+}
 
-	// Decrypt
+// ageDecryptMessage is ageDecryptWithIdentities returning the plain data in a buffer the caller can wipe
+func ageDecryptMessage(encrypted []byte, identities []age.Identity) ([]byte, error) {
 	r, err := age.Decrypt(bytes.NewReader(encrypted), identities...)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt using identities from '%s': %v", identityFile, err)
+		return nil, err
 	}
 
 	// Read decrypted content
-	decryptedBytes, err := io.ReadAll(r)
+	decrypted, err := io.ReadAll(r)
 	if err != nil {
-		return "", fmt.Errorf("failed to read decrypted content: %v", err)
+		return nil, fmt.Errorf("failed to read decrypted content: %v", err)
 	}
-
-	return string(decryptedBytes), nil
+	return decrypted, nil
 }
 
 // AgeEncryptFile encrypts a file using an age recipient
 func AgeEncryptFile(plainFile string, targetFile string, recipientsFile string) error {
 	// Read recipient (public key)
-	recFile, err := os.Open(recipientsFile)
+	recipients, err := readAgeRecipients(recipientsFile)
 	if err != nil {
-		err = fmt.Errorf("failed to open recipients file '%s: %v", recipientsFile, err)
 		return err
 	}
-	recipients, err := age.ParseRecipients(recFile)
-	_ = recFile.Close()
-	if err != nil {
-		return fmt.Errorf("failed to parse recipient file '%s': %v", recipientsFile, err)
-	}
 
 	// Read plain content
 	plain, err := common.ReadFileToString(plainFile)
@@ -119,26 +136,67 @@ func AgeEncryptFile(plainFile string, targetFile string, recipientsFile string)
 		return fmt.Errorf("failed to read plain file: %v", err)
 	}
 
+	encrypted, err := ageEncryptWithRecipients([]byte(plain), recipients)
+	if err != nil {
+		return err
+	}
+
 	// Create encrypted file
-	encryptedFile, err := os.Create(targetFile)
+	err = os.WriteFile(targetFile, encrypted, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to create encrypted file '%s': %v", targetFile, err)
 	}
+	return nil
+}
 
+// ageEncryptWithRecipients encrypts data for the given age recipients
+func ageEncryptWithRecipients(plain []byte, recipients []age.Recipient) ([]byte, error) {
+	buf := new(bytes.Buffer)
 	// Create encryptor
-	w, err := age.Encrypt(encryptedFile, recipients...)
+	w, err := age.Encrypt(buf, recipients...)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt: %v", err)
+		return nil, fmt.Errorf("failed to encrypt: %v", err)
 	}
 
 	// Write and encrypt content
-	if _, err = w.Write([]byte(plain)); err != nil {
-		return fmt.Errorf("failed to write encrypted content: %v", err)
+	if _, err = w.Write(plain); err != nil {
+		return nil, fmt.Errorf("failed to write encrypted content: %v", err)
 	}
 
 	if err = w.Close(); err != nil {
-		return fmt.Errorf("failed to finalize encryption: %v", err)
+		return nil, fmt.Errorf("failed to finalize encryption: %v", err)
 	}
-	_ = encryptedFile.Close()
-	return nil
+	return buf.Bytes(), nil
+}
+
+// readAgeRecipients parses age recipients from a recipients file
+func readAgeRecipients(recipientsFile string) ([]age.Recipient, error) {
+	recFile, err := os.Open(recipientsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recipients file '%s: %v", recipientsFile, err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(recFile)
+	recipients, err := age.ParseRecipients(recFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse recipient file '%s': %v", recipientsFile, err)
+	}
+	return recipients, nil
+}
+
+// readAgeIdentities parses age identities from an identity file
+func readAgeIdentities(identityFile string) ([]age.Identity, error) {
+	keyFile, err := os.Open(identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file '%s: %v", identityFile, err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(keyFile)
+	identities, err := age.ParseIdentities(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file '%s': %v", identityFile, err)
+	}
+	return identities, nil
 }
@@ -0,0 +1,403 @@
+package pwlib
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	log "github.com/sirupsen/logrus"
+	"github.com/tommi2day/gomodules/common"
+)
+
+// DefaultAgentTTL is the default lifetime of an unlocked key in the agent
+var DefaultAgentTTL = 15 * time.Minute
+
+// AgentDecrypter decrypts crypted data with keys held by an agent
+type AgentDecrypter interface {
+	DecryptData(method string, crypted []byte, privateKeyFile string, keyPass string, sessionPassFile string) (string, error)
+}
+
+// agentKey holds an unlocked key and its expiration
+type agentKey struct {
+	keyFile   string
+	keyType   string
+	rsaKey    *rsa.PrivateKey
+	ecdsaKey  *ecdsa.PrivateKey
+	gpgKeys   openpgp.EntityList
+	ageKeys   []byte
+	expires   time.Time
+	expireJob *time.Timer
+	// refs counts running operations, a removed key is wiped when the last one is released
+	refs    int
+	removed bool
+}
+
+// KeyAgent keeps unlocked private keys in memory for a limited time
+type KeyAgent struct {
+	TTL  time.Duration
+	mu   sync.Mutex
+	keys map[string]*agentKey
+}
+
+// NewKeyAgent creates a new key agent, keys expire after ttl (0 means never, negative uses DefaultAgentTTL)
+func NewKeyAgent(ttl time.Duration) *KeyAgent {
+	if ttl < 0 {
+		ttl = DefaultAgentTTL
+	}
+	return &KeyAgent{
+		TTL:  ttl,
+		keys: make(map[string]*agentKey),
+	}
+}
+
+// AddKey reads and unlocks the private key from keyFile and keeps it until the agent TTL expires
+func (a *KeyAgent) AddKey(keyFile string, keyPass string) (err error) {
+	return a.AddKeyWithTTL(keyFile, keyPass, a.TTL)
+}
+
+// AddKeyWithTTL reads and unlocks the private key from keyFile and keeps it for the given ttl (0 means never)
+func (a *KeyAgent) AddKeyWithTTL(keyFile string, keyPass string, ttl time.Duration) (err error) {
+	var k *agentKey
+	log.Debugf("Agent: add key %s", keyFile)
+	name := agentKeyName(keyFile)
+	k, err = loadAgentKey(name, keyPass)
+	if err != nil {
+		log.Debugf("Agent: cannot unlock key %s: %s", keyFile, err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeLocked(name)
+	if ttl > 0 {
+		k.expires = time.Now().Add(ttl)
+		k.expireJob = time.AfterFunc(ttl, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if cur, ok := a.keys[name]; ok && cur == k {
+				log.Debugf("Agent: key %s expired", name)
+				a.removeLocked(name)
+			}
+		})
+	}
+	a.keys[name] = k
+	log.Debugf("Agent: key %s of type %s unlocked", name, k.keyType)
+	return
+}
+
+// HasKey checks if an unlocked key for keyFile is available
+func (a *KeyAgent) HasKey(keyFile string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.validLocked(agentKeyName(keyFile)) != nil
+}
+
+// ListKeys returns the key files of all unlocked keys
+func (a *KeyAgent) ListKeys() (keyFiles []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name := range a.keys {
+		keyFiles = append(keyFiles, name)
+	}
+	sort.Strings(keyFiles)
+	return
+}
+
+// RemoveKey removes and wipes the key for keyFile
+func (a *KeyAgent) RemoveKey(keyFile string) (found bool) {
+	name := agentKeyName(keyFile)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.removeLocked(name)
+}
+
+// Lock removes and wipes all keys
+func (a *KeyAgent) Lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name := range a.keys {
+		a.removeLocked(name)
+	}
+	log.Debug("Agent: all keys removed")
+}
+
+// DecryptFile decrypts a crypted file with the cached key, the key will be unlocked with keyPass if not yet available
+func (a *KeyAgent) DecryptFile(method string, cryptedFile string, privateKeyFile string, keyPass string, sessionPassFile string) (content string, err error) {
+	var crypted []byte
+	log.Debugf("Agent: decrypt %s with method %s", cryptedFile, method)
+	crypted, err = os.ReadFile(cryptedFile) //nolint gosec
+	if err != nil {
+		log.Debugf("Cannot Read file '%s': %s", cryptedFile, err)
+		return
+	}
+	return a.DecryptData(method, crypted, privateKeyFile, keyPass, sessionPassFile)
+}
+
+// DecryptData decrypts crypted data with the cached key, the key will be unlocked with keyPass if not yet available
+func (a *KeyAgent) DecryptData(method string, crypted []byte, privateKeyFile string, keyPass string, sessionPassFile string) (content string, err error) {
+	data := string(crypted)
+	name := agentKeyName(privateKeyFile)
+	k := a.acquire(name)
+	if k == nil {
+		if err = a.AddKey(privateKeyFile, keyPass); err != nil {
+			return
+		}
+		if k = a.acquire(name); k == nil {
+			err = fmt.Errorf("key %s expired before use", privateKeyFile)
+			return
+		}
+	}
+	// the key must not be wiped by expiry or removal while in use
+	defer a.release(k)
+
+	switch method {
+	case typeGO:
+		if err = k.require(k.rsaKey != nil, "rsa"); err == nil {
+			content, err = decryptGoData(data, k.rsaKey)
+		}
+	case typeOpenssl:
+		if err = k.require(k.rsaKey != nil, "rsa"); err == nil {
+			content, err = decryptSSLWithKey(data, k.rsaKey, sessionPassFile)
+		}
+	case typeGPG:
+		if err = k.require(len(k.gpgKeys) > 0, "gpg"); err == nil {
+			content, err = gpgDecryptWithKeyRing(data, k.gpgKeys)
+		}
+	case typeAge:
+		var identities []age.Identity
+		if err = k.require(len(k.ageKeys) > 0, "age"); err == nil {
+			if identities, err = age.ParseIdentities(bytes.NewReader(k.ageKeys)); err == nil {
+				content, err = ageDecryptWithIdentities([]byte(data), identities)
+			}
+		}
+	default:
+		err = fmt.Errorf("agent decryption not supported for method %s", method)
+	}
+	if err != nil {
+		log.Debugf("Agent: decrypt with method %s failed: %s", method, err)
+	}
+	return
+}
+
+func decryptSSLWithKey(data string, privkey *rsa.PrivateKey, sessionPassFile string) (content string, err error) {
+	var cryptedKey string
+	var sessionKey string
+	cryptedKey, err = common.ReadFileToString(sessionPassFile)
+	if err != nil {
+		return
+	}
+	sessionKey, err = rsaDecryptWithKey(cryptedKey, privkey)
+	if err != nil {
+		return
+	}
+	return decryptSSLData(data, sessionKey)
+}
+
+// require returns an error if the key has not the needed type
+func (k *agentKey) require(ok bool, keyType string) error {
+	if !ok {
+		return fmt.Errorf("no unlocked %s key for %s", keyType, k.keyFile)
+	}
+	return nil
+}
+
+// acquire returns a valid key and protects it from wiping until release is called
+func (a *KeyAgent) acquire(name string) *agentKey {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := a.validLocked(name)
+	if k != nil {
+		k.refs++
+	}
+	return k
+}
+
+// release ends the use of an acquired key and wipes it if it was removed meanwhile
+func (a *KeyAgent) release(k *agentKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k.refs--
+	if k.removed && k.refs == 0 {
+		k.wipe()
+	}
+}
+
+// validLocked returns the key if not expired, caller must hold the lock
+func (a *KeyAgent) validLocked(name string) *agentKey {
+	k, ok := a.keys[name]
+	if !ok {
+		return nil
+	}
+	if !k.expires.IsZero() && time.Now().After(k.expires) {
+		a.removeLocked(name)
+		return nil
+	}
+	return k
+}
+
+// removeLocked removes and wipes a key, caller must hold the lock
+func (a *KeyAgent) removeLocked(name string) bool {
+	k, ok := a.keys[name]
+	if !ok {
+		return false
+	}
+	delete(a.keys, name)
+	if k.expireJob != nil {
+		k.expireJob.Stop()
+	}
+	if k.refs > 0 {
+		// wiped by the last release
+		k.removed = true
+	} else {
+		k.wipe()
+	}
+	log.Debugf("Agent: key %s removed", name)
+	return true
+}
+
+// wipe overwrites the secret key material as far as accessible
+func (k *agentKey) wipe() {
+	wipeRsaKey(k.rsaKey)
+	wipeEcdsaKey(k.ecdsaKey)
+	for _, e := range k.gpgKeys {
+		if e.PrivateKey != nil {
+			wipePrivateKey(e.PrivateKey.PrivateKey)
+		}
+		for _, s := range e.Subkeys {
+			if s.PrivateKey != nil {
+				wipePrivateKey(s.PrivateKey.PrivateKey)
+			}
+		}
+	}
+	wipeBytes(k.ageKeys)
+	k.rsaKey = nil
+	k.ecdsaKey = nil
+	k.gpgKeys = nil
+	k.ageKeys = nil
+}
+
+func loadAgentKey(keyFile string, keyPass string) (k *agentKey, err error) {
+	var keyType string
+	keyType, err = GetKeyTypeFromFile(keyFile)
+	if err != nil {
+		return
+	}
+	k = &agentKey{keyFile: keyFile, keyType: keyType}
+	switch keyType {
+	case KeyTypeRSA:
+		_, k.rsaKey, err = GetPrivateKeyFromFile(keyFile, keyPass)
+		if err == nil && k.rsaKey == nil {
+			err = fmt.Errorf("no rsa private key found in %s", keyFile)
+		}
+	case KeyTypeECDSA:
+		_, k.ecdsaKey, err = GetEcdsaPrivateKeyFromFile(keyFile, keyPass)
+	case KeyTypeGPG:
+		k.gpgKeys, err = loadGPGKeyRing(keyFile, keyPass)
+	case KeyTypeAGE:
+		k.ageKeys, err = loadAgeIdentities(keyFile)
+	default:
+		err = fmt.Errorf("unsupported key type %s in %s", keyType, keyFile)
+	}
+	if err != nil {
+		k = nil
+	}
+	return
+}
+
+func loadGPGKeyRing(keyFile string, keyPass string) (entityList openpgp.EntityList, err error) {
+	var key string
+	var entity *openpgp.Entity
+	key, err = common.ReadFileToString(keyFile)
+	if err != nil {
+		return
+	}
+	entityList, err = GPGReadAmoredKeyRing(key)
+	if err != nil {
+		return
+	}
+	entity, err = GPGSelectEntity(entityList, "")
+	if err != nil {
+		return
+	}
+	err = GPGUnlockKey(entity, keyPass)
+	return
+}
+
+func loadAgeIdentities(keyFile string) (content []byte, err error) {
+	var data string
+	data, err = common.ReadFileToString(keyFile)
+	if err != nil {
+		return
+	}
+	content = []byte(data)
+	if _, err = age.ParseIdentities(bytes.NewReader(content)); err != nil {
+		wipeBytes(content)
+		content = nil
+		err = fmt.Errorf("failed to parse identity file '%s': %v", keyFile, err)
+	}
+	return
+}
+
+func agentKeyName(keyFile string) string {
+	if keyFile == "" {
+		return ""
+	}
+	if a, err := filepath.Abs(keyFile); err == nil {
+		return a
+	}
+	return keyFile
+}
+
+func wipePrivateKey(key any) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		wipeRsaKey(k)
+	case *ecdsa.PrivateKey:
+		wipeEcdsaKey(k)
+	}
+}
+
+func wipeRsaKey(key *rsa.PrivateKey) {
+	if key == nil {
+		return
+	}
+	wipeBigInt(key.D)
+	for _, p := range key.Primes {
+		wipeBigInt(p)
+	}
+	wipeBigInt(key.Precomputed.Dp)
+	wipeBigInt(key.Precomputed.Dq)
+	wipeBigInt(key.Precomputed.Qinv)
+}
+
+func wipeEcdsaKey(key *ecdsa.PrivateKey) {
+	if key == nil {
+		return
+	}
+	wipeBigInt(key.D)
+}
+
+func wipeBigInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	w := n.Bits()
+	for i := range w {
+		w[i] = 0
+	}
+	n.SetInt64(0)
+}
+
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
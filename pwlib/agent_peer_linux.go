@@ -0,0 +1,43 @@
+//go:build linux
+
+package pwlib
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// peerUID returns the uid of the process on the other side of a unix socket connection
+func peerUID(conn net.Conn) (uid int, err error) {
+	var cred *syscall.Ucred
+	var credErr error
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, errors.New("not a unix socket connection")
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	err = rc.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return -1, err
+	}
+	return int(cred.Uid), nil
+}
+
+// fileOwner returns the uid of the file owner
+func fileOwner(fi os.FileInfo) (int, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, false
+	}
+	return int(st.Uid), true
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package pwlib
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// peerUID is not supported on this platform
+func peerUID(_ net.Conn) (int, error) {
+	return -1, errors.ErrUnsupported
+}
+
+// fileOwner is not supported on this platform
+func fileOwner(_ os.FileInfo) (int, bool) {
+	return -1, false
+}
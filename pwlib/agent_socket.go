@@ -0,0 +1,348 @@
+package pwlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tommi2day/gomodules/common"
+)
+
+// AgentSocketEnv is the environment variable holding the agent socket path
+const AgentSocketEnv = "PWLIB_AGENT_SOCK"
+
+// agent socket operations
+const (
+	agentOpAdd     = "add"
+	agentOpHas     = "has"
+	agentOpList    = "list"
+	agentOpRemove  = "remove"
+	agentOpLock    = "lock"
+	agentOpDecrypt = "decrypt"
+)
+
+// agentRequest is a request sent to the agent socket
+type agentRequest struct {
+	Op              string `json:"op"`
+	Method          string `json:"method,omitempty"`
+	KeyFile         string `json:"key_file,omitempty"`
+	KeyPass         string `json:"key_pass,omitempty"`
+	Data            []byte `json:"data,omitempty"`
+	SessionPassFile string `json:"session_pass_file,omitempty"`
+	TTL             int64  `json:"ttl,omitempty"`
+}
+
+// agentResponse is the answer of the agent socket
+type agentResponse struct {
+	OK      bool     `json:"ok"`
+	Error   string   `json:"error,omitempty"`
+	Content string   `json:"content,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+}
+
+// GetAgentSocket returns the agent socket path from environment or a default in the private runtime dir of the user
+func GetAgentSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = path.Join(os.TempDir(), fmt.Sprintf("pwlib-agent-%d", os.Getuid()))
+	}
+	return common.GetStringEnv(AgentSocketEnv, path.Join(dir, "pwlib-agent.sock"))
+}
+
+// checkSocketDir ensures the socket directory is only accessible by the current user, it will be created if requested
+func checkSocketDir(socketPath string, create bool) (err error) {
+	var fi os.FileInfo
+	dir := filepath.Dir(socketPath)
+	if create {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("cannot create socket dir %s: %v", dir, err)
+		}
+	}
+	fi, err = os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("cannot access socket dir %s: %v", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("socket dir %s is not a directory", dir)
+	}
+	// windows has no unix permission bits
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("socket dir %s must not be accessible by other users (mode %s)", dir, fi.Mode().Perm())
+	}
+	if uid, ok := fileOwner(fi); ok && uid != os.Getuid() {
+		return fmt.Errorf("socket dir %s is owned by uid %d", dir, uid)
+	}
+	return
+}
+
+// checkPeer ensures the other side of the connection runs as the current user
+func checkPeer(conn net.Conn) error {
+	uid, err := peerUID(conn)
+	if errors.Is(err, errors.ErrUnsupported) {
+		// rely on the private socket dir only
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get peer credentials: %v", err)
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("peer uid %d does not match uid %d", uid, os.Getuid())
+	}
+	return nil
+}
+
+// AgentServer exposes a KeyAgent on a unix socket
+type AgentServer struct {
+	Agent      *KeyAgent
+	SocketPath string
+	listener   net.Listener
+	wg         sync.WaitGroup
+}
+
+// NewAgentServer creates a new socket server for the given agent
+func NewAgentServer(agent *KeyAgent, socketPath string) *AgentServer {
+	if socketPath == "" {
+		socketPath = GetAgentSocket()
+	}
+	return &AgentServer{Agent: agent, SocketPath: socketPath}
+}
+
+// Start listens on the unix socket and serves requests in background
+func (s *AgentServer) Start() (err error) {
+	if s.Agent == nil {
+		return errors.New("no agent given")
+	}
+	// the private dir protects the socket already while binding
+	if err = checkSocketDir(s.SocketPath, true); err != nil {
+		return
+	}
+	if common.FileExists(s.SocketPath) {
+		// remove stale socket if nobody is listening
+		if c, e := net.Dial("unix", s.SocketPath); e == nil {
+			_ = c.Close()
+			return fmt.Errorf("agent already running on %s", s.SocketPath)
+		}
+		_ = os.Remove(s.SocketPath)
+	}
+	s.listener, err = net.Listen("unix", s.SocketPath)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", s.SocketPath, err)
+	}
+	if err = os.Chmod(s.SocketPath, 0600); err != nil {
+		_ = s.listener.Close()
+		return fmt.Errorf("cannot set permissions on %s: %v", s.SocketPath, err)
+	}
+	log.Debugf("Agent: listening on %s", s.SocketPath)
+	s.wg.Add(1)
+	go s.serve()
+	return
+}
+
+// Stop closes the socket, waits for running requests and wipes all keys
+func (s *AgentServer) Stop() (err error) {
+	if s.listener == nil {
+		return
+	}
+	err = s.listener.Close()
+	s.wg.Wait()
+	s.listener = nil
+	s.Agent.Lock()
+	_ = os.Remove(s.SocketPath)
+	log.Debugf("Agent: stopped listening on %s", s.SocketPath)
+	return
+}
+
+func (s *AgentServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Warnf("Agent: accept failed: %s", err)
+			}
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *AgentServer) handle(conn net.Conn) {
+	var req agentRequest
+	var resp agentResponse
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	if err := checkPeer(conn); err != nil {
+		log.Warnf("Agent: connection rejected: %s", err)
+		return
+	}
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp = agentResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+	} else {
+		resp = s.process(req)
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Debugf("Agent: cannot send response: %s", err)
+	}
+}
+
+func (s *AgentServer) process(req agentRequest) (resp agentResponse) {
+	var err error
+	resp.OK = true
+	log.Debugf("Agent: request %s", req.Op)
+	switch req.Op {
+	case agentOpAdd:
+		ttl := s.Agent.TTL
+		if req.TTL > 0 {
+			ttl = time.Duration(req.TTL)
+		}
+		err = s.Agent.AddKeyWithTTL(req.KeyFile, req.KeyPass, ttl)
+	case agentOpHas:
+		resp.OK = s.Agent.HasKey(req.KeyFile)
+	case agentOpList:
+		resp.Keys = s.Agent.ListKeys()
+	case agentOpRemove:
+		resp.OK = s.Agent.RemoveKey(req.KeyFile)
+	case agentOpLock:
+		s.Agent.Lock()
+	case agentOpDecrypt:
+		resp.Content, err = s.Agent.DecryptData(req.Method, req.Data, req.KeyFile, req.KeyPass, req.SessionPassFile)
+	default:
+		err = fmt.Errorf("unknown agent operation %s", req.Op)
+	}
+	if err != nil {
+		resp.OK = false
+		resp.Error = err.Error()
+	}
+	return
+}
+
+// AgentClient talks to an AgentServer over its unix socket
+type AgentClient struct {
+	SocketPath string
+	Timeout    time.Duration
+}
+
+// NewAgentClient creates a new client for the agent listening on socketPath
+func NewAgentClient(socketPath string) *AgentClient {
+	if socketPath == "" {
+		socketPath = GetAgentSocket()
+	}
+	return &AgentClient{SocketPath: socketPath, Timeout: 30 * time.Second}
+}
+
+// IsRunning checks if an agent is listening on the socket
+func (c *AgentClient) IsRunning() bool {
+	conn, err := net.DialTimeout("unix", c.SocketPath, c.Timeout)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// AddKey unlocks the key in the agent, a ttl of 0 uses the agent default
+func (c *AgentClient) AddKey(keyFile string, keyPass string, ttl time.Duration) (err error) {
+	_, err = c.call(agentRequest{Op: agentOpAdd, KeyFile: agentKeyName(keyFile), KeyPass: keyPass, TTL: int64(ttl)})
+	return
+}
+
+// HasKey checks if the agent holds an unlocked key for keyFile
+func (c *AgentClient) HasKey(keyFile string) bool {
+	resp, err := c.call(agentRequest{Op: agentOpHas, KeyFile: agentKeyName(keyFile)})
+	return err == nil && resp.OK
+}
+
+// ListKeys returns the key files of all unlocked keys in the agent
+func (c *AgentClient) ListKeys() (keyFiles []string, err error) {
+	var resp agentResponse
+	resp, err = c.call(agentRequest{Op: agentOpList})
+	keyFiles = resp.Keys
+	return
+}
+
+// RemoveKey removes the key for keyFile from the agent
+func (c *AgentClient) RemoveKey(keyFile string) (found bool, err error) {
+	var resp agentResponse
+	resp, err = c.call(agentRequest{Op: agentOpRemove, KeyFile: agentKeyName(keyFile)})
+	found = resp.OK
+	return
+}
+
+// Lock removes all keys from the agent
+func (c *AgentClient) Lock() (err error) {
+	_, err = c.call(agentRequest{Op: agentOpLock})
+	return
+}
+
+// DecryptFile lets the agent decrypt the crypted file, the key is unlocked with keyPass if not yet available
+func (c *AgentClient) DecryptFile(method string, cryptedFile string, privateKeyFile string, keyPass string, sessionPassFile string) (content string, err error) {
+	var crypted []byte
+	crypted, err = os.ReadFile(cryptedFile) //nolint gosec
+	if err != nil {
+		return
+	}
+	return c.DecryptData(method, crypted, privateKeyFile, keyPass, sessionPassFile)
+}
+
+// DecryptData lets the agent decrypt the crypted data, the key is unlocked with keyPass if not yet available
+func (c *AgentClient) DecryptData(method string, crypted []byte, privateKeyFile string, keyPass string, sessionPassFile string) (content string, err error) {
+	var resp agentResponse
+	req := agentRequest{
+		Op:              agentOpDecrypt,
+		Method:          method,
+		KeyFile:         agentKeyName(privateKeyFile),
+		KeyPass:         keyPass,
+		Data:            crypted,
+		SessionPassFile: agentKeyName(sessionPassFile),
+	}
+	resp, err = c.call(req)
+	content = resp.Content
+	return
+}
+
+func (c *AgentClient) call(req agentRequest) (resp agentResponse, err error) {
+	var conn net.Conn
+	if err = checkSocketDir(c.SocketPath, false); err != nil {
+		return
+	}
+	conn, err = net.DialTimeout("unix", c.SocketPath, c.Timeout)
+	if err != nil {
+		err = fmt.Errorf("cannot connect to agent on %s: %v", c.SocketPath, err)
+		return
+	}
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	if err = checkPeer(conn); err != nil {
+		err = fmt.Errorf("agent on %s rejected: %v", c.SocketPath, err)
+		return
+	}
+	if c.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		err = fmt.Errorf("cannot send agent request: %v", err)
+		return
+	}
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		err = fmt.Errorf("cannot read agent response: %v", err)
+		return
+	}
+	if resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	return
+}
@@ -0,0 +1,210 @@
+package pwlib
+
+import (
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+func TestKeyAgent(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	agent := NewKeyAgent(0)
+	for _, m := range []string{typeGO, typeOpenssl, typeGPG, typeAge} {
+		app := "test_agent_" + m
+		pc := NewConfig(app, test.TestData, test.TestData, "agentpass", m)
+		_ = os.Remove(pc.PlainTextFile)
+		err = common.WriteStringToFile(pc.PlainTextFile, plain)
+		require.NoErrorf(t, err, "Create testdata failed")
+		switch m {
+		case typeGPG:
+			entity, _, e := CreateGPGEntity(testGPGName, "TestAgent", testGPGEmail, pc.KeyPass)
+			require.NoErrorf(t, e, "Prepare GPG Keys failed:%s", e)
+			err = ExportGPGKeyPair(entity, pc.PubKeyFile, pc.PrivateKeyFile)
+		case typeAge:
+			identity, _, e := CreateAgeIdentity()
+			require.NoErrorf(t, e, "Prepare Age Keys failed:%s", e)
+			err = ExportAgeKeyPair(identity, pc.PubKeyFile, pc.PrivateKeyFile)
+		default:
+			_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+		}
+		require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+		err = pc.EncryptFile()
+		require.NoErrorf(t, err, "Encryption failed:%s", err)
+
+		t.Run("Agent decrypt method "+m, func(t *testing.T) {
+			pc.Agent = agent
+			lines, e := pc.DecryptFile()
+			assert.NoErrorf(t, e, "Decryption failed:%s", e)
+			assert.Contains(t, lines, "test:testuser:testpass")
+			assert.True(t, agent.HasKey(pc.PrivateKeyFile), "key should be cached")
+			// cached key must work without passphrase
+			pc.KeyPass = ""
+			lines, e = pc.DecryptFile()
+			assert.NoErrorf(t, e, "Decryption with cached key failed:%s", e)
+			assert.Contains(t, lines, "test:testuser:testpass")
+		})
+	}
+
+	t.Run("Agent wrong passphrase", func(t *testing.T) {
+		pc := NewConfig("test_agent_"+typeGO, test.TestData, test.TestData, "wrong", typeGO)
+		a := NewKeyAgent(0)
+		err = a.AddKey(pc.PrivateKeyFile, pc.KeyPass)
+		assert.Error(t, err, "wrong passphrase should fail")
+		assert.False(t, a.HasKey(pc.PrivateKeyFile))
+	})
+
+	t.Run("Agent list and remove", func(t *testing.T) {
+		keys := agent.ListKeys()
+		assert.Len(t, keys, 4)
+		pc := NewConfig("test_agent_"+typeGO, test.TestData, test.TestData, "", typeGO)
+		k := agent.keys[agentKeyName(pc.PrivateKeyFile)].rsaKey
+		require.NotNil(t, k)
+		assert.True(t, agent.RemoveKey(pc.PrivateKeyFile))
+		assert.False(t, agent.HasKey(pc.PrivateKeyFile))
+		assert.Equal(t, int64(0), k.D.Int64(), "key should be wiped")
+		agent.Lock()
+		assert.Empty(t, agent.ListKeys())
+	})
+
+	t.Run("Agent key in use", func(t *testing.T) {
+		pc := NewConfig("test_agent_"+typeGO, test.TestData, test.TestData, "agentpass", typeGO)
+		a := NewKeyAgent(0)
+		err = a.AddKey(pc.PrivateKeyFile, pc.KeyPass)
+		require.NoError(t, err)
+		k := a.acquire(agentKeyName(pc.PrivateKeyFile))
+		require.NotNil(t, k)
+		a.Lock()
+		assert.False(t, a.HasKey(pc.PrivateKeyFile))
+		assert.NotEqual(t, int64(0), k.rsaKey.D.Int64(), "key in use must not be wiped")
+		a.release(k)
+		assert.Nil(t, k.rsaKey, "key should be wiped after release")
+	})
+
+	t.Run("Agent TTL", func(t *testing.T) {
+		pc := NewConfig("test_agent_"+typeGO, test.TestData, test.TestData, "agentpass", typeGO)
+		a := NewKeyAgent(0)
+		err = a.AddKeyWithTTL(pc.PrivateKeyFile, pc.KeyPass, 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.True(t, a.HasKey(pc.PrivateKeyFile))
+		time.Sleep(100 * time.Millisecond)
+		assert.False(t, a.HasKey(pc.PrivateKeyFile), "key should be expired")
+	})
+}
+
+func TestAgentSocket(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	app := "test_agent_socket"
+	pc := NewConfig(app, test.TestData, test.TestData, "agentpass", typeGO)
+	err = common.WriteStringToFile(pc.PlainTextFile, plain)
+	require.NoErrorf(t, err, "Create testdata failed")
+	_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+	require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+	err = pc.EncryptFile()
+	require.NoErrorf(t, err, "Encryption failed:%s", err)
+
+	// unix socket path length is limited, so use a short temp dir
+	dir, err := os.MkdirTemp("", "pwagent")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	socket := path.Join(dir, "agent.sock")
+	server := NewAgentServer(NewKeyAgent(time.Minute), socket)
+	err = server.Start()
+	require.NoErrorf(t, err, "Agent start failed:%s", err)
+	defer func() {
+		_ = server.Stop()
+	}()
+	client := NewAgentClient(socket)
+
+	t.Run("Agent running", func(t *testing.T) {
+		assert.True(t, client.IsRunning())
+		second := NewAgentServer(NewKeyAgent(0), socket)
+		assert.Error(t, second.Start(), "second agent should not start")
+	})
+	t.Run("Agent socket dir", func(t *testing.T) {
+		shared := path.Join(dir, "shared")
+		require.NoError(t, os.Mkdir(shared, 0700))
+		require.NoError(t, os.Chmod(shared, 0755))
+		s := NewAgentServer(NewKeyAgent(0), path.Join(shared, "agent.sock"))
+		assert.ErrorContains(t, s.Start(), "must not be accessible by other users")
+		_, e := NewAgentClient(path.Join(shared, "agent.sock")).ListKeys()
+		assert.ErrorContains(t, e, "must not be accessible by other users")
+		private := path.Join(dir, "private", "agent.sock")
+		require.NoError(t, checkSocketDir(private, true))
+		fi, e := os.Stat(path.Dir(private))
+		require.NoError(t, e)
+		assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+	})
+	t.Run("Agent add key", func(t *testing.T) {
+		assert.False(t, client.HasKey(pc.PrivateKeyFile))
+		err = client.AddKey(pc.PrivateKeyFile, "wrong", 0)
+		assert.Error(t, err, "wrong passphrase should fail")
+		err = client.AddKey(pc.PrivateKeyFile, pc.KeyPass, 0)
+		assert.NoError(t, err)
+		assert.True(t, client.HasKey(pc.PrivateKeyFile))
+		keys, e := client.ListKeys()
+		assert.NoError(t, e)
+		assert.Len(t, keys, 1)
+	})
+	t.Run("Agent GetPassword", func(t *testing.T) {
+		pc.KeyPass = ""
+		pc.Agent = client
+		pass, e := pc.GetPassword("test", "testuser")
+		assert.NoErrorf(t, e, "GetPassword failed:%s", e)
+		assert.Equal(t, "testpass", pass)
+	})
+	t.Run("Agent remove key", func(t *testing.T) {
+		found, e := client.RemoveKey(pc.PrivateKeyFile)
+		assert.NoError(t, e)
+		assert.True(t, found)
+		_, e = pc.GetPassword("test", "testuser")
+		assert.Error(t, e, "removed key should need passphrase")
+		err = client.Lock()
+		assert.NoError(t, err)
+	})
+}
+
+func TestAgentPeer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pwpeer")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	socket := path.Join(dir, "peer.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+	go func() {
+		if c, e := l.Accept(); e == nil {
+			_ = c.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	assert.NoError(t, checkPeer(conn), "same user should be accepted")
+	if runtime.GOOS == "linux" {
+		uid, e := peerUID(conn)
+		assert.NoError(t, e)
+		assert.Equal(t, os.Getuid(), uid)
+	}
+}
@@ -0,0 +1,265 @@
+package pwlib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/tommi2day/gomodules/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// audit results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is a single audit record of a password store operation
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Host      string    `json:"host"`
+	App       string    `json:"app,omitempty"`
+	Operation string    `json:"operation"`
+	System    string    `json:"system,omitempty"`
+	Account   string    `json:"account,omitempty"`
+	Method    string    `json:"method"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	// PrevHash and Hash chain the events of an AuditFileSink with HMAC-SHA256
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// AuditSink receives audit events
+type AuditSink interface {
+	WriteEvent(event AuditEvent) error
+}
+
+// AuditFunc is a callback audit sink
+type AuditFunc func(event AuditEvent) error
+
+// WriteEvent implements AuditSink
+func (f AuditFunc) WriteEvent(event AuditEvent) error {
+	return f(event)
+}
+
+// auditUser returns the name of the current os user
+func auditUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// audit sends an event for the operation to the configured sink, sink errors are logged only
+func (pc *PassConfig) audit(operation string, system string, account string, opErr error) {
+	if pc.Audit == nil {
+		return
+	}
+	event := AuditEvent{
+		Time:      time.Now().UTC(),
+		User:      auditUser(),
+		Host:      common.GetHostname(),
+		App:       pc.AppName,
+		Operation: operation,
+		System:    system,
+		Account:   account,
+		Method:    pc.Method,
+		Result:    AuditSuccess,
+	}
+	if opErr != nil {
+		event.Result = AuditFailure
+		event.Error = opErr.Error()
+	}
+	if err := pc.Audit.WriteEvent(event); err != nil {
+		log.Errorf("cannot write audit event for %s: %v", operation, err)
+	}
+}
+
+// AuditFileSink appends events as JSON lines, each event contains the HMAC-SHA256 of itself and the previous event.
+// Without the key events cannot be modified, inserted or removed without breaking the chain.
+// Truncated files are only detected by comparing the last hash with a copy kept elsewhere
+type AuditFileSink struct {
+	Filename string
+	key      []byte
+	mu       sync.Mutex
+}
+
+// NewAuditFileSink opens an audit file with the secret chain key and verifies existing events
+func NewAuditFileSink(filename string, key []byte) (sink *AuditFileSink, err error) {
+	if len(key) == 0 {
+		return nil, errors.New("audit key required")
+	}
+	sink = &AuditFileSink{Filename: filename, key: append([]byte(nil), key...)}
+	if !common.FileExists(filename) {
+		return
+	}
+	_, _, err = VerifyAuditFile(filename, key)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// WriteEvent appends the event to the audit file, the file is locked while the last event is read and the new one appended
+func (s *AuditFileSink) WriteEvent(event AuditEvent) (err error) {
+	var line []byte
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	//nolint gosec
+	f, err := os.OpenFile(s.Filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open audit file %s: %v", s.Filename, err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	if err = lockAuditFile(f); err != nil {
+		return fmt.Errorf("cannot lock audit file %s: %v", s.Filename, err)
+	}
+	defer func(f *os.File) {
+		_ = unlockAuditFile(f)
+	}(f)
+	// other sinks may have appended events since the last write
+	event.PrevHash, err = s.lastHash(f)
+	if err != nil {
+		return
+	}
+	event.Hash, err = auditEventHash(event, s.key)
+	if err != nil {
+		return
+	}
+	line, err = json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(append(line, '\n'))
+	return
+}
+
+// lastHash reads the last event of the locked audit file and checks its hash
+func (s *AuditFileSink) lastHash(f *os.File) (string, error) {
+	var event AuditEvent
+	line, err := auditLastLine(f)
+	if err != nil || line == nil {
+		return "", err
+	}
+	if err = json.Unmarshal(line, &event); err != nil {
+		return "", fmt.Errorf("last audit event is invalid: %v", err)
+	}
+	hash, err := auditEventHash(event, s.key)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(hash), []byte(event.Hash)) {
+		return "", errors.New("last audit event has been modified")
+	}
+	return event.Hash, nil
+}
+
+// auditLastLine returns the last non empty line of the file or nil for an empty file
+func auditLastLine(f *os.File) ([]byte, error) {
+	const maxLine = 1024 * 1024
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	n := min(size, maxLine+1)
+	buf := make([]byte, n)
+	if _, err = f.ReadAt(buf, size-n); err != nil {
+		return nil, fmt.Errorf("cannot read audit file: %v", err)
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	i := bytes.LastIndexByte(buf, '\n')
+	if i < 0 && n < size {
+		return nil, errors.New("last audit event is too long")
+	}
+	return buf[i+1:], nil
+}
+
+// auditEventHash returns the HMAC-SHA256 of the event without its own hash
+func auditEventHash(event AuditEvent, key []byte) (string, error) {
+	event.Hash = ""
+	b, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifyAuditFile checks the hash chain of an audit file with the chain key and returns the number of events and the last hash
+func VerifyAuditFile(filename string, key []byte) (count int, lastHash string, err error) {
+	if len(key) == 0 {
+		return 0, "", errors.New("audit key required")
+	}
+	//nolint gosec
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot open audit file %s: %v", filename, err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event AuditEvent
+		var hash string
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		count++
+		if err = json.Unmarshal(line, &event); err != nil {
+			return count, lastHash, fmt.Errorf("audit event %d is invalid: %v", count, err)
+		}
+		if event.PrevHash != lastHash {
+			return count, lastHash, fmt.Errorf("audit event %d breaks the hash chain", count)
+		}
+		hash, err = auditEventHash(event, key)
+		if err != nil {
+			return
+		}
+		if !hmac.Equal([]byte(hash), []byte(event.Hash)) {
+			return count, lastHash, fmt.Errorf("audit event %d has been modified", count)
+		}
+		lastHash = event.Hash
+	}
+	err = scanner.Err()
+	if err == nil {
+		log.Debugf("audit file %s verified with %d events", filename, count)
+	}
+	return
+}
+
+// AuditMultiSink sends events to several sinks
+type AuditMultiSink []AuditSink
+
+// WriteEvent implements AuditSink and returns all sink errors
+func (m AuditMultiSink) WriteEvent(event AuditEvent) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.WriteEvent(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
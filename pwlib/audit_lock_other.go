@@ -0,0 +1,18 @@
+//go:build !unix && !windows
+
+package pwlib
+
+import (
+	"errors"
+	"os"
+)
+
+// lockAuditFile is not supported on this platform
+func lockAuditFile(_ *os.File) error {
+	return errors.ErrUnsupported
+}
+
+// unlockAuditFile is not supported on this platform
+func unlockAuditFile(_ *os.File) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package pwlib
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockAuditFile takes an exclusive lock shared with other processes writing the audit file
+func lockAuditFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX) //nolint gosec
+}
+
+// unlockAuditFile releases the audit file lock
+func unlockAuditFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN) //nolint gosec
+}
@@ -0,0 +1,17 @@
+package pwlib
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockAuditFile takes an exclusive lock shared with other processes writing the audit file
+func lockAuditFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
+
+// unlockAuditFile releases the audit file lock
+func unlockAuditFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+
+package pwlib
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// AuditSyslogSink sends events as JSON to syslog
+type AuditSyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewAuditSyslogSink connects to syslog, empty network and raddr use the local syslog daemon
+func NewAuditSyslogSink(network string, raddr string, tag string) (*AuditSyslogSink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_AUTHPRIV, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditSyslogSink{writer: w}, nil
+}
+
+// WriteEvent implements AuditSink, failed operations are logged with warning priority
+func (s *AuditSyslogSink) WriteEvent(event AuditEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Result == AuditFailure {
+		return s.writer.Warning(string(b))
+	}
+	return s.writer.Info(string(b))
+}
+
+// Close closes the syslog connection
+func (s *AuditSyslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,23 @@
+//go:build windows || plan9
+
+package pwlib
+
+import "errors"
+
+// AuditSyslogSink is not available on this platform
+type AuditSyslogSink struct{}
+
+// NewAuditSyslogSink returns an error as syslog is not supported on this platform
+func NewAuditSyslogSink(_ string, _ string, _ string) (*AuditSyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// WriteEvent implements AuditSink
+func (s *AuditSyslogSink) WriteEvent(_ AuditEvent) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+// Close implements io.Closer
+func (s *AuditSyslogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,117 @@
+package pwlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+func TestAudit(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	app := "test_audit"
+	pc := NewConfig(app, test.TestData, test.TestData, app, typeGO)
+	err = common.WriteStringToFile(pc.PlainTextFile, plain)
+	require.NoErrorf(t, err, "Create testdata failed")
+	_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+	require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+
+	auditFile := path.Join(test.TestData, app+".audit.jsonl")
+	auditKey := []byte("test audit chain key")
+	_ = os.Remove(auditFile)
+	_, err = NewAuditFileSink(auditFile, nil)
+	require.Error(t, err, "sink without key should fail")
+	fileSink, err := NewAuditFileSink(auditFile, auditKey)
+	require.NoError(t, err)
+	var events []AuditEvent
+	pc.Audit = AuditMultiSink{fileSink, AuditFunc(func(e AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})}
+
+	t.Run("Audit operations", func(t *testing.T) {
+		err = pc.EncryptFile()
+		require.NoError(t, err)
+		_, err = pc.GetPassword("test", "testuser")
+		require.NoError(t, err)
+		_, err = pc.GetPassword("test", "unknown")
+		require.Error(t, err)
+		_, err = pc.ListPasswords()
+		require.NoError(t, err)
+		err = pc.SignFile()
+		require.NoError(t, err)
+		require.Len(t, events, 5)
+		assert.Equal(t, "EncryptFile", events[0].Operation)
+		assert.Equal(t, "GetPassword", events[1].Operation)
+		assert.Equal(t, "test", events[1].System)
+		assert.Equal(t, "testuser", events[1].Account)
+		assert.Equal(t, AuditSuccess, events[1].Result)
+		assert.Equal(t, AuditFailure, events[2].Result)
+		assert.NotEmpty(t, events[2].Error)
+		assert.Equal(t, "SignFile", events[4].Operation)
+		assert.Equal(t, common.GetHostname(), events[0].Host)
+		assert.Equal(t, typeGO, events[0].Method)
+		content, _ := common.ReadFileToString(auditFile)
+		assert.NotContains(t, content, "testpass", "audit log must not contain secrets")
+	})
+	t.Run("Audit chain", func(t *testing.T) {
+		count, last, e := VerifyAuditFile(auditFile, auditKey)
+		assert.NoError(t, e)
+		assert.Equal(t, 5, count)
+		// a second sink on the same file and the first one continue the same chain
+		s, e := NewAuditFileSink(auditFile, auditKey)
+		require.NoError(t, e)
+		e = s.WriteEvent(AuditEvent{Operation: "Test", Result: AuditSuccess})
+		require.NoError(t, e)
+		e = fileSink.WriteEvent(AuditEvent{Operation: "Test", Result: AuditSuccess})
+		require.NoError(t, e)
+		count, next, e := VerifyAuditFile(auditFile, auditKey)
+		assert.NoError(t, e)
+		assert.Equal(t, 7, count)
+		assert.NotEqual(t, last, next)
+		_, _, e = VerifyAuditFile(auditFile, []byte("wrong key"))
+		assert.ErrorContains(t, e, "modified", "wrong key should fail")
+	})
+	t.Run("Audit tampered", func(t *testing.T) {
+		content, _ := common.ReadFileToString(auditFile)
+		tampered := strings.Replace(content, `"account":"unknown"`, `"account":"other"`, 1)
+		require.NotEqual(t, content, tampered)
+		tamperedFile := path.Join(test.TestData, app+".tampered.jsonl")
+		_ = common.WriteStringToFile(tamperedFile, tampered)
+		_, _, e := VerifyAuditFile(tamperedFile, auditKey)
+		assert.ErrorContains(t, e, "modified")
+		lines := strings.Split(strings.TrimSpace(content), "\n")
+		removed := strings.Join(append([]string{lines[0]}, lines[2:]...), "\n")
+		_ = common.WriteStringToFile(tamperedFile, removed)
+		_, _, e = VerifyAuditFile(tamperedFile, auditKey)
+		assert.ErrorContains(t, e, "hash chain")
+		_, e = NewAuditFileSink(tamperedFile, auditKey)
+		assert.Error(t, e, "sink should not continue a broken chain")
+		// rehashing a modified event without the key is detected
+		var event AuditEvent
+		require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &event))
+		event.Account = "other"
+		event.Hash = ""
+		b, _ := json.Marshal(event)
+		sum := sha256.Sum256(b)
+		event.Hash = hex.EncodeToString(sum[:])
+		b, _ = json.Marshal(event)
+		forged := strings.Join(append(lines[:len(lines)-1], string(b)), "\n") + "\n"
+		_ = common.WriteStringToFile(tamperedFile, forged)
+		_, _, e = VerifyAuditFile(tamperedFile, auditKey)
+		assert.ErrorContains(t, e, "modified")
+		s := &AuditFileSink{Filename: tamperedFile, key: auditKey}
+		e = s.WriteEvent(AuditEvent{Operation: "Test", Result: AuditSuccess})
+		assert.ErrorContains(t, e, "modified", "sink should not append to a forged event")
+	})
+}
@@ -0,0 +1,285 @@
+package pwlib
+
+// Deterministic site specific passwords in the style of spectre/master password:
+// a master key is derived once from the master secret, per site a seed is computed with HMAC
+// and expanded to a key stream, which is mapped to the profile by a frozen, versioned formatter.
+// The formatter does not use the PasswordGenerator, so changes there cannot change derived passwords.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/scrypt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// supported key derivation functions for the master key
+const (
+	DeriveScrypt   = "scrypt"
+	DeriveArgon2id = "argon2id"
+)
+
+// DeriveVersion1 is the first mapping of the key stream to password characters
+const DeriveVersion1 = 1
+
+// character classes of DeriveVersion1, they must never change
+const (
+	deriveUpperV1    = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	deriveLowerV1    = "abcdefghijklmnopqrstuvwxyz"
+	deriveDigitsV1   = "0123456789"
+	deriveSpecialsV1 = "!?#()$-_="
+)
+
+const (
+	deriveMasterScope = "pwlib.derive.master.v1"
+	deriveSiteScope   = "pwlib.derive.site.v1"
+	deriveKeyLen      = 64
+)
+
+// DeriveParams are the key derivation parameters, all parameters must be the same to regenerate a password
+type DeriveParams struct {
+	// Version selects the mapping of key bytes to characters, 0 uses DeriveVersion1
+	Version       int
+	Algorithm     string
+	ScryptN       int
+	ScryptR       int
+	ScryptP       int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// DefaultDeriveParams uses argon2id with 64 MiB memory
+var DefaultDeriveParams = DeriveParams{
+	Algorithm:     DeriveArgon2id,
+	ScryptN:       32768,
+	ScryptR:       8,
+	ScryptP:       2,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 4,
+}
+
+// PasswordDeriver derives site specific passwords from a master secret
+type PasswordDeriver struct {
+	// Profiles are the named password profiles, nil uses DefaultPasswordProfileSets
+	Profiles  PasswordProfileSets
+	masterKey *Secret
+	version   int
+}
+
+// NewPasswordDeriver derives the master key from the master secret and the identity (e.g. user name) using params
+func NewPasswordDeriver(master *Secret, identity string, params DeriveParams) (d *PasswordDeriver, err error) {
+	var key []byte
+	if master.Len() == 0 {
+		return nil, errors.New("master secret is empty")
+	}
+	version := params.Version
+	if version == 0 {
+		version = DeriveVersion1
+	}
+	if version != DeriveVersion1 {
+		return nil, fmt.Errorf("derive version %d not supported", params.Version)
+	}
+	salt := deriveMessage(deriveMasterScope, identity)
+	log.Debugf("derive master key with %s for identity %s", params.Algorithm, identity)
+	switch params.Algorithm {
+	case DeriveScrypt:
+		err = master.Use(func(b []byte) (e error) {
+			key, e = scrypt.Key(b, salt, params.ScryptN, params.ScryptR, params.ScryptP, deriveKeyLen)
+			return
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scrypt failed: %v", err)
+		}
+	case DeriveArgon2id:
+		if params.Argon2Time == 0 || params.Argon2Memory == 0 || params.Argon2Threads == 0 {
+			return nil, errors.New("invalid argon2 parameters")
+		}
+		if err = master.Use(func(b []byte) error {
+			key = argon2.IDKey(b, salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, deriveKeyLen)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("derive algorithm %s not supported", params.Algorithm)
+	}
+	return &PasswordDeriver{masterKey: NewSecret(key), version: version}, nil
+}
+
+// deriveMessage encodes the scope and all fields with length prefix, so field boundaries cannot be shifted
+func deriveMessage(scope string, fields ...string) []byte {
+	msg := []byte(scope)
+	for _, f := range fields {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(f))) //nolint gosec
+		msg = append(msg, f...)
+	}
+	return msg
+}
+
+// DerivePassword returns the password for system, account and counter formatted to the named profile.
+// The system is compared case-insensitive, an empty profile name uses "default"
+func (d *PasswordDeriver) DerivePassword(system string, account string, counter uint32, profileName string) (password string, err error) {
+	var pps PasswordProfileSet
+	if d.masterKey.Destroyed() {
+		return "", errors.New("deriver has been destroyed")
+	}
+	profiles := d.Profiles
+	if profiles == nil {
+		profiles = DefaultPasswordProfileSets
+	}
+	if profileName == "" {
+		profileName = "default"
+	}
+	pps, ok := profiles[profileName]
+	if !ok {
+		return "", fmt.Errorf("profile %s not found", profileName)
+	}
+	log.Debugf("DerivePassword for '%s'@'%s' counter %d profile %s", account, system, counter, profileName)
+	msg := deriveMessage(deriveSiteScope, strings.ToLower(system), account)
+	msg = binary.BigEndian.AppendUint32(msg, counter)
+	var seed []byte
+	if err = d.masterKey.Use(func(key []byte) error {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(msg)
+		seed = mac.Sum(nil)
+		return nil
+	}); err != nil {
+		return
+	}
+	defer wipeBytes(seed)
+	stream, err := chacha20.NewUnauthenticatedCipher(seed, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		return
+	}
+	switch d.version {
+	case DeriveVersion1:
+		return deriveFormatV1(&keyStreamReader{stream: stream}, pps)
+	}
+	return "", fmt.Errorf("derive version %d not supported", d.version)
+}
+
+// deriveFormatV1 maps the key stream to a password of the profile without retries:
+// the required characters of each class are drawn first, the rest from all classes,
+// then the password is shuffled and a letter is swapped to the front if required.
+// The output for a given key stream must never change, add a new version instead
+func deriveFormatV1(r io.Reader, pps PasswordProfileSet) (string, error) {
+	pp := pps.Profile
+	specials := pps.SpecialChars
+	if specials == "" && pp.Special > 0 {
+		specials = deriveSpecialsV1
+	}
+	upper := []rune(deriveUpperV1)
+	lower := []rune(deriveLowerV1)
+	digits := []rune(deriveDigitsV1)
+	special := deriveUniqueRunes(specials)
+	letters := append(append([]rune{}, upper...), lower...)
+	all := deriveUniqueRunes(deriveUpperV1 + deriveLowerV1 + deriveDigitsV1 + specials)
+	classes := []struct {
+		chars []rune
+		count int
+	}{{upper, pp.Upper}, {lower, pp.Lower}, {digits, pp.Digits}, {special, pp.Special}}
+	if pp.FirstIsChar && pp.Upper <= 0 && pp.Lower <= 0 {
+		classes = append(classes, struct {
+			chars []rune
+			count int
+		}{letters, 1})
+	}
+	password := make([]rune, 0, max(pp.Length, 0))
+	defer clear(password)
+	for _, c := range classes {
+		if c.count > 0 && len(c.chars) == 0 {
+			return "", errors.New("profile requires special characters but none are defined")
+		}
+		for i := 0; i < c.count; i++ {
+			if len(password) == pp.Length {
+				return "", errors.New("profile requires more characters than its length")
+			}
+			n, err := deriveIntn(r, len(c.chars))
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c.chars[n])
+		}
+	}
+	for len(password) < pp.Length {
+		n, err := deriveIntn(r, len(all))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, all[n])
+	}
+	if len(password) == 0 {
+		return "", errors.New("profile length must be positive")
+	}
+	// Fisher-Yates shuffle
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := deriveIntn(r, i+1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	if pp.FirstIsChar {
+		for i, c := range password {
+			if strings.ContainsRune(deriveUpperV1+deriveLowerV1, c) {
+				password[0], password[i] = password[i], password[0]
+				break
+			}
+		}
+	}
+	return string(password), nil
+}
+
+// deriveIntn reads big endian uint32 values until one is below the largest multiple of n and returns it modulo n
+func deriveIntn(r io.Reader, n int) (int, error) {
+	var b [4]byte
+	limit := math.MaxUint32 - math.MaxUint32%uint32(n) //nolint gosec
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		if v := binary.BigEndian.Uint32(b[:]); v < limit {
+			return int(v % uint32(n)), nil //nolint gosec
+		}
+	}
+}
+
+// deriveUniqueRunes returns the characters of s in order of first occurrence
+func deriveUniqueRunes(s string) (result []rune) {
+	seen := map[rune]bool{}
+	for _, c := range s {
+		if !seen[c] {
+			seen[c] = true
+			result = append(result, c)
+		}
+	}
+	return
+}
+
+// Destroy wipes the master key
+func (d *PasswordDeriver) Destroy() {
+	d.masterKey.Destroy()
+}
+
+// keyStreamReader returns the key stream of a stream cipher as deterministic random source
+type keyStreamReader struct {
+	stream *chacha20.Cipher
+}
+
+// Read implements io.Reader
+func (r *keyStreamReader) Read(p []byte) (int, error) {
+	clear(p)
+	r.stream.XORKeyStream(p, p)
+	return len(p), nil
+}
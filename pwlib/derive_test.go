@@ -0,0 +1,124 @@
+package pwlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20"
+)
+
+// cheap parameters for tests only
+var testDeriveParams = DeriveParams{
+	Algorithm:     DeriveArgon2id,
+	ScryptN:       1024,
+	ScryptR:       8,
+	ScryptP:       1,
+	Argon2Time:    1,
+	Argon2Memory:  1024,
+	Argon2Threads: 1,
+}
+
+const testDeriveProfiles = `
+default:
+  profile:
+    length: 16
+    upper: 1
+    lower: 1
+    digits: 1
+    specials: 1
+    first_is_char: true
+  special_chars: "!?#()$-_="
+short:
+  profile:
+    length: 8
+    upper: 1
+    lower: 1
+    digits: 1
+`
+
+func TestPasswordDeriver(t *testing.T) {
+	profiles, err := LoadPasswordProfileSets(testDeriveProfiles)
+	require.NoError(t, err)
+	for _, alg := range []string{DeriveArgon2id, DeriveScrypt} {
+		params := testDeriveParams
+		params.Algorithm = alg
+		d, e := NewPasswordDeriver(NewSecretString("correct horse battery staple"), "alice", params)
+		require.NoErrorf(t, e, "Create deriver failed:%s", e)
+		d.Profiles = profiles
+		t.Run("Derive "+alg, func(t *testing.T) {
+			p1, e := d.DerivePassword("db01.example.com", "scott", 1, "")
+			require.NoError(t, e)
+			p2, e := d.DerivePassword("DB01.example.com", "scott", 1, "default")
+			require.NoError(t, e)
+			assert.Equal(t, p1, p2, "same input should derive same password")
+			pp, cs := profiles["default"].Load()
+			assert.True(t, DoPasswordCheck(p1, pp, cs), "password should match profile")
+			p3, _ := d.DerivePassword("db01.example.com", "scott", 2, "")
+			p4, _ := d.DerivePassword("db01.example.com", "system", 1, "")
+			p5, _ := d.DerivePassword("db02.example.com", "scott", 1, "")
+			assert.NotEqual(t, p1, p3, "counter should change password")
+			assert.NotEqual(t, p1, p4, "account should change password")
+			assert.NotEqual(t, p1, p5, "system should change password")
+			short, e := d.DerivePassword("db01.example.com", "scott", 1, "short")
+			require.NoError(t, e)
+			assert.Len(t, short, 8)
+			_, e = d.DerivePassword("db01.example.com", "scott", 1, "unknown")
+			assert.Error(t, e)
+		})
+		d.Destroy()
+		_, e = d.DerivePassword("db01.example.com", "scott", 1, "")
+		assert.Error(t, e, "destroyed deriver should fail")
+	}
+
+	t.Run("Known answer", func(t *testing.T) {
+		d, e := NewPasswordDeriver(NewSecretString("master"), "alice", testDeriveParams)
+		require.NoError(t, e)
+		defer d.Destroy()
+		p, e := d.DerivePassword("example.com", "alice", 1, "")
+		require.NoError(t, e)
+		// derivation must never change, otherwise stored nowhere passwords are lost
+		assert.Equal(t, "b20HE7bQ81Tuc=rC", p)
+		// the generator settings must not influence derived passwords
+		defer func(n int) { MaxGenpassTrys = n }(MaxGenpassTrys)
+		MaxGenpassTrys = 0
+		p, e = d.DerivePassword("example.com", "alice", 1, "")
+		require.NoError(t, e)
+		assert.Equal(t, "b20HE7bQ81Tuc=rC", p)
+	})
+	t.Run("Format V1", func(t *testing.T) {
+		stream := func() *keyStreamReader {
+			c, _ := chacha20.NewUnauthenticatedCipher(make([]byte, chacha20.KeySize), make([]byte, chacha20.NonceSize))
+			return &keyStreamReader{stream: c}
+		}
+		p, e := deriveFormatV1(stream(), profiles["default"])
+		require.NoError(t, e)
+		assert.Equal(t, "oS=ZCAqf7VFiUKly", p, "mapping of version 1 must never change")
+		p, e = deriveFormatV1(stream(), PasswordProfileSet{Profile: PasswordProfile{Length: 6, Digits: 5, FirstIsChar: true}})
+		require.NoError(t, e)
+		assert.Equal(t, "m66897", p)
+		assert.True(t, DoPasswordCheck(p, PasswordProfile{Length: 6, Digits: 5, FirstIsChar: true}, GetPasswordCharSet("")))
+		_, e = deriveFormatV1(stream(), PasswordProfileSet{Profile: PasswordProfile{Length: 2, Upper: 2, Digits: 1}})
+		assert.Error(t, e, "profile longer than its length should fail")
+		_, e = deriveFormatV1(stream(), PasswordProfileSet{})
+		assert.Error(t, e, "empty profile should fail")
+		params := testDeriveParams
+		params.Version = 2
+		_, e = NewPasswordDeriver(NewSecretString("master"), "alice", params)
+		assert.ErrorContains(t, e, "version 2")
+	})
+	t.Run("Identity and master", func(t *testing.T) {
+		d1, _ := NewPasswordDeriver(NewSecretString("master"), "alice", testDeriveParams)
+		d2, _ := NewPasswordDeriver(NewSecretString("master"), "bob", testDeriveParams)
+		d3, _ := NewPasswordDeriver(NewSecretString("other"), "alice", testDeriveParams)
+		p1, _ := d1.DerivePassword("example.com", "user", 1, "")
+		p2, _ := d2.DerivePassword("example.com", "user", 1, "")
+		p3, _ := d3.DerivePassword("example.com", "user", 1, "")
+		assert.NotEqual(t, p1, p2)
+		assert.NotEqual(t, p1, p3)
+		_, e := NewPasswordDeriver(NewSecretString(""), "alice", testDeriveParams)
+		assert.Error(t, e)
+		_, e = NewPasswordDeriver(NewSecretString("master"), "alice", DeriveParams{Algorithm: "md5"})
+		assert.Error(t, e)
+	})
+}
@@ -0,0 +1,435 @@
+package pwlib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/tommi2day/gomodules/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// methods to encrypt the data key of env values, written as KEY=ENC[method,base64]
+const (
+	EnvMethodAge = "age"
+	EnvMethodRSA = "rsa"
+	EnvMethodKMS = "kms"
+)
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// EnvCrypter holds the keys to encrypt and decrypt env values
+type EnvCrypter struct {
+	// Method is used for new values, existing values keep their method
+	Method string
+	// PublicKeyFile is the age recipients file or the RSA public key
+	PublicKeyFile string
+	// PrivateKeyFile is the age identity file or the RSA private key
+	PrivateKeyFile string
+	KeyPass        string
+	KMSKeyID       string
+	// KMS client, nil connects with ConnectToKMS on first use
+	KMS *kms.Client
+
+	ageRecipients []age.Recipient
+	ageIdentities []age.Identity
+	rsaPublicKey  *rsa.PublicKey
+	rsaKey        *rsa.PrivateKey
+}
+
+// NewEnvCrypter creates an EnvCrypter with the keys of a PassConfig, supported methods are go, openssl, age and kms
+func NewEnvCrypter(pc *PassConfig) (*EnvCrypter, error) {
+	c := &EnvCrypter{
+		PublicKeyFile:  pc.PubKeyFile,
+		PrivateKeyFile: pc.PrivateKeyFile,
+		KeyPass:        pc.KeyPass,
+		KMSKeyID:       pc.KMSKeyID,
+	}
+	switch pc.Method {
+	case typeGO, typeOpenssl:
+		c.Method = EnvMethodRSA
+	case typeAge:
+		c.Method = EnvMethodAge
+	case typeKMS:
+		c.Method = EnvMethodKMS
+	default:
+		return nil, fmt.Errorf("method %s not supported for env files", pc.Method)
+	}
+	return c, nil
+}
+
+func (c *EnvCrypter) kmsClient() *kms.Client {
+	if c.KMS == nil {
+		c.KMS = ConnectToKMS()
+	}
+	return c.KMS
+}
+
+// Encrypt returns the value as ENC[method,data]. The value is sealed with AES-256-GCM using a random data key
+// and the variable name as additional data, the data key is encrypted with the configured method
+func (c *EnvCrypter) Encrypt(name string, value string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	defer wipeBytes(dataKey)
+	wrapped, err := c.wrapDataKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+	if len(wrapped) > math.MaxUint16 {
+		return "", errors.New("encrypted data key too long")
+	}
+	aesgcm, err := envCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(wrapped))) //nolint gosec
+	data = append(data, wrapped...)
+	data = append(data, nonce...)
+	data = aesgcm.Seal(data, nonce, []byte(value), []byte(name))
+	return fmt.Sprintf("ENC[%s,%s]", c.Method, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// Decrypt returns the plain value of an ENC[method,data] value, it fails if the value was encrypted for another name
+func (c *EnvCrypter) Decrypt(name string, value string) (string, error) {
+	method, data, ok := parseEnvEnc(value)
+	if !ok {
+		return "", errors.New("value is not encrypted")
+	}
+	if found, _ := common.InArray(method, []string{EnvMethodAge, EnvMethodRSA, EnvMethodKMS}); !found {
+		return "", fmt.Errorf("unknown env encryption method %q", method)
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %v", err)
+	}
+	if len(raw) < 2 {
+		return "", errors.New("encrypted value too short")
+	}
+	kl := 2 + int(binary.BigEndian.Uint16(raw))
+	if len(raw) < kl {
+		return "", errors.New("encrypted value too short")
+	}
+	dataKey, err := c.unwrapDataKey(method, raw[2:kl])
+	if err != nil {
+		return "", err
+	}
+	defer wipeBytes(dataKey)
+	aesgcm, err := envCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ns := aesgcm.NonceSize()
+	if len(raw) < kl+ns {
+		return "", errors.New("encrypted value too short")
+	}
+	plain, err := aesgcm.Open(nil, raw[kl:kl+ns], raw[kl+ns:], []byte(name))
+	if err != nil {
+		return "", errors.New("value has been modified or belongs to another variable")
+	}
+	return string(plain), nil
+}
+
+// envCipher returns the AES-256-GCM cipher for a data key
+func envCipher(dataKey []byte) (cipher.AEAD, error) {
+	if len(dataKey) != 32 {
+		return nil, errors.New("invalid data key")
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapDataKey encrypts the data key with the configured method
+func (c *EnvCrypter) wrapDataKey(dataKey []byte) (wrapped []byte, err error) {
+	switch c.Method {
+	case EnvMethodAge:
+		if c.ageRecipients == nil {
+			if c.ageRecipients, err = readAgeRecipients(c.PublicKeyFile); err != nil {
+				return
+			}
+		}
+		return ageEncryptWithRecipients(dataKey, c.ageRecipients)
+	case EnvMethodRSA:
+		if c.rsaPublicKey == nil {
+			if c.rsaPublicKey, err = GetPublicKeyFromFile(c.PublicKeyFile); err != nil {
+				return
+			}
+		}
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, c.rsaPublicKey, dataKey, label)
+	case EnvMethodKMS:
+		var crypted string
+		crypted, err = KMSEncryptString(c.kmsClient(), c.KMSKeyID, string(dataKey))
+		return []byte(crypted), err
+	}
+	return nil, fmt.Errorf("unknown env encryption method %q", c.Method)
+}
+
+// unwrapDataKey decrypts the data key with the method of the value
+func (c *EnvCrypter) unwrapDataKey(method string, wrapped []byte) (dataKey []byte, err error) {
+	var plain string
+	switch method {
+	case EnvMethodAge:
+		if c.ageIdentities == nil {
+			if c.ageIdentities, err = readAgeIdentities(c.PrivateKeyFile); err != nil {
+				return
+			}
+		}
+		plain, err = ageDecryptWithIdentities(wrapped, c.ageIdentities)
+	case EnvMethodRSA:
+		if c.rsaKey == nil {
+			_, c.rsaKey, err = GetPrivateKeyFromFile(c.PrivateKeyFile, c.KeyPass)
+			if err != nil {
+				return
+			}
+		}
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, c.rsaKey, wrapped, label)
+	case EnvMethodKMS:
+		plain, err = KMSDecryptString(c.kmsClient(), c.KMSKeyID, string(wrapped))
+	default:
+		err = fmt.Errorf("unknown env encryption method %q", method)
+	}
+	return []byte(plain), err
+}
+
+// parseEnvEnc splits ENC[method,data]
+func parseEnvEnc(value string) (method string, data string, ok bool) {
+	if !strings.HasPrefix(value, "ENC[") || !strings.HasSuffix(value, "]") {
+		return
+	}
+	method, data, ok = strings.Cut(value[4:len(value)-1], ",")
+	return
+}
+
+// envLine is a variable or a verbatim comment or blank line
+type envLine struct {
+	Key    string
+	Value  string
+	Raw    string
+	Export bool
+}
+
+// EncryptedEnv is a .env file with encrypted values and clear keys, comments and order are preserved
+type EncryptedEnv struct {
+	lines []envLine
+}
+
+// ParseEncryptedEnv parses the content of an env file, plain values may be quoted
+func ParseEncryptedEnv(content string) (*EncryptedEnv, error) {
+	e := &EncryptedEnv{}
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			e.lines = append(e.lines, envLine{Raw: line})
+			continue
+		}
+		export := strings.HasPrefix(trimmed, "export ")
+		trimmed = strings.TrimPrefix(trimmed, "export ")
+		key, value, found := strings.Cut(trimmed, "=")
+		key = strings.TrimSpace(key)
+		if !found || !envKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid env entry", i+1)
+		}
+		value, err := unquoteEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		e.lines = append(e.lines, envLine{Key: key, Value: value, Export: export})
+	}
+	// drop the empty element after the final newline
+	if n := len(e.lines); n > 0 && e.lines[n-1].Key == "" && e.lines[n-1].Raw == "" {
+		e.lines = e.lines[:n-1]
+	}
+	return e, nil
+}
+
+func unquoteEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		v, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value")
+		}
+		return v, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("invalid quoted value")
+		}
+		return value[1 : len(value)-1], nil
+	}
+	// strip inline comments of unquoted values
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+func quoteEnvValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\r\"'#\\$") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// ReadEncryptedEnvFile reads and parses an env file
+func ReadEncryptedEnvFile(filename string) (*EncryptedEnv, error) {
+	content, err := common.ReadFileToString(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEncryptedEnv(content)
+}
+
+// WriteFile writes the env file with mode 0600
+func (e *EncryptedEnv) WriteFile(filename string) error {
+	return common.WriteStringToFile(filename, e.String())
+}
+
+// String returns the env file content
+func (e *EncryptedEnv) String() string {
+	var sb strings.Builder
+	for _, l := range e.lines {
+		if l.Key == "" {
+			sb.WriteString(l.Raw)
+		} else {
+			if l.Export {
+				sb.WriteString("export ")
+			}
+			sb.WriteString(l.Key + "=" + quoteEnvValue(l.Value))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Keys returns the variable names in file order
+func (e *EncryptedEnv) Keys() (keys []string) {
+	for _, l := range e.lines {
+		if l.Key != "" {
+			keys = append(keys, l.Key)
+		}
+	}
+	return
+}
+
+// IsEncrypted reports if the key exists with an encrypted value
+func (e *EncryptedEnv) IsEncrypted(key string) bool {
+	for _, l := range e.lines {
+		if l.Key == key {
+			_, _, ok := parseEnvEnc(l.Value)
+			return ok
+		}
+	}
+	return false
+}
+
+// Set encrypts value and adds or replaces key
+func (e *EncryptedEnv) Set(key string, value string, c *EnvCrypter) error {
+	enc, err := c.Encrypt(key, value)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt %s: %v", key, err)
+	}
+	return e.set(key, enc)
+}
+
+// SetPlain adds or replaces key with a value kept in clear
+func (e *EncryptedEnv) SetPlain(key string, value string) error {
+	if _, _, ok := parseEnvEnc(value); ok {
+		return fmt.Errorf("plain value of %s must not look encrypted", key)
+	}
+	return e.set(key, value)
+}
+
+func (e *EncryptedEnv) set(key string, value string) error {
+	if !envKeyRe.MatchString(key) {
+		return fmt.Errorf("invalid env key %q", key)
+	}
+	for i, l := range e.lines {
+		if l.Key == key {
+			e.lines[i].Value = value
+			return nil
+		}
+	}
+	e.lines = append(e.lines, envLine{Key: key, Value: value})
+	return nil
+}
+
+// Unset removes key and returns false if it did not exist
+func (e *EncryptedEnv) Unset(key string) bool {
+	for i, l := range e.lines {
+		if l.Key == key {
+			e.lines = append(e.lines[:i], e.lines[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Decrypt returns all variables with decrypted values, plain values are returned as they are
+func (e *EncryptedEnv) Decrypt(c *EnvCrypter) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, l := range e.lines {
+		if l.Key == "" {
+			continue
+		}
+		value := l.Value
+		if _, _, ok := parseEnvEnc(value); ok {
+			var err error
+			if value, err = c.Decrypt(l.Key, value); err != nil {
+				return nil, fmt.Errorf("cannot decrypt %s: %v", l.Key, err)
+			}
+		}
+		result[l.Key] = value
+	}
+	log.Debugf("decrypted %d env variables", len(result))
+	return result, nil
+}
+
+// ReadEncryptedEnv reads an env file and returns the decrypted variables
+func ReadEncryptedEnv(filename string, c *EnvCrypter) (map[string]string, error) {
+	e, err := ReadEncryptedEnvFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return e.Decrypt(c)
+}
+
+// LoadEncryptedEnv decrypts an env file into the process environment,
+// variables already set are kept unless override is true
+func LoadEncryptedEnv(filename string, c *EnvCrypter, override bool) error {
+	vars, err := ReadEncryptedEnv(filename, c)
+	if err != nil {
+		return err
+	}
+	for k, v := range vars {
+		if _, exists := os.LookupEnv(k); exists && !override {
+			log.Debugf("env %s already set, skipped", k)
+			continue
+		}
+		if err = os.Setenv(k, v); err != nil {
+			return fmt.Errorf("cannot set %s: %v", k, err)
+		}
+	}
+	return nil
+}
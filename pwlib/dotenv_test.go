@@ -0,0 +1,123 @@
+package pwlib
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+func TestEncryptedEnv(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	for _, m := range []string{typeGO, typeAge} {
+		app := "test_dotenv_" + m
+		pc := NewConfig(app, test.TestData, test.TestData, app, m)
+		if m == typeAge {
+			identity, _, e := CreateAgeIdentity()
+			require.NoError(t, e)
+			err = ExportAgeKeyPair(identity, pc.PubKeyFile, pc.PrivateKeyFile)
+		} else {
+			_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+		}
+		require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+		envFile := path.Join(test.TestData, app+".env")
+
+		t.Run("Edit "+m, func(t *testing.T) {
+			c, e := NewEnvCrypter(pc)
+			require.NoError(t, e)
+			env, e := ParseEncryptedEnv("# service secrets\nexport APP_NAME=\"my app\"\n\nDB_HOST=localhost # inline\n")
+			require.NoError(t, e)
+			require.NoError(t, env.Set("DB_PASSWORD", "s3cret pass#1", c))
+			require.NoError(t, env.Set("API_TOKEN", "token", c))
+			assert.Error(t, env.Set("1INVALID", "x", c))
+			assert.Error(t, env.SetPlain("FAKE", "ENC[age,abc]"))
+			assert.Equal(t, []string{"APP_NAME", "DB_HOST", "DB_PASSWORD", "API_TOKEN"}, env.Keys())
+			assert.True(t, env.IsEncrypted("DB_PASSWORD"))
+			assert.False(t, env.IsEncrypted("DB_HOST"))
+			require.NoError(t, env.WriteFile(envFile))
+			content, _ := common.ReadFileToString(envFile)
+			assert.NotContains(t, content, "s3cret")
+			assert.Contains(t, content, "# service secrets\n")
+			assert.Contains(t, content, "DB_PASSWORD=ENC["+c.Method+",")
+			assert.Contains(t, content, `export APP_NAME="my app"`, "export prefix should be kept")
+
+			// editing keeps unrelated encrypted values unchanged
+			before := content
+			env, e = ReadEncryptedEnvFile(envFile)
+			require.NoError(t, e)
+			assert.True(t, env.Unset("API_TOKEN"))
+			assert.False(t, env.Unset("API_TOKEN"))
+			require.NoError(t, env.SetPlain("DB_HOST", "db.example.com"))
+			require.NoError(t, env.WriteFile(envFile))
+			content, _ = common.ReadFileToString(envFile)
+			passLine := func(s string) string {
+				for _, l := range strings.Split(s, "\n") {
+					if strings.HasPrefix(l, "DB_PASSWORD=") {
+						return l
+					}
+				}
+				return ""
+			}
+			assert.Equal(t, passLine(before), passLine(content))
+			assert.NotContains(t, content, "API_TOKEN")
+		})
+		t.Run("Bound to name "+m, func(t *testing.T) {
+			c, e := NewEnvCrypter(pc)
+			require.NoError(t, e)
+			enc, e := c.Encrypt("DB_PASSWORD", "s3cret")
+			require.NoError(t, e)
+			plain, e := c.Decrypt("DB_PASSWORD", enc)
+			require.NoError(t, e)
+			assert.Equal(t, "s3cret", plain)
+			_, e = c.Decrypt("API_TOKEN", enc)
+			assert.ErrorContains(t, e, "another variable", "value moved to another key should fail")
+			// values are not limited by the key size
+			long := strings.Repeat("x", 4096)
+			enc, e = c.Encrypt("CERT", long)
+			require.NoError(t, e)
+			plain, e = c.Decrypt("CERT", enc)
+			require.NoError(t, e)
+			assert.Equal(t, long, plain)
+		})
+		t.Run("Load "+m, func(t *testing.T) {
+			c, e := NewEnvCrypter(pc)
+			require.NoError(t, e)
+			vars, e := ReadEncryptedEnv(envFile, c)
+			require.NoError(t, e)
+			assert.Equal(t, map[string]string{
+				"APP_NAME":    "my app",
+				"DB_HOST":     "db.example.com",
+				"DB_PASSWORD": "s3cret pass#1",
+			}, vars)
+			t.Setenv("DB_HOST", "preset")
+			t.Setenv("DB_PASSWORD", "")
+			require.NoError(t, LoadEncryptedEnv(envFile, c, false))
+			assert.Equal(t, "preset", common.GetStringEnv("DB_HOST", ""))
+			assert.Equal(t, "", os.Getenv("DB_PASSWORD"))
+			require.NoError(t, LoadEncryptedEnv(envFile, c, true))
+			assert.Equal(t, "db.example.com", common.GetStringEnv("DB_HOST", ""))
+			assert.Equal(t, "s3cret pass#1", common.GetStringEnv("DB_PASSWORD", ""))
+			_ = os.Unsetenv("APP_NAME")
+		})
+	}
+	t.Run("Invalid", func(t *testing.T) {
+		_, err = ParseEncryptedEnv("NOVALUE\n")
+		assert.Error(t, err)
+		_, err = ParseEncryptedEnv("KEY=\"unterminated\n")
+		assert.Error(t, err)
+		env, e := ParseEncryptedEnv("KEY=ENC[rot13,abc=]\n")
+		require.NoError(t, e)
+		_, e = env.Decrypt(&EnvCrypter{})
+		assert.ErrorContains(t, e, "rot13")
+		_, e = NewEnvCrypter(&PassConfig{Method: typeVault})
+		assert.Error(t, e)
+	})
+}
@@ -77,6 +77,19 @@ func GenEcdsaKey(pubfilename string, privfilename string, password string) (publ
 
 // GetEcdsaPrivateKeyFromFile read private key from PEM encoded File and returns publicKey and private key objects
 func GetEcdsaPrivateKeyFromFile(privfilename string, password string) (publicKey *ecdsa.PublicKey, privateKey *ecdsa.PrivateKey, err error) {
+	return getEcdsaPrivateKey(privfilename, []byte(password))
+}
+
+// GetEcdsaPrivateKeyFromFileSecret is GetEcdsaPrivateKeyFromFile with the passphrase given as Secret
+func GetEcdsaPrivateKeyFromFileSecret(privfilename string, password *Secret) (publicKey *ecdsa.PublicKey, privateKey *ecdsa.PrivateKey, err error) {
+	err = password.Use(func(b []byte) (e error) {
+		publicKey, privateKey, e = getEcdsaPrivateKey(privfilename, b)
+		return
+	})
+	return
+}
+
+func getEcdsaPrivateKey(privfilename string, password []byte) (publicKey *ecdsa.PublicKey, privateKey *ecdsa.PrivateKey, err error) {
 	var parsedKey any
 	var privPemBytes []byte
 
@@ -98,9 +111,9 @@ func GetEcdsaPrivateKeyFromFile(privfilename string, password string) (publicKey
 		return
 	}
 
-	if password != "" {
+	if len(password) > 0 {
 		//nolint:staticcheck
-		privPemBytes, err = x509.DecryptPEMBlock(privPem, []byte(password))
+		privPemBytes, err = x509.DecryptPEMBlock(privPem, password)
 		if err != nil {
 			log.Debugf("ecdsa private password error:%s", err)
 			return
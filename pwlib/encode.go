@@ -18,7 +18,12 @@ func EncodeFile(plainFile string, targetFile string) (err error) {
 		log.Debugf("Cannot read plaintext file %s:%s", plainFile, err)
 		return
 	}
-	b64 := base64.StdEncoding.EncodeToString([]byte(plainData))
+	return encodeData([]byte(plainData), targetFile)
+}
+
+// encodeData writes plain base64 encoded to targetFile
+func encodeData(plain []byte, targetFile string) (err error) {
+	b64 := base64.StdEncoding.EncodeToString(plain)
 	err = common.WriteStringToFile(targetFile, b64)
 	if err != nil {
 		log.Debugf("Cannot write: %s", err.Error())
@@ -36,11 +41,14 @@ func DecodeFile(cryptedfile string) (content []byte, err error) {
 		log.Debugf("Cannot Read file '%s': %s", cryptedfile, err)
 		return
 	}
-	bindata, err := base64.StdEncoding.DecodeString(data)
+	return decodeData(data)
+}
+
+// decodeData decodes base64 encoded data
+func decodeData(data string) (content []byte, err error) {
+	content, err = base64.StdEncoding.DecodeString(data)
 	if err != nil {
-		log.Debugf("decode base64 for %s failed: %s", cryptedfile, err)
-		return
+		log.Debugf("decode base64 failed: %s", err)
 	}
-	content = bindata
 	return
 }
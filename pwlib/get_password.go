@@ -1,9 +1,13 @@
 package pwlib
 
 import (
+	"bytes"
+	"context"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/tommi2day/gomodules/common"
@@ -14,57 +18,113 @@ import (
 // Methods all available methods for get_passwword
 var Methods = []string{typeGO, typeOpenssl, typeEnc, typePlain, typeVault, typeGPG, typeGopass, typeKMS}
 
+// agentMethods are the methods which may use an agent with unlocked keys
+var agentMethods = []string{typeGO, typeOpenssl, typeGPG, typeAge}
+
 // DecryptFile decripts an rsa protected file
 func (pc *PassConfig) DecryptFile() (lines []string, err error) {
+	content, err := pc.decryptContent()
+	if err != nil {
+		log.Debug("load data failed")
+		return
+	}
+	text := strings.ReplaceAll(string(content), "\r", "")
+	wipeBytes(content)
+	lines = strings.Split(text, "\n")
+	log.Debug("load data success")
+	return
+}
+
+// decryptContent decrypts the crypted file into a buffer the caller has to wipe. Vault and agent
+// contents are returned by their APIs as string and cannot be wiped
+func (pc *PassConfig) decryptContent() (content []byte, err error) {
 	cryptedfile := pc.CryptedFile
 	privatekeyfile := pc.PrivateKeyFile
-	keypass := pc.KeyPass
 	sessionpassfile := pc.SessionPassFile
 	passflag := "open"
-	content := ""
 	method := pc.Method
 	keyID := pc.KMSKeyID
-	var data []byte
-	if len(keypass) > 0 {
+	var crypted []byte
+	if len(pc.KeyPass) > 0 || pc.KeyPassSecret.Len() > 0 {
 		passflag = "Encypted"
 	}
 	log.Debugf("Decrypt data from %s with method %s(%s)", cryptedfile, method, passflag)
 
+	if slices.Contains(storeSigningMethods, method) {
+		// read the crypted file once, so the verified bytes are decrypted
+		crypted, err = os.ReadFile(cryptedfile) //nolint gosec
+		if err != nil {
+			log.Debugf("Cannot Read file '%s': %s", cryptedfile, err)
+			return
+		}
+		if pc.StoreSigning != nil {
+			if _, err = pc.verifyCryptedData(crypted); err != nil {
+				log.Debug("store signature check failed")
+				return
+			}
+		}
+	}
+	data := ""
+	if pc.Agent != nil && slices.Contains(agentMethods, method) {
+		keypass := pc.KeyPass
+		if pc.KeyPassSecret != nil {
+			keypass = pc.KeyPassSecret.Reveal()
+		}
+		data, err = pc.Agent.DecryptData(method, crypted, privatekeyfile, keypass, sessionpassfile)
+		content = []byte(data)
+		return
+	}
 	switch method {
 	case typeOpenssl:
-		content, err = PrivateDecryptFileSSL(cryptedfile, privatekeyfile, keypass, sessionpassfile)
+		err = pc.useKeyPass(func(keyPass []byte) (e error) {
+			content, e = privateDecryptSSLBytes(crypted, privatekeyfile, keyPass, sessionpassfile)
+			return
+		})
 	case typeGO:
-		content, err = PrivateDecryptFileGo(cryptedfile, privatekeyfile, keypass)
+		err = pc.useKeyPass(func(keyPass []byte) (e error) {
+			var privkey *rsa.PrivateKey
+			if _, privkey, e = getRsaPrivateKey(privatekeyfile, keyPass); e != nil {
+				log.Debugf("Cannot read keys from '%s': %s", privatekeyfile, e)
+				return
+			}
+			content, e = decryptGoBytes(crypted, privkey)
+			return
+		})
 	case typeEnc:
-		data, err = DecodeFile(cryptedfile)
-		content = string(data)
+		content, err = decodeData(string(crypted))
 	case typePlain:
-		content, err = common.ReadFileToString(cryptedfile)
+		content = crypted
 	case typeVault:
-		content, err = GetVaultSecret(cryptedfile, "", "")
+		data, err = GetVaultSecret(cryptedfile, "", "")
+		content = []byte(data)
 	case typeGPG:
-		content, err = GPGDecryptFile(cryptedfile, privatekeyfile, keypass, "")
+		err = pc.useKeyPass(func(keyPass []byte) (e error) {
+			content, e = gpgDecryptBytes(crypted, privatekeyfile, keyPass, "")
+			return
+		})
 	case typeAge:
-		content, err = AgeDecryptFile(cryptedfile, privatekeyfile)
+		content, err = ageDecryptBytes(crypted, privatekeyfile)
 	/*
 		case typeGopass:
 			content, err = GetGopassSecrets(privatekeyfile, keypass)
 	*/
 	case typeKMS:
-		content, err = KMSDecryptFile(cryptedfile, keyID, sessionpassfile)
+		content, err = kmsDecryptBytesContext(context.Background(), crypted, keyID, sessionpassfile)
 	default:
 		log.Fatalf("encryption method %s not known", method)
 		os.Exit(1)
 	}
+	return
+}
 
-	if err != nil {
-		log.Debug("load data failed")
-		return
+// useKeyPass calls fn with the private key passphrase from KeyPassSecret or KeyPass
+func (pc *PassConfig) useKeyPass(fn func(keyPass []byte) error) error {
+	if pc.KeyPassSecret != nil {
+		return pc.KeyPassSecret.Use(fn)
 	}
-	content = strings.ReplaceAll(content, "\r", "")
-	lines = strings.Split(content, "\n")
-	log.Debug("load data success")
-	return
+	keyPass := []byte(pc.KeyPass)
+	defer wipeBytes(keyPass)
+	return fn(keyPass)
 }
 
 // EncryptFile encrypt plain text to rsa protected file
@@ -76,6 +136,9 @@ func (pc *PassConfig) EncryptFile() (err error) {
 	method := pc.Method
 	keyID := pc.KMSKeyID
 	log.Debugf("Encrypt data from %s method %s", plaintextfile, method)
+	defer func() {
+		pc.audit("EncryptFile", "", "", err)
+	}()
 	switch method {
 	case typeOpenssl:
 		err = PubEncryptFileSSL(plaintextfile, cryptedFile, pubKeyFile, sessionpassfile)
@@ -104,6 +167,13 @@ func (pc *PassConfig) EncryptFile() (err error) {
 		log.Debug("encryption data failed")
 		return
 	}
+	if pc.StoreSigning != nil && slices.Contains(storeSigningMethods, method) {
+		err = pc.SignCryptedFile()
+		if err != nil {
+			log.Debug("signing crypted file failed")
+			return
+		}
+	}
 	log.Debug("encryption data success")
 	return
 }
@@ -111,6 +181,9 @@ func (pc *PassConfig) EncryptFile() (err error) {
 // ListPasswords printout list of pwcli
 func (pc *PassConfig) ListPasswords() (lines []string, err error) {
 	log.Debugf("ListPasswords entered")
+	defer func() {
+		pc.audit("ListPasswords", "", "", err)
+	}()
 	lines, err = pc.DecryptFile()
 	if err != nil {
 		log.Errorf("Decode Failed")
@@ -123,28 +196,16 @@ func (pc *PassConfig) ListPasswords() (lines []string, err error) {
 func (pc *PassConfig) GetPassword(system string, account string) (password string, err error) {
 	var lines []string
 	log.Debugf("GetPassword for '%s'@'%s' entered", account, system)
-	switch pc.Method {
-	case typeVault:
-		pc.CryptedFile = system
-	case typeAge:
-		pc.CryptedFile = pc.DataDir + "/" + system + "/" + account + "." + extAge
-	}
-
+	defer func(system string) {
+		pc.audit("GetPassword", system, account, err)
+	}(system)
+	system = pc.prepareGetPassword(system, account)
 	lines, err = pc.DecryptFile()
 	if err != nil {
 		return
 	}
 	found := false
 	direct := false
-	if pc.Method == typeVault {
-		// in vault mode we need to replace ":" in system = vault path to match
-		system = strings.ReplaceAll(system, ":", "_")
-		pc.CaseSensitive = true
-	}
-
-	if pc.Method == typeGopass {
-		pc.CaseSensitive = true
-	}
 	// match strings in function to make linter happy
 	password, found, direct = pc.match(lines, system, account)
 	// not found
@@ -162,6 +223,49 @@ func (pc *PassConfig) GetPassword(system string, account string) (password strin
 	return
 }
 
+// GetPasswordSecret ask System for data and returns the password as Secret. The decrypted store is
+// wiped after the password has been copied into the Secret
+func (pc *PassConfig) GetPasswordSecret(system string, account string) (password *Secret, err error) {
+	log.Debugf("GetPasswordSecret for '%s'@'%s' entered", account, system)
+	defer func(system string) {
+		pc.audit("GetPasswordSecret", system, account, err)
+	}(system)
+	system = pc.prepareGetPassword(system, account)
+	content, err := pc.decryptContent()
+	defer wipeBytes(content)
+	if err != nil {
+		log.Debug("load data failed")
+		return
+	}
+	value, found, direct := pc.matchBytes(content, system, account)
+	if !found {
+		log.Debug("GetPasswordSecret finished with no Match")
+		err = fmt.Errorf("no record found for '%s'@'%s'", account, system)
+		return
+	}
+	if !direct {
+		log.Debug("use default entry")
+	}
+	password = NewSecret(value)
+	return
+}
+
+// prepareGetPassword selects the crypted file for methods with a file per record and returns the system to match
+func (pc *PassConfig) prepareGetPassword(system string, account string) string {
+	switch pc.Method {
+	case typeVault:
+		pc.CryptedFile = system
+		// in vault mode we need to replace ":" in system = vault path to match
+		system = strings.ReplaceAll(system, ":", "_")
+		pc.CaseSensitive = true
+	case typeGopass:
+		pc.CaseSensitive = true
+	case typeAge:
+		pc.CryptedFile = pc.DataDir + "/" + system + "/" + account + "." + extAge
+	}
+	return system
+}
+
 func (pc *PassConfig) match(lines []string, system string, account string) (password string, found bool, direct bool) {
 	password = ""
 	found = false
@@ -192,6 +296,35 @@ func (pc *PassConfig) match(lines []string, system string, account string) (pass
 	return
 }
 
+// matchBytes is match on the decrypted content, the password is returned as slice of content
+func (pc *PassConfig) matchBytes(content []byte, system string, account string) (password []byte, found bool, direct bool) {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(bytes.TrimSpace(line)) == 0 || line[0] == '#' {
+			continue
+		}
+
+		fields := bytes.SplitN(line, []byte(":"), 3)
+		if len(fields) != 3 {
+			log.Debug("Skip incomplete record")
+			continue
+		}
+		names := []string{string(fields[0]), string(fields[1])}
+
+		if pc.isDirectMatch(names, system, account) {
+			log.Debug("Found direct match")
+			password, found, direct = fields[2], true, true
+			break
+		}
+
+		if pc.isDefaultMatch(names, account) {
+			log.Debug("Found new default match candidate")
+			password, found = fields[2], true
+		}
+	}
+	return
+}
+
 func (pc *PassConfig) isDirectMatch(fields []string, system string, account string) bool {
 	if pc.CaseSensitive {
 		return system == fields[0] && account == fields[1]
@@ -28,7 +28,9 @@ func GPGUnlockKey(gpgEntity *openpgp.Entity, keypass string) (err error) {
 		err = fmt.Errorf("no key loaded")
 		return
 	}
-	err = gpgEntity.DecryptPrivateKeys([]byte(keypass))
+	pass := []byte(keypass)
+	err = gpgEntity.DecryptPrivateKeys(pass)
+	wipeBytes(pass)
 	return
 }
 
@@ -91,6 +93,26 @@ func GPGReadAmoredKeyRing(amoredKeyRing string) (entityList openpgp.EntityList,
 
 // GPGDecryptFile decrypt file with GPG Key
 func GPGDecryptFile(filename string, secretKeyFile string, keypass string, gpgid string) (decryptedContent string, err error) {
+	encrypted := ""
+	encrypted, err = common.ReadFileToString(filename)
+	if err != nil {
+		return
+	}
+	return gpgDecryptData(encrypted, secretKeyFile, keypass, gpgid)
+}
+
+// gpgDecryptData decrypts a gpg message with the key from secretKeyFile
+func gpgDecryptData(encrypted string, secretKeyFile string, keypass string, gpgid string) (decryptedContent string, err error) {
+	pass := []byte(keypass)
+	defer wipeBytes(pass)
+	decrypted, err := gpgDecryptBytes([]byte(encrypted), secretKeyFile, pass, gpgid)
+	decryptedContent = string(decrypted)
+	wipeBytes(decrypted)
+	return
+}
+
+// gpgDecryptBytes is gpgDecryptData with the key passphrase and the plain data in buffers the caller can wipe
+func gpgDecryptBytes(encrypted []byte, secretKeyFile string, keypass []byte, gpgid string) (decrypted []byte, err error) {
 	var entityList openpgp.EntityList
 	var entity *openpgp.Entity
 	var key string
@@ -106,34 +128,46 @@ func GPGDecryptFile(filename string, secretKeyFile string, keypass string, gpgid
 	if err != nil {
 		return
 	}
-	err = GPGUnlockKey(entity, keypass)
+	err = entity.DecryptPrivateKeys(keypass)
 	if err != nil {
 		return
 	}
-	encrypted := ""
+	decrypted, err = gpgDecryptMessage(encrypted, entityList)
+	return
+}
+
+// gpgDecryptWithKeyRing decrypts a gpg message with an already unlocked keyring
+func gpgDecryptWithKeyRing(encrypted string, entityList openpgp.EntityList) (decryptedContent string, err error) {
+	decrypted, err := gpgDecryptMessage([]byte(encrypted), entityList)
+	decryptedContent = string(decrypted)
+	wipeBytes(decrypted)
+	return
+}
+
+// gpgDecryptMessage is gpgDecryptWithKeyRing returning the plain data in a buffer the caller can wipe
+func gpgDecryptMessage(encrypted []byte, entityList openpgp.EntityList) (decrypted []byte, err error) {
 	var md *openpgp.MessageDetails
-	encrypted, err = common.ReadFileToString(filename)
+	md, err = openpgp.ReadMessage(bytes.NewReader(encrypted), entityList, nil, nil)
 	if err != nil {
 		return
 	}
-	r := bytes.NewReader([]byte(encrypted))
-	md, err = openpgp.ReadMessage(r, entityList, nil, nil)
-	if err != nil {
-		return
-	}
-	decryptedBytes, err := io.ReadAll(md.UnverifiedBody)
-	if err != nil {
-		return
-	}
-	decryptedContent = string(decryptedBytes)
+	decrypted, err = io.ReadAll(md.UnverifiedBody)
 	return
 }
 
 // GPGEncryptFile encrypt file with GPG Key
 func GPGEncryptFile(plainFile string, targetFile string, publicKeyFile string) (err error) {
+	plain, err := common.ReadFileToString(plainFile)
+	if err != nil {
+		return
+	}
+	return gpgEncrypt([]byte(plain), targetFile, publicKeyFile)
+}
+
+// gpgEncrypt encrypts plain for the keys in publicKeyFile to targetFile
+func gpgEncrypt(plain []byte, targetFile string, publicKeyFile string) (err error) {
 	var entityList openpgp.EntityList
 	var pubKeys string
-	var plain string
 	var encryptedBytes []byte
 
 	// recipients allowed to decrypt
@@ -145,17 +179,13 @@ func GPGEncryptFile(plainFile string, targetFile string, publicKeyFile string) (
 	if err != nil {
 		return
 	}
-	plain, err = common.ReadFileToString(plainFile)
-	if err != nil {
-		return
-	}
 	encBuffer := new(bytes.Buffer)
 	pw, err := openpgp.Encrypt(encBuffer, entityList, nil, &openpgp.FileHints{IsBinary: true}, nil)
 	if err != nil {
 		return
 	}
 	// write plaintext to encryptor
-	_, err = pw.Write([]byte(plain))
+	_, err = pw.Write(plain)
 	if err != nil {
 		return
 	}
@@ -170,6 +200,29 @@ func GPGEncryptFile(plainFile string, targetFile string, publicKeyFile string) (
 	return
 }
 
+// gpgEncryptArmored encrypts data for the given public keys as armored PGP message
+func gpgEncryptArmored(plain []byte, entityList openpgp.EntityList) (string, error) {
+	buf := new(bytes.Buffer)
+	aw, err := armor.Encode(buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", err
+	}
+	pw, err := openpgp.Encrypt(aw, entityList, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err = pw.Write(plain); err != nil {
+		return "", err
+	}
+	if err = pw.Close(); err != nil {
+		return "", err
+	}
+	if err = aw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 // GPGSignFile signs a file using a private GPG key
 func GPGSignFile(plainFile string, signatureFile string, secretKeyFile string, keypass string) error {
 	log.Debugf("Sign %s with GPG private key %s", plainFile, secretKeyFile)
@@ -217,32 +270,29 @@ func GPGSignFile(plainFile string, signatureFile string, secretKeyFile string, k
 // GPGVerifyFile verifies a GPG signature
 func GPGVerifyFile(plainFile string, signatureFile string, publicKeyFile string) (bool, error) {
 	log.Debugf("Verify %s with GPG public key %s", plainFile, publicKeyFile)
-	key, err := common.ReadFileToString(publicKeyFile)
+	plain, err := os.ReadFile(plainFile) //nolint gosec
 	if err != nil {
 		return false, err
 	}
-	entityList, err := GPGReadAmoredKeyRing(key)
+	sig, err := common.ReadFileToString(signatureFile)
 	if err != nil {
 		return false, err
 	}
+	return gpgVerifyData(plain, sig, publicKeyFile)
+}
 
-	plain, err := os.Open(plainFile)
+// gpgVerifyData verifies an armored detached GPG signature of data
+func gpgVerifyData(plain []byte, signature string, publicKeyFile string) (bool, error) {
+	key, err := common.ReadFileToString(publicKeyFile)
 	if err != nil {
 		return false, err
 	}
-	defer func(plain *os.File) {
-		_ = plain.Close()
-	}(plain)
-
-	sig, err := os.Open(signatureFile)
+	entityList, err := GPGReadAmoredKeyRing(key)
 	if err != nil {
 		return false, err
 	}
-	defer func(sig *os.File) {
-		_ = sig.Close()
-	}(sig)
 
-	signer, err := openpgp.CheckArmoredDetachedSignature(entityList, plain, sig, nil)
+	signer, err := openpgp.CheckArmoredDetachedSignature(entityList, bytes.NewReader(plain), strings.NewReader(signature), nil)
 	if err != nil {
 		return false, fmt.Errorf("signature verification failed: %v", err)
 	}
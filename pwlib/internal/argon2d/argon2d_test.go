@@ -0,0 +1,20 @@
+package argon2d
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgon2d(t *testing.T) {
+	// RFC 9106 section 5.1 test vector
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+	tag := deriveKey(password, salt, secret, data, 3, 32, 4, 32)
+	assert.Equal(t, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb", hex.EncodeToString(tag))
+	assert.Len(t, Key([]byte("password"), []byte("somesalt"), 1, 64, 1, 64), 64)
+}
@@ -0,0 +1,165 @@
+package pwlib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key (RFC 7517) holding a public RSA, EC P-256 or Ed25519 key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as served on jwks_uri endpoints
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64url = base64.RawURLEncoding
+
+// JWKFromPublicKey converts a public key to a JWK, an empty kid uses the RFC 7638 thumbprint
+func JWKFromPublicKey(pub crypto.PublicKey, alg string, kid string) (jwk JWK, err error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64url.EncodeToString(k.N.Bytes())
+		jwk.E = b64url.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return jwk, errors.New("only P-256 ecdsa keys are supported")
+		}
+		var point []byte
+		point, err = k.Bytes()
+		if err != nil {
+			return
+		}
+		// uncompressed point 0x04 || X || Y
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = b64url.EncodeToString(point[1:33])
+		jwk.Y = b64url.EncodeToString(point[33:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64url.EncodeToString(k)
+	default:
+		return jwk, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	jwk.Use = "sig"
+	jwk.Alg = alg
+	jwk.Kid = kid
+	if kid == "" {
+		jwk.Kid, err = jwk.Thumbprint()
+	}
+	return
+}
+
+// PublicKey returns the public key of the JWK
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64url.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rsa modulus: %v", err)
+		}
+		e, err := b64url.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := b64url.DecodeString(k.X)
+		if err != nil || len(x) != 32 {
+			return nil, errors.New("invalid ec x coordinate")
+		}
+		y, err := b64url.DecodeString(k.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errors.New("invalid ec y coordinate")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := b64url.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key
+func (k JWK) Thumbprint() (string, error) {
+	var members string
+	// required members in lexicographic order without whitespace
+	switch k.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	default:
+		return "", fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return b64url.EncodeToString(sum[:]), nil
+}
+
+// NewJWKS exports the public keys of the signers
+func NewJWKS(signers ...JWTSigner) (jwks JWKS, err error) {
+	jwks.Keys = []JWK{}
+	for _, s := range signers {
+		var jwk JWK
+		jwk, err = JWKFromPublicKey(s.PublicKey(), s.Algorithm(), s.KeyID())
+		if err != nil {
+			return
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return
+}
+
+// ParseJWKS parses a JSON Web Key Set
+func ParseJWKS(data []byte) (jwks JWKS, err error) {
+	err = json.Unmarshal(data, &jwks)
+	if err != nil {
+		err = fmt.Errorf("invalid jwks: %v", err)
+	}
+	return
+}
+
+// Key returns the key with the given kid
+func (s JWKS) Key(kid string) (JWK, bool) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
@@ -0,0 +1,384 @@
+package pwlib
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// supported JWS algorithms
+const (
+	JWTAlgRS256 = "RS256"
+	JWTAlgPS256 = "PS256"
+	JWTAlgES256 = "ES256"
+	JWTAlgEdDSA = "EdDSA"
+)
+
+// JWTHeader is the JOSE header of a token
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// JWTAudience is the aud claim, which may be a single string or a list
+type JWTAudience []string
+
+// MarshalJSON writes a single audience as string
+func (a JWTAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON accepts a string or a list of strings
+func (a *JWTAudience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = JWTAudience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(data, &l); err != nil {
+		return errors.New("aud must be a string or a list of strings")
+	}
+	*a = l
+	return nil
+}
+
+// JWTClaims are the registered claims of RFC 7519, other claims are kept in Custom
+type JWTClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JWTAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	Custom    map[string]interface{}
+}
+
+type jwtRegisteredClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JWTAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+var jwtRegisteredNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+// MarshalJSON merges registered and custom claims, registered claims win
+func (c JWTClaims) MarshalJSON() ([]byte, error) {
+	reg, err := json.Marshal(jwtRegisteredClaims{c.Issuer, c.Subject, c.Audience, c.ExpiresAt, c.NotBefore, c.IssuedAt, c.ID})
+	if err != nil || len(c.Custom) == 0 {
+		return reg, err
+	}
+	all := make(map[string]interface{}, len(c.Custom)+len(jwtRegisteredNames))
+	for k, v := range c.Custom {
+		all[k] = v
+	}
+	if err = json.Unmarshal(reg, &all); err != nil {
+		return nil, err
+	}
+	return json.Marshal(all)
+}
+
+// UnmarshalJSON splits registered and custom claims
+func (c *JWTClaims) UnmarshalJSON(data []byte) error {
+	var reg jwtRegisteredClaims
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	// numeric dates may be written as floats
+	for _, n := range []string{"exp", "nbf", "iat"} {
+		if f, ok := all[n].(float64); ok {
+			all[n] = int64(f)
+		}
+	}
+	fixed, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(fixed, &reg); err != nil {
+		return fmt.Errorf("invalid registered claim: %v", err)
+	}
+	for _, n := range jwtRegisteredNames {
+		delete(all, n)
+	}
+	*c = JWTClaims{reg.Issuer, reg.Subject, reg.Audience, reg.ExpiresAt, reg.NotBefore, reg.IssuedAt, reg.ID, nil}
+	if len(all) > 0 {
+		c.Custom = all
+	}
+	return nil
+}
+
+// JWTValidation defines the claim checks of JWTVerify
+type JWTValidation struct {
+	// Issuer must match iss if set
+	Issuer string
+	// Audience must be contained in aud if set
+	Audience string
+	// Algorithms restricts the accepted algorithms, empty accepts all supported
+	Algorithms []string
+	// AllowNoExpiry accepts tokens without exp, which are rejected by default
+	AllowNoExpiry bool
+	// Leeway tolerates clock skew for exp and nbf
+	Leeway time.Duration
+	// Now is the validation time, zero uses the current time
+	Now time.Time
+}
+
+// Validate checks exp, nbf, iss and aud of the claims
+func (v JWTValidation) Validate(c *JWTClaims) error {
+	now := v.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	leeway := int64(v.Leeway.Seconds())
+	if c.ExpiresAt == 0 && !v.AllowNoExpiry {
+		return errors.New("token has no expiry")
+	}
+	if c.ExpiresAt != 0 && now.Unix() >= c.ExpiresAt+leeway {
+		return fmt.Errorf("token expired at %s", time.Unix(c.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	if c.NotBefore != 0 && now.Unix() < c.NotBefore-leeway {
+		return fmt.Errorf("token not valid before %s", time.Unix(c.NotBefore, 0).UTC().Format(time.RFC3339))
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return fmt.Errorf("token issuer %q not accepted", c.Issuer)
+	}
+	if v.Audience != "" && !slices.Contains(c.Audience, v.Audience) {
+		return fmt.Errorf("token not issued for audience %q", v.Audience)
+	}
+	return nil
+}
+
+// JWTIssue creates a signed token, iat is set to now if empty
+func JWTIssue(signer JWTSigner, claims JWTClaims) (token string, err error) {
+	return JWTIssueContext(context.Background(), signer, claims)
+}
+
+// JWTIssueContext creates a signed token, ctx bounds the signing call of a JWTContextSigner
+func JWTIssueContext(ctx context.Context, signer JWTSigner, claims JWTClaims) (token string, err error) {
+	var sig []byte
+	header := JWTHeader{Alg: signer.Algorithm(), Typ: "JWT", Kid: signer.KeyID()}
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = time.Now().Unix()
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return
+	}
+	input := b64url.EncodeToString(h) + "." + b64url.EncodeToString(p)
+	if cs, ok := signer.(JWTContextSigner); ok {
+		sig, err = cs.SignContext(ctx, []byte(input))
+	} else {
+		sig, err = signer.Sign([]byte(input))
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot sign token: %v", err)
+	}
+	log.Debugf("issued %s token with kid %s", header.Alg, header.Kid)
+	return input + "." + b64url.EncodeToString(sig), nil
+}
+
+// JWTParseUnverified decodes header and claims without checking the signature
+func JWTParseUnverified(token string) (header JWTHeader, claims JWTClaims, err error) {
+	header, claims, _, _, err = jwtParse(token)
+	return
+}
+
+func jwtParse(token string) (header JWTHeader, claims JWTClaims, input []byte, sig []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = errors.New("token must have 3 parts")
+		return
+	}
+	h, err := b64url.DecodeString(parts[0])
+	if err != nil {
+		err = fmt.Errorf("invalid token header: %v", err)
+		return
+	}
+	if err = json.Unmarshal(h, &header); err != nil {
+		err = fmt.Errorf("invalid token header: %v", err)
+		return
+	}
+	p, err := b64url.DecodeString(parts[1])
+	if err != nil {
+		err = fmt.Errorf("invalid token payload: %v", err)
+		return
+	}
+	if err = json.Unmarshal(p, &claims); err != nil {
+		err = fmt.Errorf("invalid token claims: %v", err)
+		return
+	}
+	sig, err = b64url.DecodeString(parts[2])
+	if err != nil {
+		err = fmt.Errorf("invalid token signature: %v", err)
+		return
+	}
+	input = []byte(parts[0] + "." + parts[1])
+	return
+}
+
+// JWTVerify checks signature and claims of a token with the given public key
+func JWTVerify(token string, pub crypto.PublicKey, v JWTValidation) (*JWTClaims, error) {
+	header, claims, input, sig, err := jwtParse(token)
+	if err != nil {
+		return nil, err
+	}
+	return jwtVerify(header, &claims, input, sig, pub, v)
+}
+
+// JWTVerifyJWKS checks a token with the JWKS key selected by kid, a token without kid needs a JWKS with one key
+func JWTVerifyJWKS(token string, jwks JWKS, v JWTValidation) (*JWTClaims, error) {
+	header, claims, input, sig, err := jwtParse(token)
+	if err != nil {
+		return nil, err
+	}
+	var jwk JWK
+	switch {
+	case header.Kid != "":
+		var ok bool
+		if jwk, ok = jwks.Key(header.Kid); !ok {
+			return nil, fmt.Errorf("no key with kid %s in jwks", header.Kid)
+		}
+	case len(jwks.Keys) == 1:
+		jwk = jwks.Keys[0]
+	default:
+		return nil, errors.New("token has no kid to select a key")
+	}
+	if jwk.Alg != "" && jwk.Alg != header.Alg {
+		return nil, fmt.Errorf("key %s is for %s, not %s", jwk.Kid, jwk.Alg, header.Alg)
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, fmt.Errorf("key %s is not a signing key", jwk.Kid)
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return jwtVerify(header, &claims, input, sig, pub, v)
+}
+
+func jwtVerify(header JWTHeader, claims *JWTClaims, input []byte, sig []byte, pub crypto.PublicKey, v JWTValidation) (*JWTClaims, error) {
+	if len(v.Algorithms) > 0 && !slices.Contains(v.Algorithms, header.Alg) {
+		return nil, fmt.Errorf("algorithm %s not accepted", header.Alg)
+	}
+	if err := jwtVerifySignature(header.Alg, pub, input, sig); err != nil {
+		return nil, err
+	}
+	if err := v.Validate(claims); err != nil {
+		return nil, err
+	}
+	log.Debugf("token of %s verified", claims.Subject)
+	return claims, nil
+}
+
+// jwtCheckKey ensures the key type fits the algorithm, so tokens cannot choose a weaker interpretation
+func jwtCheckKey(alg string, pub crypto.PublicKey) error {
+	ok := false
+	switch alg {
+	case JWTAlgRS256, JWTAlgPS256:
+		_, ok = pub.(*rsa.PublicKey)
+	case JWTAlgES256:
+		k, isEC := pub.(*ecdsa.PublicKey)
+		ok = isEC && k.Curve == elliptic.P256()
+	case JWTAlgEdDSA:
+		_, ok = pub.(ed25519.PublicKey)
+	default:
+		return fmt.Errorf("algorithm %q not supported", alg)
+	}
+	if !ok {
+		return fmt.Errorf("key type %T does not match algorithm %s", pub, alg)
+	}
+	return nil
+}
+
+func jwtVerifySignature(alg string, pub crypto.PublicKey, input []byte, sig []byte) (err error) {
+	if err = jwtCheckKey(alg, pub); err != nil {
+		return
+	}
+	digest := sha256.Sum256(input)
+	switch alg {
+	case JWTAlgRS256:
+		err = rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig)
+	case JWTAlgPS256:
+		err = rsa.VerifyPSS(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case JWTAlgES256:
+		if len(sig) != 64 {
+			return errors.New("invalid ES256 signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub.(*ecdsa.PublicKey), digest[:], r, s) {
+			err = errors.New("ecdsa verification error")
+		}
+	case JWTAlgEdDSA:
+		if !ed25519.Verify(pub.(ed25519.PublicKey), input, sig) {
+			err = errors.New("ed25519 verification error")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid token signature: %v", err)
+	}
+	return nil
+}
+
+// jwtECDSASignature converts an ASN.1 ecdsa signature to the fixed size r||s form of JWS
+func jwtECDSASignature(der []byte) ([]byte, error) {
+	var s struct{ R, S *big.Int }
+	rest, err := asn1.Unmarshal(der, &s)
+	if err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid ecdsa signature")
+	}
+	sig := make([]byte, 64)
+	s.R.FillBytes(sig[:32])
+	s.S.FillBytes(sig[32:])
+	return sig, nil
+}
+
+// jwtDefaultAlg returns the algorithm used if none is given for a key
+func jwtDefaultAlg(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return JWTAlgRS256
+	case *ecdsa.PublicKey:
+		return JWTAlgES256
+	case ed25519.PublicKey:
+		return JWTAlgEdDSA
+	}
+	return ""
+}
+
+// jwtKid returns kid or the thumbprint of pub if kid is empty
+func jwtKid(pub crypto.PublicKey, alg string, kid string) (string, error) {
+	if kid != "" {
+		return kid, nil
+	}
+	jwk, err := JWKFromPublicKey(pub, alg, "")
+	return jwk.Kid, err
+}
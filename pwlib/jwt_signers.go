@@ -0,0 +1,354 @@
+package pwlib
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/tommi2day/gomodules/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JWTSigner signs JWS signing input with a local or remote key
+type JWTSigner interface {
+	// Algorithm returns the JWS alg
+	Algorithm() string
+	// KeyID returns the kid written to the token header
+	KeyID() string
+	// PublicKey returns the public key for verification and JWKS export
+	PublicKey() crypto.PublicKey
+	// Sign returns the JWS signature of the signing input
+	Sign(input []byte) ([]byte, error)
+}
+
+// JWTContextSigner is a JWTSigner with a remote key, its calls can be bounded or cancelled by a context
+type JWTContextSigner interface {
+	JWTSigner
+	// SignContext returns the JWS signature of the signing input
+	SignContext(ctx context.Context, input []byte) ([]byte, error)
+}
+
+// jwtKeySigner signs with a key in memory
+type jwtKeySigner struct {
+	key crypto.Signer
+	alg string
+	kid string
+}
+
+// NewJWTSigner creates a signer for a RSA, ECDSA P-256 or Ed25519 private key.
+// An empty alg uses RS256, ES256 or EdDSA by key type, an empty kid the JWK thumbprint
+func NewJWTSigner(key crypto.Signer, alg string, kid string) (JWTSigner, error) {
+	if key == nil {
+		return nil, errors.New("private key is nil")
+	}
+	pub := key.Public()
+	if alg == "" {
+		alg = jwtDefaultAlg(pub)
+	}
+	if err := jwtCheckKey(alg, pub); err != nil {
+		return nil, err
+	}
+	kid, err := jwtKid(pub, alg, kid)
+	if err != nil {
+		return nil, err
+	}
+	return &jwtKeySigner{key: key, alg: alg, kid: kid}, nil
+}
+
+// NewJWTSignerFromFile loads a RSA or ECDSA PEM private key, or an unencrypted PKCS8 Ed25519 key
+func NewJWTSignerFromFile(privateKeyFile string, keyPass string, alg string, kid string) (JWTSigner, error) {
+	var key crypto.Signer
+	keyType, err := GetKeyTypeFromFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	switch keyType {
+	case KeyTypeRSA:
+		_, k, e := GetPrivateKeyFromFile(privateKeyFile, keyPass)
+		if k != nil {
+			key = k
+		}
+		err = e
+	case KeyTypeECDSA:
+		_, k, e := GetEcdsaPrivateKeyFromFile(privateKeyFile, keyPass)
+		if k != nil {
+			key = k
+		}
+		err = e
+	default:
+		key, err = getEd25519PrivateKey(privateKeyFile)
+	}
+	if err == nil && key == nil {
+		err = fmt.Errorf("cannot load private key from %s", privateKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewJWTSigner(key, alg, kid)
+}
+
+// getEd25519PrivateKey reads an unencrypted PKCS8 Ed25519 key as written by openssl genpkey -algorithm ed25519
+func getEd25519PrivateKey(privateKeyFile string) (ed25519.PrivateKey, error) {
+	data, err := common.ReadFileToString(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no supported private key in %s", privateKeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key type %T not supported", parsed)
+	}
+	return key, nil
+}
+
+func (s *jwtKeySigner) Algorithm() string           { return s.alg }
+func (s *jwtKeySigner) KeyID() string               { return s.kid }
+func (s *jwtKeySigner) PublicKey() crypto.PublicKey { return s.key.Public() }
+
+func (s *jwtKeySigner) Sign(input []byte) ([]byte, error) {
+	if s.alg == JWTAlgEdDSA {
+		return s.key.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(input)
+	switch s.alg {
+	case JWTAlgPS256:
+		return s.key.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	case JWTAlgES256:
+		der, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		return jwtECDSASignature(der)
+	}
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// jwtKMSSigner signs with an asymmetric AWS KMS key
+type jwtKMSSigner struct {
+	svc   *kms.Client
+	keyID string
+	alg   string
+	kid   string
+	pub   crypto.PublicKey
+}
+
+var jwtKMSAlgorithms = map[string]types.SigningAlgorithmSpec{
+	JWTAlgRS256: types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	JWTAlgPS256: types.SigningAlgorithmSpecRsassaPssSha256,
+	JWTAlgES256: types.SigningAlgorithmSpecEcdsaSha256,
+	JWTAlgEdDSA: types.SigningAlgorithmSpecEd25519Sha512,
+}
+
+// NewKMSJWTSigner creates a signer for an asymmetric KMS key, the public key is fetched from KMS
+func NewKMSJWTSigner(svc *kms.Client, keyID string, alg string, kid string) (JWTContextSigner, error) {
+	return NewKMSJWTSignerContext(context.Background(), svc, keyID, alg, kid)
+}
+
+// NewKMSJWTSignerContext creates a signer for an asymmetric KMS key, see NewKMSJWTSigner
+func NewKMSJWTSignerContext(ctx context.Context, svc *kms.Client, keyID string, alg string, kid string) (JWTContextSigner, error) {
+	if svc == nil {
+		return nil, errors.New("KMS service is nil")
+	}
+	if keyID == "" {
+		return nil, errors.New("keyID is empty")
+	}
+	output, err := withRetry(ctx, "GetPublicKey", func(ctx context.Context) (*kms.GetPublicKeyOutput, error) {
+		return svc.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)}, kmsSingleAttempt)
+	})
+	if err != nil {
+		return nil, checkOperationError(err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(output.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key of %s: %v", keyID, err)
+	}
+	if alg == "" {
+		alg = jwtDefaultAlg(pub)
+	}
+	if err = jwtCheckKey(alg, pub); err != nil {
+		return nil, err
+	}
+	if kid, err = jwtKid(pub, alg, kid); err != nil {
+		return nil, err
+	}
+	log.Debugf("KMS jwt signer for %s with %s", keyID, alg)
+	return &jwtKMSSigner{svc: svc, keyID: keyID, alg: alg, kid: kid, pub: pub}, nil
+}
+
+func (s *jwtKMSSigner) Algorithm() string           { return s.alg }
+func (s *jwtKMSSigner) KeyID() string               { return s.kid }
+func (s *jwtKMSSigner) PublicKey() crypto.PublicKey { return s.pub }
+
+func (s *jwtKMSSigner) Sign(input []byte) ([]byte, error) {
+	return s.SignContext(context.Background(), input)
+}
+
+func (s *jwtKMSSigner) SignContext(ctx context.Context, input []byte) ([]byte, error) {
+	output, err := withRetry(ctx, "Sign", func(ctx context.Context) (*kms.SignOutput, error) {
+		return s.svc.Sign(ctx, &kms.SignInput{
+			KeyId:            aws.String(s.keyID),
+			Message:          input,
+			MessageType:      types.MessageTypeRaw,
+			SigningAlgorithm: jwtKMSAlgorithms[s.alg],
+		}, kmsSingleAttempt)
+	})
+	if err != nil {
+		return nil, checkOperationError(err)
+	}
+	if s.alg == JWTAlgES256 {
+		return jwtECDSASignature(output.Signature)
+	}
+	return output.Signature, nil
+}
+
+// jwtVaultSigner signs with a Vault transit key
+type jwtVaultSigner struct {
+	client *vault.Client
+	mount  string
+	name   string
+	alg    string
+	kid    string
+	pub    crypto.PublicKey
+	// version is the key version of pub, signing is pinned to it as the key may be rotated
+	version int
+}
+
+// NewVaultJWTSigner creates a signer for the latest version of a Vault transit key, mount defaults to transit
+func NewVaultJWTSigner(client *vault.Client, mount string, keyName string, alg string, kid string) (JWTContextSigner, error) {
+	return NewVaultJWTSignerContext(context.Background(), client, mount, keyName, alg, kid)
+}
+
+// NewVaultJWTSignerContext creates a signer for the latest version of a Vault transit key, see NewVaultJWTSigner
+func NewVaultJWTSignerContext(ctx context.Context, client *vault.Client, mount string, keyName string, alg string, kid string) (JWTContextSigner, error) {
+	if client == nil {
+		return nil, errors.New("vault client is nil")
+	}
+	if mount == "" {
+		mount = "transit"
+	}
+	keyPath := path.Join(mount, "keys", keyName)
+	vs, err := VaultReadContext(ctx, client, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if vs == nil || vs.Data == nil {
+		return nil, fmt.Errorf("transit key %s not found", keyPath)
+	}
+	pub, version, err := vaultTransitPublicKey(vs.Data)
+	if err != nil {
+		return nil, fmt.Errorf("transit key %s: %v", keyPath, err)
+	}
+	if alg == "" {
+		alg = jwtDefaultAlg(pub)
+	}
+	if err = jwtCheckKey(alg, pub); err != nil {
+		return nil, err
+	}
+	if kid, err = jwtKid(pub, alg, kid); err != nil {
+		return nil, err
+	}
+	log.Debugf("vault jwt signer for %s version %d with %s", keyPath, version, alg)
+	return &jwtVaultSigner{client: client, mount: mount, name: keyName, alg: alg, kid: kid, pub: pub, version: version}, nil
+}
+
+// vaultTransitPublicKey returns the public key and version of the latest version of a transit key read response
+func vaultTransitPublicKey(data map[string]interface{}) (pub crypto.PublicKey, version int, err error) {
+	latest := fmt.Sprint(data["latest_version"])
+	version, err = strconv.Atoi(latest)
+	if err != nil || version < 1 {
+		return nil, 0, fmt.Errorf("invalid latest version %s", latest)
+	}
+	keys, ok := data["keys"].(map[string]interface{})
+	if !ok {
+		return nil, 0, errors.New("no key versions")
+	}
+	entry, ok := keys[latest].(map[string]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("version %s not found", latest)
+	}
+	pubKey, _ := entry["public_key"].(string)
+	if pubKey == "" {
+		return nil, 0, errors.New("key has no public key, asymmetric key type required")
+	}
+	if block, _ := pem.Decode([]byte(pubKey)); block != nil {
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+		return
+	}
+	// ed25519 keys are returned as plain base64
+	raw, err := base64.StdEncoding.DecodeString(pubKey)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, 0, errors.New("cannot decode public key")
+	}
+	return ed25519.PublicKey(raw), version, nil
+}
+
+func (s *jwtVaultSigner) Algorithm() string           { return s.alg }
+func (s *jwtVaultSigner) KeyID() string               { return s.kid }
+func (s *jwtVaultSigner) PublicKey() crypto.PublicKey { return s.pub }
+
+func (s *jwtVaultSigner) Sign(input []byte) ([]byte, error) {
+	return s.SignContext(context.Background(), input)
+}
+
+func (s *jwtVaultSigner) SignContext(ctx context.Context, input []byte) ([]byte, error) {
+	signPath := path.Join(s.mount, "sign", s.name)
+	data := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(input),
+		"key_version": s.version,
+	}
+	switch s.alg {
+	case JWTAlgRS256:
+		signPath += "/sha2-256"
+		data["signature_algorithm"] = "pkcs1v15"
+	case JWTAlgPS256:
+		signPath += "/sha2-256"
+		data["signature_algorithm"] = "pss"
+		data["salt_length"] = "hash"
+	case JWTAlgES256:
+		signPath += "/sha2-256"
+		data["marshaling_algorithm"] = "jws"
+	}
+	vs, err := s.client.Logical().WriteWithContext(ctx, signPath, data)
+	if err != nil {
+		return nil, fmt.Errorf("vault sign with %s failed: %v", signPath, err)
+	}
+	if vs == nil || vs.Data == nil {
+		return nil, fmt.Errorf("vault sign with %s returned no data", signPath)
+	}
+	signature, _ := vs.Data["signature"].(string)
+	// vault:v<version>:<signature>
+	parts := strings.SplitN(signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("unexpected vault signature format")
+	}
+	if parts[1] != fmt.Sprintf("v%d", s.version) {
+		return nil, fmt.Errorf("vault signed with key %s instead of v%d", parts[1], s.version)
+	}
+	if s.alg == JWTAlgES256 {
+		return b64url.DecodeString(parts[2])
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
@@ -0,0 +1,261 @@
+package pwlib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+// fakeTransit serves the transit key read and sign endpoints for ecdsa key versions, latest selects the current version
+func fakeTransit(t *testing.T, keys []*ecdsa.PrivateKey, latest *atomic.Int32) *httptest.Server {
+	versions := map[string]interface{}{}
+	for i, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		versions[strconv.Itoa(i+1)] = map[string]interface{}{"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]interface{}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/transit/keys/jwt":
+			data = map[string]interface{}{
+				"type":           "ecdsa-p256",
+				"latest_version": latest.Load(),
+				"keys":           versions,
+			}
+		case r.Method == http.MethodPut && r.URL.Path == "/v1/transit/sign/jwt/sha2-256":
+			var req map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req["marshaling_algorithm"] != "jws" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// vault signs with the latest version if key_version is not given
+			version := int(latest.Load())
+			if v, ok := req["key_version"].(float64); ok && v > 0 {
+				version = int(v)
+			}
+			input, _ := base64.StdEncoding.DecodeString(req["input"].(string))
+			digest := sha256.Sum256(input)
+			asn, _ := ecdsa.SignASN1(rand.Reader, keys[version-1], digest[:])
+			sig, _ := jwtECDSASignature(asn)
+			data = map[string]interface{}{"signature": fmt.Sprintf("vault:v%d:%s", version, b64url.EncodeToString(sig))}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestJWT(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	rsaPriv := path.Join(test.TestData, "test_jwt_rsa"+privPemExt)
+	_, _, err = GenRsaKey(path.Join(test.TestData, "test_jwt_rsa"+pubPemExt), rsaPriv, "jwt")
+	require.NoErrorf(t, err, "Prepare RSA Key failed:%s", err)
+	ecPriv := path.Join(test.TestData, "test_jwt_ec"+privPemExt)
+	_, _, err = GenEcdsaKey(path.Join(test.TestData, "test_jwt_ec"+pubPemExt), ecPriv, "jwt")
+	require.NoErrorf(t, err, "Prepare ECDSA Key failed:%s", err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDer, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edPriv := path.Join(test.TestData, "test_jwt_ed"+privPemExt)
+	err = common.WriteStringToFile(edPriv, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDer})))
+	require.NoError(t, err)
+
+	now := time.Now()
+	claims := JWTClaims{
+		Issuer:    "pwlib",
+		Subject:   "alice",
+		Audience:  JWTAudience{"api"},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		NotBefore: now.Add(-time.Minute).Unix(),
+		Custom:    map[string]interface{}{"role": "admin", "iss": "ignored"},
+	}
+	validation := JWTValidation{Issuer: "pwlib", Audience: "api"}
+	var signers []JWTSigner
+	for _, tc := range []struct {
+		file string
+		alg  string
+		kid  string
+	}{
+		{rsaPriv, "", ""},
+		{rsaPriv, JWTAlgPS256, "rsa-pss"},
+		{ecPriv, "", ""},
+		{edPriv, "", ""},
+	} {
+		s, e := NewJWTSignerFromFile(tc.file, "jwt", tc.alg, tc.kid)
+		require.NoErrorf(t, e, "load %s failed", tc.file)
+		signers = append(signers, s)
+	}
+	t.Run("Sign and Verify", func(t *testing.T) {
+		for i, want := range []string{JWTAlgRS256, JWTAlgPS256, JWTAlgES256, JWTAlgEdDSA} {
+			s := signers[i]
+			assert.Equal(t, want, s.Algorithm())
+			token, e := JWTIssue(s, claims)
+			require.NoErrorf(t, e, "issue %s failed", want)
+			header, _, e := JWTParseUnverified(token)
+			require.NoError(t, e)
+			assert.Equal(t, want, header.Alg)
+			assert.Equal(t, s.KeyID(), header.Kid)
+			c, e := JWTVerify(token, s.PublicKey(), validation)
+			require.NoErrorf(t, e, "verify %s failed", want)
+			assert.Equal(t, "alice", c.Subject)
+			assert.Equal(t, "pwlib", c.Issuer, "registered claim must win")
+			assert.Equal(t, "admin", c.Custom["role"])
+			assert.NotZero(t, c.IssuedAt)
+			// tampered payload
+			parts := strings.Split(token, ".")
+			evil := claims
+			evil.Subject = "mallory"
+			p, _ := json.Marshal(evil)
+			_, e = JWTVerify(parts[0]+"."+b64url.EncodeToString(p)+"."+parts[2], s.PublicKey(), validation)
+			assert.ErrorContainsf(t, e, "signature", "tampered %s token accepted", want)
+		}
+	})
+	t.Run("Wrong key and algorithm", func(t *testing.T) {
+		token, e := JWTIssue(signers[0], claims)
+		require.NoError(t, e)
+		_, e = JWTVerify(token, signers[2].PublicKey(), validation)
+		assert.ErrorContains(t, e, "does not match")
+		_, e = JWTVerify(token, signers[0].PublicKey(), JWTValidation{Algorithms: []string{JWTAlgES256}})
+		assert.ErrorContains(t, e, "not accepted")
+		none := b64url.EncodeToString([]byte(`{"alg":"none"}`)) + "." + strings.Split(token, ".")[1] + "."
+		_, e = JWTVerify(none, signers[0].PublicKey(), JWTValidation{})
+		assert.Error(t, e)
+		_, e = NewJWTSignerFromFile(ecPriv, "jwt", JWTAlgRS256, "")
+		assert.Error(t, e)
+	})
+	t.Run("Claims", func(t *testing.T) {
+		s := signers[2]
+		exp := now.Add(3 * time.Hour).Unix()
+		for name, tc := range map[string]struct {
+			claims JWTClaims
+			v      JWTValidation
+			err    string
+		}{
+			"expired":      {JWTClaims{ExpiresAt: now.Add(-time.Minute).Unix()}, JWTValidation{}, "expired"},
+			"leeway":       {JWTClaims{ExpiresAt: now.Add(-time.Minute).Unix()}, JWTValidation{Leeway: 2 * time.Minute}, ""},
+			"not yet":      {JWTClaims{NotBefore: now.Add(time.Hour).Unix(), ExpiresAt: exp}, JWTValidation{}, "not valid before"},
+			"future check": {JWTClaims{NotBefore: now.Add(time.Hour).Unix(), ExpiresAt: exp}, JWTValidation{Now: now.Add(2 * time.Hour)}, ""},
+			"no expiry":    {JWTClaims{}, JWTValidation{}, "no expiry"},
+			"allow no exp": {JWTClaims{}, JWTValidation{AllowNoExpiry: true}, ""},
+			"issuer":       {JWTClaims{Issuer: "other"}, JWTValidation{Issuer: "pwlib", AllowNoExpiry: true}, "issuer"},
+			"audience":     {JWTClaims{Audience: JWTAudience{"a", "b"}}, JWTValidation{Audience: "b", AllowNoExpiry: true}, ""},
+			"wrong aud":    {JWTClaims{Audience: JWTAudience{"a", "b"}}, JWTValidation{Audience: "c", AllowNoExpiry: true}, "audience"},
+		} {
+			token, e := JWTIssue(s, tc.claims)
+			require.NoError(t, e)
+			_, e = JWTVerify(token, s.PublicKey(), tc.v)
+			if tc.err == "" {
+				assert.NoErrorf(t, e, "%s should be valid", name)
+			} else {
+				assert.ErrorContainsf(t, e, tc.err, "%s should fail", name)
+			}
+		}
+		var c JWTClaims
+		err = json.Unmarshal([]byte(`{"aud":"single","exp":1.7e9,"x":1}`), &c)
+		require.NoError(t, err)
+		assert.Equal(t, JWTAudience{"single"}, c.Audience)
+		assert.Equal(t, int64(1700000000), c.ExpiresAt)
+		assert.Len(t, c.Custom, 1)
+		b, _ := json.Marshal(c)
+		assert.Contains(t, string(b), `"aud":"single"`)
+	})
+	t.Run("JWKS", func(t *testing.T) {
+		jwks, e := NewJWKS(signers...)
+		require.NoError(t, e)
+		require.Len(t, jwks.Keys, len(signers))
+		data, e := json.Marshal(jwks)
+		require.NoError(t, e)
+		assert.NotContains(t, string(data), `"d"`, "jwks must not contain private parts")
+		parsed, e := ParseJWKS(data)
+		require.NoError(t, e)
+		for _, s := range signers {
+			k, ok := parsed.Key(s.KeyID())
+			require.True(t, ok)
+			pub, e := k.PublicKey()
+			require.NoError(t, e)
+			assert.Truef(t, s.PublicKey().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub), "%s key differs", s.Algorithm())
+			token, e := JWTIssue(s, claims)
+			require.NoError(t, e)
+			_, e = JWTVerifyJWKS(token, parsed, validation)
+			assert.NoErrorf(t, e, "jwks verify %s failed", s.Algorithm())
+		}
+		thumbprint, e := jwks.Keys[0].Thumbprint()
+		require.NoError(t, e)
+		assert.Equal(t, thumbprint, signers[0].KeyID(), "default kid is the thumbprint")
+		other, e := NewJWTSignerFromFile(ecPriv, "jwt", "", "unknown-kid")
+		require.NoError(t, e)
+		token, e := JWTIssue(other, claims)
+		require.NoError(t, e)
+		_, e = JWTVerifyJWKS(token, parsed, validation)
+		assert.ErrorContains(t, e, "no key with kid")
+		// RFC 7638 example key
+		k := JWK{Kty: "RSA", E: "AQAB", N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}
+		tp, e := k.Thumbprint()
+		require.NoError(t, e)
+		assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", tp)
+	})
+	t.Run("Vault Transit", func(t *testing.T) {
+		var keys []*ecdsa.PrivateKey
+		for range 2 {
+			key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			require.NoError(t, e)
+			keys = append(keys, key)
+		}
+		var latest atomic.Int32
+		latest.Store(1)
+		srv := fakeTransit(t, keys, &latest)
+		defer srv.Close()
+		client, e := VaultConfig(srv.URL, "test")
+		require.NoError(t, e)
+		s, e := NewVaultJWTSigner(client, "", "jwt", "", "vault-1")
+		require.NoError(t, e)
+		assert.Equal(t, JWTAlgES256, s.Algorithm())
+		token, e := JWTIssue(s, claims)
+		require.NoError(t, e)
+		jwks, e := NewJWKS(s)
+		require.NoError(t, e)
+		_, e = JWTVerifyJWKS(token, jwks, validation)
+		assert.NoError(t, e)
+		// signing stays on the version of the published public key after a rotation
+		latest.Store(2)
+		token, e = JWTIssue(s, claims)
+		require.NoError(t, e)
+		_, e = JWTVerifyJWKS(token, jwks, validation)
+		assert.NoError(t, e)
+		rotated, e := NewVaultJWTSigner(client, "", "jwt", "", "vault-2")
+		require.NoError(t, e)
+		assert.NotEqual(t, s.PublicKey(), rotated.PublicKey())
+		_, e = NewVaultJWTSigner(client, "", "missing", "", "")
+		assert.Error(t, e)
+	})
+}
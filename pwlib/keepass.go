@@ -0,0 +1,794 @@
+package pwlib
+
+// KeePass KDBX 3.1 and 4.x reader and KeePass 2 XML export,
+// see https://keepass.info/help/kb/kdbx_4.html
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20/salsa"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tommi2day/gomodules/pwlib/internal/argon2d"
+)
+
+const (
+	kdbxSignature1 = 0x9AA2D903
+	kdbxSignature2 = 0xB54BFB67
+	kdbxVersion3   = 3
+	kdbxVersion4   = 4
+)
+
+// outer header field ids
+const (
+	kdbxHeaderEnd                 = 0
+	kdbxHeaderCipherID            = 2
+	kdbxHeaderCompressionFlags    = 3
+	kdbxHeaderMasterSeed          = 4
+	kdbxHeaderTransformSeed       = 5
+	kdbxHeaderTransformRounds     = 6
+	kdbxHeaderEncryptionIV        = 7
+	kdbxHeaderProtectedStreamKey  = 8
+	kdbxHeaderStreamStartBytes    = 9
+	kdbxHeaderInnerRandomStreamID = 10
+	kdbxHeaderKdfParameters       = 11
+)
+
+// inner header field ids (KDBX 4)
+const (
+	kdbxInnerEnd          = 0
+	kdbxInnerStreamID     = 1
+	kdbxInnerStreamKey    = 2
+	kdbxInnerBinary       = 3
+	kdbxInnerStreamSalsa  = 2
+	kdbxInnerStreamChaCha = 3
+)
+
+// cipher and kdf uuids
+const (
+	kdbxCipherAES256   = "31c1f2e6bf714350be5805216afc5aff"
+	kdbxCipherChaCha20 = "d6038a2b8b6f4cb5a524339a31dbb59a"
+	kdbxKdfAES3        = "c9d9f39a628a4460bf740d08c18a4fea"
+	kdbxKdfAES4        = "7c02bb8279a74ac0927d114a00648238"
+	kdbxKdfArgon2d     = "ef636ddf8c29444b91f7a9a403e30a0c"
+	kdbxKdfArgon2id    = "9e298b1956db4773b23dfc3ec6f0a1e6"
+)
+
+// limits of the kdf parameters, argon2 memory in KiB
+const (
+	kdbxMaxAESRounds        = 1 << 28
+	kdbxMaxArgon2Iterations = 1 << 12
+	kdbxMaxArgon2Memory     = 2 << 20
+)
+
+// salsa20 nonce for protected values in KDBX 3
+var kdbxSalsaNonce = []byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}
+
+// keePassFile is the XML document inside a KDBX file and the KeePass 2 XML export format
+type keePassFile struct {
+	XMLName xml.Name    `xml:"KeePassFile"`
+	Meta    keePassMeta `xml:"Meta"`
+	Root    keePassRoot `xml:"Root"`
+}
+
+type keePassMeta struct {
+	Generator         string `xml:"Generator"`
+	DatabaseName      string `xml:"DatabaseName,omitempty"`
+	RecycleBinEnabled string `xml:"RecycleBinEnabled,omitempty"`
+	RecycleBinUUID    string `xml:"RecycleBinUUID,omitempty"`
+}
+
+type keePassRoot struct {
+	Groups []keePassGroup `xml:"Group"`
+}
+
+type keePassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+type keePassEntry struct {
+	UUID    string          `xml:"UUID"`
+	Strings []keePassString `xml:"String"`
+}
+
+type keePassString struct {
+	Key   string       `xml:"Key"`
+	Value keePassValue `xml:"Value"`
+}
+
+type keePassValue struct {
+	ProtectInMemory string `xml:"ProtectInMemory,attr,omitempty"`
+	Text            string `xml:",chardata"`
+}
+
+func (e keePassEntry) get(key string) string {
+	for _, s := range e.Strings {
+		if s.Key == key {
+			return s.Value.Text
+		}
+	}
+	return ""
+}
+
+// ImportKeePassFile reads entries from a KeePass KDBX 3.1 or 4.x database, keyFile is optional
+func ImportKeePassFile(filename string, password string, keyFile string, opts ImportOptions) (records []PasswordRecord, err error) {
+	var data []byte
+	var key []byte
+	var doc []byte
+	log.Debugf("Import KeePass database %s", filename)
+	data, err = os.ReadFile(filename) //nolint gosec
+	if err != nil {
+		err = fmt.Errorf("cannot read %s: %v", filename, err)
+		return
+	}
+	key, err = keePassCompositeKey(password, keyFile)
+	if err != nil {
+		return
+	}
+	defer wipeBytes(key)
+	doc, err = decodeKDBX(data, key)
+	if err != nil {
+		return
+	}
+	defer wipeBytes(doc)
+	return ImportKeePassXML(string(doc), opts)
+}
+
+// ImportKeePassXML reads entries from a KeePass 2 XML export or a decrypted KDBX document
+func ImportKeePassXML(content string, opts ImportOptions) (records []PasswordRecord, err error) {
+	var kf keePassFile
+	err = xml.Unmarshal([]byte(content), &kf)
+	if err != nil {
+		err = fmt.Errorf("cannot parse keepass xml: %v", err)
+		return
+	}
+	recycleBin := ""
+	if strings.EqualFold(kf.Meta.RecycleBinEnabled, "true") {
+		recycleBin = kf.Meta.RecycleBinUUID
+	}
+	var walk func(g keePassGroup, folder string)
+	walk = func(g keePassGroup, folder string) {
+		if recycleBin != "" && g.UUID == recycleBin {
+			log.Debugf("skip recycle bin %s", g.Name)
+			return
+		}
+		for _, e := range g.Entries {
+			records = append(records, NewPasswordRecord(e.get("Title"), e.get("URL"), folder, e.get("UserName"), e.get("Password"), e.get("Notes"), opts))
+		}
+		for _, sub := range g.Groups {
+			f := sub.Name
+			if folder != "" {
+				f = folder + "/" + sub.Name
+			}
+			walk(sub, f)
+		}
+	}
+	// the top level group is the database root and not part of the folder path
+	for _, g := range kf.Root.Groups {
+		walk(g, "")
+	}
+	log.Debugf("read %d keepass entries", len(records))
+	return
+}
+
+// ExportKeePassXML formats records as KeePass 2 XML, folders are exported as groups
+func ExportKeePassXML(records []PasswordRecord) (string, error) {
+	entries := map[string][]keePassEntry{}
+	children := map[string][]string{}
+	var addFolder func(folder string)
+	addFolder = func(folder string) {
+		if _, ok := entries[folder]; ok || folder == "" {
+			return
+		}
+		entries[folder] = nil
+		parent := ""
+		if i := strings.LastIndex(folder, "/"); i >= 0 {
+			parent = folder[:i]
+		}
+		addFolder(parent)
+		children[parent] = append(children[parent], folder)
+	}
+	for _, r := range records {
+		title := r.Title
+		if title == "" {
+			title = r.System
+		}
+		addFolder(r.Folder)
+		entries[r.Folder] = append(entries[r.Folder], keePassEntry{
+			UUID: keePassUUID(),
+			Strings: []keePassString{
+				{Key: "Title", Value: keePassValue{Text: title}},
+				{Key: "UserName", Value: keePassValue{Text: r.Account}},
+				{Key: "Password", Value: keePassValue{Text: r.Password, ProtectInMemory: "True"}},
+				{Key: "URL", Value: keePassValue{Text: r.URL}},
+				{Key: "Notes", Value: keePassValue{Text: r.Notes}},
+			},
+		})
+	}
+	var build func(folder string, name string) keePassGroup
+	build = func(folder string, name string) keePassGroup {
+		g := keePassGroup{UUID: keePassUUID(), Name: name, Entries: entries[folder]}
+		for _, c := range children[folder] {
+			g.Groups = append(g.Groups, build(c, c[strings.LastIndex(c, "/")+1:]))
+		}
+		return g
+	}
+	kf := keePassFile{Meta: keePassMeta{Generator: "pwlib"}, Root: keePassRoot{Groups: []keePassGroup{build("", "Root")}}}
+	b, err := xml.MarshalIndent(kf, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(b) + "\n", nil
+}
+
+func keePassUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// keePassCompositeKey builds the composite key from password and optional key file
+func keePassCompositeKey(password string, keyFile string) ([]byte, error) {
+	h := sha256.New()
+	if password != "" || keyFile == "" {
+		p := sha256.Sum256([]byte(password))
+		h.Write(p[:])
+	}
+	if keyFile != "" {
+		k, err := readKeePassKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		h.Write(k)
+	}
+	return h.Sum(nil), nil
+}
+
+// readKeePassKeyFile returns the 32 byte key of a XML (v1/v2), binary, hex or arbitrary key file
+func readKeePassKeyFile(keyFile string) ([]byte, error) {
+	data, err := os.ReadFile(keyFile) //nolint gosec
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file %s: %v", keyFile, err)
+	}
+	if bytes.Contains(data, []byte("<KeyFile>")) {
+		var kf struct {
+			Meta struct {
+				Version string `xml:"Version"`
+			} `xml:"Meta"`
+			Key struct {
+				Data string `xml:"Data"`
+			} `xml:"Key"`
+		}
+		if err = xml.Unmarshal(data, &kf); err != nil {
+			return nil, fmt.Errorf("invalid xml key file %s: %v", keyFile, err)
+		}
+		d := strings.Join(strings.Fields(kf.Key.Data), "")
+		if strings.HasPrefix(kf.Meta.Version, "2.") {
+			return hex.DecodeString(d)
+		}
+		return base64.StdEncoding.DecodeString(d)
+	}
+	switch len(data) {
+	case 32:
+		return data, nil
+	case 64:
+		if k, e := hex.DecodeString(string(data)); e == nil {
+			return k, nil
+		}
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// kdbxHeader holds the parsed outer header
+type kdbxHeader struct {
+	major  uint16
+	fields map[byte][]byte
+	kdf    map[string]any
+	// length of the header including signature and version
+	size int
+}
+
+func parseKDBXHeader(data []byte) (h kdbxHeader, err error) {
+	if len(data) < 12 || binary.LittleEndian.Uint32(data[0:4]) != kdbxSignature1 || binary.LittleEndian.Uint32(data[4:8]) != kdbxSignature2 {
+		err = errors.New("not a keepass kdbx file")
+		return
+	}
+	h.major = binary.LittleEndian.Uint16(data[10:12])
+	if h.major != kdbxVersion3 && h.major != kdbxVersion4 {
+		err = fmt.Errorf("unsupported kdbx version %d", h.major)
+		return
+	}
+	h.fields = map[byte][]byte{}
+	pos := 12
+	for {
+		var l int
+		if pos+1 > len(data) {
+			err = errors.New("kdbx header truncated")
+			return
+		}
+		id := data[pos]
+		if h.major == kdbxVersion3 {
+			if pos+3 > len(data) {
+				err = errors.New("kdbx header truncated")
+				return
+			}
+			l = int(binary.LittleEndian.Uint16(data[pos+1 : pos+3]))
+			pos += 3
+		} else {
+			if pos+5 > len(data) {
+				err = errors.New("kdbx header truncated")
+				return
+			}
+			l = int(binary.LittleEndian.Uint32(data[pos+1 : pos+5]))
+			pos += 5
+		}
+		if l < 0 || pos+l > len(data) {
+			err = errors.New("kdbx header truncated")
+			return
+		}
+		h.fields[id] = data[pos : pos+l]
+		pos += l
+		if id == kdbxHeaderEnd {
+			break
+		}
+	}
+	h.size = pos
+	if h.major == kdbxVersion4 {
+		h.kdf, err = parseVariantDictionary(h.fields[kdbxHeaderKdfParameters])
+	}
+	return
+}
+
+// parseVariantDictionary parses the KDBX 4 key/value structure used for kdf parameters
+func parseVariantDictionary(data []byte) (dict map[string]any, err error) {
+	dict = map[string]any{}
+	if len(data) < 2 {
+		return nil, errors.New("kdf parameters missing")
+	}
+	pos := 2
+	for pos < len(data) {
+		t := data[pos]
+		pos++
+		if t == 0 {
+			return
+		}
+		if pos+4 > len(data) {
+			break
+		}
+		kl := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if pos+kl+4 > len(data) {
+			break
+		}
+		name := string(data[pos : pos+kl])
+		pos += kl
+		vl := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if pos+vl > len(data) {
+			break
+		}
+		v := data[pos : pos+vl]
+		pos += vl
+		switch {
+		case t == 0x04 && vl == 4:
+			dict[name] = uint64(binary.LittleEndian.Uint32(v))
+		case t == 0x05 && vl == 8:
+			dict[name] = binary.LittleEndian.Uint64(v)
+		case t == 0x08 && vl == 1:
+			dict[name] = v[0] != 0
+		case t == 0x0C && vl == 4:
+			dict[name] = uint64(int32(binary.LittleEndian.Uint32(v))) //nolint gosec
+		case t == 0x0D && vl == 8:
+			dict[name] = binary.LittleEndian.Uint64(v)
+		case t == 0x18:
+			dict[name] = string(v)
+		default:
+			dict[name] = v
+		}
+	}
+	return nil, errors.New("kdf parameters truncated")
+}
+
+func (h kdbxHeader) kdfUint(name string) uint64 {
+	v, _ := h.kdf[name].(uint64)
+	return v
+}
+
+func (h kdbxHeader) kdfBytes(name string) []byte {
+	v, _ := h.kdf[name].([]byte)
+	return v
+}
+
+// transformKey applies the configured key derivation to the composite key
+func (h kdbxHeader) transformKey(compositeKey []byte) ([]byte, error) {
+	if h.major == kdbxVersion3 {
+		rounds := h.fields[kdbxHeaderTransformRounds]
+		if len(rounds) != 8 {
+			return nil, errors.New("transform rounds missing")
+		}
+		return aesKdf(compositeKey, h.fields[kdbxHeaderTransformSeed], binary.LittleEndian.Uint64(rounds))
+	}
+	uuid := hex.EncodeToString(h.kdfBytes("$UUID"))
+	log.Debugf("kdbx kdf %s", uuid)
+	switch uuid {
+	case kdbxKdfAES3, kdbxKdfAES4:
+		return aesKdf(compositeKey, h.kdfBytes("S"), h.kdfUint("R"))
+	case kdbxKdfArgon2d, kdbxKdfArgon2id:
+		iterations := h.kdfUint("I")
+		memory := h.kdfUint("M") / 1024
+		parallelism := h.kdfUint("P")
+		if iterations == 0 || memory == 0 || parallelism == 0 || parallelism > 255 {
+			return nil, errors.New("invalid argon2 parameters")
+		}
+		// a crafted file must not exhaust memory or cpu before the key is checked
+		if iterations > kdbxMaxArgon2Iterations || memory > kdbxMaxArgon2Memory {
+			return nil, fmt.Errorf("argon2 parameters exceed limits of %d iterations and %d MiB", kdbxMaxArgon2Iterations, kdbxMaxArgon2Memory/1024)
+		}
+		if uuid == kdbxKdfArgon2id {
+			return argon2.IDKey(compositeKey, h.kdfBytes("S"), uint32(iterations), uint32(memory), uint8(parallelism), 32), nil
+		}
+		return argon2d.Key(compositeKey, h.kdfBytes("S"), uint32(iterations), uint32(memory), uint8(parallelism), 32), nil
+	}
+	return nil, fmt.Errorf("unsupported kdf %s", uuid)
+}
+
+// aesKdf encrypts the key rounds times with AES-256-ECB using seed as key
+func aesKdf(key []byte, seed []byte, rounds uint64) ([]byte, error) {
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform seed: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("invalid composite key length")
+	}
+	if rounds > kdbxMaxAESRounds {
+		return nil, fmt.Errorf("aes kdf rounds exceed limit of %d", kdbxMaxAESRounds)
+	}
+	out := make([]byte, 32)
+	copy(out, key)
+	defer wipeBytes(out)
+	for i := uint64(0); i < rounds; i++ {
+		block.Encrypt(out[:16], out[:16])
+		block.Encrypt(out[16:], out[16:])
+	}
+	sum := sha256.Sum256(out)
+	return sum[:], nil
+}
+
+// decodeKDBX decrypts a KDBX file and returns the XML document with decrypted protected values
+func decodeKDBX(data []byte, compositeKey []byte) (doc []byte, err error) {
+	var h kdbxHeader
+	var transformed []byte
+	var payload []byte
+	var streamID uint32
+	var streamKey []byte
+	h, err = parseKDBXHeader(data)
+	if err != nil {
+		return
+	}
+	log.Debugf("kdbx version %d", h.major)
+	transformed, err = h.transformKey(compositeKey)
+	if err != nil {
+		return
+	}
+	defer wipeBytes(transformed)
+	seed := h.fields[kdbxHeaderMasterSeed]
+	masterKey := sha256.Sum256(append(append([]byte{}, seed...), transformed...))
+	defer wipeBytes(masterKey[:])
+	rest := data[h.size:]
+
+	if h.major == kdbxVersion4 {
+		if len(rest) < 64 {
+			return nil, errors.New("kdbx file truncated")
+		}
+		headerHash := sha256.Sum256(data[:h.size])
+		if !bytes.Equal(headerHash[:], rest[:32]) {
+			return nil, errors.New("kdbx header checksum mismatch")
+		}
+		hk := sha512.Sum512(append(append(append([]byte{}, seed...), transformed...), 1))
+		hmacKey := hk[:]
+		defer wipeBytes(hmacKey)
+		if !hmac.Equal(kdbxHMAC(hmacKey, ^uint64(0), data[:h.size]), rest[32:64]) {
+			return nil, errors.New("wrong credentials or corrupted kdbx file")
+		}
+		rest, err = readHMACBlocks(rest[64:], hmacKey)
+		if err != nil {
+			return
+		}
+	}
+
+	payload, err = kdbxDecrypt(h, masterKey[:], rest)
+	if err != nil {
+		return
+	}
+	if h.major == kdbxVersion3 {
+		start := h.fields[kdbxHeaderStreamStartBytes]
+		if len(payload) < len(start) || !bytes.Equal(payload[:len(start)], start) {
+			return nil, errors.New("wrong credentials or corrupted kdbx file")
+		}
+		payload, err = readHashedBlocks(payload[len(start):])
+		if err != nil {
+			return
+		}
+	}
+	if f := h.fields[kdbxHeaderCompressionFlags]; len(f) == 4 && binary.LittleEndian.Uint32(f) == 1 {
+		payload, err = gunzip(payload)
+		if err != nil {
+			return
+		}
+	}
+	if h.major == kdbxVersion3 {
+		streamKey = h.fields[kdbxHeaderProtectedStreamKey]
+		if f := h.fields[kdbxHeaderInnerRandomStreamID]; len(f) == 4 {
+			streamID = binary.LittleEndian.Uint32(f)
+		}
+	} else {
+		streamID, streamKey, payload, err = parseInnerHeader(payload)
+		if err != nil {
+			return
+		}
+	}
+	stream, err := newInnerStream(streamID, streamKey)
+	if err != nil {
+		return
+	}
+	return decryptProtectedValues(payload, stream)
+}
+
+// kdbxDecrypt decrypts the payload with the outer cipher
+func kdbxDecrypt(h kdbxHeader, key []byte, data []byte) ([]byte, error) {
+	iv := h.fields[kdbxHeaderEncryptionIV]
+	switch hex.EncodeToString(h.fields[kdbxHeaderCipherID]) {
+	case kdbxCipherAES256:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != aes.BlockSize || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+			return nil, errors.New("invalid aes payload")
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+		pad := int(out[len(out)-1])
+		if pad == 0 || pad > aes.BlockSize || pad > len(out) {
+			return nil, errors.New("wrong credentials or corrupted kdbx file")
+		}
+		return out[:len(out)-pad], nil
+	case kdbxCipherChaCha20:
+		c, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		c.XORKeyStream(out, data)
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported kdbx cipher %x", h.fields[kdbxHeaderCipherID])
+}
+
+// kdbxHMAC computes the HMAC-SHA256 of a block with the block specific key, the header
+// with index ^0 is authenticated without index and length
+func kdbxHMAC(hmacKey []byte, index uint64, data []byte) []byte {
+	idx := make([]byte, 8)
+	binary.LittleEndian.PutUint64(idx, index)
+	k := sha512.Sum512(append(idx, hmacKey...))
+	m := hmac.New(sha256.New, k[:])
+	if index != ^uint64(0) {
+		m.Write(idx)
+		l := make([]byte, 4)
+		binary.LittleEndian.PutUint32(l, uint32(len(data))) //nolint gosec
+		m.Write(l)
+	}
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// readHMACBlocks reads and verifies the KDBX 4 HMAC block stream
+func readHMACBlocks(data []byte, hmacKey []byte) ([]byte, error) {
+	var out bytes.Buffer
+	for index := uint64(0); ; index++ {
+		if len(data) < 36 {
+			return nil, errors.New("kdbx block stream truncated")
+		}
+		mac := data[:32]
+		l := int(binary.LittleEndian.Uint32(data[32:36]))
+		if l < 0 || len(data) < 36+l {
+			return nil, errors.New("kdbx block stream truncated")
+		}
+		block := data[36 : 36+l]
+		if !hmac.Equal(mac, kdbxHMAC(hmacKey, index, block)) {
+			return nil, fmt.Errorf("kdbx block %d hmac mismatch", index)
+		}
+		if l == 0 {
+			return out.Bytes(), nil
+		}
+		out.Write(block)
+		data = data[36+l:]
+	}
+}
+
+// readHashedBlocks reads and verifies the KDBX 3 hashed block stream
+func readHashedBlocks(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	for {
+		if len(data) < 40 {
+			return nil, errors.New("kdbx block stream truncated")
+		}
+		hash := data[4:36]
+		l := int(binary.LittleEndian.Uint32(data[36:40]))
+		if l < 0 || len(data) < 40+l {
+			return nil, errors.New("kdbx block stream truncated")
+		}
+		if l == 0 {
+			return out.Bytes(), nil
+		}
+		block := data[40 : 40+l]
+		sum := sha256.Sum256(block)
+		if !bytes.Equal(sum[:], hash) {
+			return nil, errors.New("kdbx block hash mismatch")
+		}
+		out.Write(block)
+		data = data[40+l:]
+	}
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress kdbx payload: %v", err)
+	}
+	defer func(r *gzip.Reader) {
+		_ = r.Close()
+	}(r)
+	return io.ReadAll(r)
+}
+
+// parseInnerHeader reads the KDBX 4 inner header and returns the remaining XML document
+func parseInnerHeader(data []byte) (streamID uint32, streamKey []byte, doc []byte, err error) {
+	pos := 0
+	for {
+		if pos+5 > len(data) {
+			err = errors.New("kdbx inner header truncated")
+			return
+		}
+		id := data[pos]
+		l := int(binary.LittleEndian.Uint32(data[pos+1 : pos+5]))
+		pos += 5
+		if l < 0 || pos+l > len(data) {
+			err = errors.New("kdbx inner header truncated")
+			return
+		}
+		v := data[pos : pos+l]
+		pos += l
+		switch id {
+		case kdbxInnerEnd:
+			doc = data[pos:]
+			return
+		case kdbxInnerStreamID:
+			if l == 4 {
+				streamID = binary.LittleEndian.Uint32(v)
+			}
+		case kdbxInnerStreamKey:
+			streamKey = v
+		case kdbxInnerBinary:
+			// attachments are not imported
+		}
+	}
+}
+
+// newInnerStream returns the cipher stream used for protected values
+func newInnerStream(id uint32, key []byte) (cipher.Stream, error) {
+	switch id {
+	case kdbxInnerStreamSalsa:
+		s := &salsa20Stream{pos: 64}
+		s.key = sha256.Sum256(key)
+		copy(s.nonce[:], kdbxSalsaNonce)
+		return s, nil
+	case kdbxInnerStreamChaCha:
+		h := sha512.Sum512(key)
+		return chacha20.NewUnauthenticatedCipher(h[:32], h[32:44])
+	}
+	return nil, fmt.Errorf("unsupported inner random stream %d", id)
+}
+
+// salsa20Stream is a stateful salsa20 key stream as the salsa20 package only supports one-shot encryption
+type salsa20Stream struct {
+	key     [32]byte
+	nonce   [8]byte
+	counter uint64
+	buf     [64]byte
+	pos     int
+}
+
+// XORKeyStream implements cipher.Stream
+func (s *salsa20Stream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.pos == len(s.buf) {
+			var in [16]byte
+			var zero [64]byte
+			copy(in[:8], s.nonce[:])
+			binary.LittleEndian.PutUint64(in[8:], s.counter)
+			salsa.XORKeyStream(s.buf[:], zero[:], &in, &s.key)
+			s.counter++
+			s.pos = 0
+		}
+		dst[i] = src[i] ^ s.buf[s.pos]
+		s.pos++
+	}
+}
+
+// decryptProtectedValues replaces protected values in document order with their plain text
+func decryptProtectedValues(doc []byte, stream cipher.Stream) ([]byte, error) {
+	var buf bytes.Buffer
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	enc := xml.NewEncoder(&buf)
+	protected := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse kdbx xml: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.ProcInst:
+			continue
+		case xml.StartElement:
+			if t.Name.Local == "Value" {
+				var attrs []xml.Attr
+				for _, a := range t.Attr {
+					if a.Name.Local == "Protected" {
+						protected = strings.EqualFold(a.Value, "true")
+						continue
+					}
+					attrs = append(attrs, a)
+				}
+				t.Attr = attrs
+				tok = t
+			}
+		case xml.CharData:
+			if protected {
+				raw, e := base64.StdEncoding.DecodeString(strings.TrimSpace(string(t)))
+				if e != nil {
+					return nil, fmt.Errorf("invalid protected value: %v", e)
+				}
+				stream.XORKeyStream(raw, raw)
+				tok = xml.CharData(raw)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "Value" {
+				protected = false
+			}
+		}
+		if err = enc.EncodeToken(tok); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,348 @@
+package pwlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/test"
+	"golang.org/x/crypto/chacha20"
+)
+
+const kdbxTestXML = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>KeePass</Generator>
+		<RecycleBinEnabled>True</RecycleBinEnabled>
+		<RecycleBinUUID>cmVjeWNsZWJpbnV1aWQxMg==</RecycleBinUUID>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>cm9vdGdyb3VwdXVpZDEyMw==</UUID>
+			<Name>Database</Name>
+			<Entry>
+				<UUID>ZW50cnkxdXVpZDEyMzQ1Ng==</UUID>
+				<String><Key>Title</Key><Value>Mail</Value></String>
+				<String><Key>UserName</Key><Value>mailuser</Value></String>
+				<String><Key>Password</Key><Value Protected="True">%s</Value></String>
+				<String><Key>URL</Key><Value>https://mail.example.com/login</Value></String>
+			</Entry>
+			<Group>
+				<UUID>c3ViZ3JvdXB1dWlkMTIzNA==</UUID>
+				<Name>Servers</Name>
+				<Entry>
+					<UUID>ZW50cnkydXVpZDEyMzQ1Ng==</UUID>
+					<String><Key>Title</Key><Value>db01</Value></String>
+					<String><Key>UserName</Key><Value>root</Value></String>
+					<String><Key>Password</Key><Value Protected="True">%s</Value></String>
+					<String><Key>Notes</Key><Value>primary database</Value></String>
+				</Entry>
+			</Group>
+			<Group>
+				<UUID>cmVjeWNsZWJpbnV1aWQxMg==</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<UUID>ZW50cnkzdXVpZDEyMzQ1Ng==</UUID>
+					<String><Key>Title</Key><Value>deleted</Value></String>
+					<String><Key>Password</Key><Value>old</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>
+`
+
+// kdbxTestOptions describes the kdbx file written by writeTestKDBX
+type kdbxTestOptions struct {
+	version  uint16
+	cipher   string
+	kdf      string
+	stream   uint32
+	password string
+	keyFile  string
+}
+
+func kdbxTestDocument(stream cipher.Stream) []byte {
+	var values []string
+	for _, p := range []string{"mail:secret", "root-pass"} {
+		b := []byte(p)
+		stream.XORKeyStream(b, b)
+		values = append(values, base64.StdEncoding.EncodeToString(b))
+	}
+	doc := []byte(kdbxTestXML)
+	doc = bytes.Replace(doc, []byte("%s"), []byte(values[0]), 1)
+	doc = bytes.Replace(doc, []byte("%s"), []byte(values[1]), 1)
+	return doc
+}
+
+func kdbxTestField(version uint16, id byte, data []byte) []byte {
+	var b []byte
+	b = append(b, id)
+	if version == kdbxVersion3 {
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(data)))
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	}
+	return append(b, data...)
+}
+
+func kdbxTestVariant(t byte, name string, value []byte) []byte {
+	b := []byte{t}
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(name)))
+	b = append(b, name...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+func kdbxTestRandom(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+// writeTestKDBX creates a kdbx file like KeePass would do
+func writeTestKDBX(t *testing.T, filename string, o kdbxTestOptions) {
+	masterSeed := kdbxTestRandom(32)
+	kdfSeed := kdbxTestRandom(32)
+	streamKey := kdbxTestRandom(32)
+	iv := kdbxTestRandom(16)
+	if o.cipher == kdbxCipherChaCha20 {
+		iv = iv[:12]
+	}
+	cipherID, _ := hex.DecodeString(o.cipher)
+	compression := binary.LittleEndian.AppendUint32(nil, 1)
+
+	h := kdbxHeader{major: o.version, fields: map[byte][]byte{}, kdf: map[string]any{}}
+	var header []byte
+	header = binary.LittleEndian.AppendUint32(header, kdbxSignature1)
+	header = binary.LittleEndian.AppendUint32(header, kdbxSignature2)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, o.version)
+	header = append(header, kdbxTestField(o.version, kdbxHeaderCipherID, cipherID)...)
+	header = append(header, kdbxTestField(o.version, kdbxHeaderCompressionFlags, compression)...)
+	header = append(header, kdbxTestField(o.version, kdbxHeaderMasterSeed, masterSeed)...)
+	header = append(header, kdbxTestField(o.version, kdbxHeaderEncryptionIV, iv)...)
+	startBytes := kdbxTestRandom(32)
+	if o.version == kdbxVersion3 {
+		rounds := binary.LittleEndian.AppendUint64(nil, 100)
+		h.fields[kdbxHeaderTransformSeed] = kdfSeed
+		h.fields[kdbxHeaderTransformRounds] = rounds
+		header = append(header, kdbxTestField(o.version, kdbxHeaderTransformSeed, kdfSeed)...)
+		header = append(header, kdbxTestField(o.version, kdbxHeaderTransformRounds, rounds)...)
+		header = append(header, kdbxTestField(o.version, kdbxHeaderProtectedStreamKey, streamKey)...)
+		header = append(header, kdbxTestField(o.version, kdbxHeaderStreamStartBytes, startBytes)...)
+		header = append(header, kdbxTestField(o.version, kdbxHeaderInnerRandomStreamID, binary.LittleEndian.AppendUint32(nil, o.stream))...)
+	} else {
+		kdfID, _ := hex.DecodeString(o.kdf)
+		vd := []byte{0, 1}
+		vd = append(vd, kdbxTestVariant(0x42, "$UUID", kdfID)...)
+		vd = append(vd, kdbxTestVariant(0x42, "S", kdfSeed)...)
+		h.kdf["$UUID"] = kdfID
+		h.kdf["S"] = kdfSeed
+		if o.kdf == kdbxKdfAES4 {
+			vd = append(vd, kdbxTestVariant(0x05, "R", binary.LittleEndian.AppendUint64(nil, 100))...)
+			h.kdf["R"] = uint64(100)
+		} else {
+			vd = append(vd, kdbxTestVariant(0x05, "I", binary.LittleEndian.AppendUint64(nil, 2))...)
+			vd = append(vd, kdbxTestVariant(0x05, "M", binary.LittleEndian.AppendUint64(nil, 64*1024))...)
+			vd = append(vd, kdbxTestVariant(0x04, "P", binary.LittleEndian.AppendUint32(nil, 2))...)
+			vd = append(vd, kdbxTestVariant(0x04, "V", binary.LittleEndian.AppendUint32(nil, 0x13))...)
+			h.kdf["I"] = uint64(2)
+			h.kdf["M"] = uint64(64 * 1024)
+			h.kdf["P"] = uint64(2)
+		}
+		vd = append(vd, 0)
+		header = append(header, kdbxTestField(o.version, kdbxHeaderKdfParameters, vd)...)
+	}
+	header = append(header, kdbxTestField(o.version, kdbxHeaderEnd, []byte{'\r', '\n', '\r', '\n'})...)
+
+	compositeKey, err := keePassCompositeKey(o.password, o.keyFile)
+	require.NoError(t, err)
+	transformed, err := h.transformKey(compositeKey)
+	require.NoError(t, err)
+	masterKey := sha256.Sum256(append(append([]byte{}, masterSeed...), transformed...))
+
+	stream, err := newInnerStream(o.stream, streamKey)
+	require.NoError(t, err)
+	doc := kdbxTestDocument(stream)
+	var plain []byte
+	if o.version == kdbxVersion4 {
+		plain = append(plain, kdbxTestField(o.version, kdbxInnerStreamID, binary.LittleEndian.AppendUint32(nil, o.stream))...)
+		plain = append(plain, kdbxTestField(o.version, kdbxInnerStreamKey, streamKey)...)
+		plain = append(plain, kdbxTestField(o.version, kdbxInnerEnd, nil)...)
+	}
+	plain = append(plain, doc...)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write(plain)
+	_ = w.Close()
+	payload := gz.Bytes()
+	if o.version == kdbxVersion3 {
+		sum := sha256.Sum256(payload)
+		blocks := binary.LittleEndian.AppendUint32(nil, 0)
+		blocks = append(blocks, sum[:]...)
+		blocks = binary.LittleEndian.AppendUint32(blocks, uint32(len(payload)))
+		blocks = append(blocks, payload...)
+		blocks = binary.LittleEndian.AppendUint32(blocks, 1)
+		blocks = append(blocks, make([]byte, 36)...)
+		payload = append(append([]byte{}, startBytes...), blocks...)
+	}
+	var encrypted []byte
+	if o.cipher == kdbxCipherAES256 {
+		block, e := aes.NewCipher(masterKey[:])
+		require.NoError(t, e)
+		pad := aes.BlockSize - len(payload)%aes.BlockSize
+		payload = append(payload, bytes.Repeat([]byte{byte(pad)}, pad)...)
+		encrypted = make([]byte, len(payload))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, payload)
+	} else {
+		c, e := chacha20.NewUnauthenticatedCipher(masterKey[:], iv)
+		require.NoError(t, e)
+		encrypted = make([]byte, len(payload))
+		c.XORKeyStream(encrypted, payload)
+	}
+	out := append([]byte{}, header...)
+	if o.version == kdbxVersion4 {
+		headerHash := sha256.Sum256(header)
+		hk := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformed...), 1))
+		out = append(out, headerHash[:]...)
+		out = append(out, kdbxHMAC(hk[:], ^uint64(0), header)...)
+		out = append(out, kdbxHMAC(hk[:], 0, encrypted)...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(encrypted)))
+		out = append(out, encrypted...)
+		out = append(out, kdbxHMAC(hk[:], 1, nil)...)
+		out = binary.LittleEndian.AppendUint32(out, 0)
+	} else {
+		out = append(out, encrypted...)
+	}
+	err = os.WriteFile(filename, out, 0600)
+	require.NoError(t, err)
+}
+
+func TestKeePass(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	keyFile := path.Join(test.TestData, "test_keepass.keyx")
+	err = os.WriteFile(keyFile, []byte(`<?xml version="1.0" encoding="utf-8"?>
+<KeyFile>
+	<Meta><Version>2.0</Version></Meta>
+	<Key><Data Hash="00000000">
+		A7007945 D07D54BA 28DF6434 1B4500FC
+		9750DFB1 D36ADA2D 9C32DC19 4C7AB01B
+	</Data></Key>
+</KeyFile>
+`), 0600)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opts kdbxTestOptions
+	}{
+		{"KDBX3 AES Salsa20", kdbxTestOptions{version: kdbxVersion3, cipher: kdbxCipherAES256, stream: kdbxInnerStreamSalsa, password: "kdbx3"}},
+		{"KDBX4 AES-KDF ChaCha20", kdbxTestOptions{version: kdbxVersion4, cipher: kdbxCipherChaCha20, kdf: kdbxKdfAES4, stream: kdbxInnerStreamChaCha, password: "kdbx4"}},
+		{"KDBX4 Argon2d AES", kdbxTestOptions{version: kdbxVersion4, cipher: kdbxCipherAES256, kdf: kdbxKdfArgon2d, stream: kdbxInnerStreamChaCha, password: "kdbx4"}},
+		{"KDBX4 Argon2id keyfile", kdbxTestOptions{version: kdbxVersion4, cipher: kdbxCipherAES256, kdf: kdbxKdfArgon2id, stream: kdbxInnerStreamChaCha, password: "kdbx4", keyFile: keyFile}},
+	}
+	for i, tc := range tests {
+		filename := path.Join(test.TestData, "test_keepass_"+string(rune('a'+i))+".kdbx")
+		writeTestKDBX(t, filename, tc.opts)
+		t.Run(tc.name, func(t *testing.T) {
+			records, e := ImportKeePassFile(filename, tc.opts.password, tc.opts.keyFile, ImportOptions{})
+			require.NoErrorf(t, e, "Import failed:%s", e)
+			require.Len(t, records, 2, "recycle bin should be skipped")
+			assert.Equal(t, "Mail", records[0].System)
+			assert.Equal(t, "mailuser", records[0].Account)
+			assert.Equal(t, "mail:secret", records[0].Password)
+			assert.Equal(t, "Servers", records[1].Folder)
+			assert.Equal(t, "root-pass", records[1].Password)
+			assert.Equal(t, "primary database", records[1].Notes)
+			_, e = ImportKeePassFile(filename, "wrong", tc.opts.keyFile, ImportOptions{})
+			assert.Error(t, e, "wrong password should fail")
+		})
+	}
+	// databases written by KeePass, see test/keepass/README.md
+	fixtures := []struct {
+		name    string
+		file    string
+		keyFile string
+		entries int
+	}{
+		{"KeePass KDBX3.1 AES-KDF", "kdbx31_aes.kdbx", "", 3},
+		{"KeePass KDBX4 Argon2d ChaCha20", "kdbx4_argon2d_chacha20.kdbx", "", 4},
+		{"KeePass KDBX4 Argon2d keyfile", "kdbx4_argon2d_aes_keyfile.kdbx", "kdbx4_argon2d_aes_keyfile.key", 3},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			filename := path.Join(test.TestDir, "keepass", f.file)
+			keyFile := ""
+			if f.keyFile != "" {
+				keyFile = path.Join(test.TestDir, "keepass", f.keyFile)
+			}
+			records, e := ImportKeePassFile(filename, "abcdefg12345678", keyFile, ImportOptions{})
+			require.NoErrorf(t, e, "Import failed:%s", e)
+			require.Len(t, records, f.entries)
+			assert.Equal(t, PasswordRecord{System: "Sample Entry", Account: "User Name", Password: "Password",
+				Title: "Sample Entry", URL: "http://keepass.info/", Folder: "General", Notes: "Notes"}, records[0])
+			assert.Equal(t, "test", records[1].Account)
+			assert.Equal(t, "AnotherPassword", records[1].Password)
+			assert.Equal(t, "General", records[1].Folder)
+			assert.Equal(t, "File test", records[2].System)
+			assert.Equal(t, "Windows", records[2].Folder)
+			records, e = ImportKeePassFile(filename, "abcdefg12345678", keyFile, ImportOptions{SystemFrom: SystemFromURL, FolderPrefix: true})
+			require.NoError(t, e)
+			assert.Equal(t, "General/keepass.info", records[0].System)
+			_, e = ImportKeePassFile(filename, "wrong", keyFile, ImportOptions{})
+			assert.Error(t, e, "wrong password should fail")
+		})
+	}
+	t.Run("KeePass XML export", func(t *testing.T) {
+		records := []PasswordRecord{
+			{System: "db01", Account: "root", Password: "pw<1>", Folder: "Servers/DB"},
+			{System: "mail", Account: "me", Password: "pw2", Title: "Mail"},
+		}
+		content, e := ExportKeePassXML(records)
+		require.NoError(t, e)
+		assert.Contains(t, content, "<Name>DB</Name>")
+		imported, e := ImportKeePassXML(content, ImportOptions{})
+		require.NoError(t, e)
+		require.Len(t, imported, 2)
+		assert.Equal(t, "Mail", imported[0].System)
+		assert.Equal(t, "Servers/DB", imported[1].Folder)
+		assert.Equal(t, "pw<1>", imported[1].Password)
+	})
+	t.Run("Invalid file", func(t *testing.T) {
+		filename := path.Join(test.TestData, "test_keepass_invalid.kdbx")
+		_ = os.WriteFile(filename, []byte("no keepass"), 0600)
+		_, e := ImportKeePassFile(filename, "", "", ImportOptions{})
+		assert.ErrorContains(t, e, "not a keepass")
+	})
+	t.Run("KDF limits", func(t *testing.T) {
+		key := make([]byte, 32)
+		argonID, _ := hex.DecodeString(kdbxKdfArgon2d)
+		h := kdbxHeader{major: kdbxVersion4, kdf: map[string]any{"$UUID": argonID, "S": kdbxTestRandom(32),
+			"I": uint64(kdbxMaxArgon2Iterations + 1), "M": uint64(64 * 1024), "P": uint64(1)}}
+		_, e := h.transformKey(key)
+		assert.ErrorContains(t, e, "exceed limits", "too many iterations should fail")
+		h.kdf["I"] = uint64(1)
+		h.kdf["M"] = uint64(kdbxMaxArgon2Memory+1) * 1024
+		_, e = h.transformKey(key)
+		assert.ErrorContains(t, e, "exceed limits", "too much memory should fail")
+		aesID, _ := hex.DecodeString(kdbxKdfAES4)
+		h.kdf = map[string]any{"$UUID": aesID, "S": kdbxTestRandom(32), "R": uint64(kdbxMaxAESRounds + 1)}
+		_, e = h.transformKey(key)
+		assert.ErrorContains(t, e, "exceed limit", "too many aes rounds should fail")
+	})
+}
@@ -26,11 +26,20 @@ const aliasPrefix = "alias/"
 
 // ConnectToKMS Establish a connection to AWS KMS
 func ConnectToKMS() (svc *kms.Client) {
-	log.Debugf("Connect to KMS")
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	svc, err := ConnectToKMSContext(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
+	return svc
+}
+
+// ConnectToKMSContext Establish a connection to AWS KMS, the context bounds loading the AWS configuration
+func ConnectToKMSContext(ctx context.Context) (svc *kms.Client, err error) {
+	log.Debugf("Connect to KMS")
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load AWS config: %v", err)
+	}
 	ep := common.GetStringEnv("KMS_ENDPOINT", "")
 	if ep != "" {
 		KmsEndpoint = ep
@@ -41,14 +50,14 @@ func ConnectToKMS() (svc *kms.Client) {
 			o.BaseEndpoint = aws.String(KmsEndpoint)
 		}
 	})
-	return svc
+	return svc, nil
 }
 
 func checkOperationError(err error) error {
 	var oe *smithy.OperationError
 	e := err
 	if errors.As(err, &oe) {
-		e = fmt.Errorf("failed to call service: %s, operation: %s, error: %v", oe.Service(), oe.Operation(), oe.Unwrap())
+		e = fmt.Errorf("failed to call service: %s, operation: %s, error: %w", oe.Service(), oe.Operation(), oe.Unwrap())
 	}
 	log.Debugf("OperationError:%v", e)
 	return e
@@ -56,11 +65,18 @@ func checkOperationError(err error) error {
 
 // ListKMSKeys List all KMS keys
 func ListKMSKeys(svc *kms.Client) ([]types.KeyListEntry, error) {
+	return ListKMSKeysContext(context.Background(), svc)
+}
+
+// ListKMSKeysContext List all KMS keys
+func ListKMSKeysContext(ctx context.Context, svc *kms.Client) ([]types.KeyListEntry, error) {
 	log.Debugf("List KMS Keys")
 	if svc == nil {
 		return nil, errors.New("KMS service is nil")
 	}
-	output, err := svc.ListKeys(context.TODO(), &kms.ListKeysInput{})
+	output, err := withRetry(ctx, "ListKeys", func(ctx context.Context) (*kms.ListKeysOutput, error) {
+		return svc.ListKeys(ctx, &kms.ListKeysInput{}, kmsSingleAttempt)
+	})
 	if err != nil {
 		e := checkOperationError(err)
 		return nil, e
@@ -85,6 +101,11 @@ func GetKMSKeyIDs(metadata *types.KeyMetadata) (keyID string, keyARN string) {
 
 // DescribeKMSKey Describe a KMS key
 func DescribeKMSKey(svc *kms.Client, keyID string) (*kms.DescribeKeyOutput, error) {
+	return DescribeKMSKeyContext(context.Background(), svc, keyID)
+}
+
+// DescribeKMSKeyContext Describe a KMS key
+func DescribeKMSKeyContext(ctx context.Context, svc *kms.Client, keyID string) (*kms.DescribeKeyOutput, error) {
 	if svc == nil {
 		return nil, errors.New("KMS service is nil")
 	}
@@ -92,8 +113,10 @@ func DescribeKMSKey(svc *kms.Client, keyID string) (*kms.DescribeKeyOutput, erro
 		return nil, errors.New("keyID is empty")
 	}
 	log.Debugf("Describe KMS Key:%s", keyID)
-	output, err := svc.DescribeKey(context.TODO(), &kms.DescribeKeyInput{
-		KeyId: aws.String(keyID),
+	output, err := withRetry(ctx, "DescribeKey", func(ctx context.Context) (*kms.DescribeKeyOutput, error) {
+		return svc.DescribeKey(ctx, &kms.DescribeKeyInput{
+			KeyId: aws.String(keyID),
+		}, kmsSingleAttempt)
 	})
 	if err != nil {
 		e := checkOperationError(err)
@@ -105,6 +128,11 @@ func DescribeKMSKey(svc *kms.Client, keyID string) (*kms.DescribeKeyOutput, erro
 
 // ListKMSAliases List all KMS aliases
 func ListKMSAliases(svc *kms.Client, keyID string) ([]types.AliasListEntry, error) {
+	return ListKMSAliasesContext(context.Background(), svc, keyID)
+}
+
+// ListKMSAliasesContext List all KMS aliases
+func ListKMSAliasesContext(ctx context.Context, svc *kms.Client, keyID string) ([]types.AliasListEntry, error) {
 	if svc == nil {
 		return nil, errors.New("KMS service is nil")
 	}
@@ -115,7 +143,9 @@ func ListKMSAliases(svc *kms.Client, keyID string) ([]types.AliasListEntry, erro
 		}
 	}
 	log.Debugf("List KMS Key Aliases for %s", keyID)
-	output, err := svc.ListAliases(context.TODO(), ip)
+	output, err := withRetry(ctx, "ListAliases", func(ctx context.Context) (*kms.ListAliasesOutput, error) {
+		return svc.ListAliases(ctx, ip, kmsSingleAttempt)
+	})
 	if err != nil {
 		e := checkOperationError(err)
 		return nil, e
@@ -130,6 +160,11 @@ func ListKMSAliases(svc *kms.Client, keyID string) ([]types.AliasListEntry, erro
 
 // CreateKMSAlias Create a KMS alias
 func CreateKMSAlias(svc *kms.Client, aliasName string, targetKeyID string) (*kms.CreateAliasOutput, error) {
+	return CreateKMSAliasContext(context.Background(), svc, aliasName, targetKeyID)
+}
+
+// CreateKMSAliasContext Create a KMS alias
+func CreateKMSAliasContext(ctx context.Context, svc *kms.Client, aliasName string, targetKeyID string) (*kms.CreateAliasOutput, error) {
 	if svc == nil {
 		return nil, errors.New("KMS service is nil")
 	}
@@ -143,10 +178,11 @@ func CreateKMSAlias(svc *kms.Client, aliasName string, targetKeyID string) (*kms
 	if !strings.HasPrefix(aliasName, aliasPrefix) {
 		aliasName = aliasPrefix + aliasName
 	}
-	output, err := svc.CreateAlias(context.TODO(), &kms.CreateAliasInput{
+	// not repeated, a lost response of a successful call would fail the retry
+	output, err := svc.CreateAlias(ctx, &kms.CreateAliasInput{
 		AliasName:   aws.String(aliasName),
 		TargetKeyId: aws.String(targetKeyID),
-	})
+	}, kmsSingleAttempt)
 	if err != nil {
 		e := checkOperationError(err)
 		return nil, e
@@ -157,6 +193,11 @@ func CreateKMSAlias(svc *kms.Client, aliasName string, targetKeyID string) (*kms
 
 // DeleteKMSAlias Delete a KMS alias
 func DeleteKMSAlias(svc *kms.Client, aliasName string) (*kms.DeleteAliasOutput, error) {
+	return DeleteKMSAliasContext(context.Background(), svc, aliasName)
+}
+
+// DeleteKMSAliasContext Delete a KMS alias
+func DeleteKMSAliasContext(ctx context.Context, svc *kms.Client, aliasName string) (*kms.DeleteAliasOutput, error) {
 	if svc == nil {
 		return nil, errors.New("KMS service is nil")
 	}
@@ -167,9 +208,9 @@ func DeleteKMSAlias(svc *kms.Client, aliasName string) (*kms.DeleteAliasOutput,
 		aliasName = aliasPrefix + aliasName
 	}
 	log.Debugf("Delete KMS Alias:%s", aliasName)
-	output, err := svc.DeleteAlias(context.TODO(), &kms.DeleteAliasInput{
+	output, err := svc.DeleteAlias(ctx, &kms.DeleteAliasInput{
 		AliasName: aws.String(aliasName),
-	})
+	}, kmsSingleAttempt)
 	if err != nil {
 		e := checkOperationError(err)
 		return nil, e
@@ -193,6 +234,11 @@ func GetKMSAliasIDs(entry *types.AliasListEntry) (targetKeyID string, aliasName
 
 // DescribeKMSAlias Search and Describe a KMS alias
 func DescribeKMSAlias(svc *kms.Client, aliasName string) (*types.AliasListEntry, error) {
+	return DescribeKMSAliasContext(context.Background(), svc, aliasName)
+}
+
+// DescribeKMSAliasContext Search and Describe a KMS alias
+func DescribeKMSAliasContext(ctx context.Context, svc *kms.Client, aliasName string) (*types.AliasListEntry, error) {
 	if svc == nil {
 		return nil, errors.New("KMS service is nil")
 	}
@@ -203,7 +249,9 @@ func DescribeKMSAlias(svc *kms.Client, aliasName string) (*types.AliasListEntry,
 		aliasName = aliasPrefix + aliasName
 	}
 	log.Debugf("Describe KMS Alias:%s", aliasName)
-	output, err := svc.ListAliases(context.TODO(), &kms.ListAliasesInput{})
+	output, err := withRetry(ctx, "ListAliases", func(ctx context.Context) (*kms.ListAliasesOutput, error) {
+		return svc.ListAliases(ctx, &kms.ListAliasesInput{}, kmsSingleAttempt)
+	})
 	if err != nil {
 		e := checkOperationError(err)
 		return nil, e
@@ -224,6 +272,11 @@ func DescribeKMSAlias(svc *kms.Client, aliasName string) (*types.AliasListEntry,
 
 // GenKMSKey Create a new KMS key
 func GenKMSKey(svc *kms.Client, keyspec string, description string, tags map[string]string) (*kms.CreateKeyOutput, error) {
+	return GenKMSKeyContext(context.Background(), svc, keyspec, description, tags)
+}
+
+// GenKMSKeyContext Create a new KMS key
+func GenKMSKeyContext(ctx context.Context, svc *kms.Client, keyspec string, description string, tags map[string]string) (*kms.CreateKeyOutput, error) {
 	log.Debugf("Create KMS Key")
 	if svc == nil {
 		return nil, errors.New("KMS service is nil")
@@ -254,10 +307,8 @@ func GenKMSKey(svc *kms.Client, keyspec string, description string, tags map[str
 		}
 	}
 
-	keyOutput, err := svc.CreateKey(
-		context.TODO(),
-		input,
-	)
+	// not repeated, a retry could create a second key
+	keyOutput, err := svc.CreateKey(ctx, input, kmsSingleAttempt)
 	if err != nil {
 		e := checkOperationError(err)
 		return nil, e
@@ -268,6 +319,11 @@ func GenKMSKey(svc *kms.Client, keyspec string, description string, tags map[str
 
 // KMSEncryptString Encrypt a string using the KMS key
 func KMSEncryptString(svc *kms.Client, keyID string, plaintext string) (string, error) {
+	return KMSEncryptStringContext(context.Background(), svc, keyID, plaintext)
+}
+
+// KMSEncryptStringContext Encrypt a string using the KMS key
+func KMSEncryptStringContext(ctx context.Context, svc *kms.Client, keyID string, plaintext string) (string, error) {
 	if svc == nil {
 		return "", errors.New("KMS service is nil")
 	}
@@ -275,13 +331,12 @@ func KMSEncryptString(svc *kms.Client, keyID string, plaintext string) (string,
 		return "", errors.New("keyID is empty")
 	}
 	log.Debugf("Encrypt with KMS key:%s", keyID)
-	output, err := svc.Encrypt(
-		context.TODO(),
-		&kms.EncryptInput{
+	output, err := withRetry(ctx, "Encrypt", func(ctx context.Context) (*kms.EncryptOutput, error) {
+		return svc.Encrypt(ctx, &kms.EncryptInput{
 			KeyId:     aws.String(keyID),
 			Plaintext: []byte(plaintext),
-		},
-	)
+		}, kmsSingleAttempt)
+	})
 	if err != nil {
 		e := checkOperationError(err)
 		return "", e
@@ -292,6 +347,11 @@ func KMSEncryptString(svc *kms.Client, keyID string, plaintext string) (string,
 
 // KMSDecryptString Decrypt a string using the KMS key
 func KMSDecryptString(svc *kms.Client, keyID string, ciphertext string) (string, error) {
+	return KMSDecryptStringContext(context.Background(), svc, keyID, ciphertext)
+}
+
+// KMSDecryptStringContext Decrypt a string using the KMS key
+func KMSDecryptStringContext(ctx context.Context, svc *kms.Client, keyID string, ciphertext string) (string, error) {
 	if svc == nil {
 		return "", errors.New("KMS service is nil")
 	}
@@ -302,13 +362,12 @@ func KMSDecryptString(svc *kms.Client, keyID string, ciphertext string) (string,
 		return "", errors.New("ciphertext is empty")
 	}
 	log.Debugf("Decrypt with KMS key:%s", keyID)
-	output, err := svc.Decrypt(
-		context.TODO(),
-		&kms.DecryptInput{
+	output, err := withRetry(ctx, "Decrypt", func(ctx context.Context) (*kms.DecryptOutput, error) {
+		return svc.Decrypt(ctx, &kms.DecryptInput{
 			KeyId:          aws.String(keyID),
 			CiphertextBlob: []byte(ciphertext),
-		},
-	)
+		}, kmsSingleAttempt)
+	})
 	if err != nil {
 		e := checkOperationError(err)
 		return "", e
@@ -319,6 +378,11 @@ func KMSDecryptString(svc *kms.Client, keyID string, ciphertext string) (string,
 
 // KMSSignString signs a string using AWS KMS
 func KMSSignString(svc *kms.Client, keyID string, plaintext string) (string, error) {
+	return KMSSignStringContext(context.Background(), svc, keyID, plaintext)
+}
+
+// KMSSignStringContext signs a string using AWS KMS
+func KMSSignStringContext(ctx context.Context, svc *kms.Client, keyID string, plaintext string) (string, error) {
 	if svc == nil {
 		return "", errors.New("KMS service is nil")
 	}
@@ -332,7 +396,9 @@ func KMSSignString(svc *kms.Client, keyID string, plaintext string) (string, err
 		MessageType:      types.MessageTypeRaw,
 		SigningAlgorithm: types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
 	}
-	output, err := svc.Sign(context.TODO(), input)
+	output, err := withRetry(ctx, "Sign", func(ctx context.Context) (*kms.SignOutput, error) {
+		return svc.Sign(ctx, input, kmsSingleAttempt)
+	})
 	if err != nil {
 		return "", checkOperationError(err)
 	}
@@ -341,6 +407,11 @@ func KMSSignString(svc *kms.Client, keyID string, plaintext string) (string, err
 
 // KMSVerifyString verifies a signature using AWS KMS
 func KMSVerifyString(svc *kms.Client, keyID string, plaintext string, signature string) (bool, error) {
+	return KMSVerifyStringContext(context.Background(), svc, keyID, plaintext, signature)
+}
+
+// KMSVerifyStringContext verifies a signature using AWS KMS
+func KMSVerifyStringContext(ctx context.Context, svc *kms.Client, keyID string, plaintext string, signature string) (bool, error) {
 	if svc == nil {
 		return false, errors.New("KMS service is nil")
 	}
@@ -359,7 +430,9 @@ func KMSVerifyString(svc *kms.Client, keyID string, plaintext string, signature
 		Signature:        sigBytes,
 		SigningAlgorithm: types.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
 	}
-	output, err := svc.Verify(context.TODO(), input)
+	output, err := withRetry(ctx, "Verify", func(ctx context.Context) (*kms.VerifyOutput, error) {
+		return svc.Verify(ctx, input, kmsSingleAttempt)
+	})
 	if err != nil {
 		return false, checkOperationError(err)
 	}
@@ -368,16 +441,33 @@ func KMSVerifyString(svc *kms.Client, keyID string, plaintext string, signature
 
 // KMSEncryptFile Encrypt a file using the KMS key
 func KMSEncryptFile(plainFile string, targetFile string, keyID string, sessionPassFile string) (err error) {
-	const rb = 16
+	return KMSEncryptFileContext(context.Background(), plainFile, targetFile, keyID, sessionPassFile)
+}
+
+// KMSEncryptFileContext Encrypt a file using the KMS key
+func KMSEncryptFileContext(ctx context.Context, plainFile string, targetFile string, keyID string, sessionPassFile string) (err error) {
 	log.Debugf("Encrypt %s with KMS key %s in OpenSSL compatible format", plainFile, keyID)
 	if keyID == "" || plainFile == "" || targetFile == "" {
 		err = fmt.Errorf("keyID, plainFile or targetFile is empty")
 		log.Debug(err)
 		return
 	}
-	svc := ConnectToKMS()
-	if svc == nil {
-		err = fmt.Errorf("cannot connect to KMS")
+	plainData, err := common.ReadFileToString(plainFile)
+	if err != nil {
+		log.Debugf("Cannot read plaintext file %s:%s", plainFile, err)
+		return
+	}
+	return kmsEncryptContext(ctx, []byte(plainData), targetFile, keyID, sessionPassFile)
+}
+
+// kmsEncryptContext encrypts plain with a session key protected by the KMS key to targetFile
+func kmsEncryptContext(ctx context.Context, plain []byte, targetFile string, keyID string, sessionPassFile string) (err error) {
+	const rb = 16
+	if keyID == "" || targetFile == "" {
+		return fmt.Errorf("keyID or targetFile is empty")
+	}
+	svc, err := ConnectToKMSContext(ctx)
+	if err != nil {
 		log.Debug(err)
 		return
 	}
@@ -388,7 +478,7 @@ func KMSEncryptFile(plainFile string, targetFile string, keyID string, sessionPa
 		return
 	}
 	sessionKey := base64.StdEncoding.EncodeToString(random)
-	crypted, err := KMSEncryptString(svc, keyID, sessionKey)
+	crypted, err := KMSEncryptStringContext(ctx, svc, keyID, sessionKey)
 	if err != nil {
 		log.Errorf("Encrypting Keyfile failed: %v", err)
 	}
@@ -400,17 +490,11 @@ func KMSEncryptFile(plainFile string, targetFile string, keyID string, sessionPa
 		}
 	}
 
-	plainData, err := common.ReadFileToString(plainFile)
-	if err != nil {
-		log.Debugf("Cannot read plaintext file %s:%s", plainFile, err)
-		return
-	}
-
 	o := openssl.New()
 	// openssl enc -e -aes-256-cbc -md sha246 -base64 -in $SOURCE -out $TARGET -pass pass:$PASSPHRASE
-	encrypted, err := o.EncryptBytes(sessionKey, []byte(plainData), SSLDigest)
+	encrypted, err := o.EncryptBytes(sessionKey, plain, SSLDigest)
 	if err != nil {
-		log.Errorf("cannot encrypt plaintext file %s:%s", plainFile, err)
+		log.Errorf("cannot encrypt plaintext for %s:%s", targetFile, err)
 		return
 	}
 	// write crypted output file
@@ -424,22 +508,43 @@ func KMSEncryptFile(plainFile string, targetFile string, keyID string, sessionPa
 
 // KMSDecryptFile Decrypt a file using the KMS key
 func KMSDecryptFile(cryptedFile string, keyID string, sessionPassFile string) (content string, err error) {
+	return KMSDecryptFileContext(context.Background(), cryptedFile, keyID, sessionPassFile)
+}
+
+// KMSDecryptFileContext Decrypt a file using the KMS key
+func KMSDecryptFileContext(ctx context.Context, cryptedFile string, keyID string, sessionPassFile string) (content string, err error) {
 	if cryptedFile == "" || sessionPassFile == "" || keyID == "" {
 		err = fmt.Errorf("keyID, crypted filename or sessionpassfilename is empty")
 		log.Debug(err)
 		return
 	}
 	log.Debugf("decrypt %s with KMS key %s", cryptedFile, keyID)
-	svc := ConnectToKMS()
-	if svc == nil {
-		err = fmt.Errorf("cannot connect to KMS")
-		log.Debug(err)
+	cryptedData, err := common.ReadFileToString(cryptedFile)
+	if err != nil {
+		log.Debugf("cannot Read file '%s': %s", cryptedFile, err)
 		return
 	}
+	return kmsDecryptDataContext(ctx, cryptedData, keyID, sessionPassFile)
+}
 
-	cryptedData, err := common.ReadFileToString(cryptedFile)
+// kmsDecryptDataContext decrypts openssl compatible data with the session key decrypted by KMS
+func kmsDecryptDataContext(ctx context.Context, cryptedData string, keyID string, sessionPassFile string) (content string, err error) {
+	decoded, err := kmsDecryptBytesContext(ctx, []byte(cryptedData), keyID, sessionPassFile)
+	content = string(decoded)
+	wipeBytes(decoded)
+	return
+}
+
+// kmsDecryptBytesContext is kmsDecryptDataContext returning the plain data in a buffer the caller can wipe
+func kmsDecryptBytesContext(ctx context.Context, cryptedData []byte, keyID string, sessionPassFile string) (content []byte, err error) {
+	if sessionPassFile == "" || keyID == "" {
+		err = fmt.Errorf("keyID or sessionpassfilename is empty")
+		log.Debug(err)
+		return
+	}
+	svc, err := ConnectToKMSContext(ctx)
 	if err != nil {
-		log.Debugf("cannot Read file '%s': %s", cryptedFile, err)
+		log.Debug(err)
 		return
 	}
 	encSessionKey := ""
@@ -448,7 +553,7 @@ func KMSDecryptFile(cryptedFile string, keyID string, sessionPassFile string) (c
 		log.Debugf("cannot Read file '%s': %s", sessionPassFile, err)
 		return
 	}
-	sessionKey, err := KMSDecryptString(svc, keyID, encSessionKey)
+	sessionKey, err := KMSDecryptStringContext(ctx, svc, keyID, encSessionKey)
 	if err != nil {
 		log.Debugf("decode session key failed:%s", err)
 		return
@@ -458,12 +563,11 @@ func KMSDecryptFile(cryptedFile string, keyID string, sessionPassFile string) (c
 
 	// OPENSSL enc -d -aes-256-cbc -md sha256 -base64 -in $SOURCE -pass pass:$SESSIONKEY
 	o := openssl.New()
-	decoded, err := o.DecryptBytes(sessionKey, []byte(cryptedData), SSLDigest)
+	content, err = o.DecryptBytes(sessionKey, cryptedData, SSLDigest)
 	if err != nil {
-		log.Debugf("Cannot decrypt data from '%s': %s", cryptedFile, err)
+		log.Debugf("Cannot decrypt data: %s", err)
 		return
 	}
-	content = string(decoded)
 	log.Debug("Decoding successfully")
 	return
 }
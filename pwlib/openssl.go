@@ -3,6 +3,7 @@ package pwlib
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
 
 	"github.com/tommi2day/gomodules/common"
 
@@ -13,13 +14,21 @@ import (
 // PrivateDecryptFileSSL Decrypt a file with private key with openssl API
 func PrivateDecryptFileSSL(cryptedFile string, privateKeyFile string, keyPass string, sessionPassFile string) (content string, err error) {
 	log.Debugf("decrypt %s with private key %s in OpenSSL format", cryptedFile, privateKeyFile)
-	cryptedkey := ""
-
 	data, err := common.ReadFileToString(cryptedFile)
 	if err != nil {
 		log.Debugf("Cannot Read file '%s': %s", cryptedFile, err)
 		return
 	}
+	content, err = privateDecryptSSLData(data, privateKeyFile, keyPass, sessionPassFile)
+	if err != nil {
+		log.Debugf("Cannot decrypt data from '%s': %s", cryptedFile, err)
+	}
+	return
+}
+
+// privateDecryptSSLData decrypts openssl compatible data with the session key decrypted by the private key
+func privateDecryptSSLData(data string, privateKeyFile string, keyPass string, sessionPassFile string) (content string, err error) {
+	cryptedkey := ""
 	if len(sessionPassFile) > 0 {
 		cryptedkey, err = common.ReadFileToString(sessionPassFile)
 		if err != nil {
@@ -27,27 +36,76 @@ func PrivateDecryptFileSSL(cryptedFile string, privateKeyFile string, keyPass st
 			return
 		}
 	}
-	crypted := []byte(data)
 	sessionKey, err := PrivateDecryptString(cryptedkey, privateKeyFile, keyPass)
 	if err != nil {
 		log.Debugf("Cannot decrypt Session Key from '%s': %s", sessionPassFile, err)
 		return
 	}
-	// OPENSSL enc -d -aes-256-cbc -md sha256 -base64 -in $SOURCE -pass pass:$PASSPHRASE
-	o := openssl.New()
-	decoded, err := o.DecryptBytes(sessionKey, crypted, SSLDigest)
+	return decryptSSLData(data, sessionKey)
+}
+
+// privateDecryptSSLBytes is privateDecryptSSLData with the key passphrase and the plain data in buffers
+// the caller can wipe
+func privateDecryptSSLBytes(data []byte, privateKeyFile string, keyPass []byte, sessionPassFile string) (content []byte, err error) {
+	cryptedkey := ""
+	if len(sessionPassFile) > 0 {
+		cryptedkey, err = common.ReadFileToString(sessionPassFile)
+		if err != nil {
+			log.Debugf("Cannot Read file '%s': %s", sessionPassFile, err)
+			return
+		}
+	}
+	keyType, err := GetKeyTypeFromFile(privateKeyFile)
 	if err != nil {
-		log.Debugf("Cannot decrypt data from '%s': %s", cryptedFile, err)
 		return
 	}
+	if keyType != KeyTypeRSA {
+		err = fmt.Errorf("decryption not supported for %s keys", keyType)
+		return
+	}
+	_, privkey, err := getRsaPrivateKey(privateKeyFile, keyPass)
+	if err != nil {
+		log.Debugf("Cannot read keys from '%s': %s", privateKeyFile, err)
+		return
+	}
+	sessionKey, err := rsaDecryptWithKey(cryptedkey, privkey)
+	if err != nil {
+		log.Debugf("Cannot decrypt Session Key from '%s': %s", sessionPassFile, err)
+		return
+	}
+	return decryptSSLBytes(data, sessionKey)
+}
+
+// decryptSSLData decrypts openssl compatible crypted data with the given plain session key
+func decryptSSLData(data string, sessionKey string) (content string, err error) {
+	decoded, err := decryptSSLBytes([]byte(data), sessionKey)
 	content = string(decoded)
+	wipeBytes(decoded)
 	return
 }
 
+// decryptSSLBytes is decryptSSLData returning the plain data in a buffer the caller can wipe
+func decryptSSLBytes(data []byte, sessionKey string) (content []byte, err error) {
+	// OPENSSL enc -d -aes-256-cbc -md sha256 -base64 -in $SOURCE -pass pass:$PASSPHRASE
+	o := openssl.New()
+	return o.DecryptBytes(sessionKey, data, SSLDigest)
+}
+
 // PubEncryptFileSSL encrypts a file with public key with openssl API
 func PubEncryptFileSSL(plainFile string, targetFile string, publicKeyFile string, sessionPassFile string) (err error) {
-	const rb = 16
 	log.Debugf("Encrypt %s with public key %s in OpenSSL format", plainFile, publicKeyFile)
+	//nolint gosec
+	plainData, err := common.ReadFileToString(plainFile)
+	if err != nil {
+		log.Debugf("Cannot read plaintext file %s:%s", plainFile, err)
+		return
+	}
+	return pubEncryptSSL([]byte(plainData), targetFile, publicKeyFile, sessionPassFile)
+}
+
+// pubEncryptSSL encrypts plain with public key in OpenSSL format to targetFile
+func pubEncryptSSL(plain []byte, targetFile string, publicKeyFile string, sessionPassFile string) (err error) {
+	const rb = 16
 	random := make([]byte, rb)
 	_, err = rand.Read(random)
 	if err != nil {
@@ -67,18 +125,11 @@ func PubEncryptFileSSL(plainFile string, targetFile string, publicKeyFile string
 		}
 	}
 
-	//nolint gosec
-	plainData, err := common.ReadFileToString(plainFile)
-	if err != nil {
-		log.Debugf("Cannot read plaintext file %s:%s", plainFile, err)
-		return
-	}
-
 	o := openssl.New()
 	// openssl enc -e -aes-256-cbc -md sha246 -base64 -in $SOURCE -out $TARGET -pass pass:$PASSPHRASE
-	encrypted, err := o.EncryptBytes(sessionKey, []byte(plainData), SSLDigest)
+	encrypted, err := o.EncryptBytes(sessionKey, plain, SSLDigest)
 	if err != nil {
-		log.Errorf("cannot encrypt plaintext file %s:%s", plainFile, err)
+		log.Errorf("cannot encrypt plaintext for %s:%s", targetFile, err)
 		return
 	}
 
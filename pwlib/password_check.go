@@ -54,6 +54,30 @@ func DoPasswordCheck(password string, profile PasswordProfile, cs PasswordCharse
 	return ls && ucs && lcs && ncs && sps && ccs && fcs
 }
 
+// checkPasswordBytes is DoPasswordCheck without logging for a generated password in a buffer.
+// Generated passwords consist of single byte characters
+func checkPasswordBytes(password []byte, profile PasswordProfile, cs PasswordCharset) bool {
+	if len(password) == 0 || len(password) < profile.Length {
+		return false
+	}
+	count := func(chars string) (cnt int) {
+		for _, c := range password {
+			if strings.IndexByte(chars, c) >= 0 {
+				cnt++
+			}
+		}
+		return
+	}
+	if count(cs.AllChars) != len(password) {
+		return false
+	}
+	if profile.FirstIsChar && strings.IndexByte(cs.UpperChar+cs.LowerChar, password[0]) < 0 {
+		return false
+	}
+	return count(cs.UpperChar) >= profile.Upper && count(cs.LowerChar) >= profile.Lower &&
+		count(cs.Digits) >= profile.Digits && count(cs.SpecialChar) >= profile.Special
+}
+
 func logError(name string, err error) {
 	if SilentCheck {
 		return
@@ -2,8 +2,10 @@ package pwlib
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
-	"math/big"
+	"io"
+	"math"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -11,54 +13,104 @@ import (
 // MaxGenpassTrys defines the maximum number of attempts to generate a password meeting the specified constraints.
 var MaxGenpassTrys = 200
 
-// GenerateRandomString generate a randow string with the given length and out of allowed charset
-func GenerateRandomString(length int, allowedChars string) string {
-	letters := allowedChars
-	if len(allowedChars) == 0 {
-		letters = AllChars
-		log.Debug("GenRandom: No allowed chars specified, using all chars")
-	}
-	ret := make([]byte, length)
-	for i := 0; i < length; i++ {
-		num, _ := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
-		ret[i] = letters[num.Int64()]
+// PasswordGenerator generates passwords with uniformly distributed characters
+type PasswordGenerator struct {
+	// Rand is the random source, nil uses crypto/rand. Inject a deterministic reader for reproducible tests only
+	Rand io.Reader
+}
+
+// NewPasswordGenerator creates a generator reading from r, nil uses crypto/rand
+func NewPasswordGenerator(r io.Reader) *PasswordGenerator {
+	return &PasswordGenerator{Rand: r}
+}
+
+func (g *PasswordGenerator) reader() io.Reader {
+	if g == nil || g.Rand == nil {
+		return rand.Reader
 	}
-	log.Debugf("GenRandom candidate: %s", string(ret))
-	return string(ret)
+	return g.Rand
 }
 
-// GenPassword generates a password with the given complexity
-func GenPassword(profile string) (string, error) {
-	var err error
-	pp := DefaultPasswordProfile
-	if profile != "" {
-		pp, err = GetPasswordProfileFromString(profile)
-		if err != nil {
-			return "", err
+// uniqueChars removes duplicate characters, which would be chosen more often otherwise
+func uniqueChars(chars string) []byte {
+	var seen [256]bool
+	var result []byte
+	for i := 0; i < len(chars); i++ {
+		c := chars[i]
+		if !seen[c] {
+			seen[c] = true
+			result = append(result, c)
 		}
 	}
-	pps := PasswordProfileSet{
-		Profile: pp,
+	return result
+}
+
+// RandomString returns a random string of length characters out of allowedChars.
+// Random bytes are mapped by rejection sampling, so each character has the same probability
+func (g *PasswordGenerator) RandomString(length int, allowedChars string) (string, error) {
+	ret, err := g.randomBytes(length, allowedChars)
+	if err != nil {
+		return "", err
 	}
-	return GenPasswordProfile(pps)
+	s := string(ret)
+	wipeBytes(ret)
+	return s, nil
 }
 
-// GenPasswordProfile generates a password based on the given PasswordProfileSet configuration.
-// It ensures the generated password meets the specified constraints and attempts up to MaxGenpassTrys times.
-// Returns the generated password and an error if unable to create a valid password.
-func GenPasswordProfile(pps PasswordProfileSet) (string, error) {
-	log.Debug("GenPassword entered")
-	// allowed chars
-	var ok = false
-	var err error
+// randomBytes is RandomString returning the characters in a buffer the caller has to wipe
+func (g *PasswordGenerator) randomBytes(length int, allowedChars string) ([]byte, error) {
+	if length < 0 {
+		return nil, errors.New("length must not be negative")
+	}
+	letters := uniqueChars(allowedChars)
+	if len(letters) == 0 {
+		letters = uniqueChars(AllChars)
+		log.Debug("GenRandom: No allowed chars specified, using all chars")
+	}
+	n := len(letters)
+	// largest multiple of n below 256, bytes above would prefer the first characters
+	limit := 256 - 256%n
+	ret := make([]byte, 0, length)
+	buf := make([]byte, length+length/4+8)
+	defer wipeBytes(buf)
+	for rounds := 0; len(ret) < length; rounds++ {
+		// a broken source returning only rejected bytes must not loop forever
+		if rounds >= MaxGenpassTrys {
+			wipeBytes(ret)
+			return nil, fmt.Errorf("random source returned no usable data in %d reads", MaxGenpassTrys)
+		}
+		if _, err := io.ReadFull(g.reader(), buf); err != nil {
+			wipeBytes(ret)
+			return nil, fmt.Errorf("cannot read random data: %v", err)
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			ret = append(ret, letters[int(b)%n])
+			if len(ret) == length {
+				break
+			}
+		}
+	}
+	log.Debugf("GenRandom candidate with length %d generated", length)
+	return ret, nil
+}
 
+// Generate creates a password matching the profile set, it attempts up to MaxGenpassTrys times
+func (g *PasswordGenerator) Generate(pps PasswordProfileSet) (newPassword string, err error) {
+	var ok bool
 	pp, cs := pps.Load()
-	newPassword := ""
 	// skip password check logging when used to generate
 	SilentCheck = true
-	// max 50 tries to generate a valid password
+	defer func() {
+		SilentCheck = false
+	}()
 	for c := 0; c < MaxGenpassTrys; c++ {
-		newPassword = GenerateRandomString(pp.Length, cs.AllChars)
+		newPassword, err = g.RandomString(pp.Length, cs.AllChars)
+		if err != nil {
+			return "", err
+		}
 		ok = DoPasswordCheck(newPassword, pp, cs)
 		if ok {
 			break
@@ -66,12 +118,123 @@ func GenPasswordProfile(pps PasswordProfileSet) (string, error) {
 		newPassword = ""
 		log.Debugf("generate retry %d", c)
 	}
-	SilentCheck = false
-
 	if !ok {
-		err = fmt.Errorf("unable to create required Password")
-	} else {
-		log.Debug("Generation succeeded")
+		return "", fmt.Errorf("unable to create required Password")
+	}
+	log.Debug("Generation succeeded")
+	return
+}
+
+// GenerateSecret is Generate returning the password as Secret, the candidates are created and checked
+// in buffers which are wiped
+func (g *PasswordGenerator) GenerateSecret(pps PasswordProfileSet) (*Secret, error) {
+	pp, cs := pps.Load()
+	for c := 0; c < MaxGenpassTrys; c++ {
+		candidate, err := g.randomBytes(pp.Length, cs.AllChars)
+		if err != nil {
+			return nil, err
+		}
+		if checkPasswordBytes(candidate, pp, cs) {
+			log.Debug("Generation succeeded")
+			return NewSecret(candidate), nil
+		}
+		wipeBytes(candidate)
+		log.Debugf("generate retry %d", c)
+	}
+	return nil, fmt.Errorf("unable to create required Password")
+}
+
+// GenerateBatch creates count different passwords matching the profile set
+func (g *PasswordGenerator) GenerateBatch(pps PasswordProfileSet, count int) (passwords []string, err error) {
+	log.Debugf("GenerateBatch for %d passwords", count)
+	seen := make(map[string]bool, count)
+	duplicates := 0
+	for len(passwords) < count {
+		var p string
+		p, err = g.Generate(pps)
+		if err != nil {
+			return nil, err
+		}
+		if seen[p] {
+			duplicates++
+			if duplicates > MaxGenpassTrys {
+				return nil, fmt.Errorf("unable to create %d unique passwords, profile allows too few combinations", count)
+			}
+			continue
+		}
+		seen[p] = true
+		passwords = append(passwords, p)
+	}
+	return
+}
+
+// CharsetEntropy returns the entropy in bits of a random string with length characters out of chars
+func CharsetEntropy(length int, chars string) float64 {
+	n := len(uniqueChars(chars))
+	if n == 0 || length <= 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(n))
+}
+
+// Entropy returns the entropy in bits of passwords generated for the profile set.
+// The class constraints of the profile are not subtracted, so this is an upper bound
+func (pps PasswordProfileSet) Entropy() float64 {
+	pp, cs := pps.Load()
+	return CharsetEntropy(pp.Length, cs.AllChars)
+}
+
+// GenerateRandomString generate a randow string with the given length and out of allowed charset
+func GenerateRandomString(length int, allowedChars string) string {
+	s, err := NewPasswordGenerator(nil).RandomString(length, allowedChars)
+	if err != nil {
+		log.Errorf("GenRandom failed: %v", err)
+	}
+	return s
+}
+
+// GenPassword generates a password with the given complexity
+func GenPassword(profile string) (string, error) {
+	pps, err := profileSetFromString(profile)
+	if err != nil {
+		return "", err
 	}
-	return newPassword, err
+	return GenPasswordProfile(pps)
+}
+
+// GenPasswordSecret is GenPassword returning the password as Secret
+func GenPasswordSecret(profile string) (*Secret, error) {
+	pps, err := profileSetFromString(profile)
+	if err != nil {
+		return nil, err
+	}
+	return GenPasswordProfileSecret(pps)
+}
+
+// profileSetFromString returns the profile set for a profile string, empty uses DefaultPasswordProfile
+func profileSetFromString(profile string) (PasswordProfileSet, error) {
+	var err error
+	pp := DefaultPasswordProfile
+	if profile != "" {
+		pp, err = GetPasswordProfileFromString(profile)
+		if err != nil {
+			return PasswordProfileSet{}, err
+		}
+	}
+	return PasswordProfileSet{Profile: pp}, nil
+}
+
+// GenPasswordProfile generates a password based on the given PasswordProfileSet configuration.
+// It ensures the generated password meets the specified constraints and attempts up to MaxGenpassTrys times.
+// Returns the generated password and an error if unable to create a valid password.
+func GenPasswordProfile(pps PasswordProfileSet) (string, error) {
+	log.Debug("GenPassword entered")
+	return NewPasswordGenerator(nil).Generate(pps)
+}
+
+// GenPasswordProfileSecret is GenPasswordProfile returning the password as Secret. The password is generated
+// into the Secret without an intermediate string
+func GenPasswordProfileSecret(pps PasswordProfileSet) (*Secret, error) {
+	log.Debug("GenPasswordSecret entered")
+	return NewPasswordGenerator(nil).GenerateSecret(pps)
 }
@@ -1,7 +1,12 @@
 package pwlib
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -171,3 +176,115 @@ func TestGenPassword(t *testing.T) {
 		assert.True(t, DoPasswordCheck(password, DefaultPasswordProfile, cs))
 	})
 }
+
+// testRandReader is a deterministic random source for reproducible tests
+type testRandReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func (r *testRandReader) Read(p []byte) (int, error) {
+	for i := range p {
+		if len(r.buf) == 0 {
+			b := binary.LittleEndian.AppendUint64(append([]byte{}, r.seed...), r.counter)
+			sum := sha256.Sum256(b)
+			r.buf = sum[:]
+			r.counter++
+		}
+		p[i] = r.buf[0]
+		r.buf = r.buf[1:]
+	}
+	return len(p), nil
+}
+
+// constantReader returns the same byte forever
+type constantReader byte
+
+func (r constantReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestPasswordGenerator(t *testing.T) {
+	pps := PasswordProfileSet{Profile: DefaultPasswordProfile, SpecialChars: DefaultSpecialChars}
+	t.Run("Reproducible", func(t *testing.T) {
+		p1, err := NewPasswordGenerator(&testRandReader{seed: []byte("seed")}).Generate(pps)
+		require.NoError(t, err)
+		p2, err := NewPasswordGenerator(&testRandReader{seed: []byte("seed")}).Generate(pps)
+		require.NoError(t, err)
+		p3, err := NewPasswordGenerator(&testRandReader{seed: []byte("other")}).Generate(pps)
+		require.NoError(t, err)
+		assert.Equal(t, p1, p2, "same seed should create same password")
+		assert.NotEqual(t, p1, p3)
+	})
+	t.Run("Rejection sampling", func(t *testing.T) {
+		// 3 chars: bytes >= 255 are rejected
+		g := NewPasswordGenerator(bytes.NewReader(append(bytes.Repeat([]byte{255}, 8), 0, 1, 2, 254, 3, 4, 5, 6, 7, 8, 9)))
+		s, err := g.RandomString(3, "abc")
+		require.NoError(t, err)
+		assert.Equal(t, "abc", s)
+		g = NewPasswordGenerator(bytes.NewReader(bytes.Repeat([]byte{255}, 64)))
+		_, err = g.RandomString(3, "abc")
+		assert.Error(t, err, "exhausted reader should fail")
+	})
+	t.Run("Constant source", func(t *testing.T) {
+		// an endless source of rejected bytes must fail instead of spinning forever
+		done := make(chan error, 1)
+		go func() {
+			_, err := NewPasswordGenerator(constantReader(0xFF)).RandomString(16, "abc")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.ErrorContains(t, err, "no usable data")
+		case <-time.After(10 * time.Second):
+			t.Fatal("RandomString did not return")
+		}
+		_, err := NewPasswordGenerator(constantReader(0xFF)).GenerateSecret(pps)
+		assert.Error(t, err)
+	})
+	t.Run("Uniform distribution", func(t *testing.T) {
+		// 62 chars do not divide 256, biased mapping would prefer the first 8 chars
+		chars := UpperChar + LowerChar + Digits
+		g := NewPasswordGenerator(&testRandReader{seed: []byte("uniform")})
+		s, err := g.RandomString(62*1000, chars)
+		require.NoError(t, err)
+		counts := map[rune]int{}
+		for _, c := range s {
+			counts[c]++
+		}
+		require.Len(t, counts, 62)
+		chi := 0.0
+		for _, c := range counts {
+			d := float64(c) - 1000
+			chi += d * d / 1000
+		}
+		// chi square critical value for 61 degrees of freedom at p=0.001 is about 100.9
+		assert.Less(t, chi, 100.9, "distribution should be uniform")
+	})
+	t.Run("Duplicate chars", func(t *testing.T) {
+		assert.Equal(t, []byte("abc"), uniqueChars("abcabca"))
+	})
+	t.Run("Entropy", func(t *testing.T) {
+		assert.InDelta(t, 16*math.Log2(71), pps.Entropy(), 0.0001)
+		assert.InDelta(t, 10*math.Log2(10), CharsetEntropy(10, Digits+Digits), 0.0001)
+		assert.Equal(t, 0.0, CharsetEntropy(0, Digits))
+	})
+	t.Run("Batch", func(t *testing.T) {
+		passwords, err := NewPasswordGenerator(nil).GenerateBatch(pps, 50)
+		require.NoError(t, err)
+		assert.Len(t, passwords, 50)
+		seen := map[string]bool{}
+		for _, p := range passwords {
+			assert.False(t, seen[p], "passwords should be unique")
+			seen[p] = true
+			assert.Len(t, p, DefaultPasswordProfile.Length)
+		}
+		small := PasswordProfileSet{Profile: PasswordProfile{Length: 1, Digits: 1}}
+		_, err = NewPasswordGenerator(nil).GenerateBatch(small, 100)
+		assert.Error(t, err, "more passwords than combinations should fail")
+	})
+}
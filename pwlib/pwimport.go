@@ -0,0 +1,434 @@
+package pwlib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/tommi2day/gomodules/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// supported import and export formats
+const (
+	FormatPwlib      = "pwlib"
+	FormatCSV        = "csv"
+	FormatJSON       = "json"
+	FormatBitwarden  = "bitwarden"
+	Format1Password  = "1password"
+	FormatPass       = "pass"
+	FormatKeePass    = "keepass"
+	FormatKeePassXML = "keepassxml"
+)
+
+// sources for the system field of imported records
+const (
+	SystemFromTitle  = "title"
+	SystemFromURL    = "url"
+	SystemFromFolder = "folder"
+)
+
+// ImportOptions controls how imported entries are mapped to records
+type ImportOptions struct {
+	// SystemFrom selects which field of an imported entry is used as system, empty means SystemFromTitle
+	SystemFrom string
+	// FolderPrefix prepends the folder path to the system of imported records
+	FolderPrefix bool
+}
+
+// PasswordRecord is a single entry of a password store
+type PasswordRecord struct {
+	System   string `json:"system"`
+	Account  string `json:"account"`
+	Password string `json:"password"`
+	Title    string `json:"title,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Folder   string `json:"folder,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+}
+
+// Line returns the record in pwlib system:account:password format
+func (r PasswordRecord) Line() string {
+	return fmt.Sprintf("%s:%s:%s", cleanRecordField(r.System), cleanRecordField(r.Account), cleanRecordPassword(r.Password))
+}
+
+// cleanRecordField removes separators and line breaks from system and account
+func cleanRecordField(s string) string {
+	s = strings.TrimSpace(s)
+	return strings.NewReplacer(":", "_", "\r", "", "\n", " ").Replace(s)
+}
+
+func cleanRecordPassword(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// NewPasswordRecord creates a record from typical password manager fields and maps system according to opts
+func NewPasswordRecord(title string, entryURL string, folder string, username string, password string, notes string, opts ImportOptions) PasswordRecord {
+	r := PasswordRecord{
+		Account:  strings.TrimSpace(username),
+		Password: password,
+		Title:    strings.TrimSpace(title),
+		URL:      strings.TrimSpace(entryURL),
+		Folder:   strings.Trim(strings.TrimSpace(folder), "/"),
+		Notes:    notes,
+	}
+	host := urlHost(r.URL)
+	candidates := []string{r.Title, host, r.Folder}
+	switch opts.SystemFrom {
+	case SystemFromURL:
+		candidates = []string{host, r.Title, r.Folder}
+	case SystemFromFolder:
+		candidates = []string{r.Folder, r.Title, host}
+	}
+	for _, c := range candidates {
+		if c != "" {
+			r.System = c
+			break
+		}
+	}
+	if opts.FolderPrefix && r.Folder != "" && opts.SystemFrom != SystemFromFolder {
+		r.System = r.Folder + "/" + r.System
+	}
+	return r
+}
+
+func urlHost(u string) string {
+	if u == "" {
+		return ""
+	}
+	if !strings.Contains(u, "://") {
+		u = "https://" + u
+	}
+	p, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return p.Hostname()
+}
+
+// RecordsToLines converts records to pwlib system:account:password lines
+func RecordsToLines(records []PasswordRecord) (lines []string) {
+	for _, r := range records {
+		if r.System == "" && r.Account == "" {
+			continue
+		}
+		lines = append(lines, r.Line())
+	}
+	return
+}
+
+// RecordsFromLines converts pwlib system:account:password lines to records
+func RecordsFromLines(lines []string) (records []PasswordRecord) {
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if common.CheckSkip(line) {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			log.Debugf("Skip incomplete record %s", line)
+			continue
+		}
+		records = append(records, PasswordRecord{System: fields[0], Account: fields[1], Password: fields[2], Title: fields[0]})
+	}
+	return
+}
+
+// ImportRecords reads a file in the given format, keyFile and keyPass are used for encrypted sources (pass, keepass)
+func ImportRecords(format string, filename string, keyFile string, keyPass string, opts ImportOptions) (records []PasswordRecord, err error) {
+	var content string
+	log.Debugf("Import %s from %s", format, filename)
+	switch format {
+	case FormatPass:
+		return ImportPassStore(filename, keyFile, keyPass, opts)
+	case FormatKeePass:
+		return ImportKeePassFile(filename, keyPass, keyFile, opts)
+	}
+	content, err = common.ReadFileToString(filename)
+	if err != nil {
+		return
+	}
+	switch format {
+	case FormatPwlib:
+		records = RecordsFromLines(strings.Split(content, "\n"))
+	case FormatCSV, Format1Password:
+		records, err = ImportCSV(content, opts)
+	case FormatJSON:
+		records, err = ImportJSONRecords(content)
+	case FormatBitwarden:
+		records, err = ImportBitwardenJSON(content, opts)
+	case FormatKeePassXML:
+		records, err = ImportKeePassXML(content, opts)
+	default:
+		err = fmt.Errorf("import format %s not supported", format)
+	}
+	if err == nil {
+		log.Debugf("imported %d records", len(records))
+	}
+	return
+}
+
+// ExportRecords formats records in the given format
+func ExportRecords(format string, records []PasswordRecord) (content string, err error) {
+	log.Debugf("Export %d records as %s", len(records), format)
+	switch format {
+	case FormatPwlib:
+		content = strings.Join(RecordsToLines(records), "\n") + "\n"
+	case FormatCSV:
+		content, err = ExportCSV(records)
+	case FormatJSON:
+		content, err = ExportJSON(records)
+	case FormatKeePassXML:
+		content, err = ExportKeePassXML(records)
+	default:
+		err = fmt.Errorf("export format %s not supported", format)
+	}
+	return
+}
+
+// csv header aliases
+var csvColumnAliases = map[string][]string{
+	"system":   {"system", "title", "name"},
+	"account":  {"account", "username", "user", "login", "login_username", "email"},
+	"password": {"password", "pass", "login_password"},
+	"url":      {"url", "website", "uri", "login_uri", "urls"},
+	"folder":   {"folder", "group", "path", "vault", "tags"},
+	"notes":    {"notes", "note", "extra", "comments"},
+}
+
+// ImportCSV reads CSV with a header line, columns are matched by common names (title, username, password, url, folder, notes).
+// CSV without known header columns is read as system,account,password. This covers 1Password CSV exports as well
+func ImportCSV(content string, opts ImportOptions) (records []PasswordRecord, err error) {
+	var rows [][]string
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(content, "\ufeff")))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	rows, err = r.ReadAll()
+	if err != nil {
+		err = fmt.Errorf("cannot parse csv: %v", err)
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+	cols := map[string]int{}
+	for i, h := range rows[0] {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for name, list := range csvColumnAliases {
+			if _, ok := cols[name]; ok {
+				continue
+			}
+			if found, _ := common.InArray(h, list); found {
+				cols[name] = i
+			}
+		}
+	}
+	if _, ok := cols["password"]; !ok {
+		// no header, use pwlib column order
+		cols = map[string]int{"system": 0, "account": 1, "password": 2}
+	} else {
+		rows = rows[1:]
+	}
+	get := func(row []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+	for _, row := range rows {
+		if len(row) == 0 || (len(row) == 1 && strings.TrimSpace(row[0]) == "") {
+			continue
+		}
+		folder := get(row, "folder")
+		// multiple tags are separated by comma, use the first one
+		folder = strings.TrimSpace(strings.Split(folder, ",")[0])
+		records = append(records, NewPasswordRecord(get(row, "system"), get(row, "url"), folder, get(row, "account"), get(row, "password"), get(row, "notes"), opts))
+	}
+	return
+}
+
+// ExportCSV formats records as CSV with header system,account,password,url,folder,notes
+func ExportCSV(records []PasswordRecord) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"system", "account", "password", "url", "folder", "notes"})
+	for _, r := range records {
+		if err := w.Write([]string{r.System, r.Account, r.Password, r.URL, r.Folder, r.Notes}); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// ImportJSONRecords reads records written by ExportJSON
+func ImportJSONRecords(content string) (records []PasswordRecord, err error) {
+	err = json.Unmarshal([]byte(content), &records)
+	if err != nil {
+		err = fmt.Errorf("cannot parse json records: %v", err)
+	}
+	return
+}
+
+// ExportJSON formats records as JSON array
+func ExportJSON(records []PasswordRecord) (string, error) {
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// bitwardenExport is the structure of an unencrypted Bitwarden JSON export
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []struct {
+		FolderID string `json:"folderId"`
+		Type     int    `json:"type"`
+		Name     string `json:"name"`
+		Notes    string `json:"notes"`
+		Login    *struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			URIs     []struct {
+				URI string `json:"uri"`
+			} `json:"uris"`
+		} `json:"login"`
+	} `json:"items"`
+}
+
+// ImportBitwardenJSON reads login items of an unencrypted Bitwarden JSON export
+func ImportBitwardenJSON(content string, opts ImportOptions) (records []PasswordRecord, err error) {
+	var bw bitwardenExport
+	err = json.Unmarshal([]byte(content), &bw)
+	if err != nil {
+		err = fmt.Errorf("cannot parse bitwarden json: %v", err)
+		return
+	}
+	if bw.Encrypted {
+		err = fmt.Errorf("encrypted bitwarden exports are not supported")
+		return
+	}
+	folders := map[string]string{}
+	for _, f := range bw.Folders {
+		folders[f.ID] = f.Name
+	}
+	for _, item := range bw.Items {
+		// only login items contain credentials
+		if item.Login == nil {
+			log.Debugf("skip bitwarden item %s of type %d", item.Name, item.Type)
+			continue
+		}
+		u := ""
+		if len(item.Login.URIs) > 0 {
+			u = item.Login.URIs[0].URI
+		}
+		records = append(records, NewPasswordRecord(item.Name, u, folders[item.FolderID], item.Login.Username, item.Login.Password, item.Notes, opts))
+	}
+	return
+}
+
+// ImportPassStore reads a pass/gopass store directory, gpg entries are decrypted with the private gpg key, age entries with the age identity
+func ImportPassStore(storeDir string, privateKeyFile string, keyPass string, opts ImportOptions) (records []PasswordRecord, err error) {
+	var entityList openpgp.EntityList
+	var identities []age.Identity
+	var files []string
+	log.Debugf("Import pass store %s", storeDir)
+	err = filepath.WalkDir(storeDir, func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && p != storeDir {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && (strings.HasSuffix(p, ".gpg") || strings.HasSuffix(p, ".age")) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot read pass store %s: %v", storeDir, err)
+		return
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		var content string
+		var data string
+		data, err = common.ReadFileToString(f)
+		if err != nil {
+			return
+		}
+		if strings.HasSuffix(f, ".age") {
+			if identities == nil {
+				if identities, err = readAgeIdentities(privateKeyFile); err != nil {
+					return
+				}
+			}
+			content, err = ageDecryptWithIdentities([]byte(data), identities)
+		} else {
+			if entityList == nil {
+				if entityList, err = loadGPGKeyRing(privateKeyFile, keyPass); err != nil {
+					return
+				}
+			}
+			content, err = gpgDecryptWithKeyRing(data, entityList)
+		}
+		if err != nil {
+			err = fmt.Errorf("cannot decrypt %s: %v", f, err)
+			return
+		}
+		rel, _ := filepath.Rel(storeDir, f)
+		records = append(records, parsePassEntry(filepath.ToSlash(rel), content, opts))
+	}
+	return
+}
+
+// parsePassEntry converts a pass entry with password in first line and key: value lines into a record
+func parsePassEntry(name string, content string, opts ImportOptions) PasswordRecord {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gpg"), ".age")
+	folder, title := "", name
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		folder, title = name[:i], name[i+1:]
+	}
+	lines := strings.Split(strings.ReplaceAll(content, "\r", ""), "\n")
+	password := lines[0]
+	username := ""
+	u := ""
+	var notes []string
+	for _, l := range lines[1:] {
+		k, v, found := strings.Cut(l, ":")
+		key := strings.ToLower(strings.TrimSpace(k))
+		switch {
+		case found && username == "" && (key == "login" || key == "username" || key == "user"):
+			username = strings.TrimSpace(v)
+		case found && u == "" && (key == "url" || key == "website"):
+			u = strings.TrimSpace(v)
+		case strings.TrimSpace(l) != "":
+			notes = append(notes, l)
+		}
+	}
+	// pass layout site/username: use the entry name as account
+	if username == "" && folder != "" {
+		username = title
+		title = folder
+		folder = ""
+		if i := strings.LastIndex(title, "/"); i >= 0 {
+			folder, title = title[:i], title[i+1:]
+		}
+	}
+	return NewPasswordRecord(title, u, folder, username, password, strings.Join(notes, "\n"), opts)
+}
@@ -0,0 +1,137 @@
+package pwlib
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+const bitwardenTestJSON = `{
+  "encrypted": false,
+  "folders": [{"id": "f1", "name": "Work"}],
+  "items": [
+    {"id": "i1", "folderId": "f1", "type": 1, "name": "Intranet", "notes": "vpn needed",
+     "login": {"uris": [{"match": null, "uri": "https://intra.example.com"}], "username": "jdoe", "password": "pa:ss"}},
+    {"id": "i2", "folderId": null, "type": 2, "name": "Secure Note", "notes": "text"},
+    {"id": "i3", "folderId": null, "type": 1, "name": "", "login": {"uris": [{"uri": "https://shop.example.org/login"}], "username": "buyer", "password": "shop"}}
+  ]
+}`
+
+const onePasswordTestCSV = `Title,Url,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes
+GitHub,https://github.com,octocat,"gh,pass",,false,false,"dev,code",my notes
+"Bank: Online",bank.example.com,customer,bankpw,,true,false,,
+`
+
+func TestImportFormats(t *testing.T) {
+	t.Run("Bitwarden JSON", func(t *testing.T) {
+		records, err := ImportBitwardenJSON(bitwardenTestJSON, ImportOptions{})
+		require.NoErrorf(t, err, "Import failed:%s", err)
+		require.Len(t, records, 2, "secure note should be skipped")
+		assert.Equal(t, "Intranet", records[0].System)
+		assert.Equal(t, "Work", records[0].Folder)
+		assert.Equal(t, "Intranet:jdoe:pa:ss", records[0].Line())
+		assert.Equal(t, "shop.example.org", records[1].System, "system should fall back to url host")
+		_, err = ImportBitwardenJSON(`{"encrypted": true}`, ImportOptions{})
+		assert.Error(t, err, "encrypted export should fail")
+	})
+	t.Run("1Password CSV", func(t *testing.T) {
+		records, err := ImportCSV(onePasswordTestCSV, ImportOptions{})
+		require.NoErrorf(t, err, "Import failed:%s", err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "GitHub:octocat:gh,pass", records[0].Line())
+		assert.Equal(t, "dev", records[0].Folder)
+		assert.Equal(t, "Bank_ Online:customer:bankpw", records[1].Line(), "separator must be replaced")
+	})
+	t.Run("Generic CSV headerless", func(t *testing.T) {
+		records, err := ImportCSV("db1,scott,tiger\ndb2,sys,change:me\n", ImportOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"db1:scott:tiger", "db2:sys:change:me"}, RecordsToLines(records))
+	})
+	t.Run("System mapping", func(t *testing.T) {
+		r := NewPasswordRecord("Intranet", "https://intra.example.com/app", "Work", "jdoe", "pw", "", ImportOptions{SystemFrom: SystemFromURL})
+		assert.Equal(t, "intra.example.com", r.System)
+		r = NewPasswordRecord("Intranet", "", "Work/IT", "jdoe", "pw", "", ImportOptions{SystemFrom: SystemFromFolder})
+		assert.Equal(t, "Work/IT", r.System)
+		r = NewPasswordRecord("Intranet", "", "Work", "jdoe", "pw", "", ImportOptions{FolderPrefix: true})
+		assert.Equal(t, "Work/Intranet", r.System)
+		r = NewPasswordRecord("Intranet", "https://intra.example.com/app", "Work", "jdoe", "pw", "", ImportOptions{})
+		assert.Equal(t, "Intranet", r.System, "default should use title")
+	})
+	t.Run("Export roundtrip", func(t *testing.T) {
+		records := RecordsFromLines([]string{"# comment", "db1:scott:tiger", "mail:me:p,w\"1", "incomplete"})
+		require.Len(t, records, 2)
+		for _, f := range []string{FormatCSV, FormatJSON, FormatKeePassXML, FormatPwlib} {
+			content, err := ExportRecords(f, records)
+			require.NoErrorf(t, err, "Export %s failed:%s", f, err)
+			var imported []PasswordRecord
+			switch f {
+			case FormatCSV:
+				imported, err = ImportCSV(content, ImportOptions{})
+			case FormatJSON:
+				imported, err = ImportJSONRecords(content)
+			case FormatKeePassXML:
+				imported, err = ImportKeePassXML(content, ImportOptions{})
+			default:
+				imported = RecordsFromLines(strings.Split(content, "\n"))
+			}
+			require.NoErrorf(t, err, "Import %s failed:%s", f, err)
+			assert.Equal(t, RecordsToLines(records), RecordsToLines(imported), "roundtrip %s", f)
+		}
+		_, err := ExportRecords("unknown", records)
+		assert.Error(t, err)
+	})
+}
+
+func TestImportPassStore(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	app := "test_pass_store"
+	store := path.Join(test.TestData, app)
+	_ = os.RemoveAll(store)
+	err = os.MkdirAll(path.Join(store, "sites", "example.com"), 0700)
+	require.NoError(t, err)
+	err = os.MkdirAll(path.Join(store, ".git"), 0700)
+	require.NoError(t, err)
+
+	keyPass := "passstore"
+	gpgPub := path.Join(test.TestData, app+pubGPGExt)
+	gpgPriv := path.Join(test.TestData, app+privGPGExt)
+	entity, _, err := CreateGPGEntity(testGPGName, "TestPass", testGPGEmail, keyPass)
+	require.NoErrorf(t, err, "Prepare GPG Keys failed:%s", err)
+	err = ExportGPGKeyPair(entity, gpgPub, gpgPriv)
+	require.NoError(t, err)
+
+	entries := map[string]string{
+		"email/mail.example.com": "mailpass\nlogin: me@example.com\nurl: https://mail.example.com\n",
+		"sites/example.com/jdoe": "sitepass\nsecurity question: none\n",
+	}
+	plainFile := path.Join(test.TestData, app+".txt")
+	for name, content := range entries {
+		_ = os.MkdirAll(path.Dir(path.Join(store, name)), 0700)
+		err = common.WriteStringToFile(plainFile, content)
+		require.NoError(t, err)
+		err = GPGEncryptFile(plainFile, path.Join(store, name+".gpg"), gpgPub)
+		require.NoError(t, err)
+	}
+	_ = common.WriteStringToFile(path.Join(store, ".gpg-id"), testGPGEmail)
+	_ = common.WriteStringToFile(path.Join(store, ".git", "ignored.gpg"), "not encrypted")
+
+	records, err := ImportRecords(FormatPass, store, gpgPriv, keyPass, ImportOptions{})
+	require.NoErrorf(t, err, "Import pass store failed:%s", err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "mail.example.com:me@example.com:mailpass", records[0].Line())
+	assert.Equal(t, "email", records[0].Folder)
+	assert.Equal(t, "example.com:jdoe:sitepass", records[1].Line())
+	assert.Equal(t, "sites", records[1].Folder)
+	assert.Equal(t, "security question: none", records[1].Notes)
+
+	_, err = ImportPassStore(store, gpgPriv, "wrong", ImportOptions{})
+	assert.Error(t, err, "wrong passphrase should fail")
+}
@@ -66,6 +66,7 @@ type PassConfig struct {
 	DataDir         string
 	KeyDir          string
 	KeyPass         string
+	KeyPassSecret   *Secret // used instead of KeyPass to unlock the private key if set
 	CryptedFile     string
 	PrivateKeyFile  string
 	PubKeyFile      string
@@ -78,6 +79,9 @@ type PassConfig struct {
 	CaseSensitive   bool
 	KeyType         string
 	SignatureFile   string
+	Agent           AgentDecrypter
+	Audit           AuditSink
+	StoreSigning    *StoreSigning
 }
 
 var (
@@ -0,0 +1,126 @@
+package pwlib
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/smithy-go"
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryPolicy defines the exponential backoff of throttled Vault and KMS calls
+type RetryPolicy struct {
+	// MaxAttempts is the number of calls including the first one, values below 1 mean a single call
+	MaxAttempts int
+	// InitialDelay is the wait time before the first retry, it doubles with every retry
+	InitialDelay time.Duration
+	// MaxDelay limits the wait time between two calls
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used by the idempotent KMS operations and the Vault clients created by VaultConfig
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+}
+
+// kmsRetryCodes are KMS error codes for throttling and temporary failures
+var kmsRetryCodes = []string{
+	"ThrottlingException",
+	"LimitExceededException",
+	"RequestLimitExceeded",
+	"TooManyRequestsException",
+	"KMSInternalException",
+	"DependencyTimeoutException",
+	"ServiceUnavailable",
+}
+
+// vaultRetryStatus are Vault status codes for throttling, sealed or standby nodes
+var vaultRetryStatus = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// vaultRetryMethods are the read methods of the Vault API
+var vaultRetryMethods = []string{http.MethodGet, http.MethodHead, "LIST"}
+
+// IsRetryableError reports if a Vault or KMS error is caused by throttling or a temporary failure
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return slices.Contains(kmsRetryCodes, ae.ErrorCode())
+	}
+	var re *vault.ResponseError
+	if errors.As(err, &re) {
+		return slices.Contains(vaultRetryStatus, re.StatusCode)
+	}
+	return false
+}
+
+// delay returns the backoff before retry n (starting with 1) with jitter of up to the half
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// kmsSingleAttempt disables the AWS SDK retries of a call, so withRetry is the only retry layer
+// and calls which are not safe to repeat are sent once
+func kmsSingleAttempt(o *kms.Options) {
+	o.RetryMaxAttempts = 1
+}
+
+// vaultCheckRetry lets the Vault client retry throttled or temporarily failed reads, writes are sent once
+func vaultCheckRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil || resp == nil || resp.Request == nil {
+		return false, nil
+	}
+	if !slices.Contains(vaultRetryMethods, resp.Request.Method) {
+		return false, nil
+	}
+	return slices.Contains(vaultRetryStatus, resp.StatusCode), nil
+}
+
+// vaultBackoff uses the DefaultRetryPolicy delays for the Vault client retries
+func vaultBackoff(_, _ time.Duration, attemptNum int, _ *http.Response) time.Duration {
+	return DefaultRetryPolicy.delay(attemptNum + 1)
+}
+
+// withRetry calls fn until it succeeds, returns a non retryable error, the attempts are exhausted or ctx is done.
+// It must only wrap calls which are safe to repeat and whose client does not retry itself
+func withRetry[T any](ctx context.Context, op string, fn func(context.Context) (T, error)) (result T, err error) {
+	p := DefaultRetryPolicy
+	for attempt := 1; ; attempt++ {
+		result, err = fn(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !IsRetryableError(err) {
+			return
+		}
+		d := p.delay(attempt)
+		log.Debugf("%s throttled, retry %d in %s: %v", op, attempt, d, err)
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return result, errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package pwlib
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/smithy-go"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	saved := DefaultRetryPolicy
+	DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	defer func() {
+		DefaultRetryPolicy = saved
+	}()
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+
+	t.Run("Classify", func(t *testing.T) {
+		assert.True(t, IsRetryableError(throttled))
+		assert.True(t, IsRetryableError(fmt.Errorf("wrapped: %w", throttled)))
+		assert.True(t, IsRetryableError(&vault.ResponseError{StatusCode: http.StatusTooManyRequests}))
+		assert.True(t, IsRetryableError(&vault.ResponseError{StatusCode: http.StatusServiceUnavailable}))
+		assert.False(t, IsRetryableError(&vault.ResponseError{StatusCode: http.StatusForbidden}))
+		assert.False(t, IsRetryableError(&smithy.GenericAPIError{Code: "NotFoundException"}))
+		assert.False(t, IsRetryableError(errors.New("other")))
+		assert.False(t, IsRetryableError(context.DeadlineExceeded))
+		assert.False(t, IsRetryableError(nil))
+	})
+	t.Run("Backoff", func(t *testing.T) {
+		p := RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+		for n, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second} {
+			d := p.delay(n)
+			assert.LessOrEqualf(t, d, limit, "retry %d", n)
+			assert.GreaterOrEqualf(t, d, limit/2, "retry %d", n)
+		}
+		assert.Zero(t, RetryPolicy{}.delay(1))
+	})
+	t.Run("Attempts", func(t *testing.T) {
+		calls := 0
+		v, err := withRetry(context.Background(), "test", func(context.Context) (string, error) {
+			calls++
+			if calls < 3 {
+				return "", throttled
+			}
+			return "ok", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "ok", v)
+		assert.Equal(t, 3, calls)
+
+		calls = 0
+		_, err = withRetry(context.Background(), "test", func(context.Context) (string, error) {
+			calls++
+			return "", throttled
+		})
+		assert.ErrorIs(t, err, throttled)
+		assert.Equal(t, 3, calls, "attempts must be limited")
+
+		calls = 0
+		_, err = withRetry(context.Background(), "test", func(context.Context) (string, error) {
+			calls++
+			return "", errors.New("access denied")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls, "other errors must not be retried")
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		DefaultRetryPolicy.InitialDelay = time.Minute
+		DefaultRetryPolicy.MaxDelay = time.Minute
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := withRetry(ctx, "test", func(context.Context) (string, error) {
+			return "", throttled
+		})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, err, throttled)
+		assert.Less(t, time.Since(start), 10*time.Second)
+		DefaultRetryPolicy.InitialDelay = time.Millisecond
+		DefaultRetryPolicy.MaxDelay = 5 * time.Millisecond
+	})
+	t.Run("Vault", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 || r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"errors":["rate limit quota exceeded"]}`))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"data":{"password":"secret"}}`))
+		}))
+		defer srv.Close()
+		t.Setenv(vault.EnvVaultMaxRetries, "")
+		client, err := VaultConfig(srv.URL, "token")
+		require.NoError(t, err)
+		assert.Equal(t, 2, client.MaxRetries(), "retries follow DefaultRetryPolicy")
+		vs, err := VaultReadContext(context.Background(), client, "secret/test")
+		require.NoError(t, err)
+		require.NotNil(t, vs)
+		assert.Equal(t, "secret", vs.Data["password"])
+		assert.Equal(t, int32(3), calls.Load(), "client retries only, no additional layer")
+
+		calls.Store(0)
+		err = VaultWriteContext(context.Background(), client, "secret/test", map[string]interface{}{"password": "new"})
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), calls.Load(), "writes must not be retried")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = VaultReadContext(ctx, client, "secret/test")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("KMS", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			if calls.Add(1) < 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type":"ThrottlingException","message":"Rate exceeded"}`))
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"CiphertextBlob":"%s","KeyId":"test"}`, base64.StdEncoding.EncodeToString([]byte("crypted")))
+		}))
+		defer srv.Close()
+		svc := kms.New(kms.Options{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(srv.URL),
+			Credentials:  aws.AnonymousCredentials{},
+			Retryer:      aws.NopRetryer{},
+		})
+		crypted, err := KMSEncryptStringContext(context.Background(), svc, "test", "plain")
+		require.NoError(t, err)
+		assert.Equal(t, "crypted", crypted)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+	t.Run("KMS SDK retries", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ThrottlingException","message":"Rate exceeded"}`))
+		}))
+		defer srv.Close()
+		// client with the default SDK retryer
+		svc := kms.New(kms.Options{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(srv.URL),
+			Credentials:  aws.AnonymousCredentials{},
+		})
+		_, err := KMSEncryptStringContext(context.Background(), svc, "test", "plain")
+		assert.Error(t, err)
+		assert.Equal(t, int32(DefaultRetryPolicy.MaxAttempts), calls.Load(), "attempts must not multiply")
+
+		calls.Store(0)
+		_, err = CreateKMSAliasContext(context.Background(), svc, "test", "key")
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), calls.Load(), "CreateAlias must not be retried")
+		calls.Store(0)
+		_, err = GenKMSKeyContext(context.Background(), svc, "", "test", nil)
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), calls.Load(), "CreateKey must not be retried")
+	})
+}
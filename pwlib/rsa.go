@@ -126,6 +126,19 @@ func GenRsaKey(pubfilename string, privfilename string, password string) (public
 
 // GetPrivateKeyFromFile  read private key from PEM encoded File and returns publicKey and private key objects
 func GetPrivateKeyFromFile(privfilename string, rsaPrivateKeyPassword string) (publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey, err error) {
+	return getRsaPrivateKey(privfilename, []byte(rsaPrivateKeyPassword))
+}
+
+// GetPrivateKeyFromFileSecret is GetPrivateKeyFromFile with the passphrase given as Secret
+func GetPrivateKeyFromFileSecret(privfilename string, password *Secret) (publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey, err error) {
+	err = password.Use(func(b []byte) (e error) {
+		publicKey, privateKey, e = getRsaPrivateKey(privfilename, b)
+		return
+	})
+	return
+}
+
+func getRsaPrivateKey(privfilename string, rsaPrivateKeyPassword []byte) (publicKey *rsa.PublicKey, privateKey *rsa.PrivateKey, err error) {
 	var parsedKey interface{}
 	var privPemBytes []byte
 
@@ -145,9 +158,9 @@ func GetPrivateKeyFromFile(privfilename string, rsaPrivateKeyPassword string) (p
 		return
 	}
 
-	if rsaPrivateKeyPassword != "" {
+	if len(rsaPrivateKeyPassword) > 0 {
 		//nolint:staticcheck
-		privPemBytes, err = x509.DecryptPEMBlock(privPem, []byte(rsaPrivateKeyPassword))
+		privPemBytes, err = x509.DecryptPEMBlock(privPem, rsaPrivateKeyPassword)
 		if err != nil {
 			log.Debugf("rsa private password error:%s", err)
 			return
@@ -213,8 +226,18 @@ func GetPublicKeyFromFile(publicKeyFile string) (publicKey *rsa.PublicKey, err e
 
 // PubEncryptFileGo encrypts a file with public key with GO API
 func PubEncryptFileGo(plainFile string, targetFile string, publicKeyFile string) (err error) {
-	const rb = 16
 	log.Debugf("Encrypt %s with public key %s", plainFile, publicKeyFile)
+	plainData, err := common.ReadFileToString(plainFile)
+	if err != nil {
+		log.Debugf("Cannot read plaintext file %s:%s", plainFile, err)
+		return
+	}
+	return pubEncryptGo([]byte(plainData), targetFile, publicKeyFile)
+}
+
+// pubEncryptGo encrypts plain with public key with GO API to targetFile
+func pubEncryptGo(plain []byte, targetFile string, publicKeyFile string) (err error) {
+	const rb = 16
 	publicKey, err := GetPublicKeyFromFile(publicKeyFile)
 	if err != nil {
 		return
@@ -225,13 +248,6 @@ func PubEncryptFileGo(plainFile string, targetFile string, publicKeyFile string)
 		log.Debugf("Cannot generate session key:%s", err)
 		return
 	}
-	plainData := ""
-	plainData, err = common.ReadFileToString(plainFile)
-	if err != nil {
-		log.Debugf("Cannot read plaintext file %s:%s", plainFile, err)
-		return
-	}
-
 	// sha1 for compatibility with python version
 	hash := sha256.New()
 	// oder rsa.EncryptPKCS1v15()
@@ -263,7 +279,7 @@ func PubEncryptFileGo(plainFile string, targetFile string, publicKeyFile string)
 	}
 
 	// do encryption and seal
-	cipherdata := aesgcm.Seal(nil, nonce, []byte(plainData), nil)
+	cipherdata := aesgcm.Seal(nil, nonce, plain, nil)
 
 	// encode all parts in base64
 	bindata := bytes.Join([][]byte{encSessionKey, nonce, cipherdata}, []byte(""))
@@ -286,18 +302,48 @@ func PrivateDecryptFileGo(cryptedfile string, privatekeyfile string, keypass str
 		log.Debugf("Cannot Read file '%s': %s", cryptedfile, err)
 		return
 	}
+	content, err = privateDecryptGoData(data, privatekeyfile, keypass)
+	if err != nil {
+		log.Debugf("Cannot decrypt data from '%s': %s", cryptedfile, err)
+	}
+	return
+}
+
+// privateDecryptGoData decrypts data created by PubEncryptFileGo with the private key from privatekeyfile
+func privateDecryptGoData(data string, privatekeyfile string, keypass string) (content string, err error) {
 	_, privkey, err := GetPrivateKeyFromFile(privatekeyfile, keypass)
 	if err != nil {
 		log.Debugf("Cannot read keys from '%s': %s", privatekeyfile, err)
 		return
 	}
-	bindata, err := base64.StdEncoding.DecodeString(data)
+	return decryptGoData(data, privkey)
+}
+
+// decryptGoData decrypts base64 encoded data created by PubEncryptFileGo with the given private key
+func decryptGoData(data string, privkey *rsa.PrivateKey) (content string, err error) {
+	plain, err := decryptGoBytes([]byte(data), privkey)
+	content = string(plain)
+	wipeBytes(plain)
+	return
+}
+
+// decryptGoBytes is decryptGoData returning the plain data in a buffer the caller can wipe
+func decryptGoBytes(data []byte, privkey *rsa.PrivateKey) (content []byte, err error) {
+	if privkey == nil {
+		err = errors.New("no private key given")
+		return
+	}
+	bindata, err := base64.StdEncoding.DecodeString(string(data))
 	if err != nil {
-		log.Debugf("decode base64 for %s failed: %s", cryptedfile, err)
+		log.Debugf("decode base64 failed: %s", err)
 		return
 	}
 	s := 0
 	e := privkey.Size()
+	if len(bindata) < e {
+		err = errors.New("crypted data too short")
+		return
+	}
 	encSessionKey := bindata[s:e]
 
 	hash := sha256.New()
@@ -326,18 +372,20 @@ func PrivateDecryptFileGo(cryptedfile string, privatekeyfile string, keypass str
 	ns := aesgcm.NonceSize()
 	s = e
 	e = s + ns
+	if len(bindata) < e {
+		err = errors.New("crypted data too short")
+		return
+	}
 	nonce := bindata[s:e]
 	s = e
 	cipherdata := bindata[s:]
 
 	// do decrypt
-	plaindata, err := aesgcm.Open(nil, nonce, cipherdata, nil)
+	content, err = aesgcm.Open(nil, nonce, cipherdata, nil)
 	if err != nil {
 		log.Debugf("Cannot decode crypted data:%s", err)
 		return
 	}
-	// return content
-	content = string(plaindata)
 	log.Debug("Decoding successfully")
 	return
 }
@@ -351,6 +399,15 @@ func RsaDecryptString(crypted string, privatekeyfile string, keypass string) (pl
 		log.Debugf("Cannot read keys from '%s': %s", privatekeyfile, err)
 		return
 	}
+	return rsaDecryptWithKey(crypted, privkey)
+}
+
+// rsaDecryptWithKey decrypts a base64 encoded PKCS1v15 crypted string with the given private key
+func rsaDecryptWithKey(crypted string, privkey *rsa.PrivateKey) (plain string, err error) {
+	if privkey == nil {
+		err = errors.New("no private key given")
+		return
+	}
 	b64dec, err := base64.StdEncoding.DecodeString(crypted)
 	if err != nil {
 		log.Debugf("decode base64 failed: %s", err)
@@ -0,0 +1,171 @@
+package pwlib
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// redacted is printed instead of the secret value
+const redacted = "[REDACTED]"
+
+// Secret holds sensitive data in a locked memory buffer which is wiped on Destroy.
+// Formatting, logging and marshaling a Secret never reveals its value. The value is only accessible in Use,
+// so the memory cannot be released by Destroy or the finalizer while it is in use.
+// A Secret only protects data created in it, wrapping an existing go string does not wipe that string.
+type Secret struct {
+	mu        sync.RWMutex
+	mem       []byte
+	size      int
+	mapped    bool
+	locked    bool
+	destroyed bool
+}
+
+// NewSecret copies data into a new Secret and wipes data
+func NewSecret(data []byte) *Secret {
+	s := &Secret{size: len(data)}
+	s.mem, s.mapped, s.locked = allocSecretMemory(len(data))
+	copy(s.mem, data)
+	wipeBytes(data)
+	runtime.SetFinalizer(s, func(s *Secret) { s.Destroy() })
+	return s
+}
+
+// NewSecretString creates a Secret from a string, the string itself cannot be wiped
+func NewSecretString(value string) *Secret {
+	return NewSecret([]byte(value))
+}
+
+// errSecretDestroyed is returned by Use after Destroy
+var errSecretDestroyed = errors.New("secret has been destroyed")
+
+// Use calls fn with the secret value without copy. The value is only valid during fn and must not be
+// retained, as the memory is wiped and unmapped on Destroy. fn must not call Destroy of the same Secret
+func (s *Secret) Use(fn func(value []byte) error) error {
+	if s == nil {
+		return errSecretDestroyed
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// the finalizer must not unmap the memory while fn is running
+	defer runtime.KeepAlive(s)
+	if s.destroyed {
+		return errSecretDestroyed
+	}
+	return fn(s.mem[:s.size:s.size])
+}
+
+// Reveal returns the secret value as string for APIs which need one, the copy cannot be wiped
+func (s *Secret) Reveal() (value string) {
+	_ = s.Use(func(b []byte) error {
+		value = string(b)
+		return nil
+	})
+	return
+}
+
+// Len returns the length of the secret value
+func (s *Secret) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.destroyed {
+		return 0
+	}
+	return s.size
+}
+
+// Locked reports if the secret memory is locked against swapping
+func (s *Secret) Locked() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.locked && !s.destroyed
+}
+
+// Equal compares two secrets in constant time, destroyed secrets are not equal to any secret.
+// The value of o is copied to locked memory first, so only one lock is held at a time and crossed
+// calls cannot deadlock with a pending Destroy
+func (s *Secret) Equal(o *Secret) (equal bool) {
+	if s == o {
+		return !s.Destroyed()
+	}
+	var b []byte
+	var n int
+	var mapped, locked bool
+	err := o.Use(func(value []byte) error {
+		n = len(value)
+		b, mapped, locked = allocSecretMemory(n)
+		copy(b, value)
+		return nil
+	})
+	if err != nil {
+		return false
+	}
+	defer freeSecretMemory(b, mapped, locked)
+	_ = s.Use(func(a []byte) error {
+		equal = subtle.ConstantTimeCompare(a, b[:n]) == 1
+		return nil
+	})
+	return
+}
+
+// Destroy wipes and releases the secret memory
+func (s *Secret) Destroy() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.destroyed {
+		return
+	}
+	freeSecretMemory(s.mem, s.mapped, s.locked)
+	s.mem = nil
+	s.size = 0
+	s.destroyed = true
+	runtime.SetFinalizer(s, nil)
+}
+
+// Destroyed reports if Destroy has been called
+func (s *Secret) Destroyed() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.destroyed
+}
+
+// String implements fmt.Stringer and returns a redacted value
+func (s *Secret) String() string {
+	return redacted
+}
+
+// GoString implements fmt.GoStringer and returns a redacted value
+func (s *Secret) GoString() string {
+	return redacted
+}
+
+// Format implements fmt.Formatter so that no verb prints the value
+func (s *Secret) Format(f fmt.State, _ rune) {
+	_, _ = f.Write([]byte(redacted))
+}
+
+// MarshalJSON implements json.Marshaler and returns a redacted value
+func (s *Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+// MarshalText implements encoding.TextMarshaler and returns a redacted value
+func (s *Secret) MarshalText() ([]byte, error) {
+	return []byte(redacted), nil
+}
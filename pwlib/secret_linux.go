@@ -0,0 +1,42 @@
+package pwlib
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// allocSecretMemory maps anonymous memory outside the go heap, locks it against swapping
+// and excludes it from core dumps. If locking fails (RLIMIT_MEMLOCK) the memory is used unlocked
+func allocSecretMemory(size int) (mem []byte, mapped bool, locked bool) {
+	pageSize := os.Getpagesize()
+	mapSize := (size/pageSize + 1) * pageSize
+	mem, err := unix.Mmap(-1, 0, mapSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		log.Debugf("mmap for secret failed, use heap memory: %v", err)
+		return make([]byte, size), false, false
+	}
+	if err = unix.Mlock(mem); err != nil {
+		log.Debugf("mlock for secret failed: %v", err)
+	} else {
+		locked = true
+	}
+	if err = unix.Madvise(mem, unix.MADV_DONTDUMP); err != nil {
+		log.Debugf("madvise for secret failed: %v", err)
+	}
+	return mem, true, locked
+}
+
+// freeSecretMemory wipes and unmaps the secret memory
+func freeSecretMemory(mem []byte, mapped bool, locked bool) {
+	wipeBytes(mem)
+	if !mapped {
+		return
+	}
+	if locked {
+		_ = unix.Munlock(mem)
+	}
+	_ = unix.Munmap(mem)
+}
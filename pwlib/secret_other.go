@@ -0,0 +1,13 @@
+//go:build !linux
+
+package pwlib
+
+// allocSecretMemory uses heap memory as memory locking is only supported on linux
+func allocSecretMemory(size int) (mem []byte, mapped bool, locked bool) {
+	return make([]byte, size), false, false
+}
+
+// freeSecretMemory wipes the secret memory
+func freeSecretMemory(mem []byte, _ bool, _ bool) {
+	wipeBytes(mem)
+}
@@ -0,0 +1,197 @@
+package pwlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+func TestSecret(t *testing.T) {
+	t.Run("Secret value", func(t *testing.T) {
+		input := []byte("top secret")
+		s := NewSecret(input)
+		assert.Equal(t, make([]byte, len(input)), input, "input should be wiped")
+		assert.NoError(t, s.Use(func(b []byte) error {
+			assert.Equal(t, "top secret", string(b))
+			return nil
+		}))
+		assert.Equal(t, "top secret", s.Reveal())
+		assert.Equal(t, 10, s.Len())
+		assert.True(t, s.Equal(NewSecretString("top secret")))
+		assert.False(t, s.Equal(NewSecretString("other")))
+		t.Logf("secret locked: %v", s.Locked())
+		s.Destroy()
+		assert.True(t, s.Destroyed())
+		assert.Error(t, s.Use(func([]byte) error { return nil }))
+		assert.Empty(t, s.Reveal())
+		assert.False(t, s.Equal(s))
+		assert.Equal(t, 0, s.Len())
+		// second destroy is a noop
+		s.Destroy()
+	})
+	t.Run("Secret redacted", func(t *testing.T) {
+		s := NewSecretString("top secret")
+		defer s.Destroy()
+		for _, f := range []string{"%s", "%v", "%+v", "%#v", "%q", "%x", "%d"} {
+			out := fmt.Sprintf(f, s)
+			assert.Equal(t, redacted, out, "format %s", f)
+		}
+		st := struct {
+			User     string
+			Password *Secret
+		}{"scott", s}
+		assert.NotContains(t, fmt.Sprintf("%+v", st), "top secret")
+		b, err := json.Marshal(st)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"User":"scott","Password":"[REDACTED]"}`, string(b))
+	})
+	t.Run("Nil secret", func(t *testing.T) {
+		var s *Secret
+		assert.Error(t, s.Use(func([]byte) error { return nil }))
+		assert.Equal(t, 0, s.Len())
+		assert.True(t, s.Destroyed())
+		s.Destroy()
+	})
+	t.Run("Secret GC", func(t *testing.T) {
+		// the finalizer must not unmap the memory of an unreachable Secret while it is in use
+		for i := 0; i < 100; i++ {
+			err := NewSecretString("x").Use(func(b []byte) error {
+				runtime.GC()
+				runtime.GC()
+				if b[0] != 'x' {
+					return errors.New("secret memory changed")
+				}
+				return nil
+			})
+			require.NoError(t, err)
+		}
+		runtime.GC()
+	})
+	t.Run("Equal crossed", func(t *testing.T) {
+		// crossed Equal calls with a pending Destroy must not deadlock
+		for i := 0; i < 200; i++ {
+			a := NewSecretString("same")
+			b := NewSecretString("same")
+			done := make(chan bool, 4)
+			go func() { done <- a.Equal(b) }()
+			go func() { done <- b.Equal(a) }()
+			go func() { a.Destroy(); done <- true }()
+			go func() { b.Destroy(); done <- true }()
+			for j := 0; j < 4; j++ {
+				select {
+				case <-done:
+				case <-time.After(10 * time.Second):
+					t.Fatal("Equal deadlocked")
+				}
+			}
+		}
+		a := NewSecretString("abc")
+		defer a.Destroy()
+		assert.False(t, a.Equal(NewSecretString("ab")))
+		assert.False(t, NewSecretString("ab").Equal(a))
+	})
+	t.Run("Empty secret", func(t *testing.T) {
+		s := NewSecret(nil)
+		assert.Equal(t, 0, s.Len())
+		assert.Empty(t, s.Reveal())
+		s.Destroy()
+	})
+}
+
+func TestSecretAPI(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	app := "test_secret"
+	pc := NewConfig(app, test.TestData, test.TestData, app, typeGO)
+	_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+	require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+
+	t.Run("PrivateKeySecret", func(t *testing.T) {
+		_, key, e := GetPrivateKeyFromFileSecret(pc.PrivateKeyFile, NewSecretString(pc.KeyPass))
+		assert.NoError(t, e)
+		assert.NotNil(t, key)
+		_, _, e = GetPrivateKeyFromFileSecret(pc.PrivateKeyFile, NewSecretString("wrong"))
+		assert.Error(t, e)
+		pub := test.TestData + "/" + app + "_ecdsa" + pubPemExt
+		priv := test.TestData + "/" + app + "_ecdsa" + privPemExt
+		_, _, e = GenEcdsaKey(pub, priv, "ecpass")
+		require.NoError(t, e)
+		_, eckey, e := GetEcdsaPrivateKeyFromFileSecret(priv, NewSecretString("ecpass"))
+		assert.NoError(t, e)
+		assert.NotNil(t, eckey)
+	})
+	for _, m := range []string{typeGO, typeOpenssl, typeGPG} {
+		t.Run("GetPasswordSecret "+m, func(t *testing.T) {
+			mapp := app + "_" + m
+			mpc := NewConfig(mapp, test.TestData, test.TestData, mapp, m)
+			e := common.WriteStringToFile(mpc.PlainTextFile, plain)
+			require.NoError(t, e)
+			if m == typeGPG {
+				entity, _, ge := CreateGPGEntity(testGPGName, "TestSecret", testGPGEmail, mpc.KeyPass)
+				require.NoError(t, ge)
+				require.NoError(t, ExportGPGKeyPair(entity, mpc.PubKeyFile, mpc.PrivateKeyFile))
+			} else {
+				_, _, e = GenRsaKey(mpc.PubKeyFile, mpc.PrivateKeyFile, mpc.KeyPass)
+				require.NoError(t, e)
+			}
+			require.NoError(t, mpc.EncryptFile())
+
+			mpc.KeyPassSecret = NewSecretString(mpc.KeyPass)
+			mpc.KeyPass = ""
+			lines, e := mpc.DecryptFile()
+			require.NoError(t, e, "DecryptFile with KeyPassSecret failed")
+			assert.Contains(t, lines, "test:testuser:testpass")
+			for _, c := range [][3]string{
+				{"test", "testuser", "testpass"},
+				{"", "defuser", "default"},
+				{"testdp", "testuser", "xxx:yyy"},
+			} {
+				p, pe := mpc.GetPasswordSecret(c[0], c[1])
+				require.NoError(t, pe)
+				assert.Equal(t, c[2], p.Reveal())
+				p.Destroy()
+			}
+			_, e = mpc.GetPasswordSecret("none", "none")
+			assert.Error(t, e, "unknown record should fail")
+
+			mpc.KeyPassSecret.Destroy()
+			_, e = mpc.GetPasswordSecret("test", "testuser")
+			assert.Error(t, e, "destroyed passphrase should fail")
+			mpc.KeyPassSecret = NewSecretString("wrong")
+			_, e = mpc.DecryptFile()
+			assert.Error(t, e, "wrong passphrase should fail")
+		})
+	}
+	t.Run("GenPasswordSecret", func(t *testing.T) {
+		profile := "16 1 1 1 1 1"
+		pps, e := profileSetFromString(profile)
+		require.NoError(t, e)
+		pp, cs := pps.Load()
+		for i := 0; i < 20; i++ {
+			s, ge := GenPasswordSecret(profile)
+			require.NoError(t, ge)
+			assert.Equal(t, 16, s.Len())
+			assert.True(t, DoPasswordCheck(s.Reveal(), pp, cs))
+			s.Destroy()
+		}
+		s, e := GenPasswordProfileSecret(pps)
+		require.NoError(t, e)
+		assert.Equal(t, 16, s.Len())
+		s.Destroy()
+		_, e = GenPasswordSecret("invalid")
+		assert.Error(t, e)
+		pps.Profile = PasswordProfile{Length: 4, Upper: 5}
+		_, e = GenPasswordProfileSecret(pps)
+		assert.Error(t, e, "impossible profile should fail")
+	})
+}
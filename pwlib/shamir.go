@@ -0,0 +1,318 @@
+package pwlib
+
+// Shamir's secret sharing over GF(2^8), see https://en.wikipedia.org/wiki/Shamir%27s_secret_sharing
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tommi2day/gomodules/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sharePrefix     = "pwshare"
+	shareVersion    = "v1"
+	shareIDLen      = 4
+	shareChecksum   = 4
+	maxShares       = 255
+	shareFieldCount = 7
+)
+
+// SecretShare is a single share of a splitted secret
+type SecretShare struct {
+	// ID identifies all shares of one split
+	ID string
+	// Threshold is the number of shares needed to recombine the secret
+	Threshold int
+	// Index is the x coordinate of the share (1..255)
+	Index int
+	// Data holds the share bytes
+	Data []byte
+}
+
+var gfExp [512]byte
+var gfLog [256]byte
+
+// init builds the log and exp tables for GF(2^8) with generator 3 and the AES polynomial
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		// multiply by generator 3
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret splits the secret into the given number of shares, threshold shares are needed to recombine
+func SplitSecret(secret []byte, shares int, threshold int) (result []SecretShare, err error) {
+	log.Debugf("SplitSecret into %d shares with threshold %d", shares, threshold)
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if shares < threshold {
+		return nil, fmt.Errorf("number of shares %d must not be less than threshold %d", shares, threshold)
+	}
+	if shares > maxShares {
+		return nil, fmt.Errorf("number of shares must not exceed %d", maxShares)
+	}
+	id := make([]byte, shareIDLen)
+	if _, err = rand.Read(id); err != nil {
+		return nil, fmt.Errorf("cannot generate share id: %v", err)
+	}
+
+	// append checksum to detect wrong or corrupted shares on combine
+	sum := sha256.Sum256(secret)
+	payload := make([]byte, 0, len(secret)+len(sum))
+	payload = append(payload, secret...)
+	payload = append(payload, sum[:]...)
+	defer wipeBytes(payload)
+
+	result = make([]SecretShare, shares)
+	for i := range result {
+		result[i] = SecretShare{
+			ID:        hex.EncodeToString(id),
+			Threshold: threshold,
+			Index:     i + 1,
+			Data:      make([]byte, len(payload)),
+		}
+	}
+	coeffs := make([]byte, threshold)
+	defer wipeBytes(coeffs)
+	for pos, b := range payload {
+		coeffs[0] = b
+		if _, err = rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("cannot generate random coefficients: %v", err)
+		}
+		for i := range result {
+			result[i].Data[pos] = evalPolynomial(coeffs, byte(result[i].Index))
+		}
+	}
+	log.Debugf("secret splitted into %d shares with id %s", shares, result[0].ID)
+	return
+}
+
+// evalPolynomial evaluates the polynomial with the given coefficients at x using horner's method
+func evalPolynomial(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// CombineShares recombines the secret from at least threshold shares and verifies its integrity
+func CombineShares(shares []SecretShare) (secret []byte, err error) {
+	log.Debugf("CombineShares with %d shares", len(shares))
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+	first := shares[0]
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("need at least %d shares, have %d", first.Threshold, len(shares))
+	}
+	seen := map[int]bool{}
+	for _, s := range shares {
+		switch {
+		case s.ID != first.ID:
+			return nil, fmt.Errorf("share %d belongs to another secret (id %s instead of %s)", s.Index, s.ID, first.ID)
+		case s.Threshold != first.Threshold:
+			return nil, fmt.Errorf("share %d has a different threshold", s.Index)
+		case len(s.Data) != len(first.Data):
+			return nil, fmt.Errorf("share %d has a different length", s.Index)
+		case s.Index < 1 || s.Index > maxShares:
+			return nil, fmt.Errorf("share index %d out of range", s.Index)
+		case seen[s.Index]:
+			return nil, fmt.Errorf("share %d given twice", s.Index)
+		}
+		seen[s.Index] = true
+	}
+	if len(first.Data) <= sha256.Size {
+		return nil, errors.New("share data too short")
+	}
+
+	payload := make([]byte, len(first.Data))
+	defer wipeBytes(payload)
+	for pos := range payload {
+		payload[pos] = interpolateZero(shares, pos)
+	}
+	l := len(payload) - sha256.Size
+	sum := sha256.Sum256(payload[:l])
+	if subtle.ConstantTimeCompare(sum[:], payload[l:]) != 1 {
+		return nil, errors.New("integrity check failed, shares are invalid or corrupted")
+	}
+	secret = make([]byte, l)
+	copy(secret, payload[:l])
+	log.Debug("secret successfully recombined")
+	return
+}
+
+// interpolateZero computes the lagrange interpolation at x=0 for the byte at pos
+func interpolateZero(shares []SecretShare, pos int) byte {
+	var result byte
+	for i, si := range shares {
+		xi := byte(si.Index)
+		basis := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			xj := byte(sj.Index)
+			basis = gfMul(basis, gfDiv(xj, xj^xi))
+		}
+		result ^= gfMul(si.Data[pos], basis)
+	}
+	return result
+}
+
+// String returns the printable text form of the share
+func (s SecretShare) String() string {
+	body := fmt.Sprintf("%s:%s:%s:%d:%d:%s", sharePrefix, shareVersion, s.ID, s.Threshold, s.Index, base64.RawURLEncoding.EncodeToString(s.Data))
+	return body + ":" + shareTextChecksum(body)
+}
+
+func shareTextChecksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:shareChecksum])
+}
+
+// ParseSecretShare parses a share from its printable text form
+func ParseSecretShare(text string) (share SecretShare, err error) {
+	text = strings.TrimSpace(text)
+	fields := strings.Split(text, ":")
+	if len(fields) != shareFieldCount || fields[0] != sharePrefix {
+		err = errors.New("invalid share format")
+		return
+	}
+	if fields[1] != shareVersion {
+		err = fmt.Errorf("unsupported share version %s", fields[1])
+		return
+	}
+	body := strings.Join(fields[:shareFieldCount-1], ":")
+	if shareTextChecksum(body) != fields[shareFieldCount-1] {
+		err = errors.New("share checksum mismatch, share text is corrupted")
+		return
+	}
+	share.ID = fields[2]
+	if share.Threshold, err = strconv.Atoi(fields[3]); err != nil {
+		err = fmt.Errorf("invalid share threshold: %v", err)
+		return
+	}
+	if share.Index, err = strconv.Atoi(fields[4]); err != nil {
+		err = fmt.Errorf("invalid share index: %v", err)
+		return
+	}
+	if share.Data, err = base64.RawURLEncoding.DecodeString(fields[5]); err != nil {
+		err = fmt.Errorf("invalid share data: %v", err)
+	}
+	return
+}
+
+// SplitKeyFile splits the content of a key file into shares
+func SplitKeyFile(keyFile string, shares int, threshold int) ([]SecretShare, error) {
+	content, err := os.ReadFile(keyFile) //nolint gosec
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file %s: %v", keyFile, err)
+	}
+	defer wipeBytes(content)
+	return SplitSecret(content, shares, threshold)
+}
+
+// CombineSharesToFile recombines the shares and writes the secret to targetFile with restrictive permissions
+func CombineSharesToFile(shares []SecretShare, targetFile string) (err error) {
+	secret, err := CombineShares(shares)
+	if err != nil {
+		return
+	}
+	defer wipeBytes(secret)
+	err = os.WriteFile(targetFile, secret, 0600)
+	if err != nil {
+		err = fmt.Errorf("cannot write %s: %v", targetFile, err)
+	}
+	return
+}
+
+// WriteShareFile writes the text form of a share to a file
+func WriteShareFile(share SecretShare, shareFile string) error {
+	return common.WriteStringToFile(shareFile, share.String()+"\n")
+}
+
+// ReadShareFile reads a share in text form from a file
+func ReadShareFile(shareFile string) (SecretShare, error) {
+	content, err := common.ReadFileToString(shareFile)
+	if err != nil {
+		return SecretShare{}, err
+	}
+	return ParseSecretShare(content)
+}
+
+// WriteShareAgeFile encrypts the share for the holder given by the age recipients file and writes it to shareFile
+func WriteShareAgeFile(share SecretShare, recipientsFile string, shareFile string) error {
+	recipients, err := readAgeRecipients(recipientsFile)
+	if err != nil {
+		return err
+	}
+	encrypted, err := ageEncryptWithRecipients([]byte(share.String()+"\n"), recipients)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(shareFile, encrypted, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write share file %s: %v", shareFile, err)
+	}
+	log.Debugf("share %d written to %s", share.Index, shareFile)
+	return nil
+}
+
+// ReadShareAgeFile decrypts an age encrypted share file with the holders identity file
+func ReadShareAgeFile(shareFile string, identityFile string) (share SecretShare, err error) {
+	identities, err := readAgeIdentities(identityFile)
+	if err != nil {
+		return
+	}
+	encrypted, err := os.ReadFile(shareFile) //nolint gosec
+	if err != nil {
+		err = fmt.Errorf("cannot read share file %s: %v", shareFile, err)
+		return
+	}
+	text, err := ageDecryptWithIdentities(encrypted, identities)
+	if err != nil {
+		err = fmt.Errorf("cannot decrypt share file %s: %v", shareFile, err)
+		return
+	}
+	return ParseSecretShare(text)
+}
@@ -0,0 +1,143 @@
+package pwlib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+func TestShamir(t *testing.T) {
+	secret := []byte("this is the master key of the team password store")
+
+	t.Run("GF arithmetic", func(t *testing.T) {
+		for a := 1; a < 256; a++ {
+			for _, b := range []byte{1, 2, 3, 0x53, 0xca, 0xff} {
+				assert.Equal(t, byte(a), gfDiv(gfMul(byte(a), b), b), "mul/div mismatch for %d,%d", a, b)
+			}
+		}
+		// known AES field product
+		assert.Equal(t, byte(0x01), gfMul(0x53, 0xca))
+	})
+
+	t.Run("Split invalid parameter", func(t *testing.T) {
+		_, err := SplitSecret(secret, 3, 1)
+		assert.Error(t, err, "threshold 1 should fail")
+		_, err = SplitSecret(secret, 2, 3)
+		assert.Error(t, err, "shares less than threshold should fail")
+		_, err = SplitSecret(nil, 3, 2)
+		assert.Error(t, err, "empty secret should fail")
+		_, err = SplitSecret(secret, 256, 2)
+		assert.Error(t, err, "too many shares should fail")
+	})
+
+	shares, err := SplitSecret(secret, 5, 3)
+	require.NoErrorf(t, err, "Split failed:%s", err)
+	require.Len(t, shares, 5)
+
+	t.Run("Combine subsets", func(t *testing.T) {
+		for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+			var s []SecretShare
+			for _, i := range subset {
+				s = append(s, shares[i])
+			}
+			actual, e := CombineShares(s)
+			assert.NoErrorf(t, e, "Combine %v failed:%s", subset, e)
+			assert.Equal(t, secret, actual)
+		}
+	})
+	t.Run("Combine too few shares", func(t *testing.T) {
+		_, e := CombineShares(shares[:2])
+		assert.Error(t, e)
+	})
+	t.Run("Combine duplicate shares", func(t *testing.T) {
+		_, e := CombineShares([]SecretShare{shares[0], shares[1], shares[0]})
+		assert.Error(t, e)
+	})
+	t.Run("Combine corrupted share", func(t *testing.T) {
+		bad := SecretShare{ID: shares[2].ID, Threshold: 3, Index: 3, Data: append([]byte{}, shares[2].Data...)}
+		bad.Data[0] ^= 0x01
+		_, e := CombineShares([]SecretShare{shares[0], shares[1], bad})
+		assert.ErrorContains(t, e, "integrity check failed")
+	})
+	t.Run("Combine mixed splits", func(t *testing.T) {
+		other, e := SplitSecret(secret, 3, 2)
+		require.NoError(t, e)
+		_, e = CombineShares([]SecretShare{shares[0], other[1], shares[2]})
+		assert.ErrorContains(t, e, "another secret")
+	})
+	t.Run("Share text roundtrip", func(t *testing.T) {
+		text := shares[3].String()
+		assert.Contains(t, text, sharePrefix+":"+shareVersion)
+		parsed, e := ParseSecretShare(text + "\n")
+		assert.NoError(t, e)
+		assert.Equal(t, shares[3], parsed)
+		// flip a character in the data field
+		b := []byte(text)
+		b[len(text)-12] ^= 0x01
+		_, e = ParseSecretShare(string(b))
+		assert.Error(t, e, "corrupted share text should fail")
+		_, e = ParseSecretShare("no share")
+		assert.Error(t, e)
+	})
+}
+
+func TestShamirFiles(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	app := "test_shamir"
+	pc := NewConfig(app, test.TestData, test.TestData, app, typeGO)
+	_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+	require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+
+	shares, err := SplitKeyFile(pc.PrivateKeyFile, 3, 2)
+	require.NoErrorf(t, err, "Split key file failed:%s", err)
+
+	t.Run("Plain share files", func(t *testing.T) {
+		var read []SecretShare
+		for _, s := range shares[1:] {
+			fn := path.Join(test.TestData, fmt.Sprintf("%s_share_%d.txt", app, s.Index))
+			err = WriteShareFile(s, fn)
+			require.NoError(t, err)
+			r, e := ReadShareFile(fn)
+			require.NoError(t, e)
+			read = append(read, r)
+		}
+		target := path.Join(test.TestData, app+"_restored.pem")
+		_ = os.Remove(target)
+		err = CombineSharesToFile(read, target)
+		require.NoErrorf(t, err, "Combine to file failed:%s", err)
+		_, privkey, e := GetPrivateKeyFromFile(target, pc.KeyPass)
+		assert.NoErrorf(t, e, "restored key not usable:%s", e)
+		assert.NotNil(t, privkey)
+	})
+
+	t.Run("Age share files", func(t *testing.T) {
+		var read []SecretShare
+		for _, s := range shares[:2] {
+			holder := path.Join(test.TestData, fmt.Sprintf("%s_holder_%d", app, s.Index))
+			identity, _, e := CreateAgeIdentity()
+			require.NoError(t, e)
+			err = ExportAgeKeyPair(identity, holder+pubAgeExt, holder+privAgeExt)
+			require.NoError(t, err)
+			shareFile := holder + ".share.age"
+			err = WriteShareAgeFile(s, holder+pubAgeExt, shareFile)
+			require.NoErrorf(t, err, "Write age share failed:%s", err)
+			content, _ := common.ReadFileToString(shareFile)
+			assert.NotContains(t, content, sharePrefix, "share should be encrypted")
+			r, e := ReadShareAgeFile(shareFile, holder+privAgeExt)
+			require.NoErrorf(t, e, "Read age share failed:%s", e)
+			read = append(read, r)
+		}
+		key, e := CombineShares(read)
+		require.NoError(t, e)
+		expected, _ := common.ReadFileToString(pc.PrivateKeyFile)
+		assert.Equal(t, expected, string(key))
+	})
+}
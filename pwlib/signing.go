@@ -17,6 +17,9 @@ func (pc *PassConfig) SignFile() (err error) {
 	keyID := pc.KMSKeyID
 
 	log.Debugf("Sign %s with method %s", plainFile, method)
+	defer func() {
+		pc.audit("SignFile", "", "", err)
+	}()
 	switch method {
 	case typeOpenssl, typeGO:
 		err = SignFileSSL(plainFile, signatureFile, privateKeyFile, keyPass)
@@ -0,0 +1,748 @@
+package pwlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	ageArmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/tommi2day/gomodules/common"
+	"gopkg.in/yaml.v3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// document formats of sops files
+const (
+	SopsFormatYAML = "yaml"
+	SopsFormatJSON = "json"
+)
+
+// SopsVersion is written to the metadata of encrypted documents
+var SopsVersion = "3.8.1"
+
+// SopsDefaultUnencryptedSuffix marks keys whose values stay in clear, like sops does by default
+const SopsDefaultUnencryptedSuffix = "_unencrypted"
+
+const sopsMetadataKey = "sops"
+
+var sopsValueRe = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// SopsConfig holds the master keys of sops documents
+type SopsConfig struct {
+	// AgeRecipients are age public keys (age1...) to wrap the data key for
+	AgeRecipients []string
+	// PGPPublicKeyFiles are armored public keys to wrap the data key for
+	PGPPublicKeyFiles []string
+	// KMSKeyARNs are AWS KMS keys to wrap the data key with
+	KMSKeyARNs []string
+	// KMS client, nil connects with ConnectToKMS on first use
+	KMS *kms.Client
+
+	// UnencryptedSuffix keeps values of matching keys in clear, empty uses _unencrypted
+	UnencryptedSuffix string
+	// EncryptedRegex encrypts only values of keys matching it if set
+	EncryptedRegex string
+
+	// AgeIdentityFile is used to unwrap age data keys
+	AgeIdentityFile string
+	// PGPSecretKeyFile and PGPKeyPass are used to unwrap pgp data keys
+	PGPSecretKeyFile string
+	PGPKeyPass       string
+	// IgnoreMAC decrypts documents even if the MAC does not match
+	IgnoreMAC bool
+}
+
+func (c *SopsConfig) kmsClient(ctx context.Context) (*kms.Client, error) {
+	if c.KMS == nil {
+		svc, err := ConnectToKMSContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.KMS = svc
+	}
+	return c.KMS, nil
+}
+
+// SopsFormatFromFile returns json for .json files and yaml otherwise
+func SopsFormatFromFile(filename string) string {
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		return SopsFormatJSON
+	}
+	return SopsFormatYAML
+}
+
+// SopsEncryptFile encrypts a YAML or JSON file to targetFile in sops format
+func SopsEncryptFile(plainFile string, targetFile string, cfg *SopsConfig) error {
+	return SopsEncryptFileContext(context.Background(), plainFile, targetFile, cfg)
+}
+
+// SopsEncryptFileContext encrypts a YAML or JSON file to targetFile, ctx bounds the KMS calls
+func SopsEncryptFileContext(ctx context.Context, plainFile string, targetFile string, cfg *SopsConfig) error {
+	plain, err := common.ReadFileToString(plainFile)
+	if err != nil {
+		return err
+	}
+	encrypted, err := SopsEncryptContext(ctx, []byte(plain), SopsFormatFromFile(plainFile), cfg)
+	if err != nil {
+		return err
+	}
+	return common.WriteStringToFile(targetFile, string(encrypted))
+}
+
+// SopsDecryptFile returns the decrypted document of a sops file
+func SopsDecryptFile(filename string, cfg *SopsConfig) ([]byte, error) {
+	return SopsDecryptFileContext(context.Background(), filename, cfg)
+}
+
+// SopsDecryptFileContext returns the decrypted document of a sops file, ctx bounds the KMS calls
+func SopsDecryptFileContext(ctx context.Context, filename string, cfg *SopsConfig) ([]byte, error) {
+	data, err := common.ReadFileToString(filename)
+	if err != nil {
+		return nil, err
+	}
+	return SopsDecryptContext(ctx, []byte(data), SopsFormatFromFile(filename), cfg)
+}
+
+// SopsEncrypt encrypts the leaf values of a YAML or JSON document with a new data key.
+// Comments are removed, as they would stay in clear otherwise
+func SopsEncrypt(data []byte, format string, cfg *SopsConfig) ([]byte, error) {
+	return SopsEncryptContext(context.Background(), data, format, cfg)
+}
+
+// SopsEncryptContext encrypts the leaf values of a document, ctx bounds the KMS calls
+func SopsEncryptContext(ctx context.Context, data []byte, format string, cfg *SopsConfig) ([]byte, error) {
+	root, err := sopsParse(data)
+	if err != nil {
+		return nil, err
+	}
+	if sopsMapValue(root, sopsMetadataKey) != nil {
+		return nil, errors.New("document is already encrypted")
+	}
+	w := &sopsWalker{
+		key:    make([]byte, 32),
+		mac:    sha512.New(),
+		suffix: cfg.UnencryptedSuffix,
+	}
+	if w.suffix == "" && cfg.EncryptedRegex == "" {
+		w.suffix = SopsDefaultUnencryptedSuffix
+	}
+	if cfg.EncryptedRegex != "" {
+		if w.encRe, err = regexp.Compile(cfg.EncryptedRegex); err != nil {
+			return nil, fmt.Errorf("invalid encrypted regex: %v", err)
+		}
+	}
+	if _, err = rand.Read(w.key); err != nil {
+		return nil, err
+	}
+	defer wipeBytes(w.key)
+	if err = w.encrypt(root, nil); err != nil {
+		return nil, err
+	}
+	meta, err := w.metadata(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	root.Content = append(root.Content, sopsScalar(sopsMetadataKey), meta)
+	log.Debugf("sops document encrypted")
+	return sopsMarshal(root, format)
+}
+
+// SopsDecrypt decrypts a sops YAML or JSON document and verifies its MAC
+func SopsDecrypt(data []byte, format string, cfg *SopsConfig) ([]byte, error) {
+	return SopsDecryptContext(context.Background(), data, format, cfg)
+}
+
+// SopsDecryptContext decrypts a sops document and verifies its MAC, ctx bounds the KMS calls
+func SopsDecryptContext(ctx context.Context, data []byte, format string, cfg *SopsConfig) ([]byte, error) {
+	root, err := sopsParse(data)
+	if err != nil {
+		return nil, err
+	}
+	meta := sopsMapValue(root, sopsMetadataKey)
+	if meta == nil {
+		return nil, errors.New("document has no sops metadata")
+	}
+	if sopsMapValue(meta, "key_groups") != nil {
+		return nil, errors.New("sops key groups with shamir threshold are not supported")
+	}
+	key, err := sopsDataKey(ctx, meta, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(key)
+	sopsDeleteKey(root, sopsMetadataKey)
+	w := &sopsWalker{key: key, mac: sha512.New()}
+	w.macOnlyEncrypted = sopsString(meta, "mac_only_encrypted") == "true"
+	if err = w.decrypt(root, nil); err != nil {
+		return nil, err
+	}
+	if err = w.verifyMAC(meta); err != nil {
+		if !cfg.IgnoreMAC {
+			return nil, err
+		}
+		log.Warnf("sops: %v", err)
+	}
+	log.Debugf("sops document decrypted")
+	return sopsMarshal(root, format)
+}
+
+// sopsWalker encrypts or decrypts the leaves of a document and hashes their plain values for the MAC
+type sopsWalker struct {
+	key              []byte
+	mac              hash.Hash
+	suffix           string
+	encRe            *regexp.Regexp
+	macOnlyEncrypted bool
+}
+
+func (w *sopsWalker) shouldEncrypt(path []string) bool {
+	if w.encRe != nil {
+		for _, p := range path {
+			if w.encRe.MatchString(p) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, p := range path {
+		if w.suffix != "" && strings.HasSuffix(p, w.suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *sopsWalker) encrypt(node *yaml.Node, path []string) error {
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i]
+			k.HeadComment, k.LineComment, k.FootComment = "", "", ""
+			if err := w.encrypt(node.Content[i+1], append(path, k.Value)); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		// list items share the path of the list
+		for _, c := range node.Content {
+			if err := w.encrypt(c, path); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() == "!!null" {
+			return nil
+		}
+		plain, macBytes, typ, err := sopsPlainValue(node)
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+		}
+		encrypt := w.shouldEncrypt(path)
+		if encrypt || !w.macOnlyEncrypted {
+			_, _ = w.mac.Write(macBytes)
+		}
+		if !encrypt {
+			return nil
+		}
+		enc, err := sopsEncryptValue(w.key, plain, typ, strings.Join(path, ":")+":")
+		if err != nil {
+			return err
+		}
+		node.Kind, node.Tag, node.Value, node.Style = yaml.ScalarNode, "!!str", enc, 0
+	default:
+		return fmt.Errorf("%s: yaml anchors and aliases are not supported", strings.Join(path, "."))
+	}
+	return nil
+}
+
+func (w *sopsWalker) decrypt(node *yaml.Node, path []string) error {
+	// comments are encrypted by sops and cannot be kept
+	if strings.Contains(node.HeadComment+node.LineComment+node.FootComment, "ENC[AES256_GCM") {
+		node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := w.decrypt(node.Content[i+1], append(path, node.Content[i].Value)); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, c := range node.Content {
+			if err := w.decrypt(c, path); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() == "!!null" {
+			return nil
+		}
+		encrypted := sopsValueRe.MatchString(node.Value)
+		if encrypted {
+			plain, typ, err := sopsDecryptValue(w.key, node.Value, strings.Join(path, ":")+":")
+			if err != nil {
+				return fmt.Errorf("cannot decrypt %s: %v", strings.Join(path, "."), err)
+			}
+			node.Value, node.Style = plain, 0
+			node.Tag = map[string]string{"str": "!!str", "int": "!!int", "float": "!!float", "bool": "!!bool"}[typ]
+			if node.Tag == "" {
+				return fmt.Errorf("%s: unsupported value type %s", strings.Join(path, "."), typ)
+			}
+		}
+		_, macBytes, _, err := sopsPlainValue(node)
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+		}
+		if encrypted || !w.macOnlyEncrypted {
+			_, _ = w.mac.Write(macBytes)
+		}
+	default:
+		return fmt.Errorf("%s: yaml anchors and aliases are not supported", strings.Join(path, "."))
+	}
+	return nil
+}
+
+// sopsPlainValue returns the encryption plaintext, the MAC bytes and the sops type of a scalar
+func sopsPlainValue(node *yaml.Node) (plain string, macBytes []byte, typ string, err error) {
+	switch node.ShortTag() {
+	case "!!int":
+		var i int64
+		if i, err = strconv.ParseInt(strings.ReplaceAll(node.Value, "_", ""), 0, 64); err != nil {
+			return
+		}
+		plain, typ = strconv.FormatInt(i, 10), "int"
+		macBytes = []byte(plain)
+	case "!!float":
+		var f float64
+		if f, err = strconv.ParseFloat(node.Value, 64); err != nil {
+			return
+		}
+		plain, typ = strconv.FormatFloat(f, 'f', -1, 64), "float"
+		macBytes = []byte(plain)
+	case "!!bool":
+		var b bool
+		if err = node.Decode(&b); err != nil {
+			return
+		}
+		// sops hashes booleans in python notation
+		plain, typ = strconv.FormatBool(b), "bool"
+		macBytes = []byte(map[bool]string{true: "True", false: "False"}[b])
+	default:
+		plain, typ = node.Value, "str"
+		macBytes = []byte(plain)
+	}
+	return
+}
+
+// sopsEncryptValue encrypts a value with AES256-GCM, the key path is authenticated as additional data
+func sopsEncryptValue(key []byte, plain string, typ string, aad string) (string, error) {
+	if typ == "str" && plain == "" {
+		return "", nil
+	}
+	gcm, err := sopsGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, 32)
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plain), []byte(aad))
+	n := len(sealed) - gcm.Overhead()
+	enc := base64.StdEncoding
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		enc.EncodeToString(sealed[:n]), enc.EncodeToString(iv), enc.EncodeToString(sealed[n:]), typ), nil
+}
+
+func sopsDecryptValue(key []byte, value string, aad string) (plain string, typ string, err error) {
+	m := sopsValueRe.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", errors.New("invalid sops value")
+	}
+	enc := base64.StdEncoding
+	data, err1 := enc.DecodeString(m[1])
+	iv, err2 := enc.DecodeString(m[2])
+	tag, err3 := enc.DecodeString(m[3])
+	if err = errors.Join(err1, err2, err3); err != nil {
+		return "", "", fmt.Errorf("invalid sops value encoding: %v", err)
+	}
+	gcm, err := sopsGCM(key)
+	if err != nil {
+		return
+	}
+	if len(iv) != gcm.NonceSize() {
+		return "", "", errors.New("invalid sops iv size")
+	}
+	p, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		return "", "", errors.New("authentication failed")
+	}
+	return string(p), m[4], nil
+}
+
+func sopsGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, 32)
+}
+
+// metadata wraps the data key for all master keys and creates the sops block with the encrypted MAC
+func (w *sopsWalker) metadata(ctx context.Context, cfg *SopsConfig) (*yaml.Node, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	meta := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	var kmsEntries, ageEntries, pgpEntries []*yaml.Node
+	for _, arn := range cfg.KMSKeyARNs {
+		svc, err := cfg.kmsClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out, err := withRetry(ctx, "Encrypt", func(ctx context.Context) (*kms.EncryptOutput, error) {
+			return svc.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String(arn), Plaintext: w.key}, kmsSingleAttempt)
+		})
+		if err != nil {
+			return nil, checkOperationError(err)
+		}
+		kmsEntries = append(kmsEntries, sopsMap("arn", arn, "created_at", now,
+			"enc", base64.StdEncoding.EncodeToString(out.CiphertextBlob), "aws_profile", ""))
+	}
+	for _, r := range cfg.AgeRecipients {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %s: %v", r, err)
+		}
+		buf := new(bytes.Buffer)
+		aw := ageArmor.NewWriter(buf)
+		ew, err := age.Encrypt(aw, recipient)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = ew.Write(w.key); err != nil {
+			return nil, err
+		}
+		if err = ew.Close(); err != nil {
+			return nil, err
+		}
+		if err = aw.Close(); err != nil {
+			return nil, err
+		}
+		ageEntries = append(ageEntries, sopsMap("recipient", recipient.String(), "enc", buf.String()))
+	}
+	for _, f := range cfg.PGPPublicKeyFiles {
+		pubKeys, err := common.ReadFileToString(f)
+		if err != nil {
+			return nil, err
+		}
+		entityList, err := GPGReadAmoredKeyRing(pubKeys)
+		if err != nil {
+			return nil, err
+		}
+		enc, err := gpgEncryptArmored(w.key, entityList[:1])
+		if err != nil {
+			return nil, fmt.Errorf("pgp encryption for %s failed: %v", f, err)
+		}
+		fp := strings.ToUpper(hex.EncodeToString(entityList[0].PrimaryKey.Fingerprint))
+		pgpEntries = append(pgpEntries, sopsMap("created_at", now, "enc", enc, "fp", fp))
+	}
+	if len(kmsEntries)+len(ageEntries)+len(pgpEntries) == 0 {
+		return nil, errors.New("no master keys configured")
+	}
+	mac := strings.ToUpper(hex.EncodeToString(w.mac.Sum(nil)))
+	encMac, err := sopsEncryptValue(w.key, mac, "str", now)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range []struct {
+		name    string
+		entries []*yaml.Node
+	}{{"kms", kmsEntries}, {"age", ageEntries}} {
+		if len(e.entries) > 0 {
+			meta.Content = append(meta.Content, sopsScalar(e.name), &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: e.entries})
+		}
+	}
+	meta.Content = append(meta.Content, sopsScalar("lastmodified"), sopsScalar(now), sopsScalar("mac"), sopsScalar(encMac))
+	if len(pgpEntries) > 0 {
+		meta.Content = append(meta.Content, sopsScalar("pgp"), &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: pgpEntries})
+	}
+	if cfg.EncryptedRegex != "" {
+		meta.Content = append(meta.Content, sopsScalar("encrypted_regex"), sopsScalar(cfg.EncryptedRegex))
+	} else {
+		meta.Content = append(meta.Content, sopsScalar("unencrypted_suffix"), sopsScalar(w.suffix))
+	}
+	meta.Content = append(meta.Content, sopsScalar("version"), sopsScalar(SopsVersion))
+	return meta, nil
+}
+
+func (w *sopsWalker) verifyMAC(meta *yaml.Node) error {
+	lastModified := sopsString(meta, "lastmodified")
+	encMac := sopsString(meta, "mac")
+	if encMac == "" {
+		return errors.New("document has no MAC")
+	}
+	stored, _, err := sopsDecryptValue(w.key, encMac, lastModified)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt MAC: %v", err)
+	}
+	mac := strings.ToUpper(hex.EncodeToString(w.mac.Sum(nil)))
+	if stored != mac {
+		return errors.New("MAC mismatch, document has been modified")
+	}
+	return nil
+}
+
+// sopsDataKey unwraps the data key with the first master key that works
+func sopsDataKey(ctx context.Context, meta *yaml.Node, cfg *SopsConfig) ([]byte, error) {
+	var errs []error
+	if cfg.AgeIdentityFile != "" {
+		identities, err := readAgeIdentities(cfg.AgeIdentityFile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, e := range sopsEntries(meta, "age") {
+			if identities == nil {
+				break
+			}
+			r, err := age.Decrypt(ageArmor.NewReader(strings.NewReader(sopsString(e, "enc"))), identities...)
+			if err == nil {
+				var key []byte
+				if key, err = io.ReadAll(r); err == nil {
+					return key, nil
+				}
+			}
+			errs = append(errs, fmt.Errorf("age %s: %v", sopsString(e, "recipient"), err))
+		}
+	}
+	if cfg.PGPSecretKeyFile != "" {
+		entityList, err := loadGPGKeyRing(cfg.PGPSecretKeyFile, cfg.PGPKeyPass)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, e := range sopsEntries(meta, "pgp") {
+			if entityList == nil {
+				break
+			}
+			block, err := armor.Decode(strings.NewReader(sopsString(e, "enc")))
+			if err == nil {
+				var msg []byte
+				if msg, err = io.ReadAll(block.Body); err == nil {
+					var key string
+					if key, err = gpgDecryptWithKeyRing(string(msg), entityList); err == nil {
+						return []byte(key), nil
+					}
+				}
+			}
+			errs = append(errs, fmt.Errorf("pgp %s: %v", sopsString(e, "fp"), err))
+		}
+	}
+	for _, e := range sopsEntries(meta, "kms") {
+		arn := sopsString(e, "arn")
+		blob, err := base64.StdEncoding.DecodeString(sopsString(e, "enc"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("kms %s: %v", arn, err))
+			continue
+		}
+		input := &kms.DecryptInput{KeyId: aws.String(arn), CiphertextBlob: blob}
+		if ec := sopsMapValue(e, "context"); ec != nil && ec.Kind == yaml.MappingNode {
+			input.EncryptionContext = map[string]string{}
+			for i := 0; i+1 < len(ec.Content); i += 2 {
+				input.EncryptionContext[ec.Content[i].Value] = ec.Content[i+1].Value
+			}
+		}
+		svc, err := cfg.kmsClient(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("kms %s: %v", arn, err))
+			break
+		}
+		out, err := withRetry(ctx, "Decrypt", func(ctx context.Context) (*kms.DecryptOutput, error) {
+			return svc.Decrypt(ctx, input, kmsSingleAttempt)
+		})
+		if err == nil {
+			return out.Plaintext, nil
+		}
+		errs = append(errs, fmt.Errorf("kms %s: %v", arn, checkOperationError(err)))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no usable master key for this document")
+	}
+	return nil, fmt.Errorf("cannot decrypt data key: %v", errors.Join(errs...))
+}
+
+// sopsParse parses YAML or JSON into the root mapping node
+func sopsParse(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse document: %v", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("document root must be a map")
+	}
+	root := doc.Content[0]
+	// flow style of JSON input would be kept in YAML output
+	sopsBlockStyle(root)
+	return root, nil
+}
+
+func sopsBlockStyle(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		node.Style &^= yaml.FlowStyle
+	}
+	for _, c := range node.Content {
+		sopsBlockStyle(c)
+	}
+}
+
+func sopsMarshal(root *yaml.Node, format string) ([]byte, error) {
+	if format == SopsFormatJSON {
+		buf := new(bytes.Buffer)
+		if err := sopsWriteJSON(buf, root, ""); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+		return buf.Bytes(), nil
+	}
+	buf := new(bytes.Buffer)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(4)
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	_ = enc.Close()
+	return buf.Bytes(), nil
+}
+
+// sopsWriteJSON writes the node tree as JSON keeping the key order
+func sopsWriteJSON(buf *bytes.Buffer, node *yaml.Node, indent string) error {
+	inner := indent + "\t"
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			buf.WriteString(inner)
+			sopsWriteJSONString(buf, node.Content[i].Value)
+			buf.WriteString(": ")
+			if err := sopsWriteJSON(buf, node.Content[i+1], inner); err != nil {
+				return err
+			}
+			if i+2 < len(node.Content) {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, c := range node.Content {
+			buf.WriteString(inner)
+			if err := sopsWriteJSON(buf, c, inner); err != nil {
+				return err
+			}
+			if i+1 < len(node.Content) {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			buf.WriteString("null")
+		case "!!int", "!!float", "!!bool":
+			plain, _, _, err := sopsPlainValue(node)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(plain)
+		default:
+			sopsWriteJSONString(buf, node.Value)
+		}
+	default:
+		return errors.New("yaml anchors and aliases are not supported")
+	}
+	return nil
+}
+
+func sopsWriteJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	// Encode appends a newline
+	buf.Truncate(buf.Len() - 1)
+}
+
+func sopsScalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// sopsMap creates a mapping node from key value pairs
+func sopsMap(kv ...string) *yaml.Node {
+	m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for i := 0; i+1 < len(kv); i += 2 {
+		m.Content = append(m.Content, sopsScalar(kv[i]), sopsScalar(kv[i+1]))
+	}
+	return m
+}
+
+func sopsMapValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func sopsString(m *yaml.Node, key string) string {
+	if v := sopsMapValue(m, key); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
+
+func sopsEntries(m *yaml.Node, key string) []*yaml.Node {
+	if v := sopsMapValue(m, key); v != nil && v.Kind == yaml.SequenceNode {
+		return v.Content
+	}
+	return nil
+}
+
+func sopsDeleteKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
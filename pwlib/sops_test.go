@@ -0,0 +1,259 @@
+package pwlib
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+	"gopkg.in/yaml.v3"
+)
+
+const sopsPlainYAML = `# database settings
+database:
+    host: db.example.com
+    port: 5432
+    password: s3cret
+    ratio: 0.75
+    enabled: true
+    empty: ""
+    nothing: null
+    description_unencrypted: visible
+servers:
+    - alpha
+    - beta
+`
+
+const sopsPlainJSON = `{
+	"api": {"token": "abc<>&", "retries": 3},
+	"list": [1, "two", false]
+}`
+
+// sopsFixtureAgeKey is a test only identity, the fixtures below were created with sops 3.10.2:
+// sops encrypt --age <recipient> plain.yaml
+const sopsFixtureAgeKey = "AGE-SECRET-KEY-14XK6TXFX4RFD55CGG0Q3DSDWW77NR2ZPHGP2R7WF0SE7RENJF38S3M6H07"
+
+const sopsFixtureYAML = `database:
+    host: ENC[AES256_GCM,data:6+2MuUWscBMsBHZvvLA=,iv:uTPlysBx1QDORa0ZUkD6AZ+8eEkBXiDiz9BaUWn0Ojo=,tag:kE8dkPYR5MviWbLL2U4NVg==,type:str]
+    port: ENC[AES256_GCM,data:Js51YQ==,iv:OHqhXw5UIELhnYHaZhbBhdRHwUk0E1HvLJLLxL0rEJw=,tag:eoZupgTLIXjeSTPA8pUYVg==,type:int]
+    password: ENC[AES256_GCM,data:fDibLh7r,iv:h/KI/pbmp+XEko/lDiEgJeDxNIIze+/I/oAtSC+6krc=,tag:z1emfC9JX8syHK04vTNkVw==,type:str]
+    ratio: ENC[AES256_GCM,data:SUKaCw==,iv:RD5sw0crS7Tn9SAkrVGQVFqlgFavmZhPTByanPhJu8U=,tag:mdcIwO4NU5aYHazbTTCY7Q==,type:float]
+    enabled: ENC[AES256_GCM,data:kQ5nHQ==,iv:WoIKdrN8FFIWKVzXVmfE28HB2JXUpF7cFSGkTtHjla0=,tag:490frm6OuVOBRUr433JBDw==,type:bool]
+    description_unencrypted: visible
+servers:
+    - ENC[AES256_GCM,data:NJNSaNE=,iv:vRh9xhMwWWfhw1IgcMgsrxnbcyBubwdZXjojy4GzXjY=,tag:nNSXFk9lG2vRI5VAyP8oCQ==,type:str]
+    - ENC[AES256_GCM,data:dPf9kA==,iv:xp8LoyA2b3hiO7ExzXee0uEju3WA+tI06stiUkm4KcU=,tag:HgVx+g53ETcffReREGk6Bg==,type:str]
+sops:
+    age:
+        - recipient: age1mdvyp2dywsx4w4gfdm88820nsurw7w3kxq6vcw7yw4f7nkgnxywsmp6rsc
+          enc: |
+            -----BEGIN AGE ENCRYPTED FILE-----
+            YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSB1ZWVaME16Rk5KVGVQbU9F
+            OXVpVkh1RkFET3RpS3RQYUhoZ04vdUt6d0FFCkY1d3dEcE9DRmk4NHNvaHNXUXV0
+            bUdIZzlYeUVOdmhwa0lHeWI2aDJEMEUKLS0tIGNoRlIxckZCSWx6aS9rVEdZMWht
+            K3M0VTdNaFpGS2gxUW9LeG8vb3B0TzgKSe6zNOusEQEKiw5U6zwi2GOSgAPVdBa9
+            OuKl1uBGzj0AtSSleum2lVHsyZf5CNxzVm7+aW6AT+BCipuhDVAmIQ==
+            -----END AGE ENCRYPTED FILE-----
+    lastmodified: "2026-10-19T03:29:28Z"
+    mac: ENC[AES256_GCM,data:WsVn4SKLWrE673q3ZXw58oKHsKRuo0I29K+Hz5NamZ1G7Tpd8Ojp/Z8qCzI/fmfbGfBEptFcYSQLID9dY+LwZc71F3LXbQcryMKQcwjFz5SO6I/l0Z29QBSHl+vImHPZraCtBDl9lOxQ4oAYg4syxbqOVu7xyOPVuAGy9ZZWhKI=,iv:vnpfB47kEVOQ62Fqzt5PRYvTsNPLvZxOEnd+BBGhK8E=,tag:jh+2eyfps0w5Nl9eQ3i9rQ==,type:str]
+    unencrypted_suffix: _unencrypted
+    version: 3.10.2
+`
+
+const sopsFixtureJSON = `{
+	"api": {
+		"token": "ENC[AES256_GCM,data:RYvnZ3lP,iv:EXLjNa+RcRiNBjMCqSOLruvpHrqZjhPp9tnPP9dbXwU=,tag:Ii2p1j1M7PRWgZK1EvHavA==,type:str]",
+		"retries": "ENC[AES256_GCM,data:fQ==,iv:0rC8n/udnEX8A9PzTFPrh3+k8GM9KAgwVNs2uike2EA=,tag:jj84jvuOuFNpMfJwWP/K/Q==,type:float]"
+	},
+	"list": [
+		"ENC[AES256_GCM,data:hQ==,iv:74dHEYkvQHbiBZxAdnMFaHmuY3JVcPYxpdYL05k0vyw=,tag:EOYMGJX3H0l6weYBVxMo7A==,type:float]",
+		"ENC[AES256_GCM,data:2Q+n,iv:qEMj/FtwWuBm4zE8miwx6MgAZmsL19tfx7Zuv+fMuz8=,tag:jQdVvnDhIwoR9FlJ40ulBw==,type:str]",
+		"ENC[AES256_GCM,data:L2f2414=,iv:aFQB4dTZJXsEU9fuZjCY+KznimiHEkaD5B9gt3LbhdM=,tag:IFOTVlE+xmLOyUHCyxwtdA==,type:bool]"
+	],
+	"sops": {
+		"age": [
+			{
+				"recipient": "age1mdvyp2dywsx4w4gfdm88820nsurw7w3kxq6vcw7yw4f7nkgnxywsmp6rsc",
+				"enc": "-----BEGIN AGE ENCRYPTED FILE-----\nYWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBINnJSdTV2Y0NKYkU4TzEy\ncXFXK0VKTDF5RUFEUVRtZy84UWZDZTB3NzM4Cit6L2wzNkJiUHZ5ZGRIVDlydEhM\nU1RKZkc1WnRZWE9CN2ZkdzA0WFhSY00KLS0tIHA0Nzc2SGZ1MlFPc1dqeXI2OXRa\nVFAwcmdVb0dTRDhhMDRkdHlBM2FlRzQKB1w4KJmDBvhsDp9WL93RAgi7g/whs8Op\nTA8U8b9ywhcbDft63shrb5O0qF0VBTzuV5niEfSQacTlmuWAjs29ow==\n-----END AGE ENCRYPTED FILE-----\n"
+			}
+		],
+		"lastmodified": "2026-10-19T03:29:28Z",
+		"mac": "ENC[AES256_GCM,data:oE1axo6toW/VDIh0cJbNVueU5+9uFd0rkWSPVp/k7mj1QqwbmA+eUKNFTKLZf0vbhLYMb1GPh4nUtWaH5HdkQkYEbQNSEJ5743UdaFfk5od7KT32n55neLJZ/k/g8SX6x1WOQnxuRBCvHZYAjIuKy+3r40h5j+mokORCkZUBnvk=,iv:jfN21h1vVsNdTYqfutxV7lbYXGik7ms9JsURPz/g+BY=,tag:mLf13+wWh9Cxxf5URCc9wg==,type:str]",
+		"unencrypted_suffix": "_unencrypted",
+		"version": "3.10.2"
+	}
+}
+`
+
+func TestSops(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	agePub := path.Join(test.TestData, "test_sops"+pubAgeExt)
+	agePriv := path.Join(test.TestData, "test_sops"+privAgeExt)
+	identity, recipient, err := CreateAgeIdentity()
+	require.NoError(t, err)
+	require.NoError(t, ExportAgeKeyPair(identity, agePub, agePriv))
+	gpgPub := path.Join(test.TestData, "test_sops"+pubGPGExt)
+	gpgPriv := path.Join(test.TestData, "test_sops"+privGPGExt)
+	entity, _, err := CreateGPGEntity(testGPGName, "TestSops", testGPGEmail, "sops")
+	require.NoError(t, err)
+	require.NoError(t, ExportGPGKeyPair(entity, gpgPub, gpgPriv))
+
+	encCfg := &SopsConfig{AgeRecipients: []string{recipient}, PGPPublicKeyFiles: []string{gpgPub}}
+	var encrypted []byte
+	t.Run("Encrypt YAML", func(t *testing.T) {
+		encrypted, err = SopsEncrypt([]byte(sopsPlainYAML), SopsFormatYAML, encCfg)
+		require.NoError(t, err)
+		content := string(encrypted)
+		assert.NotContains(t, content, "s3cret")
+		assert.NotContains(t, content, "database settings", "comments must not stay in clear")
+		assert.Contains(t, content, "description_unencrypted: visible")
+		assert.Contains(t, content, "type:int]")
+		assert.Contains(t, content, "type:float]")
+		assert.Contains(t, content, "type:bool]")
+		assert.Contains(t, content, "-----BEGIN AGE ENCRYPTED FILE-----")
+		assert.Contains(t, content, "-----BEGIN PGP MESSAGE-----")
+		var doc map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(encrypted, &doc))
+		meta := doc["sops"].(map[string]interface{})
+		assert.Equal(t, SopsVersion, meta["version"])
+		assert.Equal(t, SopsDefaultUnencryptedSuffix, meta["unencrypted_suffix"])
+		assert.True(t, strings.HasPrefix(meta["mac"].(string), "ENC[AES256_GCM,"))
+		assert.Equal(t, recipient, meta["age"].([]interface{})[0].(map[string]interface{})["recipient"])
+		assert.Len(t, meta["pgp"].([]interface{})[0].(map[string]interface{})["fp"], 40)
+		db := doc["database"].(map[string]interface{})
+		assert.Equal(t, "", db["empty"])
+		assert.Nil(t, db["nothing"])
+		_, err = SopsEncrypt(encrypted, SopsFormatYAML, encCfg)
+		assert.ErrorContains(t, err, "already encrypted")
+		_, err = SopsEncrypt([]byte(sopsPlainYAML), SopsFormatYAML, &SopsConfig{})
+		assert.ErrorContains(t, err, "no master keys")
+	})
+	t.Run("Decrypt YAML", func(t *testing.T) {
+		for name, cfg := range map[string]*SopsConfig{
+			"age": {AgeIdentityFile: agePriv},
+			"pgp": {PGPSecretKeyFile: gpgPriv, PGPKeyPass: "sops"},
+		} {
+			plain, e := SopsDecrypt(encrypted, SopsFormatYAML, cfg)
+			require.NoErrorf(t, e, "decrypt with %s failed", name)
+			var got, want map[string]interface{}
+			require.NoError(t, yaml.Unmarshal(plain, &got))
+			require.NoError(t, yaml.Unmarshal([]byte(sopsPlainYAML), &want))
+			assert.Equalf(t, want, got, "decrypted with %s differs", name)
+			assert.NotContains(t, string(plain), "sops:")
+		}
+		_, e := SopsDecrypt(encrypted, SopsFormatYAML, &SopsConfig{})
+		assert.ErrorContains(t, e, "no usable master key")
+		_, e = SopsDecrypt([]byte(sopsPlainYAML), SopsFormatYAML, &SopsConfig{})
+		assert.ErrorContains(t, e, "no sops metadata")
+	})
+	t.Run("Tampered", func(t *testing.T) {
+		cfg := &SopsConfig{AgeIdentityFile: agePriv}
+		// changing a clear value breaks the MAC
+		tampered := strings.Replace(string(encrypted), "visible", "changed", 1)
+		_, e := SopsDecrypt([]byte(tampered), SopsFormatYAML, cfg)
+		assert.ErrorContains(t, e, "MAC mismatch")
+		cfg.IgnoreMAC = true
+		_, e = SopsDecrypt([]byte(tampered), SopsFormatYAML, cfg)
+		assert.NoError(t, e)
+		// moving an encrypted value to another key fails authentication
+		var doc yaml.Node
+		require.NoError(t, yaml.Unmarshal(encrypted, &doc))
+		db := sopsMapValue(doc.Content[0], "database")
+		host := sopsMapValue(db, "host")
+		host.Value = sopsMapValue(db, "password").Value
+		moved, _ := yaml.Marshal(&doc)
+		_, e = SopsDecrypt(moved, SopsFormatYAML, cfg)
+		assert.ErrorContains(t, e, "database.host")
+	})
+	t.Run("JSON File", func(t *testing.T) {
+		plainFile := path.Join(test.TestData, "test_sops.json")
+		encFile := path.Join(test.TestData, "test_sops.enc.json")
+		require.NoError(t, common.WriteStringToFile(plainFile, sopsPlainJSON))
+		require.NoError(t, SopsEncryptFile(plainFile, encFile, &SopsConfig{AgeRecipients: []string{recipient}}))
+		content, _ := common.ReadFileToString(encFile)
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(content), &doc), "encrypted file must be JSON")
+		assert.NotContains(t, content, "abc")
+		assert.Contains(t, doc, "sops")
+		plain, e := SopsDecryptFile(encFile, &SopsConfig{AgeIdentityFile: agePriv})
+		require.NoError(t, e)
+		var got, want interface{}
+		require.NoError(t, json.Unmarshal(plain, &got))
+		require.NoError(t, json.Unmarshal([]byte(sopsPlainJSON), &want))
+		assert.Equal(t, want, got)
+		assert.Contains(t, string(plain), `"abc<>&"`)
+	})
+	t.Run("Encrypted Regex", func(t *testing.T) {
+		enc, e := SopsEncrypt([]byte(sopsPlainYAML), SopsFormatYAML, &SopsConfig{AgeRecipients: []string{recipient}, EncryptedRegex: "^password$"})
+		require.NoError(t, e)
+		content := string(enc)
+		assert.Contains(t, content, "host: db.example.com")
+		assert.NotContains(t, content, "s3cret")
+		assert.Contains(t, content, "encrypted_regex: ^password$")
+		_, e = SopsDecrypt(enc, SopsFormatYAML, &SopsConfig{AgeIdentityFile: agePriv})
+		assert.NoError(t, e)
+	})
+	t.Run("Sops Fixture", func(t *testing.T) {
+		keyFile := path.Join(test.TestData, "test_sops_fixture"+privAgeExt)
+		require.NoError(t, common.WriteStringToFile(keyFile, sopsFixtureAgeKey+"\n"))
+		cfg := &SopsConfig{AgeIdentityFile: keyFile}
+		plain, e := SopsDecrypt([]byte(sopsFixtureYAML), SopsFormatYAML, cfg)
+		require.NoError(t, e, "document of sops should decrypt with a valid MAC")
+		var doc map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(plain, &doc))
+		db := doc["database"].(map[string]interface{})
+		assert.Equal(t, "s3cret", db["password"])
+		assert.Equal(t, 5432, db["port"])
+		assert.Equal(t, 0.75, db["ratio"])
+		assert.Equal(t, true, db["enabled"])
+		assert.Equal(t, []interface{}{"alpha", "beta"}, doc["servers"])
+		tampered := strings.Replace(sopsFixtureYAML, "visible", "changed", 1)
+		_, e = SopsDecrypt([]byte(tampered), SopsFormatYAML, cfg)
+		assert.ErrorContains(t, e, "MAC mismatch")
+
+		plain, e = SopsDecrypt([]byte(sopsFixtureJSON), SopsFormatJSON, cfg)
+		require.NoError(t, e)
+		var got, want interface{}
+		require.NoError(t, json.Unmarshal(plain, &got))
+		require.NoError(t, json.Unmarshal([]byte(sopsPlainJSON), &want))
+		assert.Equal(t, want, got)
+	})
+	t.Run("Sops Binary", func(t *testing.T) {
+		sops, e := exec.LookPath("sops")
+		if e != nil {
+			t.Skip("sops binary not found")
+		}
+		t.Setenv("SOPS_AGE_KEY_FILE", agePriv)
+		encFile := path.Join(test.TestData, "test_sops_binary.enc.yaml")
+		enc, e := SopsEncrypt([]byte(sopsPlainYAML), SopsFormatYAML, &SopsConfig{AgeRecipients: []string{recipient}})
+		require.NoError(t, e)
+		require.NoError(t, common.WriteStringToFile(encFile, string(enc)))
+		//nolint gosec
+		out, e := exec.Command(sops, "decrypt", encFile).Output()
+		require.NoErrorf(t, e, "sops cannot decrypt our document")
+		var got, want map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(out, &got))
+		require.NoError(t, yaml.Unmarshal([]byte(sopsPlainYAML), &want))
+		assert.Equal(t, want, got)
+
+		plainFile := path.Join(test.TestData, "test_sops_binary.yaml")
+		require.NoError(t, common.WriteStringToFile(plainFile, sopsPlainYAML))
+		//nolint gosec
+		out, e = exec.Command(sops, "encrypt", "--age", recipient, plainFile).Output()
+		require.NoErrorf(t, e, "sops encrypt failed")
+		plain, e := SopsDecrypt(out, SopsFormatYAML, &SopsConfig{AgeIdentityFile: agePriv})
+		require.NoError(t, e, "document of sops should decrypt")
+		got = nil
+		require.NoError(t, yaml.Unmarshal(plain, &got))
+		assert.Equal(t, want, got)
+	})
+}
@@ -0,0 +1,322 @@
+package pwlib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSSHCertValidity is the validity of certificates without explicit end
+var DefaultSSHCertValidity = 8 * time.Hour
+
+// DefaultSSHUserExtensions are the extensions of user certificates if none are given, like ssh-keygen does
+var DefaultSSHUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// SSHCertOptions define the content of a certificate
+type SSHCertOptions struct {
+	// KeyID identifies the certificate in server logs
+	KeyID string
+	// Serial is the certificate serial, 0 creates a random serial
+	Serial uint64
+	// Principals are the user names or host names the certificate is valid for
+	Principals []string
+	// ValidAfter defaults to now minus 5 minutes to tolerate clock skew
+	ValidAfter time.Time
+	// ValidBefore defaults to ValidAfter plus Validity
+	ValidBefore time.Time
+	// Validity is used if ValidBefore is not set, 0 uses DefaultSSHCertValidity
+	Validity time.Duration
+	// CriticalOptions like force-command or source-address
+	CriticalOptions map[string]string
+	// Extensions like permit-pty, nil uses DefaultSSHUserExtensions for user certificates
+	Extensions map[string]string
+}
+
+// SSHPublicKeyFromFile reads a RSA or ECDSA PEM public key and returns it as ssh public key
+func SSHPublicKeyFromFile(publicKeyFile string) (pub ssh.PublicKey, err error) {
+	var key crypto.PublicKey
+	keyType, err := GetKeyTypeFromFile(publicKeyFile)
+	if err != nil {
+		return
+	}
+	switch keyType {
+	case KeyTypeRSA:
+		key, err = GetPublicKeyFromFile(publicKeyFile)
+	case KeyTypeECDSA:
+		key, err = GetEcdsaPublicKeyFromFile(publicKeyFile)
+	default:
+		return nil, fmt.Errorf("key type %s not supported for ssh", keyType)
+	}
+	if err == nil && key == nil {
+		err = fmt.Errorf("cannot load public key from %s", publicKeyFile)
+	}
+	if err != nil {
+		return
+	}
+	return ssh.NewPublicKey(key)
+}
+
+// SSHSignerFromFile reads a RSA or ECDSA PEM private key and returns a ssh signer, RSA keys sign with rsa-sha2-512
+func SSHSignerFromFile(privateKeyFile string, keyPass string) (signer ssh.Signer, err error) {
+	var key crypto.Signer
+	keyType, err := GetKeyTypeFromFile(privateKeyFile)
+	if err != nil {
+		return
+	}
+	switch keyType {
+	case KeyTypeRSA:
+		_, key, err = GetPrivateKeyFromFile(privateKeyFile, keyPass)
+	case KeyTypeECDSA:
+		_, key, err = GetEcdsaPrivateKeyFromFile(privateKeyFile, keyPass)
+	default:
+		return nil, fmt.Errorf("key type %s not supported for ssh", keyType)
+	}
+	if err == nil && key == nil {
+		err = fmt.Errorf("cannot load private key from %s", privateKeyFile)
+	}
+	if err != nil {
+		return
+	}
+	signer, err = ssh.NewSignerFromKey(key)
+	if err != nil {
+		return
+	}
+	if keyType == KeyTypeRSA {
+		// ssh-rsa with sha1 is rejected by current OpenSSH
+		signer, err = ssh.NewSignerWithAlgorithms(signer.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoRSASHA512})
+	}
+	return
+}
+
+// SSHAuthorizedKey returns the public key in authorized_keys format with optional comment
+func SSHAuthorizedKey(pub ssh.PublicKey, comment string) string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+// SSHAuthorizedKeyFromFile converts a RSA or ECDSA PEM public key file to authorized_keys format
+func SSHAuthorizedKeyFromFile(publicKeyFile string, comment string) (string, error) {
+	pub, err := SSHPublicKeyFromFile(publicKeyFile)
+	if err != nil {
+		return "", err
+	}
+	return SSHAuthorizedKey(pub, comment), nil
+}
+
+// SSHParseAuthorizedKey parses a public key or certificate in authorized_keys format
+func SSHParseAuthorizedKey(line string) (pub ssh.PublicKey, comment string, err error) {
+	pub, comment, _, _, err = ssh.ParseAuthorizedKey([]byte(line))
+	return
+}
+
+// SSHFingerprint returns the SHA256 fingerprint of a key as shown by ssh-keygen -l
+func SSHFingerprint(pub ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(pub)
+}
+
+// SSHFingerprintMD5 returns the legacy MD5 fingerprint of a key
+func SSHFingerprintMD5(pub ssh.PublicKey) string {
+	return ssh.FingerprintLegacyMD5(pub)
+}
+
+// SSHCA signs ssh user and host certificates
+type SSHCA struct {
+	Signer ssh.Signer
+}
+
+// NewSSHCA loads the CA private key (RSA or ECDSA)
+func NewSSHCA(privateKeyFile string, keyPass string) (*SSHCA, error) {
+	signer, err := SSHSignerFromFile(privateKeyFile, keyPass)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("ssh ca loaded with key %s", SSHFingerprint(signer.PublicKey()))
+	return &SSHCA{Signer: signer}, nil
+}
+
+// PublicKey returns the CA public key in authorized_keys format for TrustedUserCAKeys or @cert-authority entries
+func (ca *SSHCA) PublicKey() string {
+	return SSHAuthorizedKey(ca.Signer.PublicKey(), "")
+}
+
+// SignUserKey issues a user certificate for pub
+func (ca *SSHCA) SignUserKey(pub ssh.PublicKey, opts SSHCertOptions) (*ssh.Certificate, error) {
+	if opts.Extensions == nil {
+		opts.Extensions = maps.Clone(DefaultSSHUserExtensions)
+	}
+	return ca.sign(pub, ssh.UserCert, opts)
+}
+
+// SignHostKey issues a host certificate for pub
+func (ca *SSHCA) SignHostKey(pub ssh.PublicKey, opts SSHCertOptions) (*ssh.Certificate, error) {
+	return ca.sign(pub, ssh.HostCert, opts)
+}
+
+func (ca *SSHCA) sign(pub ssh.PublicKey, certType uint32, opts SSHCertOptions) (cert *ssh.Certificate, err error) {
+	if len(opts.Principals) == 0 {
+		return nil, errors.New("certificate needs at least one principal")
+	}
+	if opts.ValidAfter.IsZero() {
+		opts.ValidAfter = time.Now().Add(-5 * time.Minute)
+	}
+	if opts.ValidBefore.IsZero() {
+		if opts.Validity == 0 {
+			opts.Validity = DefaultSSHCertValidity
+		}
+		opts.ValidBefore = opts.ValidAfter.Add(opts.Validity)
+	}
+	if !opts.ValidBefore.After(opts.ValidAfter) {
+		return nil, errors.New("certificate validity ends before it starts")
+	}
+	if opts.Serial == 0 {
+		b := make([]byte, 8)
+		if _, err = rand.Read(b); err != nil {
+			return
+		}
+		opts.Serial = binary.BigEndian.Uint64(b)
+	}
+	cert = &ssh.Certificate{
+		Key:             pub,
+		Serial:          opts.Serial,
+		CertType:        certType,
+		KeyId:           opts.KeyID,
+		ValidPrincipals: opts.Principals,
+		ValidAfter:      uint64(opts.ValidAfter.Unix()),  //nolint gosec
+		ValidBefore:     uint64(opts.ValidBefore.Unix()), //nolint gosec
+		Permissions: ssh.Permissions{
+			CriticalOptions: opts.CriticalOptions,
+			Extensions:      opts.Extensions,
+		},
+	}
+	err = cert.SignCert(rand.Reader, ca.Signer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign certificate: %v", err)
+	}
+	log.Debugf("issued ssh certificate %s serial %d for %v", opts.KeyID, opts.Serial, opts.Principals)
+	return
+}
+
+// SSHMarshalCert returns the certificate in authorized_keys format as written to id_*-cert.pub files
+func SSHMarshalCert(cert *ssh.Certificate) string {
+	return SSHAuthorizedKey(cert, "")
+}
+
+// SSHParseCert parses a certificate in authorized_keys format
+func SSHParseCert(line string) (*ssh.Certificate, error) {
+	pub, _, err := SSHParseAuthorizedKey(line)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("key is not a certificate")
+	}
+	return cert, nil
+}
+
+// SSHVerifyUserCert checks that cert is a valid user certificate for principal signed by one of the CA keys,
+// certificates restricted by source-address need SSHVerifyUserCertFrom
+func SSHVerifyUserCert(cert *ssh.Certificate, caKeys []ssh.PublicKey, principal string) error {
+	return sshVerifyCert(cert, ssh.UserCert, caKeys, principal, time.Now(), nil)
+}
+
+// SSHVerifyUserCertAt checks a user certificate like SSHVerifyUserCert at the given time
+func SSHVerifyUserCertAt(cert *ssh.Certificate, caKeys []ssh.PublicKey, principal string, at time.Time) error {
+	return sshVerifyCert(cert, ssh.UserCert, caKeys, principal, at, nil)
+}
+
+// SSHVerifyUserCertFrom checks a user certificate like SSHVerifyUserCert for a client connecting from clientAddr
+func SSHVerifyUserCertFrom(cert *ssh.Certificate, caKeys []ssh.PublicKey, principal string, clientAddr net.IP) error {
+	return sshVerifyCert(cert, ssh.UserCert, caKeys, principal, time.Now(), clientAddr)
+}
+
+// SSHVerifyHostCert checks that cert is a valid host certificate for hostname signed by one of the CA keys
+func SSHVerifyHostCert(cert *ssh.Certificate, caKeys []ssh.PublicKey, hostname string) error {
+	return sshVerifyCert(cert, ssh.HostCert, caKeys, hostname, time.Now(), nil)
+}
+
+// SSHVerifyHostCertAt checks a host certificate like SSHVerifyHostCert at the given time
+func SSHVerifyHostCertAt(cert *ssh.Certificate, caKeys []ssh.PublicKey, hostname string, at time.Time) error {
+	return sshVerifyCert(cert, ssh.HostCert, caKeys, hostname, at, nil)
+}
+
+// sshCheckSourceAddress checks clientAddr against the comma separated addresses and CIDR ranges of a source-address option
+func sshCheckSourceAddress(sourceAddress string, clientAddr net.IP) error {
+	if clientAddr == nil {
+		return errors.New("certificate is restricted by source-address, client address required")
+	}
+	for _, entry := range strings.Split(sourceAddress, ",") {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return fmt.Errorf("invalid source-address %q", entry)
+			}
+			if ipNet.Contains(clientAddr) {
+				return nil
+			}
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return fmt.Errorf("invalid source-address %q", entry)
+		}
+		if ip.Equal(clientAddr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client address %s not allowed by source-address %s", clientAddr, sourceAddress)
+}
+
+func sshVerifyCert(cert *ssh.Certificate, certType uint32, caKeys []ssh.PublicKey, principal string, at time.Time, clientAddr net.IP) error {
+	if cert.CertType != certType {
+		return fmt.Errorf("certificate type %d is not %d", cert.CertType, certType)
+	}
+	trusted := func(auth ssh.PublicKey) bool {
+		for _, k := range caKeys {
+			if bytes.Equal(k.Marshal(), auth.Marshal()) {
+				return true
+			}
+		}
+		return false
+	}
+	if !trusted(cert.SignatureKey) {
+		return fmt.Errorf("certificate signed by untrusted key %s", SSHFingerprint(cert.SignatureKey))
+	}
+	checker := ssh.CertChecker{
+		SupportedCriticalOptions: []string{"force-command", "source-address", "verify-required"},
+		Clock:                    func() time.Time { return at },
+	}
+	// CheckCert validates principal, validity window, critical options and signature
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return err
+	}
+	// CheckCert accepts the supported options without evaluating them
+	if sourceAddress, ok := cert.CriticalOptions["source-address"]; ok {
+		if err := sshCheckSourceAddress(sourceAddress, clientAddr); err != nil {
+			return err
+		}
+	}
+	log.Debugf("ssh certificate %s valid for %s", cert.KeyId, principal)
+	return nil
+}
@@ -0,0 +1,166 @@
+package pwlib
+
+import (
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/test"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHCert(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	caPub := path.Join(test.TestData, "test_ssh_ca"+pubPemExt)
+	caPriv := path.Join(test.TestData, "test_ssh_ca"+privPemExt)
+	_, _, err = GenRsaKey(caPub, caPriv, "ca")
+	require.NoErrorf(t, err, "Prepare CA Key failed:%s", err)
+	userPub := path.Join(test.TestData, "test_ssh_user"+pubPemExt)
+	userPriv := path.Join(test.TestData, "test_ssh_user"+privPemExt)
+	_, _, err = GenEcdsaKey(userPub, userPriv, "user")
+	require.NoErrorf(t, err, "Prepare user Key failed:%s", err)
+	otherPub := path.Join(test.TestData, "test_ssh_other"+pubPemExt)
+	otherPriv := path.Join(test.TestData, "test_ssh_other"+privPemExt)
+	_, _, err = GenEcdsaKey(otherPub, otherPriv, "other")
+	require.NoErrorf(t, err, "Prepare other Key failed:%s", err)
+
+	var ca *SSHCA
+	var userKey ssh.PublicKey
+	t.Run("Authorized Key", func(t *testing.T) {
+		line, e := SSHAuthorizedKeyFromFile(userPub, "test@example.com")
+		require.NoError(t, e)
+		assert.True(t, strings.HasPrefix(line, "ecdsa-sha2-nistp256 "), "wrong key type: %s", line)
+		assert.True(t, strings.HasSuffix(line, " test@example.com"))
+		pub, comment, e := SSHParseAuthorizedKey(line)
+		require.NoError(t, e)
+		assert.Equal(t, "test@example.com", comment)
+		userKey = pub
+		assert.True(t, strings.HasPrefix(SSHFingerprint(pub), "SHA256:"))
+		assert.Len(t, strings.Split(SSHFingerprintMD5(pub), ":"), 16)
+		line, e = SSHAuthorizedKeyFromFile(caPub, "")
+		require.NoError(t, e)
+		assert.True(t, strings.HasPrefix(line, "ssh-rsa "))
+		_, e = SSHAuthorizedKeyFromFile(path.Join(test.TestData, "nonexisting.pub"), "")
+		assert.Error(t, e)
+	})
+	t.Run("New CA", func(t *testing.T) {
+		_, e := NewSSHCA(caPriv, "wrong")
+		assert.Error(t, e)
+		ca, e = NewSSHCA(caPriv, "ca")
+		require.NoError(t, e)
+		line, e := SSHAuthorizedKeyFromFile(caPub, "")
+		require.NoError(t, e)
+		assert.Equal(t, line, ca.PublicKey())
+	})
+	require.NotNil(t, ca)
+	require.NotNil(t, userKey)
+	caKeys := []ssh.PublicKey{ca.Signer.PublicKey()}
+
+	t.Run("User Certificate", func(t *testing.T) {
+		_, e := ca.SignUserKey(userKey, SSHCertOptions{KeyID: "test"})
+		assert.Error(t, e, "principal required")
+		cert, e := ca.SignUserKey(userKey, SSHCertOptions{
+			KeyID:           "test",
+			Principals:      []string{"alice", "admin"},
+			Validity:        time.Hour,
+			CriticalOptions: map[string]string{"source-address": "10.0.0.0/8"},
+		})
+		require.NoError(t, e)
+		assert.Equal(t, uint32(ssh.UserCert), cert.CertType)
+		assert.NotZero(t, cert.Serial)
+		assert.Contains(t, cert.Extensions, "permit-pty")
+		assert.Equal(t, ssh.SigAlgoRSASHA2512, cert.Signature.Format)
+
+		line := SSHMarshalCert(cert)
+		assert.True(t, strings.HasPrefix(line, "ecdsa-sha2-nistp256-cert-v01@openssh.com "))
+		parsed, e := SSHParseCert(line)
+		require.NoError(t, e)
+		assert.Equal(t, cert.Serial, parsed.Serial)
+		assert.Equal(t, "10.0.0.0/8", parsed.CriticalOptions["source-address"])
+
+		assert.ErrorContains(t, SSHVerifyUserCert(parsed, caKeys, "alice"), "client address required")
+		assert.NoError(t, SSHVerifyUserCertFrom(parsed, caKeys, "alice", net.ParseIP("10.1.2.3")))
+		assert.ErrorContains(t, SSHVerifyUserCertFrom(parsed, caKeys, "alice", net.ParseIP("192.168.1.1")), "not allowed by source-address")
+		assert.Error(t, SSHVerifyUserCertFrom(parsed, caKeys, "bob", net.ParseIP("10.1.2.3")), "wrong principal")
+		assert.Error(t, SSHVerifyHostCert(parsed, caKeys, "alice"), "wrong cert type")
+		other, e := SSHPublicKeyFromFile(otherPub)
+		require.NoError(t, e)
+		assert.ErrorContains(t, SSHVerifyUserCert(parsed, []ssh.PublicKey{other}, "alice"), "untrusted")
+		_, e = SSHParseCert(SSHAuthorizedKey(other, ""))
+		assert.Error(t, e, "plain key is no certificate")
+	})
+	t.Run("Host Certificate", func(t *testing.T) {
+		cert, e := ca.SignHostKey(userKey, SSHCertOptions{
+			KeyID:      "host",
+			Serial:     42,
+			Principals: []string{"host.example.com"},
+		})
+		require.NoError(t, e)
+		assert.Equal(t, uint64(42), cert.Serial)
+		assert.Empty(t, cert.Extensions)
+		assert.NoError(t, SSHVerifyHostCert(cert, caKeys, "host.example.com"))
+		assert.Error(t, SSHVerifyHostCert(cert, caKeys, "other.example.com"))
+	})
+	t.Run("Validity", func(t *testing.T) {
+		now := time.Now()
+		_, e := ca.SignUserKey(userKey, SSHCertOptions{
+			Principals:  []string{"alice"},
+			ValidAfter:  now,
+			ValidBefore: now.Add(-time.Hour),
+		})
+		assert.Error(t, e, "validity ends before start")
+		expired, e := ca.SignUserKey(userKey, SSHCertOptions{
+			Principals:  []string{"alice"},
+			ValidAfter:  now.Add(-2 * time.Hour),
+			ValidBefore: now.Add(-time.Hour),
+		})
+		require.NoError(t, e)
+		assert.ErrorContains(t, SSHVerifyUserCert(expired, caKeys, "alice"), "expired")
+		future, e := ca.SignUserKey(userKey, SSHCertOptions{
+			Principals: []string{"alice"},
+			ValidAfter: now.Add(time.Hour),
+		})
+		require.NoError(t, e)
+		assert.Error(t, SSHVerifyUserCert(future, caKeys, "alice"))
+		assert.NoError(t, SSHVerifyUserCertAt(future, caKeys, "alice", now.Add(2*time.Hour)))
+		unknown, e := ca.SignUserKey(userKey, SSHCertOptions{
+			Principals:      []string{"alice"},
+			CriticalOptions: map[string]string{"unknown-option": "x"},
+		})
+		require.NoError(t, e)
+		assert.Error(t, SSHVerifyUserCert(unknown, caKeys, "alice"), "unsupported critical option")
+		unrestricted, e := ca.SignUserKey(userKey, SSHCertOptions{Principals: []string{"alice"}})
+		require.NoError(t, e)
+		assert.NoError(t, SSHVerifyUserCert(unrestricted, caKeys, "alice"))
+	})
+	t.Run("Source Address", func(t *testing.T) {
+		for _, tc := range []struct {
+			list  string
+			addr  string
+			valid bool
+		}{
+			{"10.0.0.0/8", "10.255.0.1", true},
+			{"192.168.1.10, 10.0.0.0/8", "192.168.1.10", true},
+			{"192.168.1.10,2001:db8::/32", "2001:db8::1", true},
+			{"192.168.1.10", "192.168.1.11", false},
+			{"10.0.0.0/8", "::1", false},
+		} {
+			err := sshCheckSourceAddress(tc.list, net.ParseIP(tc.addr))
+			if tc.valid {
+				assert.NoErrorf(t, err, "%s in %s", tc.addr, tc.list)
+			} else {
+				assert.Errorf(t, err, "%s not in %s", tc.addr, tc.list)
+			}
+		}
+		assert.ErrorContains(t, sshCheckSourceAddress("10.0.0.0/33", net.ParseIP("10.0.0.1")), "invalid source-address")
+		assert.ErrorContains(t, sshCheckSourceAddress("host.example.com", net.ParseIP("10.0.0.1")), "invalid source-address")
+	})
+}
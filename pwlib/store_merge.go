@@ -0,0 +1,487 @@
+package pwlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/tommi2day/gomodules/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// merge strategies for conflicting records
+const (
+	// MergeFail reports conflicts and does not write a merged store
+	MergeFail = "fail"
+	// MergeOurs resolves conflicts with the local version
+	MergeOurs = "ours"
+	// MergeTheirs resolves conflicts with the other version
+	MergeTheirs = "theirs"
+)
+
+// ErrMergeConflict is returned if records were changed differently in both versions
+var ErrMergeConflict = errors.New("store merge has conflicts")
+
+// mergeMethods are the methods with a single crypted store file. Age stores are left out
+// as GetPassword reads one file per system and account, vault and gopass are not files
+var mergeMethods = []string{typeGO, typeOpenssl, typeEnc, typePlain, typeGPG, typeKMS}
+
+// mergeSessionMethods write a new session key file with every encryption, each store version needs its own
+var mergeSessionMethods = []string{typeOpenssl, typeKMS}
+
+// MergeStoreFile is a crypted store version to merge. SessionPassFile is the session key file written together
+// with the store by openssl and kms, empty uses pc.SessionPassFile, which only fits the current store
+type MergeStoreFile struct {
+	CryptedFile     string
+	SessionPassFile string
+}
+
+// MergeConflict describes a record changed differently in both versions, passwords are not part of the text output
+type MergeConflict struct {
+	System  string
+	Account string
+	// Kind is added/added, modified/modified, modified/deleted or deleted/modified (ours/theirs)
+	Kind     string
+	Base     *string
+	Ours     *string
+	Theirs   *string
+	Resolved string
+}
+
+// String returns the conflict without passwords
+func (c MergeConflict) String() string {
+	s := fmt.Sprintf("%s@%s: %s", c.Account, c.System, c.Kind)
+	if c.Resolved != "" {
+		s += ", resolved with " + c.Resolved
+	}
+	return s
+}
+
+// MergeResult holds the merged store lines and the changes relative to ours
+type MergeResult struct {
+	Lines     []string
+	Conflicts []MergeConflict
+	Added     int
+	Changed   int
+	Deleted   int
+}
+
+// Unresolved returns the number of conflicts without resolution
+func (r *MergeResult) Unresolved() (n int) {
+	for _, c := range r.Conflicts {
+		if c.Resolved == "" {
+			n++
+		}
+	}
+	return
+}
+
+type mergeRecord struct {
+	system   string
+	account  string
+	password string
+}
+
+// mergeIndex returns the records of a store by key, the first record of a key wins like in GetPassword
+func mergeIndex(lines []string, caseSensitive bool) (records map[string]mergeRecord, order []string) {
+	records = make(map[string]mergeRecord)
+	for _, line := range lines {
+		key, rec, ok := mergeParse(line, caseSensitive)
+		if !ok {
+			continue
+		}
+		if _, exists := records[key]; exists {
+			log.Debugf("duplicate record for %s@%s ignored", rec.account, rec.system)
+			continue
+		}
+		records[key] = rec
+		order = append(order, key)
+	}
+	return
+}
+
+func mergeParse(line string, caseSensitive bool) (key string, rec mergeRecord, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	if common.CheckSkip(line) {
+		return
+	}
+	fields := strings.SplitN(line, ":", 3)
+	if len(fields) != 3 {
+		return
+	}
+	rec = mergeRecord{fields[0], fields[1], fields[2]}
+	key = fields[0] + ":" + fields[1]
+	if !caseSensitive {
+		key = strings.ToLower(key)
+	}
+	return key, rec, true
+}
+
+func mergeValue(records map[string]mergeRecord, key string) *string {
+	if r, ok := records[key]; ok {
+		return &r.password
+	}
+	return nil
+}
+
+func mergeEqual(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func mergeConflictKind(base *string, ours *string, theirs *string) string {
+	state := func(v *string) string {
+		switch {
+		case v == nil:
+			return "deleted"
+		case base == nil:
+			return "added"
+		}
+		return "modified"
+	}
+	return state(ours) + "/" + state(theirs)
+}
+
+// MergeStoreLines performs a three-way merge of decrypted store lines on system:account records.
+// Comments and order are taken from ours, records added by theirs are appended
+func MergeStoreLines(base []string, ours []string, theirs []string, caseSensitive bool, strategy string) (*MergeResult, error) {
+	if !slices.Contains([]string{MergeFail, MergeOurs, MergeTheirs}, strategy) {
+		return nil, fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+	baseRecs, _ := mergeIndex(base, caseSensitive)
+	ourRecs, _ := mergeIndex(ours, caseSensitive)
+	theirRecs, theirOrder := mergeIndex(theirs, caseSensitive)
+	result := &MergeResult{}
+
+	// merged password per key, nil means deleted
+	merge := func(key string) *string {
+		b, o, t := mergeValue(baseRecs, key), mergeValue(ourRecs, key), mergeValue(theirRecs, key)
+		switch {
+		case mergeEqual(o, t), mergeEqual(b, t):
+			return o
+		case mergeEqual(b, o):
+			return t
+		}
+		rec := ourRecs[key]
+		if o == nil {
+			rec = theirRecs[key]
+		}
+		c := MergeConflict{System: rec.system, Account: rec.account, Kind: mergeConflictKind(b, o, t), Base: b, Ours: o, Theirs: t}
+		switch strategy {
+		case MergeOurs:
+			c.Resolved = MergeOurs
+		case MergeTheirs:
+			c.Resolved = MergeTheirs
+			o = t
+		}
+		result.Conflicts = append(result.Conflicts, c)
+		return o
+	}
+
+	done := make(map[string]bool)
+	for _, line := range ours {
+		key, rec, ok := mergeParse(line, caseSensitive)
+		if !ok {
+			result.Lines = append(result.Lines, line)
+			continue
+		}
+		if done[key] {
+			// keep duplicates as they are, they are never matched
+			result.Lines = append(result.Lines, line)
+			continue
+		}
+		done[key] = true
+		v := merge(key)
+		switch {
+		case v == nil:
+			result.Deleted++
+		case *v != rec.password:
+			result.Changed++
+			result.Lines = append(result.Lines, rec.system+":"+rec.account+":"+*v)
+		default:
+			result.Lines = append(result.Lines, line)
+		}
+	}
+	// drop trailing empty lines before appending new records
+	for len(result.Lines) > 0 && strings.TrimSpace(result.Lines[len(result.Lines)-1]) == "" {
+		result.Lines = result.Lines[:len(result.Lines)-1]
+	}
+	for _, key := range theirOrder {
+		if done[key] {
+			continue
+		}
+		done[key] = true
+		if v := merge(key); v != nil {
+			rec := theirRecs[key]
+			result.Lines = append(result.Lines, rec.system+":"+rec.account+":"+*v)
+			result.Added++
+		}
+	}
+	log.Debugf("merge: %d added, %d changed, %d deleted, %d conflicts", result.Added, result.Changed, result.Deleted, len(result.Conflicts))
+	if result.Unresolved() > 0 {
+		return result, ErrMergeConflict
+	}
+	return result, nil
+}
+
+// decryptStore decrypts another crypted file with the keys of pc and the session key file of the store version
+func (pc *PassConfig) decryptStore(f MergeStoreFile, verify bool) ([]string, error) {
+	c := *pc
+	c.CryptedFile = f.CryptedFile
+	if f.SessionPassFile != "" {
+		c.SessionPassFile = f.SessionPassFile
+	}
+	c.Audit = nil
+	if !verify {
+		c.StoreSigning = nil
+	} else if c.StoreSigning != nil {
+		// the signature belongs to the file, not to the configured store
+		s := *c.StoreSigning
+		s.SignatureFile = ""
+		c.StoreSigning = &s
+	}
+	return c.DecryptFile()
+}
+
+// MergeStores merges the crypted stores ours and theirs with their common ancestor base
+// and encrypts the result to pc.CryptedFile. An empty base file merges without ancestor.
+// With conflicts and MergeFail nothing is written
+func (pc *PassConfig) MergeStores(base MergeStoreFile, ours MergeStoreFile, theirs MergeStoreFile, strategy string) (result *MergeResult, err error) {
+	defer func() {
+		pc.audit("MergeStores", "", "", err)
+	}()
+	return pc.mergeStores(base, true, ours, theirs, strategy)
+}
+
+func (pc *PassConfig) mergeStores(baseFile MergeStoreFile, verifyBase bool, oursFile MergeStoreFile, theirsFile MergeStoreFile, strategy string) (result *MergeResult, err error) {
+	if !slices.Contains(mergeMethods, pc.Method) {
+		return nil, fmt.Errorf("merge not supported for method %s", pc.Method)
+	}
+	var base, ours, theirs []string
+	if baseFile.CryptedFile != "" {
+		if base, err = pc.decryptStore(baseFile, verifyBase); err != nil {
+			return nil, fmt.Errorf("cannot decrypt base %s: %v", baseFile.CryptedFile, err)
+		}
+	}
+	if ours, err = pc.decryptStore(oursFile, true); err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %v", oursFile.CryptedFile, err)
+	}
+	if theirs, err = pc.decryptStore(theirsFile, true); err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %v", theirsFile.CryptedFile, err)
+	}
+	result, err = MergeStoreLines(base, ours, theirs, pc.CaseSensitive, strategy)
+	if err != nil {
+		return
+	}
+	err = pc.writeMergedStore(result.Lines)
+	return
+}
+
+// writeMergedStore encrypts lines from memory to pc.CryptedFile and signs it if configured
+func (pc *PassConfig) writeMergedStore(lines []string) (err error) {
+	content := []byte(strings.Join(lines, "\n") + "\n")
+	defer wipeBytes(content)
+	switch pc.Method {
+	case typeOpenssl:
+		err = pubEncryptSSL(content, pc.CryptedFile, pc.PubKeyFile, pc.SessionPassFile)
+	case typeGO:
+		err = pubEncryptGo(content, pc.CryptedFile, pc.PubKeyFile)
+	case typeEnc:
+		err = encodeData(content, pc.CryptedFile)
+	case typePlain:
+		err = common.WriteStringToFile(pc.CryptedFile, string(content))
+	case typeGPG:
+		err = gpgEncrypt(content, pc.CryptedFile, pc.PubKeyFile)
+	case typeKMS:
+		err = kmsEncryptContext(context.Background(), content, pc.CryptedFile, pc.KMSKeyID, pc.SessionPassFile)
+	default:
+		err = fmt.Errorf("merge not supported for method %s", pc.Method)
+	}
+	if err != nil {
+		return
+	}
+	if pc.StoreSigning != nil && slices.Contains(storeSigningMethods, pc.Method) {
+		err = pc.SignCryptedFile()
+	}
+	return
+}
+
+// MergeGitStore merges the store of the git revision theirsRev (e.g. a fetched origin/main) into the working copy
+// of the git tracked store pc.CryptedFile. The common ancestor is the store of the merge base of HEAD and theirsRev,
+// so local changes are kept whether they are committed or not. A configured store signature of theirs is verified
+// with the signature file of the same revision, openssl and kms stores are decrypted with the session key file
+// of their revision
+func (pc *PassConfig) MergeGitStore(theirsRev string, strategy string) (result *MergeResult, err error) {
+	defer func() {
+		pc.audit("MergeGitStore", "", "", err)
+	}()
+	repo, rootDir, gitName, err := pc.gitStoreRepo()
+	if err != nil {
+		return
+	}
+	sigName := ""
+	if pc.StoreSigning != nil {
+		if sigName, err = gitRepoName(rootDir, pc.cryptedSignatureFile()); err != nil {
+			return
+		}
+	}
+	sessionName := ""
+	if slices.Contains(mergeSessionMethods, pc.Method) {
+		if sessionName, err = gitRepoName(rootDir, pc.SessionPassFile); err != nil {
+			return nil, fmt.Errorf("session key file must be tracked with the store: %v", err)
+		}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read HEAD: %v", err)
+	}
+	ours, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(theirsRev))
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %s: %v", theirsRev, err)
+	}
+	theirs, err := repo.CommitObject(*hash)
+	if err != nil {
+		return
+	}
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("HEAD and %s have no common ancestor", theirsRev)
+	}
+	var tmpFiles []string
+	defer func() {
+		for _, f := range tmpFiles {
+			_ = os.Remove(f)
+		}
+	}()
+	baseFile, err := pc.gitStoreVersion(bases[0], gitName, "", sessionName, &tmpFiles)
+	if err != nil {
+		return
+	}
+	theirsFile, err := pc.gitStoreVersion(theirs, gitName, sigName, sessionName, &tmpFiles)
+	if err != nil {
+		return
+	}
+	if theirsFile.CryptedFile == "" {
+		return nil, fmt.Errorf("store %s not found in %s", gitName, theirsRev)
+	}
+	log.Debugf("merge %s from %s with base from commit %s", gitName, theirs.Hash, bases[0].Hash)
+	// the ancestor is trusted as it comes from the history of HEAD
+	return pc.mergeStores(baseFile, false, MergeStoreFile{CryptedFile: pc.CryptedFile}, theirsFile, strategy)
+}
+
+// gitRepoName returns the slash separated name of filename relative to the repository root
+func gitRepoName(rootDir string, filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	name, err := filepath.Rel(rootDir, abs)
+	if err != nil {
+		return "", err
+	}
+	name = filepath.ToSlash(name)
+	if strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("%s is outside of the repository", filename)
+	}
+	return name, nil
+}
+
+// gitStoreRepo opens the git repository of the store and returns its root and the store name in the repository
+func (pc *PassConfig) gitStoreRepo() (repo *git.Repository, rootDir string, gitName string, err error) {
+	abs, err := filepath.Abs(pc.CryptedFile)
+	if err != nil {
+		return
+	}
+	rootDir, gitName, err = common.IsGitFile(filepath.ToSlash(abs))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("store is not tracked by git: %v", err)
+	}
+	repo, err = git.PlainOpen(rootDir)
+	return
+}
+
+// gitStoreVersion writes the store of commit to a temporary file and returns it or an empty name if the
+// commit has no store. With a signature name the signature of the commit is written next to it,
+// with a session name the session key file of the commit is used for the store
+func (pc *PassConfig) gitStoreVersion(commit *object.Commit, gitName string, sigName string, sessionName string, tmpFiles *[]string) (store MergeStoreFile, err error) {
+	content, err := gitFileContent(commit, gitName)
+	if err != nil || content == nil {
+		return
+	}
+	filename, err := gitTempFile(pc.DataDir, *content, tmpFiles)
+	if err != nil {
+		return
+	}
+	store.CryptedFile = filename
+	if sessionName != "" {
+		var session *string
+		if session, err = gitFileContent(commit, sessionName); err != nil {
+			return
+		}
+		if session == nil {
+			return store, fmt.Errorf("session key file %s not found in commit %s", sessionName, commit.Hash)
+		}
+		if store.SessionPassFile, err = gitTempFile(pc.DataDir, *session, tmpFiles); err != nil {
+			return
+		}
+	}
+	if sigName == "" {
+		return
+	}
+	sig, err := gitFileContent(commit, sigName)
+	if err != nil {
+		return
+	}
+	if sig == nil {
+		return store, fmt.Errorf("signature %s not found in commit %s", sigName, commit.Hash)
+	}
+	*tmpFiles = append(*tmpFiles, filename+"."+extSig)
+	err = common.WriteStringToFile(filename+"."+extSig, *sig)
+	return
+}
+
+// gitTempFile writes content to a new temporary file in dir and registers it for removal
+func gitTempFile(dir string, content string, tmpFiles *[]string) (filename string, err error) {
+	tmp, err := os.CreateTemp(dir, ".merge-*")
+	if err != nil {
+		return
+	}
+	filename = tmp.Name()
+	*tmpFiles = append(*tmpFiles, filename)
+	_, err = tmp.WriteString(content)
+	_ = tmp.Close()
+	return
+}
+
+// gitFileContent returns the content of a file in a commit or nil if the commit has no such file
+func gitFileContent(commit *object.Commit, name string) (*string, error) {
+	f, err := commit.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s from commit %s: %v", name, commit.Hash, err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
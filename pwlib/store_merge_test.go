@@ -0,0 +1,248 @@
+package pwlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+func TestStoreMerge(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	base := []string{"# store", "db1:user:pass1", "db2:user:pass2", "db3:user:pass3", "db4:user:pass4"}
+	t.Run("Lines", func(t *testing.T) {
+		ours := []string{"# store", "db1:user:ours1", "db2:user:pass2", "db3:user:pass3", "db4:user:pass4", "db5:user:ours5"}
+		theirs := []string{"db1:user:pass1", "db2:user:theirs2", "db4:user:pass4", "db6:user:theirs6"}
+		result, e := MergeStoreLines(base, ours, theirs, false, MergeFail)
+		require.NoError(t, e)
+		assert.Equal(t, []string{"# store", "db1:user:ours1", "db2:user:theirs2", "db4:user:pass4", "db5:user:ours5", "db6:user:theirs6"}, result.Lines)
+		assert.Equal(t, 1, result.Added)
+		assert.Equal(t, 1, result.Changed)
+		assert.Equal(t, 1, result.Deleted)
+		assert.Empty(t, result.Conflicts)
+
+		// the same change on both sides is no conflict
+		result, e = MergeStoreLines(base, []string{"db1:user:new"}, []string{"db1:user:new"}, false, MergeFail)
+		require.NoError(t, e)
+		assert.Equal(t, []string{"db1:user:new"}, result.Lines)
+
+		// keys are case insensitive unless configured
+		result, e = MergeStoreLines(base, base, []string{"DB1:User:pass1", "db2:user:pass2", "db3:user:pass3", "db4:user:pass4"}, false, MergeFail)
+		require.NoError(t, e)
+		assert.Equal(t, base, result.Lines)
+		result, e = MergeStoreLines(base, base, []string{"DB1:User:pass1", "db2:user:pass2", "db3:user:pass3", "db4:user:pass4"}, true, MergeFail)
+		require.NoError(t, e)
+		assert.Equal(t, 1, result.Deleted)
+		assert.Equal(t, 1, result.Added)
+
+		_, e = MergeStoreLines(base, base, base, false, "union")
+		assert.ErrorContains(t, e, "unknown merge strategy")
+	})
+	t.Run("Conflicts", func(t *testing.T) {
+		ours := []string{"db1:user:ours1", "db2:user:pass2", "db3:user:ours3", "db5:user:ours5"}
+		theirs := []string{"db1:user:theirs1", "db4:user:theirs4", "db5:user:theirs5"}
+		result, e := MergeStoreLines(base, ours, theirs, false, MergeFail)
+		assert.ErrorIs(t, e, ErrMergeConflict)
+		require.NotNil(t, result)
+		kinds := map[string]string{}
+		for _, c := range result.Conflicts {
+			kinds[c.System] = c.Kind
+			assert.NotContains(t, c.String(), "ours", "conflict text must not show passwords")
+		}
+		assert.Equal(t, map[string]string{
+			"db1": "modified/modified",
+			"db3": "modified/deleted",
+			"db4": "deleted/modified",
+			"db5": "added/added",
+		}, kinds)
+		assert.Equal(t, 4, result.Unresolved())
+
+		result, e = MergeStoreLines(base, ours, theirs, false, MergeOurs)
+		require.NoError(t, e)
+		assert.Equal(t, []string{"db1:user:ours1", "db3:user:ours3", "db5:user:ours5"}, result.Lines)
+		assert.Contains(t, result.Conflicts[0].String(), "resolved with ours")
+
+		result, e = MergeStoreLines(base, ours, theirs, false, MergeTheirs)
+		require.NoError(t, e)
+		assert.Equal(t, []string{"db1:user:theirs1", "db5:user:theirs5", "db4:user:theirs4"}, result.Lines)
+	})
+
+	kmsServer := fakeMergeKMS(t)
+	defer kmsServer.Close()
+	for _, m := range []string{typeGO, typeOpenssl, typeKMS} {
+		testStoreMergeFiles(t, m, base, kmsServer.URL)
+	}
+}
+
+// fakeMergeKMS simulates KMS Encrypt and Decrypt, the ciphertext is the prefixed plaintext
+func fakeMergeKMS(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			KeyID          string `json:"KeyId"`
+			Plaintext      []byte `json:"Plaintext"`
+			CiphertextBlob []byte `json:"CiphertextBlob"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		resp := map[string]any{"KeyId": req.KeyID}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			resp["CiphertextBlob"] = append([]byte("fake:"), req.Plaintext...)
+		case "TrentService.Decrypt":
+			plain, ok := bytes.CutPrefix(req.CiphertextBlob, []byte("fake:"))
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"__type":"InvalidCiphertextException","message":"invalid"}`))
+				return
+			}
+			resp["Plaintext"] = plain
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func testStoreMergeFiles(t *testing.T, m string, base []string, kmsURL string) {
+	app := "test_merge_" + m
+	pc := NewConfig(app, test.TestData, test.TestData, app, m)
+	if m == typeKMS {
+		t.Setenv("KMS_ENDPOINT", kmsURL)
+		t.Setenv("AWS_REGION", "us-east-1")
+		t.Setenv("AWS_ACCESS_KEY_ID", "test")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+		t.Setenv("AWS_CONFIG_FILE", path.Join(test.TestData, "missing_aws_config"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path.Join(test.TestData, "missing_aws_credentials"))
+		defer func(ep string) { KmsEndpoint = ep }(KmsEndpoint)
+		pc.KMSKeyID = "test-key"
+	} else {
+		_, _, err := GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+		require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+	}
+	// every encryption writes its own session key file for openssl and kms
+	encrypt := func(name string, lines []string) MergeStoreFile {
+		c := *pc
+		c.PlainTextFile = path.Join(test.TestData, name+".plain")
+		c.CryptedFile = path.Join(test.TestData, name+".crypt")
+		c.SessionPassFile = path.Join(test.TestData, name+".dat")
+		require.NoError(t, common.WriteStringToFile(c.PlainTextFile, strings.Join(lines, "\n")+"\n"))
+		require.NoError(t, c.EncryptFile())
+		return MergeStoreFile{CryptedFile: c.CryptedFile, SessionPassFile: c.SessionPassFile}
+	}
+	t.Run("Files "+m, func(t *testing.T) {
+		baseFile := encrypt(app+"_base", base)
+		oursFile := encrypt(app+"_ours", []string{"# store", "db1:user:ours1", "db2:user:pass2", "db3:user:pass3", "db4:user:pass4"})
+		theirsFile := encrypt(app+"_theirs", []string{"# store", "db1:user:pass1", "db2:user:theirs2", "db3:user:pass3", "db4:user:pass4"})
+		result, e := pc.MergeStores(baseFile, oursFile, theirsFile, MergeFail)
+		require.NoError(t, e)
+		assert.Equal(t, 1, result.Changed)
+		pw, e := pc.GetPassword("db1", "user")
+		require.NoError(t, e)
+		assert.Equal(t, "ours1", pw)
+		pw, e = pc.GetPassword("db2", "user")
+		require.NoError(t, e)
+		assert.Equal(t, "theirs2", pw)
+		entries, _ := os.ReadDir(test.TestData)
+		for _, entry := range entries {
+			assert.False(t, strings.HasPrefix(entry.Name(), ".merge"), "temporary file %s left", entry.Name())
+		}
+		if slices.Contains(mergeSessionMethods, m) {
+			// the session key of the merged store does not fit the other versions
+			_, e = pc.MergeStores(baseFile, oursFile, MergeStoreFile{CryptedFile: theirsFile.CryptedFile}, MergeFail)
+			assert.Error(t, e, "theirs needs its own session key file")
+		}
+
+		// nothing is written on conflicts
+		before, _ := common.ReadFileToString(pc.CryptedFile)
+		theirsFile = encrypt(app+"_theirs", []string{"db1:user:theirs1"})
+		_, e = pc.MergeStores(baseFile, oursFile, theirsFile, MergeFail)
+		assert.ErrorIs(t, e, ErrMergeConflict)
+		after, _ := common.ReadFileToString(pc.CryptedFile)
+		assert.Equal(t, before, after)
+
+		_, e = pc.MergeStores(baseFile, oursFile, MergeStoreFile{CryptedFile: path.Join(test.TestData, "missing.crypt")}, MergeFail)
+		assert.Error(t, e)
+		_, e = (&PassConfig{Method: typeVault}).MergeStores(baseFile, oursFile, theirsFile, MergeFail)
+		assert.ErrorContains(t, e, "not supported")
+	})
+	t.Run("Git "+m, func(t *testing.T) {
+		repoDir := path.Join(test.TestData, "merge_repo_"+m)
+		_ = os.RemoveAll(repoDir)
+		repo, e := git.PlainInit(repoDir, false)
+		require.NoError(t, e)
+		wt, e := repo.Worktree()
+		require.NoError(t, e)
+		c := *pc
+		c.CryptedFile = path.Join(repoDir, app+".crypt")
+		c.SessionPassFile = path.Join(repoDir, app+".dat")
+		c.PlainTextFile = path.Join(test.TestData, app+"_git.plain")
+		if m != typeKMS {
+			c.StoreSigning = &StoreSigning{}
+		}
+		commit := func(msg string, lines ...string) {
+			require.NoError(t, common.WriteStringToFile(c.PlainTextFile, strings.Join(lines, "\n")+"\n"))
+			require.NoError(t, c.EncryptFile())
+			_, e = wt.Add(".")
+			require.NoError(t, e)
+			_, e = wt.Commit(msg, &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+			require.NoError(t, e)
+		}
+		commit("initial store", base...)
+		head, e := repo.Head()
+		require.NoError(t, e)
+
+		// remote change on another branch
+		require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("remote"), Create: true}))
+		commit("remote change", "# store", "db1:user:pass1", "db2:user:pass2", "db4:user:pass4", "db7:user:remote7")
+		require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: head.Name()}))
+
+		// committed and uncommitted local changes
+		commit("local addition", "# store", "db1:user:pass1", "db2:user:pass2", "db3:user:pass3", "db4:user:pass4", "db5:user:local5")
+		require.NoError(t, common.WriteStringToFile(c.PlainTextFile, "# store\ndb1:user:local1\ndb2:user:pass2\ndb3:user:pass3\ndb4:user:pass4\ndb5:user:local5\n"))
+		require.NoError(t, c.EncryptFile())
+
+		result, e := c.MergeGitStore("remote", MergeFail)
+		require.NoError(t, e)
+		assert.Equal(t, 1, result.Added)
+		assert.Equal(t, 1, result.Deleted)
+		lines, e := c.DecryptFile()
+		require.NoError(t, e, "merged store is readable")
+		assert.Equal(t, []string{"# store", "db1:user:local1", "db2:user:pass2", "db4:user:pass4", "db5:user:local5", "db7:user:remote7"}, lines[:6],
+			"committed local addition is kept")
+		entries, _ := os.ReadDir(test.TestData)
+		for _, entry := range entries {
+			assert.False(t, strings.HasPrefix(entry.Name(), ".merge"), "temporary file %s left", entry.Name())
+		}
+
+		_, e = c.MergeGitStore("missing", MergeFail)
+		assert.ErrorContains(t, e, "cannot resolve missing")
+		outside := c
+		outside.CryptedFile = path.Join(test.TestData, "untracked.crypt")
+		_, e = outside.MergeGitStore("remote", MergeFail)
+		assert.Error(t, e)
+		if slices.Contains(mergeSessionMethods, m) {
+			untracked := c
+			untracked.SessionPassFile = path.Join(test.TestData, app+".dat")
+			_, e = untracked.MergeGitStore("remote", MergeFail)
+			assert.ErrorContains(t, e, "session key file must be tracked")
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package pwlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tommi2day/gomodules/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// kmsSignerPrefix marks trusted signers given as KMS key id
+const kmsSignerPrefix = "kms:"
+
+// StoreSigning enables automatic signing of the crypted file in EncryptFile and
+// signature verification in DecryptFile
+type StoreSigning struct {
+	// KeyFile is the private RSA, ECDSA or GPG key used for signing, defaults to PrivateKeyFile
+	KeyFile string
+	// KeyPass is the passphrase of KeyFile, defaults to KeyPass
+	KeyPass string
+	// KMSKeyID signs with this KMS key instead of a key file
+	KMSKeyID string
+	// TrustedKeys lists public key files (RSA, ECDSA, GPG) or "kms:<keyid>" entries accepted as signer,
+	// defaults to PubKeyFile or the signing KMS key
+	TrustedKeys []string
+	// SignatureFile defaults to CryptedFile with .sig extension
+	SignatureFile string
+}
+
+// storeSigningMethods are the methods with a crypted file which can be signed
+var storeSigningMethods = []string{typeGO, typeOpenssl, typeEnc, typePlain, typeGPG, typeAge, typeKMS}
+
+// cryptedSignatureFile returns the signature file of the crypted file
+func (pc *PassConfig) cryptedSignatureFile() string {
+	if pc.StoreSigning != nil && pc.StoreSigning.SignatureFile != "" {
+		return pc.StoreSigning.SignatureFile
+	}
+	return pc.CryptedFile + "." + extSig
+}
+
+// trustedSigners returns the configured or default trusted keys
+func (pc *PassConfig) trustedSigners() []string {
+	s := pc.StoreSigning
+	if len(s.TrustedKeys) > 0 {
+		return s.TrustedKeys
+	}
+	if s.KMSKeyID != "" {
+		return []string{kmsSignerPrefix + s.KMSKeyID}
+	}
+	return []string{pc.PubKeyFile}
+}
+
+// kmsSignatureMessage returns the hex digest of the data as KMS limits the message size
+func kmsSignatureMessage(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignCryptedFile writes a signature over the crypted file
+func (pc *PassConfig) SignCryptedFile() (err error) {
+	if pc.StoreSigning == nil {
+		return errors.New("store signing not configured")
+	}
+	s := pc.StoreSigning
+	cryptedFile := pc.CryptedFile
+	signatureFile := pc.cryptedSignatureFile()
+	log.Debugf("Sign crypted file %s", cryptedFile)
+	if s.KMSKeyID != "" {
+		var data []byte
+		var signature string
+		data, err = os.ReadFile(cryptedFile) //nolint gosec
+		if err != nil {
+			return
+		}
+		signature, err = KMSSignString(ConnectToKMS(), s.KMSKeyID, kmsSignatureMessage(data))
+		if err == nil {
+			err = common.WriteStringToFile(signatureFile, signature)
+		}
+		return
+	}
+	keyFile := s.KeyFile
+	if keyFile == "" {
+		keyFile = pc.PrivateKeyFile
+	}
+	keyPass := s.KeyPass
+	if keyPass == "" {
+		keyPass = pc.KeyPass
+	}
+	keyType, err := GetKeyTypeFromFile(keyFile)
+	if err != nil {
+		return
+	}
+	switch keyType {
+	case KeyTypeRSA, KeyTypeECDSA:
+		err = SignFileSSL(cryptedFile, signatureFile, keyFile, keyPass)
+	case KeyTypeGPG:
+		err = GPGSignFile(cryptedFile, signatureFile, keyFile, keyPass)
+	default:
+		err = fmt.Errorf("cannot sign with %s key %s", keyType, keyFile)
+	}
+	if err == nil {
+		log.Debugf("signature written to %s", signatureFile)
+	}
+	return
+}
+
+// VerifyCryptedFile checks the signature of the crypted file against the trusted keys and returns the matching signer
+func (pc *PassConfig) VerifyCryptedFile() (signer string, err error) {
+	var data []byte
+	if pc.StoreSigning == nil {
+		return "", errors.New("store signing not configured")
+	}
+	data, err = os.ReadFile(pc.CryptedFile) //nolint gosec
+	if err != nil {
+		return
+	}
+	return pc.verifyCryptedData(data)
+}
+
+// verifyCryptedData checks the signature of the already read crypted file content,
+// so the verified bytes are the ones to decrypt
+func (pc *PassConfig) verifyCryptedData(data []byte) (signer string, err error) {
+	var signature string
+	cryptedFile := pc.CryptedFile
+	signatureFile := pc.cryptedSignatureFile()
+	log.Debugf("Verify crypted file %s with %s", cryptedFile, signatureFile)
+	if !common.FileExists(signatureFile) {
+		return "", fmt.Errorf("signature file %s for %s not found", signatureFile, cryptedFile)
+	}
+	signature, err = common.ReadFileToString(signatureFile)
+	if err != nil {
+		return
+	}
+	for _, key := range pc.trustedSigners() {
+		var valid bool
+		var e error
+		if strings.HasPrefix(key, kmsSignerPrefix) {
+			valid, e = KMSVerifyString(ConnectToKMS(), strings.TrimPrefix(key, kmsSignerPrefix), kmsSignatureMessage(data), signature)
+		} else {
+			var keyType string
+			keyType, e = GetKeyTypeFromFile(key)
+			switch {
+			case e != nil:
+			case keyType == KeyTypeGPG:
+				valid, e = gpgVerifyData(data, signature, key)
+			default:
+				valid, e = VerifyString(string(data), signature, key)
+			}
+		}
+		if valid {
+			log.Debugf("signature of %s valid for %s", cryptedFile, key)
+			return key, nil
+		}
+		log.Debugf("signature of %s not valid for %s: %v", cryptedFile, key, e)
+	}
+	return "", fmt.Errorf("signature of %s not valid for any trusted key", cryptedFile)
+}
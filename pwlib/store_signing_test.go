@@ -0,0 +1,93 @@
+package pwlib
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+func TestStoreSigning(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+
+	// team member keys
+	ecdsaPub := path.Join(test.TestData, "test_signing_member"+pubPemExt)
+	ecdsaPriv := path.Join(test.TestData, "test_signing_member"+privPemExt)
+	_, _, err = GenEcdsaKey(ecdsaPub, ecdsaPriv, "member")
+	require.NoErrorf(t, err, "Prepare ECDSA Key failed:%s", err)
+	otherPub := path.Join(test.TestData, "test_signing_other"+pubPemExt)
+	otherPriv := path.Join(test.TestData, "test_signing_other"+privPemExt)
+	_, _, err = GenRsaKey(otherPub, otherPriv, "other")
+	require.NoErrorf(t, err, "Prepare RSA Key failed:%s", err)
+
+	for _, m := range []string{typeGO, typeGPG, typeAge} {
+		app := "test_signing_" + m
+		pc := NewConfig(app, test.TestData, test.TestData, app, m)
+		err = common.WriteStringToFile(pc.PlainTextFile, plain)
+		require.NoErrorf(t, err, "Create testdata failed")
+		switch m {
+		case typeGPG:
+			entity, _, e := CreateGPGEntity(testGPGName, "TestSigning", testGPGEmail, pc.KeyPass)
+			require.NoErrorf(t, e, "Prepare GPG Keys failed:%s", e)
+			err = ExportGPGKeyPair(entity, pc.PubKeyFile, pc.PrivateKeyFile)
+			pc.StoreSigning = &StoreSigning{}
+		case typeAge:
+			identity, _, e := CreateAgeIdentity()
+			require.NoErrorf(t, e, "Prepare Age Keys failed:%s", e)
+			err = ExportAgeKeyPair(identity, pc.PubKeyFile, pc.PrivateKeyFile)
+			// age keys cannot sign, use a team member key
+			pc.StoreSigning = &StoreSigning{KeyFile: ecdsaPriv, KeyPass: "member", TrustedKeys: []string{otherPub, ecdsaPub}}
+		default:
+			_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+			pc.StoreSigning = &StoreSigning{}
+		}
+		require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+
+		t.Run("Signed store "+m, func(t *testing.T) {
+			err = pc.EncryptFile()
+			require.NoErrorf(t, err, "Encryption failed:%s", err)
+			assert.FileExists(t, pc.CryptedFile+"."+extSig)
+			signer, e := pc.VerifyCryptedFile()
+			assert.NoErrorf(t, e, "Verify failed:%s", e)
+			assert.NotEmpty(t, signer)
+			lines, e := pc.DecryptFile()
+			assert.NoErrorf(t, e, "Decryption failed:%s", e)
+			assert.Contains(t, lines, "test:testuser:testpass")
+		})
+		t.Run("Tampered store "+m, func(t *testing.T) {
+			content, e := os.ReadFile(pc.CryptedFile)
+			require.NoError(t, e)
+			err = os.WriteFile(pc.CryptedFile, append(content, '\n'), 0600)
+			require.NoError(t, err)
+			_, e = pc.DecryptFile()
+			assert.ErrorContains(t, e, "not valid")
+			_ = os.WriteFile(pc.CryptedFile, content, 0600)
+			_, e = pc.DecryptFile()
+			assert.NoError(t, e)
+			// only the verified bytes count, not the file content at check time
+			_, e = pc.verifyCryptedData(append(content, '\n'))
+			assert.ErrorContains(t, e, "not valid")
+		})
+	}
+
+	t.Run("Untrusted signer", func(t *testing.T) {
+		app := "test_signing_" + typeGO
+		pc := NewConfig(app, test.TestData, test.TestData, app, typeGO)
+		pc.StoreSigning = &StoreSigning{TrustedKeys: []string{otherPub, ecdsaPub}}
+		_, e := pc.DecryptFile()
+		assert.Error(t, e, "signature of untrusted key should fail")
+		pc.StoreSigning = &StoreSigning{SignatureFile: path.Join(test.TestData, "missing.sig")}
+		_, e = pc.DecryptFile()
+		assert.ErrorContains(t, e, "not found")
+		// without store signing no check is done
+		pc.StoreSigning = nil
+		_, e = pc.DecryptFile()
+		assert.NoError(t, e)
+	})
+}
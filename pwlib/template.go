@@ -0,0 +1,220 @@
+package pwlib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	vault "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// TemplateFileMode is the file mode of rendered target files
+var TemplateFileMode os.FileMode = 0600
+
+// SecretReference describes a secret used by a template
+type SecretReference struct {
+	// Function is the template function name (password, vault, totp, env)
+	Function string
+	// Args are the arguments of the function call
+	Args []string
+}
+
+// String returns the reference as it would be written in a template
+func (r SecretReference) String() string {
+	return fmt.Sprintf("%s %q", r.Function, r.Args)
+}
+
+// SecretTemplate renders text templates with secrets from a PassConfig, vault and the environment
+type SecretTemplate struct {
+	// PassConfig is used by the password and totp functions
+	PassConfig *PassConfig
+	// VaultAddr and VaultToken are used by the vault function, empty values use VAULT_ADDR and VAULT_TOKEN
+	VaultAddr  string
+	VaultToken string
+	// DryRun renders placeholders instead of resolving secrets
+	DryRun bool
+	// References lists all secrets used by the last render
+	References []SecretReference
+
+	vaultClient *vault.Client
+	vaultCache  map[string]map[string]interface{}
+}
+
+// NewSecretTemplate creates a template renderer for the given PassConfig
+func NewSecretTemplate(pc *PassConfig) *SecretTemplate {
+	return &SecretTemplate{PassConfig: pc}
+}
+
+// FuncMap returns the template functions password, vault, totp and env
+func (st *SecretTemplate) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"password": st.password,
+		"vault":    st.vault,
+		"totp":     st.totp,
+		"env":      st.env,
+	}
+}
+
+// Render executes the template text and returns the result
+func (st *SecretTemplate) Render(text string) (result string, err error) {
+	var tmpl *template.Template
+	var buf bytes.Buffer
+	st.References = nil
+	st.vaultCache = map[string]map[string]interface{}{}
+	tmpl, err = template.New("secret").Option("missingkey=error").Funcs(st.FuncMap()).Parse(text)
+	if err != nil {
+		err = fmt.Errorf("cannot parse template: %v", err)
+		return
+	}
+	err = tmpl.Execute(&buf, nil)
+	if err != nil {
+		err = fmt.Errorf("cannot render template: %v", err)
+		return
+	}
+	result = buf.String()
+	log.Debugf("template rendered with %d secret references", len(st.References))
+	return
+}
+
+// RenderFile renders templateFile into targetFile with TemplateFileMode permissions.
+// In DryRun mode the target is not written and the references are returned only
+func (st *SecretTemplate) RenderFile(templateFile string, targetFile string) (references []SecretReference, err error) {
+	var content []byte
+	var result string
+	log.Debugf("RenderFile %s to %s", templateFile, targetFile)
+	content, err = os.ReadFile(templateFile) //nolint gosec
+	if err != nil {
+		err = fmt.Errorf("cannot read template %s: %v", templateFile, err)
+		return
+	}
+	result, err = st.Render(string(content))
+	references = st.References
+	if err != nil || st.DryRun {
+		return
+	}
+	err = writeRestrictedFile(targetFile, []byte(result), TemplateFileMode)
+	return
+}
+
+// writeRestrictedFile writes data via a temp file in the target directory and renames it,
+// so the target never exists with wider permissions or partial content
+func writeRestrictedFile(targetFile string, data []byte, mode os.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(targetFile), "."+filepath.Base(targetFile)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot create temp file for %s: %v", targetFile, err)
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, targetFile)
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot write %s: %v", targetFile, err)
+	}
+	return
+}
+
+// addReference records a secret reference and returns the dry run placeholder
+func (st *SecretTemplate) addReference(function string, args ...string) string {
+	st.References = append(st.References, SecretReference{Function: function, Args: args})
+	return fmt.Sprintf("<%s:%s>", function, strings.Join(args, "/"))
+}
+
+func (st *SecretTemplate) password(system string, account string) (string, error) {
+	placeholder := st.addReference("password", system, account)
+	if st.DryRun {
+		return placeholder, nil
+	}
+	return st.lookup(system, account)
+}
+
+func (st *SecretTemplate) lookup(system string, account string) (string, error) {
+	if st.PassConfig == nil {
+		return "", errors.New("template needs a PassConfig to lookup passwords")
+	}
+	return st.PassConfig.GetPassword(system, account)
+}
+
+func (st *SecretTemplate) vault(secretPath string, key string) (value string, err error) {
+	var vs *vault.Secret
+	placeholder := st.addReference("vault", secretPath, key)
+	if st.DryRun {
+		return placeholder, nil
+	}
+	data, ok := st.vaultCache[secretPath]
+	if !ok {
+		if st.vaultClient == nil {
+			st.vaultClient, err = VaultConfig(st.VaultAddr, st.VaultToken)
+			if err != nil {
+				return
+			}
+		}
+		vs, err = VaultRead(st.vaultClient, secretPath)
+		if err != nil {
+			return
+		}
+		if vs == nil || vs.Data == nil {
+			return "", fmt.Errorf("no vault data returned for %s", secretPath)
+		}
+		data = vs.Data
+		// KV version 2 wraps the values in data
+		if d, isKV2 := vs.Data["data"].(map[string]interface{}); isKV2 {
+			data = d
+		}
+		st.vaultCache[secretPath] = data
+	}
+	v, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in vault path %s", key, secretPath)
+	}
+	return fmt.Sprintf("%v", v), nil
+}
+
+// totp accepts either the totp secret itself or system and account of the stored secret
+func (st *SecretTemplate) totp(args ...string) (string, error) {
+	var secret string
+	var err error
+	switch len(args) {
+	case 1:
+		placeholder := st.addReference("totp", "***")
+		if st.DryRun {
+			return placeholder, nil
+		}
+		secret = args[0]
+	case 2:
+		placeholder := st.addReference("totp", args...)
+		if st.DryRun {
+			return placeholder, nil
+		}
+		if secret, err = st.lookup(args[0], args[1]); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("totp needs a secret or system and account")
+	}
+	return GetOtp(secret)
+}
+
+func (st *SecretTemplate) env(name string) string {
+	placeholder := st.addReference("env", name)
+	if st.DryRun {
+		return placeholder
+	}
+	return os.Getenv(name)
+}
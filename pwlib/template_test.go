@@ -0,0 +1,76 @@
+package pwlib
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/test"
+)
+
+const secretTemplate = `# generated config
+user={{ env "TEMPLATE_TEST_USER" }}
+password={{ password "test" "testuser" }}
+otp={{ totp "test" "totpuser" }}
+`
+
+func TestSecretTemplate(t *testing.T) {
+	test.InitTestDirs()
+	err := os.Chdir(test.TestDir)
+	require.NoErrorf(t, err, "ChDir failed")
+	app := "test_template"
+	pc := NewConfig(app, test.TestData, test.TestData, app, typeGO)
+	err = common.WriteStringToFile(pc.PlainTextFile, plain+"test:totpuser:JBSWY3DPEHPK3PXP\n")
+	require.NoErrorf(t, err, "Create testdata failed")
+	_, _, err = GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
+	require.NoErrorf(t, err, "Prepare Key failed:%s", err)
+	err = pc.EncryptFile()
+	require.NoErrorf(t, err, "Encryption failed:%s", err)
+	t.Setenv("TEMPLATE_TEST_USER", "scott")
+
+	templateFile := path.Join(test.TestData, app+".tmpl")
+	targetFile := path.Join(test.TestData, app+".conf")
+	err = common.WriteStringToFile(templateFile, secretTemplate)
+	require.NoError(t, err)
+
+	t.Run("Render file", func(t *testing.T) {
+		_ = os.Remove(targetFile)
+		st := NewSecretTemplate(pc)
+		refs, e := st.RenderFile(templateFile, targetFile)
+		require.NoErrorf(t, e, "Render failed:%s", e)
+		assert.Len(t, refs, 3)
+		content, e := common.ReadFileToString(targetFile)
+		require.NoError(t, e)
+		assert.Contains(t, content, "user=scott\n")
+		assert.Contains(t, content, "password=testpass\n")
+		assert.Regexp(t, `otp=\d{6}\n`, content)
+		fi, e := os.Stat(targetFile)
+		require.NoError(t, e)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+	t.Run("Dry run", func(t *testing.T) {
+		_ = os.Remove(targetFile)
+		st := NewSecretTemplate(nil)
+		st.DryRun = true
+		refs, e := st.RenderFile(templateFile, targetFile)
+		require.NoErrorf(t, e, "Dry run failed:%s", e)
+		require.Len(t, refs, 3)
+		assert.Equal(t, SecretReference{Function: "password", Args: []string{"test", "testuser"}}, refs[1])
+		assert.NoFileExists(t, targetFile, "dry run must not write target")
+		out, e := st.Render(`{{ vault "secret/data/db" "password" }}`)
+		assert.NoError(t, e)
+		assert.Equal(t, "<vault:secret/data/db/password>", out)
+	})
+	t.Run("Missing secret", func(t *testing.T) {
+		st := NewSecretTemplate(pc)
+		_, e := st.Render(`{{ password "nosystem" "nouser" }}`)
+		assert.Error(t, e)
+		_, e = st.Render(`{{ password "test" }}`)
+		assert.Error(t, e, "wrong number of arguments should fail")
+		_, e = NewSecretTemplate(nil).Render(`{{ password "test" "testuser" }}`)
+		assert.Error(t, e, "missing PassConfig should fail")
+	})
+}
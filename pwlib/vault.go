@@ -3,6 +3,7 @@ package pwlib
 import (
 	"context"
 	"fmt"
+	"os"
 	"path"
 	"strings"
 
@@ -13,12 +14,17 @@ import (
 // VaultData is the data structure
 type VaultData map[string]interface{}
 
-// VaultConfig create a new vault client
+// VaultConfig create a new vault client, reads are retried with DefaultRetryPolicy
 func VaultConfig(address string, token string) (client *vault.Client, err error) {
 	config := vault.DefaultConfig()
 	if address != "" {
 		config.Address = address
 	}
+	if os.Getenv(vault.EnvVaultMaxRetries) == "" {
+		config.MaxRetries = max(DefaultRetryPolicy.MaxAttempts-1, 0)
+	}
+	config.CheckRetry = vaultCheckRetry
+	config.Backoff = vaultBackoff
 	client, err = vault.NewClient(config)
 	if err != nil {
 		err = fmt.Errorf("vault client for %s failed:%s", address, err)
@@ -38,9 +44,14 @@ func VaultConfig(address string, token string) (client *vault.Client, err error)
 
 // VaultKVRead read a KVv2 secret from given mount and path
 func VaultKVRead(client *vault.Client, mount string, secretPath string) (vaultSecret *vault.KVSecret, err error) {
-	vaultSecret, err = client.KVv2(mount).Get(context.Background(), secretPath)
+	return VaultKVReadContext(context.Background(), client, mount, secretPath)
+}
+
+// VaultKVReadContext read a KVv2 secret from given mount and path
+func VaultKVReadContext(ctx context.Context, client *vault.Client, mount string, secretPath string) (vaultSecret *vault.KVSecret, err error) {
+	vaultSecret, err = client.KVv2(mount).Get(ctx, secretPath)
 	if err != nil {
-		err = fmt.Errorf("read vault secret failed:%s", err)
+		err = fmt.Errorf("read vault secret failed:%w", err)
 		return
 	}
 	log.Debugf("got secret on path %s ", secretPath)
@@ -49,9 +60,14 @@ func VaultKVRead(client *vault.Client, mount string, secretPath string) (vaultSe
 
 // VaultKVWrite write a KVv2 secret to given mount and path
 func VaultKVWrite(client *vault.Client, mount string, secretPath string, data map[string]interface{}) (err error) {
-	_, err = client.KVv2(mount).Put(context.Background(), secretPath, data)
+	return VaultKVWriteContext(context.Background(), client, mount, secretPath, data)
+}
+
+// VaultKVWriteContext write a KVv2 secret to given mount and path
+func VaultKVWriteContext(ctx context.Context, client *vault.Client, mount string, secretPath string, data map[string]interface{}) (err error) {
+	_, err = client.KVv2(mount).Put(ctx, secretPath, data)
 	if err != nil {
-		err = fmt.Errorf("write vault secret to %s failed:%s", secretPath, err)
+		err = fmt.Errorf("write vault secret to %s failed:%w", secretPath, err)
 		return
 	}
 	log.Debugf("write secret to path %s successfully", secretPath)
@@ -60,9 +76,14 @@ func VaultKVWrite(client *vault.Client, mount string, secretPath string, data ma
 
 // VaultRead logical read path value
 func VaultRead(client *vault.Client, path string) (vaultSecret *vault.Secret, err error) {
-	vaultSecret, err = client.Logical().Read(path)
+	return VaultReadContext(context.Background(), client, path)
+}
+
+// VaultReadContext logical read path value
+func VaultReadContext(ctx context.Context, client *vault.Client, path string) (vaultSecret *vault.Secret, err error) {
+	vaultSecret, err = client.Logical().ReadWithContext(ctx, path)
 	if err != nil {
-		err = fmt.Errorf("read vault secret failed:%s", err)
+		err = fmt.Errorf("read vault secret failed:%w", err)
 		return
 	}
 	log.Debugf("read on path %s OK", path)
@@ -72,13 +93,18 @@ func VaultRead(client *vault.Client, path string) (vaultSecret *vault.Secret, er
 // VaultList recursively lists all secret paths under the given vaultPath using logical metadata listing.
 // It returns a flat list of all secret paths found under the specified vaultPath.
 func VaultList(client *vault.Client, vaultSecretMount string, vaultPath string) (entries []string, err error) {
+	return VaultListContext(context.Background(), client, vaultSecretMount, vaultPath)
+}
+
+// VaultListContext recursively lists all secret paths under the given vaultPath, see VaultList
+func VaultListContext(ctx context.Context, client *vault.Client, vaultSecretMount string, vaultPath string) (entries []string, err error) {
 	metaPath := vaultSecretMount + "/metadata/"
 	if !strings.HasPrefix(vaultPath, metaPath) {
 		vaultPath = path.Join(metaPath, vaultPath)
 	}
-	vaultSecret, err := client.Logical().List(vaultPath)
+	vaultSecret, err := client.Logical().ListWithContext(ctx, vaultPath)
 	if err != nil {
-		err = fmt.Errorf("read vault secret failed:%s", err)
+		err = fmt.Errorf("read vault secret failed:%w", err)
 		return
 	}
 	if vaultSecret == nil || vaultSecret.Data == nil {
@@ -98,7 +124,10 @@ func VaultList(client *vault.Client, vaultSecretMount string, vaultPath string)
 			if !strings.HasSuffix(subPath, "/") {
 				subPath += "/"
 			}
-			childEntries, childErr := VaultList(client, vaultSecretMount, subPath+k)
+			childEntries, childErr := VaultListContext(ctx, client, vaultSecretMount, subPath+k)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			if childErr != nil {
 				log.Warnf("error listing subpath %s: %v", subPath+k, childErr)
 				continue
@@ -124,9 +153,14 @@ func VaultList(client *vault.Client, vaultSecretMount string, vaultPath string)
 
 // VaultWrite write a value to the given path
 func VaultWrite(client *vault.Client, path string, data map[string]interface{}) (err error) {
-	_, err = client.Logical().Write(path, data)
+	return VaultWriteContext(context.Background(), client, path, data)
+}
+
+// VaultWriteContext write a value to the given path
+func VaultWriteContext(ctx context.Context, client *vault.Client, path string, data map[string]interface{}) (err error) {
+	_, err = client.Logical().WriteWithContext(ctx, path, data)
 	if err != nil {
-		err = fmt.Errorf("write to %s failed:%s", path, err)
+		err = fmt.Errorf("write to %s failed:%w", path, err)
 		return
 	}
 	log.Debugf("write to path %s successfully", path)
@@ -135,12 +169,17 @@ func VaultWrite(client *vault.Client, path string, data map[string]interface{})
 
 // GetVaultSecret reads a vault path as system via logical method and returns secret keys and values as plaintext format
 func GetVaultSecret(vaultPath string, vaultAddr string, vaultToken string) (content string, err error) {
+	return GetVaultSecretContext(context.Background(), vaultPath, vaultAddr, vaultToken)
+}
+
+// GetVaultSecretContext reads a vault path as system, see GetVaultSecret
+func GetVaultSecretContext(ctx context.Context, vaultPath string, vaultAddr string, vaultToken string) (content string, err error) {
 	var vc *vault.Client
 	var vs *vault.Secret
 	var vaultdata map[string]interface{}
 	log.Debugf("Vault Read entered for path '%s'", vaultPath)
 	vc, _ = VaultConfig(vaultAddr, vaultToken)
-	vs, err = VaultRead(vc, vaultPath)
+	vs, err = VaultReadContext(ctx, vc, vaultPath)
 	if err == nil {
 		sysKey := strings.ReplaceAll(vaultPath, ":", "_")
 		if vs != nil {